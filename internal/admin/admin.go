@@ -0,0 +1,312 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Serves an HTTP/JSON API for inspecting and managing a running bridge
+//
+// The API has no authentication, so it should only be served on a local
+// address. Its endpoints are:
+//
+//	GET    /members       lists the members of the Group
+//	DELETE /members/{id}  disconnects a member, if it is a TCP peer
+//	POST   /tcp-clients   connects to {"addr": "host:port"}
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+)
+
+type (
+	Server struct {
+		// Connects to a TCP server at addr, and adds it to the Group.
+		// If nil, POST /tcp-clients fails.
+		AddTCPClient func(addr string) error
+
+		mu      sync.Mutex
+		next    int
+		members map[int]bridge.ExtBridge
+	}
+
+	tracked struct {
+		s *Server
+		b bridge.ExtBridge
+	}
+
+	member struct {
+		ID     int      `json:"id"`
+		Name   string   `json:"name"`
+		Remote string   `json:"remote,omitempty"`
+		Stats  stats    `json:"stats"`
+		Nodes  []string `json:"nodes,omitempty"`
+		MACs   []string `json:"macs,omitempty"`
+	}
+
+	stats struct {
+		PacketsIn        int64 `json:"packets_in"`
+		PacketsOut       int64 `json:"packets_out"`
+		BytesIn          int64 `json:"bytes_in"`
+		BytesOut         int64 `json:"bytes_out"`
+		UnmarshalErrors  int64 `json:"unmarshal_errors"`
+		ConversionErrors int64 `json:"conversion_errors"`
+		QueueDrops       int64 `json:"queue_drops"`
+		RateLimitDrops   int64 `json:"rate_limit_drops"`
+	}
+
+	tcpClient struct {
+		Addr string `json:"addr"`
+	}
+
+	apiError struct {
+		Error string `json:"error"`
+	}
+
+	// Implemented by routers.
+	proxy interface {
+		Nodes() []ddp.Addr
+	}
+
+	// Implemented by Phase 2 EtherTalk ports.
+	learner interface {
+		LocalAddrs() []ethernet.Addr
+	}
+
+	// Implemented by TCP peers.
+	peer interface {
+		RemoteAddr() net.Addr
+	}
+)
+
+func NewServer() *Server {
+	return &Server{members: map[int]bridge.ExtBridge{}}
+}
+
+// Lists b in the API while it is a member of a Group. A nil Server
+// returns b unchanged.
+func (s *Server) Track(b bridge.ExtBridge) bridge.ExtBridge {
+	if s == nil {
+		return b
+	}
+	return &tracked{s, b}
+}
+
+func (t *tracked) String() string      { return bridge.PortName(t.b) }
+func (t *tracked) Unwrap() interface{} { return t.b }
+
+func (t *tracked) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- ethertalk.Packet,
+	recv <-chan ethertalk.Packet,
+) {
+	id := t.s.add(t.b)
+	send, r := t.b.Start(ctx, log)
+	recvCh := make(chan ethertalk.Packet)
+	go func() {
+		defer t.s.remove(id)
+		defer close(recvCh)
+		for pak := range r {
+			recvCh <- pak
+		}
+	}()
+	return send, recvCh
+}
+
+func (s *Server) add(b bridge.ExtBridge) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	s.members[s.next] = b
+	return s.next
+}
+
+func (s *Server) remove(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members, id)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path, "/")
+	switch {
+	case path == "members" && req.Method == http.MethodGet:
+		s.list(w)
+	case strings.HasPrefix(path, "members/") && req.Method == http.MethodDelete:
+		s.disconnect(w, strings.TrimPrefix(path, "members/"))
+	case path == "tcp-clients" && req.Method == http.MethodPost:
+		s.dial(w, req.Body)
+	case path == "members" || strings.HasPrefix(path, "members/") || path == "tcp-clients":
+		reply(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+	default:
+		reply(w, http.StatusNotFound, apiError{"not found"})
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter) {
+	s.mu.Lock()
+	ids := []int{}
+	for id := range s.members {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	members := []member{}
+	bridges := []bridge.ExtBridge{}
+	for _, id := range ids {
+		members = append(members, member{ID: id, Name: bridge.PortName(s.members[id])})
+		bridges = append(bridges, s.members[id])
+	}
+	s.mu.Unlock()
+
+	all := portStats()
+	for i, b := range bridges {
+		m := &members[i]
+		m.Stats = all[m.Name]
+		if p, ok := bridge.As[peer](b); ok {
+			m.Remote = p.RemoteAddr().String()
+		}
+		if p, ok := bridge.As[proxy](b); ok {
+			for _, addr := range p.Nodes() {
+				m.Nodes = append(m.Nodes, addr.String())
+			}
+		}
+		if l, ok := bridge.As[learner](b); ok {
+			for _, addr := range l.LocalAddrs() {
+				m.MACs = append(m.MACs, addr.String())
+			}
+		}
+	}
+	reply(w, http.StatusOK, members)
+}
+
+// Returns the statistics of each port, by name.
+func portStats() map[string]stats {
+	all := map[string]stats{}
+	update := func(port string, fn func(s *stats)) {
+		s := all[port]
+		fn(&s)
+		all[port] = s
+	}
+	metrics.Packets.Each(func(values []string, n int64) {
+		update(values[0], func(s *stats) {
+			if values[1] == "in" {
+				s.PacketsIn += n
+			} else {
+				s.PacketsOut += n
+			}
+		})
+	})
+	metrics.Bytes.Each(func(values []string, n int64) {
+		update(values[0], func(s *stats) {
+			if values[1] == "in" {
+				s.BytesIn += n
+			} else {
+				s.BytesOut += n
+			}
+		})
+	})
+	metrics.UnmarshalErrors.Each(func(values []string, n int64) {
+		update(values[0], func(s *stats) { s.UnmarshalErrors += n })
+	})
+	metrics.ConversionErrors.Each(func(values []string, n int64) {
+		update(values[0], func(s *stats) { s.ConversionErrors += n })
+	})
+	metrics.QueueDrops.Each(func(values []string, n int64) {
+		update(values[0], func(s *stats) { s.QueueDrops += n })
+	})
+	metrics.RateLimitDrops.Each(func(values []string, n int64) {
+		update(values[0], func(s *stats) { s.RateLimitDrops += n })
+	})
+	return all
+}
+
+func (s *Server) disconnect(w http.ResponseWriter, idStr string) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		reply(w, http.StatusNotFound, apiError{fmt.Sprintf("no member %q", idStr)})
+		return
+	}
+	s.mu.Lock()
+	b, ok := s.members[id]
+	s.mu.Unlock()
+	if !ok {
+		reply(w, http.StatusNotFound, apiError{fmt.Sprintf("no member %d", id)})
+		return
+	}
+
+	c, ok := bridge.As[io.Closer](b)
+	if !ok {
+		reply(w, http.StatusBadRequest, apiError{fmt.Sprintf("member %d can’t be disconnected", id)})
+		return
+	}
+	err = c.Close()
+	if err != nil {
+		reply(w, http.StatusInternalServerError, apiError{fmt.Sprintf("disconnect %d: %s", id, err.Error())})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) dial(w http.ResponseWriter, body io.Reader) {
+	c := tcpClient{}
+	err := json.NewDecoder(body).Decode(&c)
+	if err != nil {
+		reply(w, http.StatusBadRequest, apiError{fmt.Sprintf("read request: %s", err.Error())})
+		return
+	} else if c.Addr == "" {
+		reply(w, http.StatusBadRequest, apiError{"read request: no addr"})
+		return
+	} else if s.AddTCPClient == nil {
+		reply(w, http.StatusNotImplemented, apiError{"adding TCP clients is not supported"})
+		return
+	}
+
+	err = s.AddTCPClient(c.Addr)
+	if err != nil {
+		reply(w, http.StatusBadGateway, apiError{err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/sim"
+	"github.com/sfiera/multitalk/pkg/aep"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+// A fake TCP peer, which leaves the Group when closed.
+type fakePeer struct {
+	once sync.Once
+	recv chan ethertalk.Packet
+}
+
+func (p *fakePeer) String() string       { return "tcp:192.0.2.1:1234" }
+func (p *fakePeer) RemoteAddr() net.Addr { return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234} }
+
+func (p *fakePeer) Close() error {
+	p.once.Do(func() { close(p.recv) })
+	return nil
+}
+
+func (p *fakePeer) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- ethertalk.Packet,
+	recv <-chan ethertalk.Packet,
+) {
+	sendCh := make(chan ethertalk.Packet)
+	go func() {
+		for range sendCh {
+		}
+	}()
+	return sendCh, p.recv
+}
+
+type named struct {
+	bridge.Bridge
+	name string
+}
+
+func (n named) String() string { return n.name }
+
+func do(t *testing.T, s *Server, method, path, body string) (int, string) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w.Code, w.Body.String()
+}
+
+func list(t *testing.T, s *Server) []member {
+	code, body := do(t, s, "GET", "/members", "")
+	require.Equal(t, http.StatusOK, code)
+	members := []member{}
+	require.NoError(t, json.Unmarshal([]byte(body), &members))
+	return members
+}
+
+func TestMembers(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := sim.New(ctx, zap.NewNop())
+	adm := NewServer()
+
+	peer := &fakePeer{recv: make(chan ethertalk.Packet)}
+	s.Add(adm.Track(bridge.Metered(peer)))
+	lt := sim.NewSegment[llap.Packet]()
+	router := bridge.Extend(named{lt, "test:lt"}, bridge.Config{Network: ddp.StartupRange.Start}, []byte{2, 0, 0, 0, 0, 1})
+	s.Add(adm.Track(bridge.Metered(router)))
+
+	// A LocalTalk node sends a packet, so the router proxies for it.
+	l, err := llap.AppleTalk(20, 10, ddp.Packet{
+		Header: ddp.Header{Size: ddp.HeaderSize + 1, DstSocket: aep.Socket, SrcSocket: aep.Socket, Proto: ddp.ProtoAEP},
+		Data:   []byte{1},
+	})
+	require.NoError(t, err)
+	lt.Send(*l)
+	require.Eventually(t, func() bool {
+		members := list(t, adm)
+		return len(members) == 2 && len(members[1].Nodes) == 1
+	}, sim.DefaultTimeout, 10*time.Millisecond)
+
+	members := list(t, adm)
+	assert.Equal(1, members[0].ID)
+	assert.Equal("tcp:192.0.2.1:1234", members[0].Name)
+	assert.Equal("192.0.2.1:1234", members[0].Remote)
+	assert.Positive(members[0].Stats.PacketsOut)
+	assert.Equal(2, members[1].ID)
+	assert.Equal("test:lt", members[1].Name)
+	assert.Equal([]string{fmt.Sprintf("%d.10", ddp.StartupRange.Start)}, members[1].Nodes)
+	assert.Positive(members[1].Stats.PacketsIn)
+
+	// Routers can’t be disconnected; TCP peers can.
+	code, _ := do(t, adm, "DELETE", "/members/2", "")
+	assert.Equal(http.StatusBadRequest, code)
+	code, _ = do(t, adm, "DELETE", "/members/3", "")
+	assert.Equal(http.StatusNotFound, code)
+	code, _ = do(t, adm, "DELETE", "/members/1", "")
+	assert.Equal(http.StatusNoContent, code)
+	require.Eventually(t, func() bool {
+		return len(list(t, adm)) == 1
+	}, sim.DefaultTimeout, 10*time.Millisecond)
+	assert.Equal(2, list(t, adm)[0].ID)
+}
+
+func TestAddTCPClient(t *testing.T) {
+	assert := assert.New(t)
+	adm := NewServer()
+	code, _ := do(t, adm, "POST", "/tcp-clients", `{"addr": "192.0.2.1:1234"}`)
+	assert.Equal(http.StatusNotImplemented, code)
+
+	added := []string{}
+	adm.AddTCPClient = func(addr string) error {
+		if addr == "bad" {
+			return fmt.Errorf("dial bad: no such host")
+		}
+		added = append(added, addr)
+		return nil
+	}
+	code, _ = do(t, adm, "POST", "/tcp-clients", `{"addr": "192.0.2.1:1234"}`)
+	assert.Equal(http.StatusNoContent, code)
+	assert.Equal([]string{"192.0.2.1:1234"}, added)
+
+	code, body := do(t, adm, "POST", "/tcp-clients", `{"addr": "bad"}`)
+	assert.Equal(http.StatusBadGateway, code)
+	assert.JSONEq(`{"error": "dial bad: no such host"}`, body)
+
+	code, _ = do(t, adm, "POST", "/tcp-clients", `{}`)
+	assert.Equal(http.StatusBadRequest, code)
+	code, _ = do(t, adm, "GET", "/tcp-clients", "")
+	assert.Equal(http.StatusMethodNotAllowed, code)
+	code, _ = do(t, adm, "GET", "/nothing", "")
+	assert.Equal(http.StatusNotFound, code)
+}
@@ -32,6 +32,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sync"
 
 	"go.uber.org/zap"
 
@@ -69,21 +70,53 @@ type (
 	Group struct {
 		log    *zap.Logger
 		recvCh chan func(*Group)
+
+		mu     sync.Mutex
 		sendCh []chan<- ethertalk.Packet
 	}
 )
 
+// Returns the name of b’s port, such as "ethertalk:eth0", for logs and
+// metrics. Bridges name themselves by implementing fmt.Stringer.
+func PortName(b interface{}) string {
+	if s, ok := b.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return "unknown"
+}
+
+// Returns the first bridge in b’s chain that is a T, such as an io.Closer.
+// Bridges that wrap another, such as routers, return it from an Unwrap
+// method.
+func As[T any](b interface{}) (T, bool) {
+	for b != nil {
+		if t, ok := b.(T); ok {
+			return t, true
+		}
+		u, ok := b.(interface{ Unwrap() interface{} })
+		if !ok {
+			break
+		}
+		b = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
 func NewGroup(log *zap.Logger) *Group {
 	return &Group{
-		log,
-		make(chan func(*Group)),
-		nil,
+		log:    log,
+		recvCh: make(chan func(*Group)),
 	}
 }
 
+// Adds a member to the Group. It receives packets from the other members
+// as soon as Add returns.
 func (g *Group) Add(send chan<- ethertalk.Packet, recv <-chan ethertalk.Packet) {
+	g.mu.Lock()
+	g.sendCh = append(g.sendCh, send)
+	g.mu.Unlock()
 	go func() {
-		g.recvCh <- add(send)
 		for pak := range recv {
 			g.recvCh <- broadcast(pak, send)
 		}
@@ -105,7 +138,10 @@ func broadcast(pak ethertalk.Packet, send chan<- ethertalk.Packet) func(g *Group
 		case ethertalk.AppleTalkProto:
 			g.logAppleTalkPacket(pak)
 		}
-		for _, sendCh := range g.sendCh {
+		g.mu.Lock()
+		members := g.sendCh
+		g.mu.Unlock()
+		for _, sendCh := range members {
 			if sendCh != send {
 				sendCh <- pak
 			}
@@ -113,14 +149,9 @@ func broadcast(pak ethertalk.Packet, send chan<- ethertalk.Packet) func(g *Group
 	}
 }
 
-func add(send chan<- ethertalk.Packet) func(g *Group) {
-	return func(g *Group) {
-		g.sendCh = append(g.sendCh, send)
-	}
-}
-
 func remove(send chan<- ethertalk.Packet) func(g *Group) {
 	return func(g *Group) {
+		g.mu.Lock()
 		var newCh []chan<- ethertalk.Packet
 		for _, ch := range g.sendCh {
 			if ch != send {
@@ -128,14 +159,15 @@ func remove(send chan<- ethertalk.Packet) func(g *Group) {
 			}
 		}
 		g.sendCh = newCh
+		g.mu.Unlock()
 		close(send)
 	}
 }
 
 func (g *Group) logAARPPacket(packet ethertalk.Packet) {
 	log := g.log.With(zap.String("protocol", "aarp"))
-	a := aarp.Packet{}
-	err := aarp.Unmarshal(packet.Payload, &a)
+	a := aarp.View{}
+	err := aarp.UnmarshalView(packet.Payload, &a)
 	if err != nil {
 		log.With(zap.Error(err)).Error("unmarshal failed")
 		return
@@ -162,8 +194,8 @@ func (g *Group) logAARPPacket(packet ethertalk.Packet) {
 
 func (g *Group) logAppleTalkPacket(packet ethertalk.Packet) {
 	log := g.log.With(zap.String("protocol", "ddp"))
-	d := ddp.ExtPacket{}
-	err := ddp.ExtUnmarshal(packet.Payload, &d)
+	d := ddp.ExtView{}
+	err := ddp.ExtUnmarshalView(packet.Payload, &d)
 	if err != nil {
 		log.With(zap.Error(err)).Error("unmarshal failed")
 		return
@@ -181,23 +213,34 @@ func (g *Group) logAppleTalkPacket(packet ethertalk.Packet) {
 }
 
 func ddpProto(key string, val uint8) zap.Field {
+	if name := ProtoName(val); name != "" {
+		return zap.String("proto", name)
+	}
+	return zap.Uint8("proto", val)
+}
+
+// Returns the short name of a DDP type, such as "nbp", or "" if it has
+// none.
+func ProtoName(val uint8) string {
 	switch val {
 	case ddp.ProtoRTMPResp:
-		return zap.String("proto", "rtmp/resp")
+		return "rtmp/resp"
 	case ddp.ProtoNBP:
-		return zap.String("proto", "nbp")
+		return "nbp"
 	case ddp.ProtoATP:
-		return zap.String("proto", "atp")
+		return "atp"
 	case ddp.ProtoAEP:
-		return zap.String("proto", "aep")
+		return "aep"
 	case ddp.ProtoRTMPReq:
-		return zap.String("proto", "rtmp/req")
+		return "rtmp/req"
 	case ddp.ProtoZIP:
-		return zap.String("proto", "zip")
+		return "zip"
 	case ddp.ProtoADSP:
-		return zap.String("proto", "adsp")
+		return "adsp"
+	case ddp.ProtoIP:
+		return "ip"
 	default:
-		return zap.Uint8("proto", val)
+		return ""
 	}
 }
 
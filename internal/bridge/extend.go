@@ -29,10 +29,13 @@ package bridge
 
 import (
 	"context"
-	"sync"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/sfiera/multitalk/internal/metrics"
 	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/amt"
 	"github.com/sfiera/multitalk/pkg/ddp"
 	"github.com/sfiera/multitalk/pkg/ethernet"
 	"github.com/sfiera/multitalk/pkg/ethertalk"
@@ -40,28 +43,79 @@ import (
 	"go.uber.org/zap"
 )
 
-type router struct {
-	network ddp.Network
+type (
+	router struct {
+		network ddp.Network
+		rng     ddp.Range
+		zones   []string
+		names   []name
 
-	nodes   map[ddp.Node]bool
-	nodesMu sync.Mutex
+		// Maps EtherTalk nodes to their hardware addresses, and defends
+		// the addresses of LocalTalk nodes that this router proxies for.
+		amt *amt.Table
 
-	eth ethernet.Addr
+		eth ethernet.Addr
 
-	bridge Bridge
-}
+		bridge Bridge
+		port   string
+
+		// Outputs for packets that originate from the router itself.
+		llapOut chan<- llap.Packet
+		queue   chan ethertalk.Packet
+
+		// The router’s own node, once acquired, and the node it is
+		// trying to acquire, if any.
+		mu        sync.Mutex
+		node      ddp.Node
+		tentative ddp.Node
+		conflict  bool
+
+		// Networks reachable through other routers, keyed by the start
+		// of their ranges. Guarded by mu.
+		routes map[ddp.Network]*route
+	}
+
+	// Config describes the networks connected by an Extend router.
+	Config struct {
+		// Network number of the LocalTalk network.
+		Network ddp.Network
+
+		// Cable range of the extended network that the LocalTalk
+		// network is bridged onto. If zero, the router only proxies
+		// for LocalTalk nodes, and provides no routing services.
+		//
+		// If Network is within Range, LocalTalk nodes appear as nodes
+		// of the extended network. Otherwise, the router advertises a
+		// route between the two.
+		Range ddp.Range
+
+		// Zones of the extended network. The first is the default zone,
+		// which is also the zone of the LocalTalk network. Required with
+		// Range; without zones, the router answers no ZIP requests.
+		Zones []string
+
+		// Object name under which the router registers its own NBP
+		// names, “Name:multitalk” and “Name:AppleTalk Router”, in
+		// the default zone. If empty, no names are registered.
+		Name string
+	}
+)
 
 // Extend converts a Bridge into an ExtBridge.
 //
-// The provided network is assumed to be the network for nodes on that bridge.
-//
-// TODO: implement router features, so that nodes on the network
-// are properly aware of the network topology.
-func Extend(b Bridge, network ddp.Network, hwAddr []byte) ExtBridge {
+// The configured network is assumed to be the network for nodes on that
+// bridge. If a cable range is configured, the router acquires a node of
+// its own, and acts as a seed router for the range: it advertises routes
+// with RTMP, answers ZIP queries, and forwards NBP lookups.
+func Extend(b Bridge, cfg Config, hwAddr []byte) ExtBridge {
 	r := router{
-		network: network,
-		nodes:   map[ddp.Node]bool{},
+		network: cfg.Network,
+		rng:     cfg.Range,
+		zones:   cfg.Zones,
+		names:   names(cfg.Name),
+		amt:     amt.New(amt.DefaultMaxAge),
 		bridge:  b,
+		routes:  map[ddp.Network]*route{},
 	}
 	copy(r.eth[:], hwAddr)
 	return &r
@@ -71,28 +125,94 @@ func (r *router) Start(ctx context.Context, log *zap.Logger) (
 	send chan<- ethertalk.Packet,
 	recv <-chan ethertalk.Packet,
 ) {
+	r.port = r.String()
 	sendLLAPOutCh, recvLLAPInCh := r.bridge.Start(ctx, log)
 	sendELAPInCh, sendELAPOutCh := pipe(make(chan ethertalk.Packet))
 	recvELAPInCh, recvELAPOutCh := pipe(make(chan ethertalk.Packet))
+	r.llapOut = sendLLAPOutCh
+	r.queue = make(chan ethertalk.Packet, queueSize)
 	go r.translateCapture(ctx, log, recvLLAPInCh, recvELAPOutCh)
-	go r.translateTransmit(ctx, log, sendELAPInCh, sendLLAPOutCh, recvELAPOutCh)
+	go r.translateTransmit(ctx, log, sendELAPInCh, sendLLAPOutCh)
+	go r.forward(recvELAPOutCh)
+	go r.expire(ctx)
+	if !r.rng.IsZero() {
+		go r.seed(ctx, log)
+	}
 	return sendELAPOutCh, recvELAPInCh
 }
 
+// Forwards packets originating from the router itself to the Group.
+//
+// These are queued, rather than sent directly, because they are often
+// responses to packets received from the Group, which would deadlock.
+func (r *router) forward(elapCh chan<- ethertalk.Packet) {
+	for packet := range r.queue {
+		elapCh <- packet
+	}
+}
+
+// Queues a packet for the Group, dropping it if the queue is full.
+func (r *router) emit(packet ethertalk.Packet) {
+	select {
+	case r.queue <- packet:
+	default:
+		metrics.QueueDrops.With(r.port).Inc()
+	}
+}
+
+// A router has the name of its LocalTalk port.
+func (r *router) String() string {
+	return PortName(r.bridge)
+}
+
+func (r *router) Unwrap() interface{} {
+	return r.bridge
+}
+
+// Returns the addresses of the LocalTalk nodes that the router proxies
+// for on the extended network.
+func (r *router) Nodes() []ddp.Addr {
+	r.mu.Lock()
+	own := r.node
+	r.mu.Unlock()
+	nodes := []ddp.Addr{}
+	for _, addr := range r.amt.Owned() {
+		if own == 0 || addr.Node != own {
+			nodes = append(nodes, addr)
+		}
+	}
+	return nodes
+}
+
+func (r *router) expire(ctx context.Context) {
+	ticker := time.NewTicker(amt.DefaultMaxAge)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.amt.Expire()
+		}
+	}
+}
+
 func (r *router) translateTransmit(
 	ctx context.Context,
 	log *zap.Logger,
 	elapCh <-chan ethertalk.Packet,
 	llapCh chan<- llap.Packet,
-	respCh chan<- ethertalk.Packet,
 ) {
 	for packet := range elapCh {
 		llap, resp, err := r.elapToLLAP(packet)
 		if resp != nil {
-			respCh <- *resp
+			r.emit(*resp)
 			continue
-		} else if llap == nil {
+		} else if err != nil {
 			log.Error(fmt.Sprintf("convert failed: err %v", err))
+			metrics.ConversionErrors.With(r.port).Inc()
+			continue
+		} else if llap == nil {
 			continue
 		}
 		llapCh <- *llap
@@ -122,12 +242,35 @@ func (r *router) isLocal(net ddp.Network) bool {
 	return net == 0 || net == r.network
 }
 
+// Returns true if net is on the extended network’s cable: its range, if
+// known, or else the startup range.
+func (r *router) onCable(net ddp.Network) bool {
+	if net == 0 || r.isConnected(net) {
+		return true
+	}
+	return r.rng.IsZero() && ddp.StartupRange.Contains(net)
+}
+
 func (r *router) elapToLLAPDDP(packet ethertalk.Packet) (*llap.Packet, error) {
 	ext := ddp.ExtPacket{}
 	err := ddp.ExtUnmarshal(packet.Payload, &ext)
 	if err != nil {
 		return nil, err
 	}
+	src := ddp.Addr{Network: ext.SrcNet, Node: ext.SrcNode}
+	if ext.Hops() == 0 && r.onCable(ext.SrcNet) {
+		// Routed packets carry the address of their sender, but the
+		// hardware address of the last router.
+		r.amt.Glean(src, packet.Src)
+	}
+	r.observe(src)
+
+	if r.isRouter(ext.DstNet, ext.DstNode) {
+		r.handle(extSide, ext)
+		return nil, nil
+	} else if ext.DstNode == 0xff && (ext.DstNet == 0 || r.rng.Contains(ext.DstNet)) {
+		r.handle(extSide, ext)
+	}
 
 	if r.isLocal(ext.SrcNet) && r.isLocal(ext.DstNet) {
 		short := ddp.ExtToShort(ext)
@@ -155,17 +298,31 @@ func (r *router) elapToLLAPAARP(packet ethertalk.Packet) (
 		return nil, nil
 	}
 
-	if !r.isLocal(a.Src.Proto.Network) || !r.isLocal(a.Dst.Proto.Network) {
-		return nil, nil
-	}
+	r.amt.Learn(a)
 
 	switch a.Opcode {
 	case aarp.ProbeOp:
 		// “Is this AppleTalk node ID in use by anyone?”
+		// If it’s a node we proxy for, defend it directly. Otherwise,
+		// ask the LocalTalk network.
+		r.observe(a.Dst.Proto)
+		if resp := r.defend(a); resp != nil {
+			return nil, resp
+		} else if !r.isLocal(a.Src.Proto.Network) || !r.isLocal(a.Dst.Proto.Network) {
+			return nil, nil
+		}
 		return llap.Enq(a.Dst.Proto.Node, a.Src.Proto.Node), nil
 
 	case aarp.ResponseOp:
 		// “Yes, sorry, I’m already using that node ID.”
+		// Responses to our own requests have differing addresses, and
+		// have no LocalTalk counterpart.
+		r.observe(a.Src.Proto)
+		if a.Src.Proto != a.Dst.Proto {
+			return nil, nil
+		} else if !r.isLocal(a.Src.Proto.Network) {
+			return nil, nil
+		}
 		return llap.Ack(a.Dst.Proto.Node, a.Src.Proto.Node), nil
 
 	case aarp.RequestOp:
@@ -174,33 +331,32 @@ func (r *router) elapToLLAPAARP(packet ethertalk.Packet) (
 		// Check if the target machine is one that has broadcast UDP packets.
 		// If it has, then report this machine’s hardware address as the
 		// target for the queried AppleTalk address.
-		if !r.isProxyForNode(a.Dst.Proto.Node) {
-			return nil, nil
-		}
-		resp, err := ethertalk.AARP(r.eth, aarp.Response(aarp.AddrPair{
-			Hardware: r.eth,
-			Proto:    a.Dst.Proto,
-		}, a.Src))
-		if err != nil {
-			return nil, nil
-		}
-		return nil, resp
+		r.observe(a.Src.Proto)
+		return nil, r.defend(a)
 
 	default:
 		return nil, nil
 	}
 }
 
-func (r *router) isProxyForNode(node ddp.Node) bool {
-	r.nodesMu.Lock()
-	defer r.nodesMu.Unlock()
-	return r.nodes[node]
+func (r *router) defend(a aarp.Packet) *ethertalk.Packet {
+	if a.Dst.Proto.Network == 0 {
+		a.Dst.Proto.Network = r.network
+	}
+	resp := r.amt.Respond(r.eth, a)
+	if resp == nil {
+		return nil
+	}
+	out, err := ethertalk.AARP(r.eth, *resp)
+	if err != nil {
+		return nil
+	}
+	out.Dst = a.Src.Hardware
+	return out
 }
 
 func (r *router) markProxyForNode(node ddp.Node) {
-	r.nodesMu.Lock()
-	defer r.nodesMu.Unlock()
-	r.nodes[node] = true
+	r.amt.Defend(ddp.Addr{Network: r.network, Node: node})
 }
 
 func (r *router) translateCapture(
@@ -210,55 +366,96 @@ func (r *router) translateCapture(
 	elapCh chan<- ethertalk.Packet,
 ) {
 	for packet := range llapCh {
-		conv := r.llapToELAP(packet)
+		r.observeNode(packet.SrcNode)
+		if r.receive(packet) {
+			continue
+		}
+		conv, req := r.llapToELAP(packet)
 		if conv != nil {
 			r.markProxyForNode(packet.SrcNode)
+			if req != nil {
+				elapCh <- *req
+			}
 			elapCh <- *conv
 		}
 	}
 }
 
-func (r *router) llapToELAP(packet llap.Packet) *ethertalk.Packet {
+func (r *router) llapToELAP(packet llap.Packet) (
+	converted *ethertalk.Packet,
+	request *ethertalk.Packet,
+) {
 	switch packet.Kind {
 	case llap.TypeDDP:
 		return r.llapToELAPDDP(packet)
 	case llap.TypeExtDDP:
 		return r.llapToELAPExtDDP(packet)
 	case llap.TypeEnq:
-		return r.llapToELAPProbe(packet)
+		return r.llapToELAPProbe(packet), nil
 	case llap.TypeAck:
-		return r.llapToELAPAck(packet)
+		return r.llapToELAPAck(packet), nil
 	default:
-		return nil
+		return nil, nil
 	}
 }
 
-func (r *router) llapToELAPDDP(packet llap.Packet) *ethertalk.Packet {
+func (r *router) llapToELAPDDP(packet llap.Packet) (*ethertalk.Packet, *ethertalk.Packet) {
 	d := ddp.Packet{}
 	err := ddp.Unmarshal(packet.Payload, &d)
 	if err != nil {
-		return nil
+		return nil, nil
 	}
 
 	ext := ddp.ShortToExt(d, r.network, packet.DstNode, packet.SrcNode)
 	out, err := ethertalk.AppleTalk(r.eth, ext)
 	if err != nil {
-		return nil
+		return nil, nil
 	}
-	return out
+	return out, r.resolve(out, ext)
 }
 
-func (r *router) llapToELAPExtDDP(packet llap.Packet) *ethertalk.Packet {
+func (r *router) llapToELAPExtDDP(packet llap.Packet) (*ethertalk.Packet, *ethertalk.Packet) {
 	d := ddp.ExtPacket{}
 	err := ddp.ExtUnmarshal(packet.Payload, &d)
 	if err != nil {
-		return nil
+		return nil, nil
 	}
 	out, err := ethertalk.AppleTalk(r.eth, d)
 	if err != nil {
+		return nil, nil
+	}
+	return out, r.resolve(out, d)
+}
+
+// Addresses a converted packet to its destination’s hardware address,
+// if known. If not, the packet remains broadcast, and an AARP request
+// may be returned to find the destination for future packets.
+//
+// Packets for networks behind another router are addressed to that
+// router instead.
+func (r *router) resolve(out *ethertalk.Packet, ext ddp.ExtPacket) *ethertalk.Packet {
+	dst := ddp.Addr{Network: ext.DstNet, Node: ext.DstNode}
+	if dst.Network == 0 {
+		dst.Network = r.network
+	} else if hop, ok := r.nextHop(dst.Network, extSide); ok {
+		dst = hop
+	}
+	if hw, ok := r.amt.Lookup(dst); ok {
+		out.Dst = hw
+		return nil
+	} else if !r.amt.Miss(dst) {
 		return nil
 	}
-	return out
+
+	src := aarp.AddrPair{
+		Hardware: r.eth,
+		Proto:    ddp.Addr{Network: r.network, Node: ext.SrcNode},
+	}
+	req, err := ethertalk.AARP(r.eth, aarp.Request(src, dst))
+	if err != nil {
+		return nil
+	}
+	return req
 }
 
 func (r *router) llapToELAPProbe(packet llap.Packet) *ethertalk.Packet {
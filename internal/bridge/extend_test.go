@@ -0,0 +1,315 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/sim"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/aep"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+	"github.com/sfiera/multitalk/pkg/rtmp"
+	"github.com/sfiera/multitalk/pkg/zip"
+)
+
+var (
+	macEth  = ethernet.Addr{0x08, 0x00, 0x07, 0x12, 0x34, 0x56}
+	startup = ddp.StartupRange.Start
+)
+
+func newSim(t *testing.T) *sim.Sim {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return sim.New(ctx, zap.NewNop())
+}
+
+func extPacket(src, dst ddp.Addr, socket ddp.Socket, proto uint8, data []byte) ddp.ExtPacket {
+	return ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			Size:   uint16(ddp.ExtHeaderSize + len(data)),
+			DstNet: dst.Network, DstNode: dst.Node, DstSocket: socket,
+			SrcNet: src.Network, SrcNode: src.Node, SrcSocket: socket,
+			Proto: proto,
+		},
+		Data: data,
+	}
+}
+
+func shortPacket(t *testing.T, dst, src ddp.Node, socket ddp.Socket, proto uint8, data []byte) llap.Packet {
+	l, err := llap.AppleTalk(dst, src, ddp.Packet{
+		Header: ddp.Header{Size: uint16(ddp.HeaderSize + len(data)), DstSocket: socket, SrcSocket: socket, Proto: proto},
+		Data:   data,
+	})
+	require.NoError(t, err)
+	return *l
+}
+
+func etherDDP(t *testing.T, src ethernet.Addr, ext ddp.ExtPacket) ethertalk.Packet {
+	out, err := ethertalk.AppleTalk(src, ext)
+	require.NoError(t, err)
+	return *out
+}
+
+func etherAARP(t *testing.T, src ethernet.Addr, a aarp.Packet) ethertalk.Packet {
+	out, err := ethertalk.AARP(src, a)
+	require.NoError(t, err)
+	return *out
+}
+
+func isAARP(op aarp.Opcode) func(ethertalk.Packet) bool {
+	return func(p ethertalk.Packet) bool {
+		a, ok := sim.AARP(p)
+		return ok && a.Opcode == op
+	}
+}
+
+func isDDP(proto uint8) func(ethertalk.Packet) bool {
+	return func(p ethertalk.Packet) bool {
+		ext, ok := sim.DDP(p)
+		return ok && ext.Proto == proto
+	}
+}
+
+func isShortDDP(proto uint8) func(llap.Packet) bool {
+	return func(p llap.Packet) bool {
+		d, ok := sim.ShortDDP(p)
+		return ok && d.Proto == proto
+	}
+}
+
+func TestEnqToProbe(t *testing.T) {
+	s := newSim(t)
+	et := s.EtherTalk()
+	lt, mac := s.LocalTalk(bridge.Config{Network: startup})
+
+	lt.Send(*llap.Enq(10, 10))
+	out, err := et.Expect(isAARP(aarp.ProbeOp))
+	if assert.NoError(t, err) {
+		a, _ := sim.AARP(out)
+		assert.Equal(t, aarp.Probe(mac, ddp.Addr{Network: startup, Node: 10}), a)
+	}
+}
+
+func TestDefendProxiedNode(t *testing.T) {
+	assert := assert.New(t)
+	s := newSim(t)
+	et := s.EtherTalk()
+	lt, mac := s.LocalTalk(bridge.Config{Network: startup})
+
+	// Node 10 is active on LocalTalk.
+	lt.Send(shortPacket(t, 0xff, 10, aep.Socket, ddp.ProtoAEP, []byte{1}))
+	out, err := et.Expect(isDDP(ddp.ProtoAEP))
+	if assert.NoError(err) {
+		ext, _ := sim.DDP(out)
+		assert.Equal(ethertalk.AppleTalkBroadcast, out.Dst)
+		assert.Equal(ddp.Addr{Network: startup, Node: 10}, ddp.Addr{Network: ext.SrcNet, Node: ext.SrcNode})
+		assert.Equal([]byte{1}, ext.Data)
+	}
+
+	// So an EtherTalk node can’t take its address.
+	node10 := ddp.Addr{Network: startup, Node: 10}
+	et.Send(etherAARP(t, macEth, aarp.Probe(macEth, node10)))
+	out, err = et.Expect(isAARP(aarp.ResponseOp))
+	if assert.NoError(err) {
+		a, _ := sim.AARP(out)
+		assert.Equal(aarp.AddrPair{Hardware: mac, Proto: node10}, a.Src)
+		assert.Equal(macEth, out.Dst)
+	}
+}
+
+func TestEtherTalkToLocalTalk(t *testing.T) {
+	assert := assert.New(t)
+	s := newSim(t)
+	et := s.EtherTalk()
+	lt, _ := s.LocalTalk(bridge.Config{Network: startup})
+
+	src := ddp.Addr{Network: startup, Node: 50}
+	dst := ddp.Addr{Network: startup, Node: 10}
+	et.Send(etherDDP(t, macEth, extPacket(src, dst, aep.Socket, ddp.ProtoAEP, []byte{1, 2})))
+	out, err := lt.Expect(isShortDDP(ddp.ProtoAEP))
+	if assert.NoError(err) {
+		assert.Equal(llap.Header{DstNode: 10, SrcNode: 50, Kind: llap.TypeDDP}, out.Header)
+		d, _ := sim.ShortDDP(out)
+		assert.Equal([]byte{1, 2}, d.Data)
+	}
+
+	// Off-network packets keep the extended header.
+	src.Network = 3
+	et.Send(etherDDP(t, macEth, extPacket(src, dst, aep.Socket, ddp.ProtoAEP, []byte{3})))
+	out, err = lt.Expect(func(p llap.Packet) bool { return p.Kind == llap.TypeExtDDP })
+	if assert.NoError(err) {
+		ext, _ := sim.ExtDDP(out)
+		assert.Equal(ddp.Network(3), ext.SrcNet)
+		assert.Equal([]byte{3}, ext.Data)
+	}
+}
+
+func TestLocalTalkToLocalTalk(t *testing.T) {
+	s := newSim(t)
+	a, _ := s.LocalTalk(bridge.Config{Network: startup})
+	b, _ := s.LocalTalk(bridge.Config{Network: startup})
+
+	a.Send(shortPacket(t, 0xff, 10, aep.Socket, ddp.ProtoAEP, []byte{1}))
+	out, err := b.Expect(isShortDDP(ddp.ProtoAEP))
+	if assert.NoError(t, err) {
+		assert.Equal(t, llap.Header{DstNode: 0xff, SrcNode: 10, Kind: llap.TypeDDP}, out.Header)
+	}
+}
+
+func TestSeedRouter(t *testing.T) {
+	assert := assert.New(t)
+	s := newSim(t)
+	et := s.EtherTalk()
+	cable := ddp.Range{Start: 100, End: 109}
+	lt, mac := s.LocalTalk(bridge.Config{Network: 5, Range: cable, Zones: []string{"Lab"}})
+
+	// Once it has a node, the router advertises routes on both sides.
+	out, err := et.Expect(isDDP(ddp.ProtoRTMPResp))
+	require.NoError(t, err)
+	ext, _ := sim.DDP(out)
+	pak := rtmp.Packet{}
+	require.NoError(t, rtmp.Unmarshal(ext.Data, &pak))
+	router := pak.Router
+	assert.Equal(ddp.Network(100), router.Network)
+	assert.True(router.Node >= 0x80 && router.Node < 0xfe)
+	assert.Equal([]rtmp.Tuple{
+		{Range: cable, Extended: true},
+		{Range: ddp.Range{Start: 5, End: 5}, Distance: 0},
+	}, pak.Tuples)
+
+	local, err := lt.Expect(isShortDDP(ddp.ProtoRTMPResp))
+	if assert.NoError(err) {
+		d, _ := sim.ShortDDP(local)
+		require.NoError(t, rtmp.Unmarshal(d.Data, &pak))
+		assert.Equal(ddp.Addr{Network: 5, Node: router.Node}, pak.Router)
+	}
+
+	// A node in the startup range asks for the cable range.
+	node := ddp.Addr{Network: startup, Node: 50}
+	data, err := zip.Marshal(&zip.GetNetInfo{Zone: "Lab"})
+	require.NoError(t, err)
+	et.Send(etherDDP(t, macEth, extPacket(node, ddp.Addr{Node: 0xff}, zip.Socket, ddp.ProtoZIP, data)))
+	out, err = et.Expect(isDDP(ddp.ProtoZIP))
+	if assert.NoError(err) {
+		assert.Equal(mac, out.Src)
+		ext, _ := sim.DDP(out)
+		p, err := zip.Unmarshal(ext.Data)
+		if assert.NoError(err) {
+			reply, ok := p.(*zip.NetInfoReply)
+			if assert.True(ok) {
+				assert.Equal(cable, reply.Range)
+				assert.Equal("Lab", reply.Zone)
+				assert.Equal(zip.FlagOnlyOneZone|zip.FlagUseBroadcast, reply.Flags)
+			}
+		}
+	}
+
+	// And it can ping the router.
+	node.Network = 101
+	data, err = aep.Marshal(aep.Packet{Function: aep.Request, Data: []byte("ping")})
+	require.NoError(t, err)
+	et.Send(etherDDP(t, macEth, extPacket(node, router, aep.Socket, ddp.ProtoAEP, data)))
+	out, err = et.Expect(isDDP(ddp.ProtoAEP))
+	if assert.NoError(err) {
+		ext, _ := sim.DDP(out)
+		assert.Equal(router, ddp.Addr{Network: ext.SrcNet, Node: ext.SrcNode})
+		assert.Equal(node, ddp.Addr{Network: ext.DstNet, Node: ext.DstNode})
+		reply := aep.Packet{}
+		require.NoError(t, aep.Unmarshal(ext.Data, &reply))
+		assert.Equal(aep.Packet{Function: aep.Reply, Data: []byte("ping")}, reply)
+	}
+}
+
+func TestRouterEnq(t *testing.T) {
+	s := newSim(t)
+	et := s.EtherTalk()
+	lt, _ := s.LocalTalk(bridge.Config{Network: 5, Range: ddp.Range{Start: 100, End: 109}, Zones: []string{"Lab"}})
+
+	out, err := et.Expect(isDDP(ddp.ProtoRTMPResp))
+	require.NoError(t, err)
+	ext, _ := sim.DDP(out)
+
+	// The router answers ENQs for its own node.
+	lt.Clear()
+	lt.Send(*llap.Enq(ext.SrcNode, ext.SrcNode))
+	ack, err := lt.Expect(func(p llap.Packet) bool { return p.Kind == llap.TypeAck })
+	if assert.NoError(t, err) {
+		assert.Equal(t, *llap.Ack(ext.SrcNode, ext.SrcNode), ack)
+	}
+}
+
+func TestRouterRTS(t *testing.T) {
+	s := newSim(t)
+	et := s.EtherTalk()
+	lt, _ := s.LocalTalk(bridge.Config{Network: 5, Range: ddp.Range{Start: 100, End: 109}, Zones: []string{"Lab"}})
+
+	out, err := et.Expect(isDDP(ddp.ProtoRTMPResp))
+	require.NoError(t, err)
+	ext, _ := sim.DDP(out)
+
+	// The router clears directed frames to its own node.
+	lt.Clear()
+	lt.Send(*llap.RTS(ext.SrcNode, 10))
+	cts, err := lt.Expect(func(p llap.Packet) bool { return p.Kind == llap.TypeCTS })
+	if assert.NoError(t, err) {
+		assert.Equal(t, *llap.CTS(10, ext.SrcNode), cts)
+	}
+}
+
+func TestProxyRTS(t *testing.T) {
+	s := newSim(t)
+	et := s.EtherTalk()
+	lt, _ := s.LocalTalk(bridge.Config{Network: startup})
+
+	// Node 50 is active on EtherTalk.
+	src := ddp.Addr{Network: startup, Node: 50}
+	dst := ddp.Addr{Network: startup, Node: 10}
+	et.Send(etherDDP(t, macEth, extPacket(src, dst, aep.Socket, ddp.ProtoAEP, []byte{1})))
+	_, err := lt.Expect(isShortDDP(ddp.ProtoAEP))
+	require.NoError(t, err)
+
+	// So the router clears directed frames to it, but not to nodes it
+	// doesn’t know, which may be on LocalTalk.
+	lt.Send(*llap.RTS(20, 10))
+	lt.Send(*llap.RTS(50, 10))
+	cts, err := lt.Expect(func(p llap.Packet) bool { return p.Kind == llap.TypeCTS })
+	if assert.NoError(t, err) {
+		assert.Equal(t, *llap.CTS(10, 50), cts)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"context"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+)
+
+type meter struct {
+	b ExtBridge
+}
+
+// Counts the packets that b sends to and receives from the Group, in
+// metrics.Packets and metrics.Bytes.
+func Metered(b ExtBridge) ExtBridge {
+	return &meter{b}
+}
+
+func (m *meter) String() string {
+	return PortName(m.b)
+}
+
+func (m *meter) Unwrap() interface{} {
+	return m.b
+}
+
+func (m *meter) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- ethertalk.Packet,
+	recv <-chan ethertalk.Packet,
+) {
+	port := m.String()
+	s, r := m.b.Start(ctx, log)
+	sendCh := make(chan ethertalk.Packet)
+	recvCh := make(chan ethertalk.Packet)
+	go func() {
+		defer close(s)
+		for pak := range sendCh {
+			count(port, "out", pak)
+			s <- pak
+		}
+	}()
+	go func() {
+		defer close(recvCh)
+		for pak := range r {
+			count(port, "in", pak)
+			recvCh <- pak
+		}
+	}()
+	return sendCh, recvCh
+}
+
+func count(port, dir string, pak ethertalk.Packet) {
+	proto := "unknown"
+	switch pak.SNAPProto {
+	case ethertalk.AARPProto:
+		a := aarp.View{}
+		if aarp.UnmarshalView(pak.Payload, &a) == nil {
+			proto = "aarp"
+		}
+	case ethertalk.AppleTalkProto:
+		d := ddp.ExtView{}
+		if ddp.ExtUnmarshalView(pak.Payload, &d) == nil {
+			proto = ProtoName(d.Proto)
+			if proto == "" {
+				proto = strconv.Itoa(int(d.Proto))
+			}
+		}
+	}
+	if proto == "unknown" && dir == "in" {
+		metrics.UnmarshalErrors.With(port).Inc()
+	}
+	metrics.Packets.With(port, dir, proto).Inc()
+	metrics.Bytes.With(port, dir, proto).Add(len(pak.Payload))
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/internal/sim"
+	"github.com/sfiera/multitalk/pkg/aep"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+)
+
+type named struct {
+	bridge.ExtBridge
+	name string
+}
+
+func (n named) String() string { return n.name }
+
+type counter struct {
+	*metrics.Counter
+	name         string
+	before, want int64
+}
+
+func TestPortName(t *testing.T) {
+	seg := sim.NewSegment[ethertalk.Packet]()
+	assert.Equal(t, "unknown", bridge.PortName(seg))
+	assert.Equal(t, "test:a", bridge.PortName(named{seg, "test:a"}))
+	assert.Equal(t, "test:a", bridge.PortName(bridge.Metered(named{seg, "test:a"})))
+}
+
+func TestAs(t *testing.T) {
+	seg := sim.NewSegment[ethertalk.Packet]()
+	n, ok := bridge.As[named](bridge.Metered(named{seg, "test:a"}))
+	assert.True(t, ok)
+	assert.Equal(t, "test:a", n.name)
+	_, ok = bridge.As[io.Closer](bridge.Metered(named{seg, "test:a"}))
+	assert.False(t, ok)
+}
+
+func TestMetered(t *testing.T) {
+	assert := assert.New(t)
+	s := newSim(t)
+	a := sim.NewSegment[ethertalk.Packet]()
+	b := sim.NewSegment[ethertalk.Packet]()
+	s.Add(bridge.Metered(named{a, "test:metered-a"}))
+	s.Add(bridge.Metered(named{b, "test:metered-b"}))
+
+	// Counters are global, so compare them to their values before.
+	counters := []*counter{
+		{name: "in", Counter: metrics.Packets.With("test:metered-a", "in", "aep"), want: 1},
+		{name: "bytes", Counter: metrics.Bytes.With("test:metered-a", "in", "aep"), want: 15},
+		{name: "out", Counter: metrics.Packets.With("test:metered-b", "out", "aep"), want: 1},
+		{name: "in unknown", Counter: metrics.Packets.With("test:metered-a", "in", "unknown"), want: 1},
+		{name: "unmarshal a", Counter: metrics.UnmarshalErrors.With("test:metered-a"), want: 1},
+		{name: "unmarshal b", Counter: metrics.UnmarshalErrors.With("test:metered-b"), want: 0},
+	}
+	for _, c := range counters {
+		c.before = c.Value()
+	}
+
+	src := ddp.Addr{Network: startup, Node: 50}
+	dst := ddp.Addr{Network: startup, Node: 10}
+	pak := etherDDP(t, macEth, extPacket(src, dst, aep.Socket, ddp.ProtoAEP, []byte{1, 2}))
+	a.Send(pak)
+	_, err := b.Recv()
+	require.NoError(t, err)
+
+	bad := pak
+	bad.Payload = []byte{0x00}
+	a.Send(bad)
+	_, err = b.Recv()
+	require.NoError(t, err)
+
+	for _, c := range counters {
+		assert.Equal(c.want, c.Value()-c.before, c.name)
+	}
+}
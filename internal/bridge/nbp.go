@@ -0,0 +1,204 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/macroman"
+	"github.com/sfiera/multitalk/pkg/nbp"
+	"github.com/sfiera/multitalk/pkg/rtmp"
+	"github.com/sfiera/multitalk/pkg/zip"
+)
+
+const (
+	// NBP types of the router’s own entities.
+	nbpTypeMultiTalk = "multitalk"
+	nbpTypeRouter    = "AppleTalk Router"
+)
+
+// An NBP entity registered on one of the router’s sockets.
+type name struct {
+	object, typ string
+	socket      ddp.Socket
+}
+
+// Returns the names that the router registers for itself, given the
+// object name to register them under.
+func names(object string) []name {
+	if object == "" {
+		return nil
+	}
+	return []name{
+		{object, nbpTypeMultiTalk, nbp.Socket},
+		{object, nbpTypeRouter, rtmp.Socket},
+	}
+}
+
+// Handles NBP packets sent to the router, or broadcast.
+//
+// A LkUp is answered if it matches one of the router’s own names.
+//
+// A BrRq asks the router to look up a name in a zone. The router
+// broadcasts a LkUp on each directly connected network in the zone, and
+// sends a FwdReq to the router of each other network in the zone, which
+// broadcasts the LkUp there. Nodes with matching names reply directly to
+// the address in the request, so replies need no special handling.
+func (r *router) handleNBP(from side, req ddp.ExtPacket) {
+	pak := nbp.Packet{}
+	if len(r.zones) == 0 {
+		// A router that doesn’t seed has no zones or names of its own.
+		// Broadcasts still cross it, so lookups reach LocalTalk nodes.
+		return
+	} else if nbp.Unmarshal(req.Data, &pak) != nil || len(pak.Tuples) != 1 {
+		return
+	}
+	t := &pak.Tuples[0]
+	if t.Addr.Network == 0 {
+		t.Addr.Network = req.SrcNet
+	}
+	if t.Entity.Zone == "" || t.Entity.Zone == nbp.ThisZone {
+		// Only nodes on the LocalTalk network may omit the zone, which
+		// is the default zone. On the extended network, the zone of the
+		// requester is unknown.
+		if from != localSide {
+			return
+		}
+		t.Entity.Zone = r.zones[0]
+	}
+
+	switch pak.Function {
+	case nbp.LkUp:
+		r.answer(from, pak)
+
+	case nbp.BrRq:
+		r.lookup(pak, 0)
+		pak.Function = nbp.FwdReq
+		for _, rt := range r.zoneRoutes(t.Entity.Zone) {
+			dst := ddp.Addr{Network: rt.rng.Start}
+			r.sendNBP(rt.via, dst, pak)
+		}
+
+	case nbp.FwdReq:
+		r.lookup(pak, req.DstNet)
+	}
+}
+
+// Broadcasts a LkUp on the directly connected networks in the request’s
+// zone. If net is nonzero, only on that network.
+func (r *router) lookup(pak nbp.Packet, net ddp.Network) {
+	pak.Function = nbp.LkUp
+	zone := pak.Tuples[0].Entity.Zone
+	broadcast := ddp.Addr{Node: 0xff}
+
+	if (net == 0 || r.rng.Contains(net)) && hasZone(r.zones, zone) {
+		data, err := nbp.Marshal(pak)
+		if err != nil {
+			return
+		}
+		ext := r.packet(extSide, nbp.Socket, broadcast, nbp.Socket, ddp.ProtoNBP, data)
+		out, err := ethertalk.AppleTalk(r.eth, ext)
+		if err != nil {
+			return
+		}
+		out.Dst = zip.Multicast(zone)
+		r.emit(*out)
+	}
+
+	// The LocalTalk network is in the default zone, whether or not it is
+	// part of the extended network.
+	if (net == 0 || net == r.network || (r.isBridged() && r.rng.Contains(net))) &&
+		macroman.EqualFold(zone, r.zones[0]) {
+		r.sendNBP(localSide, broadcast, pak)
+	}
+
+	// The router doesn’t receive its own broadcasts, so it checks its
+	// own names directly.
+	r.answer(r.sideOf(pak.Tuples[0].Addr), pak)
+}
+
+// Replies to a LkUp with any of the router’s names that match it.
+// The router’s node is in the default zone, on both sides.
+func (r *router) answer(to side, pak nbp.Packet) {
+	query := pak.Tuples[0]
+	zone := query.Entity.Zone
+	if zone != "" && zone != nbp.ThisZone && !macroman.EqualFold(zone, r.zones[0]) {
+		return
+	}
+
+	self := r.self()
+	if self == 0 {
+		return
+	}
+	addr := ddp.Addr{Network: r.network, Node: self}
+	if to == extSide {
+		addr.Network = r.extNetwork()
+	}
+	reply := nbp.LookupReply(pak.ID)
+	for i, n := range r.names {
+		e := nbp.Entity{Object: n.object, Type: n.typ, Zone: nbp.ThisZone}
+		if !e.Matches(query.Entity) {
+			continue
+		}
+		reply.Tuples = append(reply.Tuples, nbp.Tuple{
+			Addr:       addr,
+			Socket:     n.socket,
+			Enumerator: uint8(i),
+			Entity:     e,
+		})
+	}
+	if len(reply.Tuples) == 0 {
+		return
+	}
+
+	data, err := nbp.Marshal(reply)
+	if err != nil {
+		return
+	}
+	r.send(to, r.packet(to, nbp.Socket, query.Addr, query.Socket, ddp.ProtoNBP, data))
+}
+
+// Returns the side of the router that addr is on.
+func (r *router) sideOf(addr ddp.Addr) side {
+	if _, ok := r.nextHop(addr.Network, localSide); ok {
+		return localSide
+	} else if !r.isLocal(addr.Network) {
+		return extSide
+	} else if !r.isBridged() || r.amt.Owns(addr) {
+		return localSide
+	}
+	return extSide
+}
+
+func (r *router) sendNBP(to side, dst ddp.Addr, pak nbp.Packet) {
+	data, err := nbp.Marshal(pak)
+	if err != nil {
+		return
+	}
+	r.send(to, r.packet(to, nbp.Socket, dst, nbp.Socket, ddp.ProtoNBP, data))
+}
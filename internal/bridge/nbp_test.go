@@ -0,0 +1,98 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+	"github.com/sfiera/multitalk/pkg/nbp"
+	"github.com/sfiera/multitalk/pkg/zip"
+)
+
+func nbpRequest(t *testing.T, from ddp.Addr, zone string) ddp.ExtPacket {
+	query := nbp.Entity{Object: nbp.Wildcard, Type: "LaserWriter", Zone: zone}
+	data, err := nbp.Marshal(nbp.BroadcastRequest(1, from, 0xfd, query))
+	require.NoError(t, err)
+	return ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			DstNode: 0, DstSocket: nbp.Socket,
+			SrcNet: from.Network, SrcNode: from.Node, SrcSocket: 0xfd,
+			Proto: ddp.ProtoNBP,
+		},
+		Data: data,
+	}
+}
+
+func TestNBPThisZone(t *testing.T) {
+	assert := assert.New(t)
+	cable := ddp.Range{Start: 100, End: 109}
+	r := Extend(nil, Config{Network: 5, Range: cable, Zones: []string{"Lab", "Office"}}, nil).(*router)
+	r.queue = make(chan ethertalk.Packet, queueSize)
+	llapOut := make(chan llap.Packet, queueSize)
+	r.llapOut = llapOut
+
+	// From the extended network, the requester’s zone is unknown.
+	r.handleNBP(extSide, nbpRequest(t, ddp.Addr{Network: 101, Node: 7}, nbp.ThisZone))
+	assert.Empty(r.queue)
+	assert.Empty(llapOut)
+
+	// From LocalTalk, it is the default zone.
+	r.handleNBP(localSide, nbpRequest(t, ddp.Addr{Network: 5, Node: 7}, nbp.ThisZone))
+	if assert.Len(r.queue, 1) {
+		out := <-r.queue
+		assert.Equal(zip.Multicast("Lab"), out.Dst)
+	}
+	assert.Len(llapOut, 1)
+
+	// The other zones of the cable can be named.
+	r.handleNBP(extSide, nbpRequest(t, ddp.Addr{Network: 101, Node: 7}, "Office"))
+	if assert.Len(r.queue, 1) {
+		out := <-r.queue
+		assert.Equal(zip.Multicast("Office"), out.Dst)
+	}
+	assert.Len(llapOut, 1)
+}
+
+func TestNBPNoSeed(t *testing.T) {
+	r := Extend(nil, Config{Network: 5}, nil).(*router)
+	r.queue = make(chan ethertalk.Packet, queueSize)
+	llapOut := make(chan llap.Packet, queueSize)
+	r.llapOut = llapOut
+
+	// Without zones, the router ignores lookups, even in named zones.
+	r.handle(extSide, nbpRequest(t, ddp.Addr{Network: 0xff00, Node: 7}, "Office"))
+	r.handle(localSide, nbpRequest(t, ddp.Addr{Network: 5, Node: 7}, nbp.ThisZone))
+	assert.Empty(t, r.queue)
+	assert.Empty(t, llapOut)
+}
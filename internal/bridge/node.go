@@ -0,0 +1,383 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/amt"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/zip"
+)
+
+// Time to wait for a router to answer ZIP GetNetInfo.
+const netInfoTimeout = time.Second
+
+type (
+	// A Node is an EtherTalk node of multitalk’s own, for sending and
+	// receiving DDP packets. It joins a Group like any ExtBridge, and
+	// acquires an address on the extended network with AARP. Programs
+	// open sockets on it with ListenDDP.
+	Node struct {
+		rng ddp.Range
+		eth ethernet.Addr
+		amt *amt.Table
+
+		queue chan ethertalk.Packet
+		ready chan struct{}
+
+		mu        sync.Mutex
+		addr      ddp.Addr
+		tentative ddp.Addr
+		conflict  bool
+		router    *ethernet.Addr
+		netInfo   chan zip.NetInfoReply
+		sockets   map[ddp.Socket]*socket
+	}
+)
+
+// NewNode creates a Node that acquires an address in rng.
+//
+// If rng is zero, the node asks a router for the cable range of the
+// network with ZIP GetNetInfo. If no router answers, the node keeps an
+// address in the startup range.
+func NewNode(rng ddp.Range) *Node {
+	n := &Node{
+		rng:   rng,
+		amt:   amt.New(amt.DefaultMaxAge),
+		queue: make(chan ethertalk.Packet, queueSize),
+		ready: make(chan struct{}),
+
+		sockets: map[ddp.Socket]*socket{},
+	}
+	// A random, locally-administered unicast address.
+	rand.Read(n.eth[:])
+	n.eth[0] = (n.eth[0] | 0x02) &^ 0x01
+	return n
+}
+
+func (n *Node) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- ethertalk.Packet,
+	recv <-chan ethertalk.Packet,
+) {
+	sendCh := make(chan ethertalk.Packet)
+	go n.capture(sendCh)
+	go n.start(ctx, log)
+	return sendCh, n.queue
+}
+
+// Addr returns the node’s address, waiting until it is acquired.
+func (n *Node) Addr(ctx context.Context) (ddp.Addr, error) {
+	select {
+	case <-n.ready:
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return n.addr, nil
+	case <-ctx.Done():
+		return ddp.Addr{}, fmt.Errorf("acquire address: %s", ctx.Err().Error())
+	}
+}
+
+// Sends a DDP packet from the node. The source address and size are
+// filled in.
+func (n *Node) send(pak ddp.ExtPacket) error {
+	n.mu.Lock()
+	pak.SrcNet, pak.SrcNode = n.addr.Network, n.addr.Node
+	router := n.router
+	n.mu.Unlock()
+	if pak.SrcNode == 0 {
+		return fmt.Errorf("send: no address")
+	}
+	pak.Size = uint16(ddp.ExtHeaderSize + len(pak.Data))
+
+	if pak.DstNode == pak.SrcNode && (pak.DstNet == 0 || pak.DstNet == pak.SrcNet) {
+		n.deliver(pak)
+		return nil
+	}
+
+	out, err := ethertalk.AppleTalk(n.eth, pak)
+	if err != nil {
+		return err
+	} else if pak.DstNode == 0xff {
+		n.emit(*out)
+		return nil
+	}
+
+	dst := ddp.Addr{Network: pak.DstNet, Node: pak.DstNode}
+	if hw, ok := n.amt.Lookup(dst); ok {
+		out.Dst = hw
+	} else if !n.isLocal(dst.Network) && router != nil {
+		out.Dst = *router
+	} else if n.amt.Miss(dst) {
+		src := aarp.AddrPair{Hardware: n.eth, Proto: ddp.Addr{Network: pak.SrcNet, Node: pak.SrcNode}}
+		if req, err := ethertalk.AARP(n.eth, aarp.Request(src, dst)); err == nil {
+			n.emit(*req)
+		}
+	}
+	n.emit(*out)
+	return nil
+}
+
+func (n *Node) isLocal(net ddp.Network) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return net == 0 || n.rng.Contains(net)
+}
+
+func (n *Node) emit(packet ethertalk.Packet) {
+	select {
+	case n.queue <- packet:
+	default:
+		metrics.QueueDrops.With(n.String()).Inc()
+	}
+}
+
+func (n *Node) String() string {
+	return "node:" + n.eth.String()
+}
+
+func (n *Node) start(ctx context.Context, log *zap.Logger) {
+	learn := n.rng.IsZero()
+	rng := n.rng
+	if learn {
+		rng = ddp.StartupRange
+	}
+	if !n.acquire(ctx, rng) {
+		log.Error("no address available for node")
+		return
+	}
+
+	if learn {
+		if reply, ok := n.getNetInfo(ctx); ok && !reply.Range.IsZero() {
+			n.amt.Release(n.addr)
+			if !n.acquire(ctx, reply.Range) {
+				log.Error("no address available for node")
+				return
+			}
+		}
+	}
+
+	log.With(zap.Stringer("addr", n.addr)).Debug("node address acquired")
+	close(n.ready)
+}
+
+// Picks a random address in rng, and probes for it until one is free.
+func (n *Node) acquire(ctx context.Context, rng ddp.Range) bool {
+	n.mu.Lock()
+	n.rng = rng
+	n.mu.Unlock()
+
+	for attempt := 0; attempt < 0x100; attempt++ {
+		addr := ddp.Addr{
+			Network: rng.Start + ddp.Network(random(int(rng.End-rng.Start)+1)),
+			Node:    ddp.Node(1 + random(0xfd)),
+		}
+		if n.probe(ctx, addr) {
+			n.mu.Lock()
+			n.addr = addr
+			n.mu.Unlock()
+			n.amt.Defend(addr)
+			return true
+		} else if ctx.Err() != nil {
+			return false
+		}
+	}
+	return false
+}
+
+func (n *Node) probe(ctx context.Context, addr ddp.Addr) bool {
+	n.mu.Lock()
+	n.tentative = addr
+	n.conflict = false
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.tentative = ddp.Addr{}
+		n.mu.Unlock()
+	}()
+
+	for i := 0; i < probeCount; i++ {
+		if probe, err := ethertalk.AARP(n.eth, aarp.Probe(n.eth, addr)); err == nil {
+			n.emit(*probe)
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(probeInterval):
+		}
+
+		n.mu.Lock()
+		conflict := n.conflict
+		n.mu.Unlock()
+		if conflict {
+			return false
+		}
+	}
+	return true
+}
+
+// Asks for the network’s cable range with ZIP GetNetInfo.
+func (n *Node) getNetInfo(ctx context.Context) (zip.NetInfoReply, bool) {
+	ch := make(chan zip.NetInfoReply, 1)
+	n.mu.Lock()
+	n.netInfo = ch
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.netInfo = nil
+		n.mu.Unlock()
+	}()
+
+	data, err := zip.Marshal(&zip.GetNetInfo{})
+	if err != nil {
+		return zip.NetInfoReply{}, false
+	}
+	n.send(ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			DstNode:   0xff,
+			DstSocket: zip.Socket,
+			SrcSocket: zip.Socket,
+			Proto:     ddp.ProtoZIP,
+		},
+		Data: data,
+	})
+
+	select {
+	case reply := <-ch:
+		return reply, true
+	case <-time.After(netInfoTimeout):
+		return zip.NetInfoReply{}, false
+	case <-ctx.Done():
+		return zip.NetInfoReply{}, false
+	}
+}
+
+// Handles packets from the Group.
+func (n *Node) capture(sendCh <-chan ethertalk.Packet) {
+	for packet := range sendCh {
+		switch packet.SNAPProto {
+		case ethertalk.AARPProto:
+			n.captureAARP(packet)
+		case ethertalk.AppleTalkProto:
+			n.captureDDP(packet)
+		}
+	}
+}
+
+func (n *Node) captureAARP(packet ethertalk.Packet) {
+	a := aarp.Packet{}
+	if aarp.Unmarshal(packet.Payload, &a) != nil {
+		return
+	}
+	n.amt.Learn(a)
+
+	switch a.Opcode {
+	case aarp.ProbeOp:
+		n.observe(a.Dst.Proto)
+	case aarp.ResponseOp, aarp.RequestOp:
+		n.observe(a.Src.Proto)
+	}
+
+	if resp := n.amt.Respond(n.eth, a); resp != nil {
+		if out, err := ethertalk.AARP(n.eth, *resp); err == nil {
+			out.Dst = a.Src.Hardware
+			n.emit(*out)
+		}
+	}
+}
+
+func (n *Node) captureDDP(packet ethertalk.Packet) {
+	ext := ddp.ExtPacket{}
+	if ddp.ExtUnmarshal(packet.Payload, &ext) != nil {
+		return
+	}
+	src := ddp.Addr{Network: ext.SrcNet, Node: ext.SrcNode}
+	if ext.Hops() == 0 && n.isLocal(ext.SrcNet) {
+		n.amt.Glean(src, packet.Src)
+	}
+	n.observe(src)
+
+	n.mu.Lock()
+	addr := n.addr
+	if ext.Proto == ddp.ProtoRTMPResp {
+		router := packet.Src
+		n.router = &router
+	}
+	netInfo := n.netInfo
+	n.mu.Unlock()
+
+	if netInfo != nil && ext.Proto == ddp.ProtoZIP {
+		if p, err := zip.Unmarshal(ext.Data); err == nil {
+			if reply, ok := p.(*zip.NetInfoReply); ok {
+				select {
+				case netInfo <- *reply:
+				default:
+				}
+			}
+		}
+	}
+
+	if addr.Node == 0 {
+		return
+	} else if ext.DstNode == 0xff {
+		if ext.DstNet != 0 && ext.DstNet != addr.Network {
+			return
+		}
+	} else if ext.DstNode != addr.Node || (ext.DstNet != 0 && ext.DstNet != addr.Network) {
+		return
+	}
+	n.deliver(ext)
+}
+
+// Notes that addr is in use by another node.
+func (n *Node) observe(addr ddp.Addr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.tentative.Node != 0 && addr == n.tentative {
+		n.conflict = true
+	}
+}
+
+func random(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return int(i.Int64())
+}
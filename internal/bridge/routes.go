@@ -0,0 +1,198 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/macroman"
+	"github.com/sfiera/multitalk/pkg/rtmp"
+	"github.com/sfiera/multitalk/pkg/zip"
+)
+
+const (
+	// Routes not refreshed by RTMP Data within this time are dropped.
+	routeMaxAge = 3 * rtmpInterval
+
+	// Routes further than this many hops away are unreachable.
+	maxDistance = 15
+)
+
+// A network that is reachable through another router.
+type route struct {
+	rng      ddp.Range
+	distance uint8
+	via      side
+	next     ddp.Addr // The router to send packets for the network to
+	zones    []string
+	updated  time.Time
+}
+
+// Returns true if the network is directly connected to the router.
+func (r *router) isConnected(net ddp.Network) bool {
+	return net == r.network || r.rng.Contains(net)
+}
+
+// Returns true if packets to net.node are for the router: either its own
+// address, or “any router” (node 0) on a directly connected network.
+func (r *router) isRouter(net ddp.Network, node ddp.Node) bool {
+	if node == 0 {
+		return r.self() != 0 && r.isConnected(net)
+	}
+	return r.isSelf(net, node)
+}
+
+// Updates the routing table from RTMP Data sent by another router, and
+// asks that router for the zones of any networks whose zones are unknown.
+func (r *router) learnRoutes(from side, data ddp.ExtPacket) {
+	pak := rtmp.Packet{}
+	if rtmp.Unmarshal(data.Data, &pak) != nil {
+		return
+	}
+	next := pak.Router
+	if next.Network == 0 {
+		next.Network = data.SrcNet
+	}
+	if r.isSelf(next.Network, next.Node) {
+		return
+	}
+
+	now := time.Now()
+	unknown := []ddp.Network{}
+	r.mu.Lock()
+	for _, t := range pak.Tuples {
+		if r.overlaps(t.Range) {
+			continue
+		}
+		rt, ok := r.routes[t.Range.Start]
+		if t.Distance >= maxDistance {
+			// Unreachable through next. If that was the route in use,
+			// drop it now, rather than when it expires.
+			if ok && rt.next == next {
+				delete(r.routes, t.Range.Start)
+			}
+			continue
+		} else if !ok {
+			rt = &route{}
+			r.routes[t.Range.Start] = rt
+		} else if rt.next != next && rt.distance <= t.Distance+1 {
+			continue // Already have a route at least as good.
+		}
+		rt.rng = t.Range
+		rt.distance = t.Distance + 1
+		rt.via = from
+		rt.next = next
+		rt.updated = now
+		if len(rt.zones) == 0 {
+			unknown = append(unknown, t.Range.Start)
+		}
+	}
+	r.mu.Unlock()
+
+	if len(unknown) > 0 {
+		data, err := zip.Marshal(&zip.Query{Networks: unknown})
+		if err != nil {
+			return
+		}
+		r.send(from, r.packet(from, zip.Socket, next, zip.Socket, ddp.ProtoZIP, data))
+	}
+}
+
+// Returns true if rng overlaps a directly connected network.
+func (r *router) overlaps(rng ddp.Range) bool {
+	if rng.Contains(r.network) {
+		return true
+	} else if r.rng.IsZero() {
+		return false
+	}
+	return rng.Start <= r.rng.End && r.rng.Start <= rng.End
+}
+
+// Records zones from a ZIP Reply, for networks in the routing table.
+func (r *router) learnZones(reply *zip.Reply) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, nz := range reply.Zones {
+		rt, ok := r.routes[nz.Network]
+		if !ok || hasZone(rt.zones, nz.Zone) {
+			continue
+		}
+		rt.zones = append(rt.zones, nz.Zone)
+	}
+}
+
+// Drops routes that have not been refreshed recently.
+func (r *router) expireRoutes() {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for net, rt := range r.routes {
+		if now.Sub(rt.updated) > routeMaxAge {
+			delete(r.routes, net)
+		}
+	}
+}
+
+// Returns the router to send packets for net to, if net is reachable
+// through another router on the given side.
+func (r *router) nextHop(net ddp.Network, via side) (ddp.Addr, bool) {
+	if net == 0 || r.isConnected(net) {
+		return ddp.Addr{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rt := range r.routes {
+		if rt.via == via && rt.rng.Contains(net) {
+			return rt.next, true
+		}
+	}
+	return ddp.Addr{}, false
+}
+
+// Returns the routes to networks in a zone.
+func (r *router) zoneRoutes(zone string) []route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	routes := []route{}
+	for _, rt := range r.routes {
+		if hasZone(rt.zones, zone) {
+			routes = append(routes, *rt)
+		}
+	}
+	return routes
+}
+
+func hasZone(zones []string, zone string) bool {
+	for _, z := range zones {
+		if macroman.EqualFold(z, zone) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/rtmp"
+)
+
+func rtmpData(t *testing.T, router ddp.Addr, tuples ...rtmp.Tuple) ddp.ExtPacket {
+	data, err := rtmp.Marshal(rtmp.Packet{Router: router, Extended: true, Tuples: tuples})
+	require.NoError(t, err)
+	return ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			DstNet: 0, DstNode: 0xff, DstSocket: rtmp.Socket,
+			SrcNet: router.Network, SrcNode: router.Node, SrcSocket: rtmp.Socket,
+			Proto: ddp.ProtoRTMPResp,
+		},
+		Data: data,
+	}
+}
+
+func TestLearnRoutes(t *testing.T) {
+	cable := ddp.Range{Start: 100, End: 109}
+	r := Extend(nil, Config{Network: 5, Range: cable, Zones: []string{"Lab"}}, nil).(*router)
+	a := ddp.Addr{Network: 100, Node: 10}
+	b := ddp.Addr{Network: 101, Node: 20}
+	tuple := func(start, end ddp.Network, distance uint8) rtmp.Tuple {
+		return rtmp.Tuple{Range: ddp.Range{Start: start, End: end}, Extended: true, Distance: distance}
+	}
+	distances := func() map[ddp.Network]uint8 {
+		d := map[ddp.Network]uint8{}
+		for net, rt := range r.routes {
+			d[net] = rt.distance
+		}
+		return d
+	}
+
+	// The tuple for the shared cable is not a route.
+	r.learnRoutes(extSide, rtmpData(t, a, tuple(100, 109, 0), tuple(20, 29, 0), tuple(30, 30, 1)))
+	assert.Equal(t, map[ddp.Network]uint8{20: 1, 30: 2}, distances())
+
+	// Worse routes through another router are ignored.
+	r.learnRoutes(extSide, rtmpData(t, b, tuple(100, 109, 0), tuple(30, 30, 3)))
+	assert.Equal(t, map[ddp.Network]uint8{20: 1, 30: 2}, distances())
+	assert.Equal(t, a, r.routes[30].next)
+
+	// Unreachable through another router: no effect.
+	r.learnRoutes(extSide, rtmpData(t, b, tuple(100, 109, 0), tuple(20, 29, maxDistance)))
+	assert.Equal(t, map[ddp.Network]uint8{20: 1, 30: 2}, distances())
+
+	// Unreachable through the next hop: dropped at once.
+	r.learnRoutes(extSide, rtmpData(t, a, tuple(100, 109, 0), tuple(20, 29, 0), tuple(30, 30, 31)))
+	assert.Equal(t, map[ddp.Network]uint8{20: 1}, distances())
+
+	// So the other router’s route is taken.
+	r.learnRoutes(extSide, rtmpData(t, b, tuple(100, 109, 0), tuple(30, 30, 3)))
+	assert.Equal(t, map[ddp.Network]uint8{20: 1, 30: 4}, distances())
+	assert.Equal(t, b, r.routes[30].next)
+}
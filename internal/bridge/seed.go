@@ -0,0 +1,511 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/aep"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+	"github.com/sfiera/multitalk/pkg/nbp"
+	"github.com/sfiera/multitalk/pkg/rtmp"
+	"github.com/sfiera/multitalk/pkg/zip"
+)
+
+const (
+	queueSize = 64
+
+	// Routers send RTMP Data packets this often.
+	rtmpInterval = 10 * time.Second
+
+	// Number of probes, and time between them, before a node is
+	// considered free.
+	probeCount    = 10
+	probeInterval = 200 * time.Millisecond
+)
+
+// Identifies which side of the router a packet came from, or is bound for.
+type side int
+
+const (
+	localSide = side(iota) // The LocalTalk network, via the Bridge
+	extSide                // The extended network, via the Group
+)
+
+// Acquires a node for the router, then advertises routes until ctx is done.
+func (r *router) seed(ctx context.Context, log *zap.Logger) {
+	log = log.With(zap.Stringer("range", r.rng))
+	if !r.acquire(ctx) {
+		log.Error("no node available for router")
+		return
+	}
+	node := r.self()
+	log.With(
+		zap.String("local", fmt.Sprintf("%d.%d", r.network, node)),
+		zap.String("ext", fmt.Sprintf("%d.%d", r.extNetwork(), node)),
+	).Info("router node acquired")
+
+	ticker := time.NewTicker(rtmpInterval)
+	defer ticker.Stop()
+	for {
+		r.advertise()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.expireRoutes()
+		}
+	}
+}
+
+// Returns true if the LocalTalk network is part of the extended network,
+// rather than a separate network behind the router.
+func (r *router) isBridged() bool {
+	return r.rng.Contains(r.network)
+}
+
+// Returns the network of the router’s address on the extended network.
+func (r *router) extNetwork() ddp.Network {
+	if r.isBridged() {
+		return r.network
+	}
+	return r.rng.Start
+}
+
+func (r *router) self() ddp.Node {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.node
+}
+
+// Returns true if net.node is the router’s own address.
+func (r *router) isSelf(net ddp.Network, node ddp.Node) bool {
+	self := r.self()
+	if self == 0 || node != self {
+		return false
+	}
+	return net == 0 || net == r.network || net == r.extNetwork()
+}
+
+// Picks a node for the router, from the top of the server range down.
+// Each candidate is probed on both sides of the router. Node 254 is
+// valid on LocalTalk, but reserved on extended networks, so the search
+// starts below it.
+func (r *router) acquire(ctx context.Context) bool {
+	for node := ddp.Node(0xfd); node >= 0x80; node-- {
+		if r.amt.Owns(ddp.Addr{Network: r.network, Node: node}) {
+			continue // Already proxied for a LocalTalk node.
+		}
+		if r.probe(ctx, node) {
+			r.mu.Lock()
+			r.node = node
+			r.mu.Unlock()
+			r.amt.Defend(ddp.Addr{Network: r.network, Node: node})
+			r.amt.Defend(ddp.Addr{Network: r.extNetwork(), Node: node})
+			return true
+		} else if ctx.Err() != nil {
+			return false
+		}
+	}
+	return false
+}
+
+func (r *router) probe(ctx context.Context, node ddp.Node) bool {
+	r.mu.Lock()
+	r.tentative = node
+	r.conflict = false
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.tentative = 0
+		r.mu.Unlock()
+	}()
+
+	ext := ddp.Addr{Network: r.extNetwork(), Node: node}
+	for i := 0; i < probeCount; i++ {
+		r.llapOut <- *llap.Enq(node, node)
+		if probe, err := ethertalk.AARP(r.eth, aarp.Probe(r.eth, ext)); err == nil {
+			r.emit(*probe)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(probeInterval):
+		}
+
+		r.mu.Lock()
+		conflict := r.conflict
+		r.mu.Unlock()
+		if conflict {
+			return false
+		}
+	}
+	return true
+}
+
+// Notes that addr is in use on the extended network.
+func (r *router) observe(addr ddp.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tentative != 0 && addr.Node == r.tentative &&
+		(addr.Network == r.network || addr.Network == r.extNetwork()) {
+		r.conflict = true
+	}
+}
+
+// Notes that node is in use on the LocalTalk network.
+func (r *router) observeNode(node ddp.Node) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tentative != 0 && node == r.tentative {
+		r.conflict = true
+	}
+}
+
+// Handles LocalTalk packets addressed to the router. Returns true if the
+// packet was consumed, and should not be forwarded.
+func (r *router) receive(packet llap.Packet) bool {
+	if packet.Kind == llap.TypeRTS {
+		return r.clear(packet)
+	}
+
+	self := r.self()
+	if self == 0 {
+		return false
+	}
+
+	switch packet.Kind {
+	case llap.TypeEnq:
+		if packet.DstNode != self {
+			return false
+		}
+		r.llapOut <- *llap.Ack(self, self)
+		return true
+
+	case llap.TypeDDP:
+		if packet.DstNode != self && packet.DstNode != 0xff {
+			return false
+		}
+		d := ddp.Packet{}
+		if ddp.Unmarshal(packet.Payload, &d) != nil {
+			return false
+		}
+		r.handle(localSide, ddp.ShortToExt(d, r.network, packet.DstNode, packet.SrcNode))
+		return packet.DstNode == self
+
+	case llap.TypeExtDDP:
+		ext := ddp.ExtPacket{}
+		if ddp.ExtUnmarshal(packet.Payload, &ext) != nil {
+			return false
+		} else if r.isRouter(ext.DstNet, ext.DstNode) {
+			r.handle(localSide, ext)
+			return true
+		} else if ext.DstNode == 0xff && r.isLocal(ext.DstNet) {
+			r.handle(localSide, ext)
+		}
+		return false
+
+	default:
+		return false
+	}
+}
+
+// Answers an RTS with a CTS, if it is for the router, or for an EtherTalk
+// node that appears on LocalTalk through the router. Returns true if it
+// was answered.
+func (r *router) clear(packet llap.Packet) bool {
+	if node := packet.DstNode; node == 0 || node != r.self() {
+		addr := ddp.Addr{Network: r.network, Node: node}
+		if _, ok := r.amt.Lookup(addr); !ok || r.amt.Owns(addr) {
+			return false
+		}
+	}
+	r.llapOut <- *llap.CTS(packet.SrcNode, packet.DstNode)
+	return true
+}
+
+// Handles a DDP packet addressed to the router, or broadcast.
+func (r *router) handle(from side, ext ddp.ExtPacket) {
+	switch {
+	case ext.DstSocket == rtmp.Socket && ext.Proto == ddp.ProtoRTMPReq:
+		r.handleRTMPRequest(from, ext)
+	case ext.DstSocket == rtmp.Socket && ext.Proto == ddp.ProtoRTMPResp:
+		r.learnRoutes(from, ext)
+	case ext.DstSocket == nbp.Socket && ext.Proto == ddp.ProtoNBP:
+		r.handleNBP(from, ext)
+	case ext.DstSocket == zip.Socket && ext.Proto == ddp.ProtoZIP:
+		r.handleZIP(from, ext)
+	case ext.DstSocket == aep.Socket && ext.Proto == ddp.ProtoAEP:
+		r.handleAEP(from, ext)
+	}
+}
+
+// Answers echo requests sent directly to the router.
+func (r *router) handleAEP(from side, req ddp.ExtPacket) {
+	pak := aep.Packet{}
+	if !r.isSelf(req.DstNet, req.DstNode) || aep.Unmarshal(req.Data, &pak) != nil {
+		return
+	} else if pak.Function != aep.Request {
+		return
+	}
+	data, err := aep.Marshal(pak.Echo())
+	if err != nil {
+		return
+	}
+
+	// Reply from whichever of the router’s addresses was pinged.
+	dst := ddp.Addr{Network: req.SrcNet, Node: req.SrcNode}
+	reply := r.packet(from, aep.Socket, dst, req.SrcSocket, ddp.ProtoAEP, data)
+	if req.DstNet != 0 {
+		reply.SrcNet = req.DstNet
+	}
+	r.send(from, reply)
+}
+
+func (r *router) handleRTMPRequest(from side, req ddp.ExtPacket) {
+	if len(req.Data) < 1 {
+		return
+	}
+	var resp rtmp.Packet
+	switch req.Data[0] {
+	case rtmp.FuncRequest:
+		// Responses carry only the header, and for extended networks,
+		// the cable range.
+		resp = r.rtmpData(from)
+		if resp.Extended {
+			resp.Tuples = resp.Tuples[:1]
+		} else {
+			resp.Tuples = nil
+		}
+	case rtmp.FuncRDRSplit, rtmp.FuncRDRNoSplit:
+		resp = r.rtmpData(from)
+	default:
+		return
+	}
+
+	data, err := rtmp.Marshal(resp)
+	if err != nil {
+		return
+	}
+	r.reply(from, req, ddp.ProtoRTMPResp, data)
+}
+
+// Returns the RTMP Data that the router sends to one side.
+func (r *router) rtmpData(to side) rtmp.Packet {
+	self := r.self()
+	ext := rtmp.Tuple{Range: r.rng, Extended: true}
+	local := rtmp.Tuple{Range: ddp.Range{Start: r.network, End: r.network}}
+
+	if to == extSide {
+		pak := rtmp.Packet{
+			Router:   ddp.Addr{Network: r.extNetwork(), Node: self},
+			Extended: true,
+			Tuples:   []rtmp.Tuple{ext},
+		}
+		if !r.isBridged() {
+			pak.Tuples = append(pak.Tuples, local)
+		}
+		return pak
+	}
+
+	pak := rtmp.Packet{
+		Router: ddp.Addr{Network: r.network, Node: self},
+		Tuples: []rtmp.Tuple{local},
+	}
+	if !r.isBridged() {
+		pak.Tuples = append(pak.Tuples, ext)
+	}
+	return pak
+}
+
+// Broadcasts RTMP Data to both sides of the router.
+func (r *router) advertise() {
+	for _, to := range []side{localSide, extSide} {
+		data, err := rtmp.Marshal(r.rtmpData(to))
+		if err != nil {
+			continue
+		}
+		r.send(to, r.packet(to, rtmp.Socket, ddp.Addr{Node: 0xff}, rtmp.Socket, ddp.ProtoRTMPResp, data))
+	}
+}
+
+func (r *router) handleZIP(from side, req ddp.ExtPacket) {
+	pak, err := zip.Unmarshal(req.Data)
+	if err != nil {
+		return
+	}
+
+	switch p := pak.(type) {
+	case *zip.GetNetInfo:
+		if from == extSide {
+			r.handleGetNetInfo(req, p)
+		}
+	case *zip.Query:
+		r.handleZIPQuery(from, req, p)
+	case *zip.Reply:
+		r.learnZones(p)
+	}
+}
+
+func (r *router) handleGetNetInfo(req ddp.ExtPacket, p *zip.GetNetInfo) {
+	if len(r.zones) == 0 {
+		// Without zones, the router has no network information to give.
+		return
+	}
+	reply := &zip.NetInfoReply{Range: r.rng, Zone: p.Zone}
+	zone := p.Zone
+	if !hasZone(r.zones, zone) {
+		reply.Flags |= zip.FlagZoneInvalid
+		reply.DefaultZone = r.zones[0]
+		zone = r.zones[0]
+	}
+	if len(r.zones) == 1 {
+		reply.Flags |= zip.FlagOnlyOneZone
+	}
+	multicast := zip.Multicast(zone)
+	reply.Multicast = multicast[:]
+
+	// A node still in the startup range can’t receive a directed reply.
+	broadcast := !r.rng.Contains(req.SrcNet)
+	if broadcast {
+		reply.Flags |= zip.FlagUseBroadcast
+	}
+
+	data, err := zip.Marshal(reply)
+	if err != nil {
+		return
+	} else if broadcast {
+		dst := ddp.Addr{Node: 0xff}
+		r.send(extSide, r.packet(extSide, zip.Socket, dst, req.SrcSocket, ddp.ProtoZIP, data))
+		return
+	}
+	r.reply(extSide, req, ddp.ProtoZIP, data)
+}
+
+func (r *router) handleZIPQuery(from side, req ddp.ExtPacket, q *zip.Query) {
+	if len(r.zones) == 0 {
+		return
+	}
+	reply := &zip.Reply{}
+	var extReplies []*zip.Reply
+	for _, n := range q.Networks {
+		switch {
+		case n == r.rng.Start && len(r.zones) > 1:
+			ext := &zip.Reply{Extended: true, Count: uint8(len(r.zones))}
+			for _, z := range r.zones {
+				ext.Zones = append(ext.Zones, zip.NetworkZone{Network: n, Zone: z})
+			}
+			extReplies = append(extReplies, ext)
+		case n == r.rng.Start || n == r.network:
+			reply.Zones = append(reply.Zones, zip.NetworkZone{Network: n, Zone: r.zones[0]})
+		}
+	}
+
+	if len(reply.Zones) > 0 {
+		extReplies = append(extReplies, reply)
+	}
+	for _, rep := range extReplies {
+		data, err := zip.Marshal(rep)
+		if err != nil {
+			continue
+		}
+		r.reply(from, req, ddp.ProtoZIP, data)
+	}
+}
+
+// Sends data back to the sender of req, from the socket it was sent to.
+func (r *router) reply(to side, req ddp.ExtPacket, proto uint8, data []byte) {
+	dst := ddp.Addr{Network: req.SrcNet, Node: req.SrcNode}
+	r.send(to, r.packet(to, req.DstSocket, dst, req.SrcSocket, proto, data))
+}
+
+// Builds a packet from the router’s address on one side.
+func (r *router) packet(
+	from side,
+	srcSocket ddp.Socket,
+	dst ddp.Addr,
+	dstSocket ddp.Socket,
+	proto uint8,
+	data []byte,
+) ddp.ExtPacket {
+	src := ddp.Addr{Network: r.network, Node: r.self()}
+	if from == extSide {
+		src.Network = r.extNetwork()
+	}
+	return ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			Size:      uint16(ddp.ExtHeaderSize + len(data)),
+			DstNet:    dst.Network,
+			DstNode:   dst.Node,
+			DstSocket: dstSocket,
+			SrcNet:    src.Network,
+			SrcNode:   src.Node,
+			SrcSocket: srcSocket,
+			Proto:     proto,
+		},
+		Data: data,
+	}
+}
+
+// Sends a packet originating from the router to one side.
+func (r *router) send(to side, ext ddp.ExtPacket) {
+	if to == localSide {
+		var out *llap.Packet
+		var err error
+		if r.isLocal(ext.SrcNet) && r.isLocal(ext.DstNet) {
+			out, err = llap.AppleTalk(ext.DstNode, ext.SrcNode, ddp.ExtToShort(ext))
+		} else if hop, ok := r.nextHop(ext.DstNet, localSide); ok {
+			out, err = llap.ExtAppleTalk(hop.Node, ext.SrcNode, ext)
+		} else {
+			out, err = llap.ExtAppleTalk(ext.DstNode, ext.SrcNode, ext)
+		}
+		if err == nil {
+			r.llapOut <- *out
+		}
+		return
+	}
+
+	out, err := ethertalk.AppleTalk(r.eth, ext)
+	if err != nil {
+		return
+	}
+	if ext.DstNode != 0xff {
+		if req := r.resolve(out, ext); req != nil {
+			r.emit(*req)
+		}
+	}
+	r.emit(*out)
+}
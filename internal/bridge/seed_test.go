@@ -0,0 +1,74 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+	"github.com/sfiera/multitalk/pkg/zip"
+)
+
+func zipRequest(t *testing.T, from ddp.Addr, pak zip.Packet) ddp.ExtPacket {
+	data, err := zip.Marshal(pak)
+	require.NoError(t, err)
+	return ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			DstNode: 0xff, DstSocket: zip.Socket,
+			SrcNet: from.Network, SrcNode: from.Node, SrcSocket: zip.Socket,
+			Proto: ddp.ProtoZIP,
+		},
+		Data: data,
+	}
+}
+
+func TestZIPNoZones(t *testing.T) {
+	for _, cfg := range []Config{
+		{Network: 5},
+		{Network: 5, Range: ddp.Range{Start: 100, End: 109}},
+	} {
+		r := Extend(nil, cfg, nil).(*router)
+		r.queue = make(chan ethertalk.Packet, queueSize)
+		llapOut := make(chan llap.Packet, queueSize)
+		r.llapOut = llapOut
+
+		// Without zones, the router ignores ZIP requests instead of
+		// answering them from an empty zone list.
+		node := ddp.Addr{Network: ddp.StartupRange.Start, Node: 7}
+		r.handle(extSide, zipRequest(t, node, &zip.GetNetInfo{Zone: "Lab"}))
+		r.handle(extSide, zipRequest(t, node, &zip.Query{Networks: []ddp.Network{5, 100}}))
+		r.handle(localSide, zipRequest(t, ddp.Addr{Network: 5, Node: 7}, &zip.Query{Networks: []ddp.Network{5}}))
+		assert.Empty(t, r.queue)
+		assert.Empty(t, llapOut)
+	}
+}
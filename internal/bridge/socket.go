@@ -0,0 +1,162 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+const (
+	// Sockets assigned by ListenDDP when none is requested.
+	dynamicSocketStart = ddp.Socket(0x80)
+	dynamicSocketEnd   = ddp.Socket(0xfe)
+)
+
+type (
+	// A DDP socket on a Node. Implements ddp.Conn.
+	socket struct {
+		n      *Node
+		addr   ddp.SocketAddr
+		recv   chan datagram
+		closed chan struct{}
+		once   sync.Once
+	}
+
+	datagram struct {
+		from  ddp.SocketAddr
+		proto uint8
+		data  []byte
+	}
+)
+
+// ListenDDP opens a DDP socket on the node, waiting until the node has
+// acquired its address. If sock is zero, a free socket is chosen from the
+// dynamically-assigned range.
+//
+// The socket receives packets sent to it, including broadcasts from
+// other nodes, and packets that the node sends to its own address.
+func (n *Node) ListenDDP(ctx context.Context, sock ddp.Socket) (ddp.Conn, error) {
+	addr, err := n.Addr(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %s", err.Error())
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if sock == 0 {
+		for s := dynamicSocketStart; s <= dynamicSocketEnd; s++ {
+			if _, ok := n.sockets[s]; !ok {
+				sock = s
+				break
+			}
+		}
+		if sock == 0 {
+			return nil, fmt.Errorf("listen: no free socket")
+		}
+	} else if sock == 0xff {
+		return nil, fmt.Errorf("listen: invalid socket %d", sock)
+	} else if _, ok := n.sockets[sock]; ok {
+		return nil, fmt.Errorf("listen %d: socket in use", sock)
+	}
+
+	s := &socket{
+		n:      n,
+		addr:   ddp.SocketAddr{Network: addr.Network, Node: addr.Node, Socket: sock},
+		recv:   make(chan datagram, queueSize),
+		closed: make(chan struct{}),
+	}
+	n.sockets[sock] = s
+	return s, nil
+}
+
+// Passes a packet to the socket it is addressed to, if any.
+func (n *Node) deliver(ext ddp.ExtPacket) {
+	n.mu.Lock()
+	s, ok := n.sockets[ext.DstSocket]
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+	d := datagram{
+		from:  ddp.SocketAddr{Network: ext.SrcNet, Node: ext.SrcNode, Socket: ext.SrcSocket},
+		proto: ext.Proto,
+		data:  ext.Data,
+	}
+	select {
+	case s.recv <- d:
+	default:
+	}
+}
+
+func (s *socket) ReadFrom(p []byte) (int, uint8, ddp.SocketAddr, error) {
+	select {
+	case d := <-s.recv:
+		return copy(p, d.data), d.proto, d.from, nil
+	case <-s.closed:
+		return 0, 0, ddp.SocketAddr{}, ddp.ErrClosed
+	}
+}
+
+func (s *socket) WriteTo(p []byte, proto uint8, to ddp.SocketAddr) error {
+	select {
+	case <-s.closed:
+		return ddp.ErrClosed
+	default:
+	}
+	if len(p) > ddp.MaxDataSize {
+		return fmt.Errorf("write to %s: data too long (%d > %d)", to, len(p), ddp.MaxDataSize)
+	}
+	return s.n.send(ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			DstNet:    to.Network,
+			DstNode:   to.Node,
+			DstSocket: to.Socket,
+			SrcSocket: s.addr.Socket,
+			Proto:     proto,
+		},
+		Data: append([]byte{}, p...),
+	})
+}
+
+func (s *socket) LocalAddr() ddp.SocketAddr {
+	return s.addr
+}
+
+func (s *socket) Close() error {
+	s.once.Do(func() {
+		s.n.mu.Lock()
+		delete(s.n.sockets, s.addr.Socket)
+		s.n.mu.Unlock()
+		close(s.closed)
+	})
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package bridge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+const testTimeout = 10 * time.Second
+
+// Reads a datagram from conn, failing if none arrives in time.
+func readDatagram(t *testing.T, conn ddp.Conn) (datagram, bool) {
+	ch := make(chan datagram, 1)
+	go func() {
+		buf := make([]byte, ddp.MaxDataSize)
+		n, proto, from, err := conn.ReadFrom(buf)
+		if err == nil {
+			ch <- datagram{from, proto, buf[:n]}
+		}
+	}()
+	select {
+	case d := <-ch:
+		return d, true
+	case <-time.After(time.Second):
+		t.Errorf("read from %s: timed out", conn.LocalAddr())
+		return datagram{}, false
+	}
+}
+
+func TestListenDDP(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	rng := ddp.Range{Start: 5, End: 5}
+	a, b := NewNode(rng), NewNode(rng)
+	grp := NewGroup(zap.NewNop())
+	grp.Add(a.Start(ctx, zap.NewNop()))
+	grp.Add(b.Start(ctx, zap.NewNop()))
+	go grp.Run()
+
+	ca, err := a.ListenDDP(ctx, 0)
+	require.NoError(t, err)
+	defer ca.Close()
+	cb, err := b.ListenDDP(ctx, 10)
+	require.NoError(t, err)
+	defer cb.Close()
+
+	assert.Equal(ddp.Network(5), ca.LocalAddr().Network)
+	assert.Equal(dynamicSocketStart, ca.LocalAddr().Socket)
+	assert.Equal(ddp.Socket(10), cb.LocalAddr().Socket)
+	assert.NotEqual(ca.LocalAddr().Node, cb.LocalAddr().Node)
+
+	_, err = b.ListenDDP(ctx, 10)
+	if assert.Error(err) {
+		assert.Equal("listen 10: socket in use", err.Error())
+	}
+
+	// Between nodes, through the group.
+	require.NoError(t, ca.WriteTo([]byte("hello"), ddp.ProtoAEP, cb.LocalAddr()))
+	if d, ok := readDatagram(t, cb); ok {
+		assert.Equal(datagram{ca.LocalAddr(), ddp.ProtoAEP, []byte("hello")}, d)
+	}
+
+	// Broadcast to the network.
+	bcast := ddp.SocketAddr{Network: 5, Node: 0xff, Socket: 10}
+	require.NoError(t, ca.WriteTo([]byte("all"), ddp.ProtoAEP, bcast))
+	if d, ok := readDatagram(t, cb); ok {
+		assert.Equal(datagram{ca.LocalAddr(), ddp.ProtoAEP, []byte("all")}, d)
+	}
+
+	// Between sockets of one node, without the group.
+	ca2, err := a.ListenDDP(ctx, 0)
+	require.NoError(t, err)
+	defer ca2.Close()
+	assert.Equal(dynamicSocketStart+1, ca2.LocalAddr().Socket)
+	require.NoError(t, ca2.WriteTo([]byte("self"), ddp.ProtoATP, ca.LocalAddr()))
+	if d, ok := readDatagram(t, ca); ok {
+		assert.Equal(datagram{ca2.LocalAddr(), ddp.ProtoATP, []byte("self")}, d)
+	}
+
+	// Closed sockets are freed.
+	require.NoError(t, cb.Close())
+	_, _, _, err = cb.ReadFrom(make([]byte, ddp.MaxDataSize))
+	assert.Equal(ddp.ErrClosed, err)
+	assert.Equal(ddp.ErrClosed, cb.WriteTo(nil, ddp.ProtoAEP, ca.LocalAddr()))
+	cb, err = b.ListenDDP(ctx, 10)
+	if assert.NoError(err) {
+		cb.Close()
+	}
+}
+
+func TestListenDDPTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewNode(ddp.Range{Start: 5, End: 5}).ListenDDP(ctx, 0)
+	if assert.Error(t, err) {
+		assert.Equal(t, "listen: acquire address: context canceled", err.Error())
+	}
+}
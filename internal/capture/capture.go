@@ -0,0 +1,224 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Records the traffic of bridges to a pcapng file
+//
+// Each bridge that a Writer wraps becomes one interface in the file, with
+// the Ethernet link type for EtherTalk bridges and the LocalTalk link type
+// for LLAP bridges. Packets are recorded in both directions.
+package capture
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+type (
+	Writer struct {
+		mu sync.Mutex
+		w  io.Writer
+		ng *pcapgo.NgWriter
+	}
+
+	extTap struct {
+		w    *Writer
+		name string
+		b    bridge.ExtBridge
+	}
+
+	tap struct {
+		w    *Writer
+		name string
+		b    bridge.Bridge
+	}
+)
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Records the packets of an EtherTalk bridge on an interface called name.
+// A nil Writer returns b unchanged.
+func (w *Writer) EtherTalk(name string, b bridge.ExtBridge) bridge.ExtBridge {
+	if w == nil {
+		return b
+	}
+	return &extTap{w, name, b}
+}
+
+// Records the packets of a LocalTalk bridge on an interface called name.
+// A nil Writer returns b unchanged.
+func (w *Writer) LocalTalk(name string, b bridge.Bridge) bridge.Bridge {
+	if w == nil {
+		return b
+	}
+	return &tap{w, name, b}
+}
+
+func (t *extTap) String() string { return t.name }
+func (t *tap) String() string    { return t.name }
+
+func (t *extTap) Unwrap() interface{} { return t.b }
+func (t *tap) Unwrap() interface{}    { return t.b }
+
+func (t *extTap) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- ethertalk.Packet,
+	recv <-chan ethertalk.Packet,
+) {
+	log = log.With(zap.String("capture", t.name))
+	id, err := t.w.addInterface(t.name, layers.LinkTypeEthernet)
+	if err != nil {
+		log.With(zap.Error(err)).Error("add interface failed")
+		return t.b.Start(ctx, log)
+	}
+	s, r := t.b.Start(ctx, log)
+	return tee(log, t.w, id, ethertalk.Marshal, s, r)
+}
+
+func (t *tap) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- llap.Packet,
+	recv <-chan llap.Packet,
+) {
+	log = log.With(zap.String("capture", t.name))
+	id, err := t.w.addInterface(t.name, layers.LinkTypeLTalk)
+	if err != nil {
+		log.With(zap.Error(err)).Error("add interface failed")
+		return t.b.Start(ctx, log)
+	}
+	s, r := t.b.Start(ctx, log)
+	return tee(log, t.w, id, llap.Marshal, s, r)
+}
+
+// Interposes on a bridge’s channels, recording each packet that passes
+// through them in either direction.
+func tee[T any](
+	log *zap.Logger,
+	w *Writer,
+	id int,
+	marshal func(T) ([]byte, error),
+	send chan<- T,
+	recv <-chan T,
+) (chan<- T, <-chan T) {
+	sendCh := make(chan T)
+	recvCh := make(chan T)
+	record := func(pak T) {
+		data, err := marshal(pak)
+		if err == nil {
+			err = w.writePacket(id, data)
+		}
+		if err != nil {
+			log.With(zap.Error(err)).Error("capture failed")
+		}
+	}
+	go func() {
+		defer close(send)
+		for pak := range sendCh {
+			record(pak)
+			send <- pak
+		}
+	}()
+	go func() {
+		defer close(recvCh)
+		for pak := range recv {
+			record(pak)
+			recvCh <- pak
+		}
+	}()
+	return sendCh, recvCh
+}
+
+func (w *Writer) addInterface(name string, link layers.LinkType) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	intf := pcapgo.NgInterface{
+		Name:     name,
+		OS:       runtime.GOOS,
+		LinkType: link,
+	}
+	if w.ng == nil {
+		// The pcapng writer can’t be created without an interface, so
+		// the first bridge to start creates it.
+		ng, err := pcapgo.NewNgWriterInterface(w.w, intf, pcapgo.NgWriterOptions{
+			SectionInfo: pcapgo.NgSectionInfo{
+				Hardware:    runtime.GOARCH,
+				OS:          runtime.GOOS,
+				Application: "multitalk",
+			},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("write section header: %s", err.Error())
+		}
+		w.ng = ng
+		return 0, w.flush()
+	}
+
+	id, err := w.ng.AddInterface(intf)
+	if err != nil {
+		return 0, fmt.Errorf("write interface: %s", err.Error())
+	}
+	return id, w.flush()
+}
+
+func (w *Writer) writePacket(id int, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.ng.WritePacket(gopacket.CaptureInfo{
+		Timestamp:      time.Now(),
+		CaptureLength:  len(data),
+		Length:         len(data),
+		InterfaceIndex: id,
+	}, data)
+	if err != nil {
+		return fmt.Errorf("write packet: %s", err.Error())
+	}
+	return w.flush()
+}
+
+// Flushes after every write, so that the file is complete even if
+// multitalk is killed.
+func (w *Writer) flush() error {
+	err := w.ng.Flush()
+	if err != nil {
+		return fmt.Errorf("flush: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package capture
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/sim"
+	"github.com/sfiera/multitalk/pkg/aep"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+type recorded struct {
+	iface int
+	data  []byte
+}
+
+// Returns the interfaces and packets written so far.
+func (w *Writer) read(t *testing.T, buf *bytes.Buffer) ([]pcapgo.NgInterface, []recorded) {
+	w.mu.Lock()
+	data := append([]byte{}, buf.Bytes()...)
+	w.mu.Unlock()
+
+	r, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	require.NoError(t, err)
+	var paks []recorded
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		paks = append(paks, recorded{ci.InterfaceIndex, data})
+	}
+	var ifaces []pcapgo.NgInterface
+	for i := 0; i < r.NInterfaces(); i++ {
+		iface, err := r.Interface(i)
+		require.NoError(t, err)
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, paks
+}
+
+func TestCapture(t *testing.T) {
+	assert := assert.New(t)
+	s := newSim(t)
+
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	et := sim.NewSegment[ethertalk.Packet]()
+	s.Add(w.EtherTalk("et", et))
+	lt := sim.NewSegment[llap.Packet]()
+	s.Add(bridge.Extend(w.LocalTalk("lt", lt), bridge.Config{Network: startup}, macLT[:]))
+
+	data := []byte{1, 2}
+	in, err := ethertalk.AppleTalk(
+		macEth,
+		ddp.ExtPacket{
+			ExtHeader: ddp.ExtHeader{
+				Size:   uint16(ddp.ExtHeaderSize + len(data)),
+				DstNet: startup, DstNode: 10, DstSocket: aep.Socket,
+				SrcNet: startup, SrcNode: 50, SrcSocket: aep.Socket,
+				Proto: ddp.ProtoAEP,
+			},
+			Data: data,
+		})
+	require.NoError(t, err)
+	et.Send(*in)
+	out, err := lt.Expect(func(p llap.Packet) bool { return p.Kind == llap.TypeDDP })
+	require.NoError(t, err)
+
+	ifaces, paks := w.read(t, buf)
+	require.Len(t, ifaces, 2)
+	assert.Equal("et", ifaces[0].Name)
+	assert.Equal(layers.LinkTypeEthernet, ifaces[0].LinkType)
+	assert.Equal("lt", ifaces[1].Name)
+	assert.Equal(layers.LinkTypeLTalk, ifaces[1].LinkType)
+
+	inData, err := ethertalk.Marshal(*in)
+	require.NoError(t, err)
+	outData, err := llap.Marshal(out)
+	require.NoError(t, err)
+	assert.Contains(paks, recorded{0, inData})
+	assert.Contains(paks, recorded{1, outData})
+}
+
+func TestNilWriter(t *testing.T) {
+	var w *Writer
+	et := sim.NewSegment[ethertalk.Packet]()
+	lt := sim.NewSegment[llap.Packet]()
+	assert.Same(t, et, w.EtherTalk("et", et))
+	assert.Same(t, lt, w.LocalTalk("lt", lt))
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+const ngMagic = 0x0a0d0d0a
+
+type (
+	packetSource interface {
+		ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	}
+
+	// Replays the packets of a pcap or pcapng file, as a bridge.
+	//
+	// A pcapng file may have several interfaces, such as the ports of a
+	// multitalk capture. Only the packets of one are replayed: the first,
+	// unless another is selected.
+	Reader struct {
+		src  packetSource
+		ng   *pcapgo.NgReader
+		link layers.LinkType
+		intf int
+
+		// A packet read while looking for the selected interface.
+		pending *packet
+
+		// If true, packets are replayed with their original timing.
+		// Otherwise, they are replayed as fast as they are accepted.
+		Realtime bool
+
+		// Name of the replay’s port, for logs and metrics.
+		Name string
+	}
+
+	packet struct {
+		data []byte
+		ci   gopacket.CaptureInfo
+	}
+
+	extReplay struct{ r *Reader }
+	replay    struct{ r *Reader }
+)
+
+// Opens a pcap or pcapng file of EtherTalk or LLAP packets.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("read magic: %s", err.Error())
+	}
+
+	rd := &Reader{Name: "replay"}
+	if binary.LittleEndian.Uint32(magic) == ngMagic {
+		ng, err := pcapgo.NewNgReader(br, pcapgo.NgReaderOptions{WantMixedLinkType: true})
+		if err != nil {
+			return nil, fmt.Errorf("read pcapng: %s", err.Error())
+		}
+		rd.src, rd.ng = ng, ng
+		err = rd.selectInterface(func(i int, _ pcapgo.NgInterface) bool { return i == 0 })
+		if err == io.EOF {
+			return nil, fmt.Errorf("read pcapng: no interfaces")
+		} else if err != nil {
+			return nil, err
+		}
+		return rd, nil
+	}
+
+	p, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("read pcap: %s", err.Error())
+	}
+	rd.src, rd.link = p, p.LinkType()
+	err = checkLink(rd.link)
+	if err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+func checkLink(link layers.LinkType) error {
+	switch link {
+	case layers.LinkTypeEthernet, layers.LinkTypeLTalk:
+		return nil
+	default:
+		return fmt.Errorf("read capture: unsupported link type %s", link)
+	}
+}
+
+// Selects the interface of a pcapng file whose packets are replayed, by
+// name. Must be called before the replay starts.
+func (r *Reader) SelectInterface(name string) error {
+	if r.ng == nil {
+		return fmt.Errorf("select interface: pcap files have no named interfaces")
+	}
+	err := r.selectInterface(func(_ int, intf pcapgo.NgInterface) bool { return intf.Name == name })
+	if err == io.EOF {
+		return fmt.Errorf("select interface: no interface %q", name)
+	}
+	return err
+}
+
+// Selects the first interface that matches. Returns io.EOF if none does.
+func (r *Reader) selectInterface(match func(int, pcapgo.NgInterface) bool) error {
+	// Interfaces may be described anywhere before their first packet,
+	// so read ahead until one matches. Packets read before then belong
+	// to other interfaces, except perhaps the last.
+	for eof := false; ; {
+		for i := 0; i < r.ng.NInterfaces(); i++ {
+			intf, err := r.ng.Interface(i)
+			if err != nil || !match(i, intf) {
+				continue
+			} else if err := checkLink(intf.LinkType); err != nil {
+				return err
+			}
+			r.intf, r.link = i, intf.LinkType
+			if r.pending != nil && r.pending.ci.InterfaceIndex != i {
+				r.pending = nil
+			}
+			return nil
+		}
+
+		if eof {
+			return io.EOF
+		}
+
+		data, ci, err := r.src.ReadPacketData()
+		if err == io.EOF {
+			eof = true
+			continue
+		} else if err != nil {
+			return fmt.Errorf("select interface: %s", err.Error())
+		}
+		r.pending = &packet{data, ci}
+	}
+}
+
+// Returns the next packet of the selected interface.
+func (r *Reader) next() ([]byte, gopacket.CaptureInfo, error) {
+	if p := r.pending; p != nil {
+		r.pending = nil
+		return p.data, p.ci, nil
+	}
+	for {
+		data, ci, err := r.src.ReadPacketData()
+		if err != nil || ci.InterfaceIndex == r.intf {
+			return data, ci, err
+		}
+	}
+}
+
+// Returns true if the file has EtherTalk packets, which are replayed by
+// EtherTalk, or false if it has LLAP packets, which are replayed by
+// LocalTalk.
+func (r *Reader) IsEtherTalk() bool {
+	return r.link == layers.LinkTypeEthernet
+}
+
+// Returns a bridge that sends the file’s EtherTalk packets.
+func (r *Reader) EtherTalk() bridge.ExtBridge {
+	return &extReplay{r}
+}
+
+// Returns a bridge that sends the file’s LLAP packets.
+func (r *Reader) LocalTalk() bridge.Bridge {
+	return &replay{r}
+}
+
+func (b *extReplay) String() string { return b.r.Name }
+func (b *replay) String() string    { return b.r.Name }
+
+func (b *extReplay) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- ethertalk.Packet,
+	recv <-chan ethertalk.Packet,
+) {
+	log = log.With(zap.String("bridge", "replay"))
+	sendCh := make(chan ethertalk.Packet)
+	recvCh := make(chan ethertalk.Packet)
+	go discard(sendCh)
+	go read(ctx, log, b.r, layers.LinkTypeEthernet, unmarshalEtherTalk, recvCh)
+	return sendCh, recvCh
+}
+
+func (b *replay) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- llap.Packet,
+	recv <-chan llap.Packet,
+) {
+	log = log.With(zap.String("bridge", "replay"))
+	sendCh := make(chan llap.Packet)
+	recvCh := make(chan llap.Packet)
+	go discard(sendCh)
+	go read(ctx, log, b.r, layers.LinkTypeLTalk, llap.Unmarshal, recvCh)
+	return sendCh, recvCh
+}
+
+// Like ethertalk.Unmarshal, but also rejects SNAP packets of other
+// protocols, which may be in captures from a real network.
+func unmarshalEtherTalk(data []byte, pak *ethertalk.Packet) error {
+	err := ethertalk.Unmarshal(data, pak)
+	if err != nil {
+		return err
+	} else if pak.SNAPProto != ethertalk.AppleTalkProto && pak.SNAPProto != ethertalk.AARPProto {
+		return fmt.Errorf("read snap proto: not AppleTalk")
+	}
+	return nil
+}
+
+// Drops packets sent to the replay. To keep them, wrap it in a Writer.
+func discard[T any](sendCh <-chan T) {
+	for range sendCh {
+	}
+}
+
+// Sends each packet in the file to recvCh, then closes it.
+func read[T any](
+	ctx context.Context,
+	log *zap.Logger,
+	r *Reader,
+	link layers.LinkType,
+	unmarshal func([]byte, *T) error,
+	recvCh chan<- T,
+) {
+	defer close(recvCh)
+	if r.link != link {
+		log.Error("wrong link type", zap.Stringer("link", r.link))
+		return
+	}
+
+	var start, first time.Time
+	for {
+		data, ci, err := r.next()
+		if err == io.EOF {
+			log.Info("finished")
+			return
+		} else if err != nil {
+			log.With(zap.Error(err)).Error("read failed")
+			return
+		}
+
+		var pak T
+		err = unmarshal(data, &pak)
+		if err != nil {
+			log.With(zap.Error(err)).Debug("unmarshal failed")
+			continue
+		}
+
+		if r.Realtime {
+			if first.IsZero() {
+				start, first = time.Now(), ci.Timestamp
+			}
+			wait := time.Until(start.Add(ci.Timestamp.Sub(first)))
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		select {
+		case recvCh <- pak:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package capture
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/sim"
+	"github.com/sfiera/multitalk/pkg/aep"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+var (
+	macEth  = ethernet.Addr{0x08, 0x00, 0x07, 0x12, 0x34, 0x56}
+	macLT   = ethernet.Addr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	startup = ddp.StartupRange.Start
+	t0      = time.Date(1989, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func newSim(t *testing.T) *sim.Sim {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return sim.New(ctx, zap.NewNop())
+}
+
+func echo(t *testing.T, src ddp.Node, data byte) []byte {
+	l, err := llap.AppleTalk(0xff, src, ddp.Packet{
+		Header: ddp.Header{Size: ddp.HeaderSize + 1, DstSocket: aep.Socket, SrcSocket: aep.Socket, Proto: ddp.ProtoAEP},
+		Data:   []byte{data},
+	})
+	require.NoError(t, err)
+	b, err := llap.Marshal(*l)
+	require.NoError(t, err)
+	return b
+}
+
+func etherEcho(t *testing.T, data byte) []byte {
+	e, err := ethertalk.AppleTalk(macEth, ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			Size:   ddp.ExtHeaderSize + 1,
+			DstNet: 0, DstNode: 0xff, DstSocket: aep.Socket,
+			SrcNet: startup, SrcNode: 50, SrcSocket: aep.Socket,
+			Proto: ddp.ProtoAEP,
+		},
+		Data: []byte{data},
+	})
+	require.NoError(t, err)
+	b, err := ethertalk.Marshal(*e)
+	require.NoError(t, err)
+	return b
+}
+
+func ci(at time.Duration, data []byte) gopacket.CaptureInfo {
+	return gopacket.CaptureInfo{Timestamp: t0.Add(at), CaptureLength: len(data), Length: len(data)}
+}
+
+func isEcho(p ethertalk.Packet) bool {
+	d, ok := sim.DDP(p)
+	return ok && d.Proto == ddp.ProtoAEP
+}
+
+func TestReplayPcap(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := pcapgo.NewWriter(buf)
+	require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeLTalk))
+	for i, data := range [][]byte{echo(t, 10, 1), {0x01}, echo(t, 10, 2)} {
+		require.NoError(t, w.WritePacket(ci(time.Duration(i)*time.Millisecond, data), data))
+	}
+
+	r, err := NewReader(buf)
+	require.NoError(t, err)
+	require.False(t, r.IsEtherTalk())
+
+	s := newSim(t)
+	et := s.EtherTalk()
+	s.Add(bridge.Extend(r.LocalTalk(), bridge.Config{Network: startup}, macLT[:]))
+
+	// The truncated packet is skipped.
+	for _, data := range []byte{1, 2} {
+		out, err := et.Expect(isEcho)
+		require.NoError(t, err)
+		d, _ := sim.DDP(out)
+		assert.Equal(t, ddp.Node(10), d.SrcNode)
+		assert.Equal(t, []byte{data}, d.Data)
+	}
+}
+
+func TestReplayPcapng(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := pcapgo.NewNgWriter(buf, layers.LinkTypeEthernet)
+	require.NoError(t, err)
+	ip := make([]byte, 60)
+	copy(ip[12:], []byte{0x08, 0x00})
+	for _, p := range []struct {
+		at   time.Duration
+		data []byte
+	}{
+		{0, etherEcho(t, 1)},
+		{10 * time.Millisecond, ip},
+		{100 * time.Millisecond, etherEcho(t, 2)},
+	} {
+		require.NoError(t, w.WritePacket(ci(p.at, p.data), p.data))
+	}
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(buf)
+	require.NoError(t, err)
+	require.True(t, r.IsEtherTalk())
+	r.Realtime = true
+
+	s := newSim(t)
+	lt, _ := s.LocalTalk(bridge.Config{Network: startup})
+	start := time.Now()
+	s.Add(r.EtherTalk())
+
+	// The IP packet is skipped, and the second echo keeps its delay.
+	for _, data := range []byte{1, 2} {
+		out, err := lt.Expect(func(p llap.Packet) bool {
+			d, ok := sim.ShortDDP(p)
+			return ok && d.Proto == ddp.ProtoAEP
+		})
+		require.NoError(t, err)
+		d, _ := sim.ShortDDP(out)
+		assert.Equal(t, []byte{data}, d.Data)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestReplayLinkType(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := pcapgo.NewWriter(buf)
+	require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeRaw))
+	_, err := NewReader(buf)
+	assert.Error(t, err)
+
+	_, err = NewReader(&bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestReplayInterface(t *testing.T) {
+	// A capture of three ports, one added after packets of the others.
+	capture := func(t *testing.T) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		lt1, err := w.addInterface("lt1", layers.LinkTypeLTalk)
+		require.NoError(t, err)
+		et, err := w.addInterface("et", layers.LinkTypeEthernet)
+		require.NoError(t, err)
+		require.NoError(t, w.writePacket(lt1, echo(t, 10, 1)))
+		require.NoError(t, w.writePacket(et, etherEcho(t, 9)))
+		lt2, err := w.addInterface("lt2", layers.LinkTypeLTalk)
+		require.NoError(t, err)
+		require.NoError(t, w.writePacket(lt2, echo(t, 20, 7)))
+		require.NoError(t, w.writePacket(lt1, echo(t, 10, 2)))
+		require.NoError(t, w.writePacket(lt2, echo(t, 20, 8)))
+		return buf
+	}
+
+	replayLocalTalk := func(t *testing.T, r *Reader) (srcs []ddp.Node, data []byte) {
+		require.False(t, r.IsEtherTalk())
+		s := newSim(t)
+		et := s.EtherTalk()
+		s.Add(bridge.Extend(r.LocalTalk(), bridge.Config{Network: startup}, macLT[:]))
+		for i := 0; i < 2; i++ {
+			out, err := et.Expect(isEcho)
+			require.NoError(t, err)
+			d, _ := sim.DDP(out)
+			srcs, data = append(srcs, d.SrcNode), append(data, d.Data...)
+		}
+		return srcs, data
+	}
+
+	t.Run("first", func(t *testing.T) {
+		r, err := NewReader(capture(t))
+		require.NoError(t, err)
+		srcs, data := replayLocalTalk(t, r)
+		assert.Equal(t, []ddp.Node{10, 10}, srcs)
+		assert.Equal(t, []byte{1, 2}, data)
+	})
+
+	t.Run("later", func(t *testing.T) {
+		r, err := NewReader(capture(t))
+		require.NoError(t, err)
+		require.NoError(t, r.SelectInterface("lt2"))
+		srcs, data := replayLocalTalk(t, r)
+		assert.Equal(t, []ddp.Node{20, 20}, srcs)
+		assert.Equal(t, []byte{7, 8}, data)
+	})
+
+	t.Run("ethertalk", func(t *testing.T) {
+		r, err := NewReader(capture(t))
+		require.NoError(t, err)
+		require.NoError(t, r.SelectInterface("et"))
+		require.True(t, r.IsEtherTalk())
+		s := newSim(t)
+		lt, _ := s.LocalTalk(bridge.Config{Network: startup})
+		s.Add(r.EtherTalk())
+		out, err := lt.Expect(func(p llap.Packet) bool {
+			d, ok := sim.ShortDDP(p)
+			return ok && d.Proto == ddp.ProtoAEP
+		})
+		require.NoError(t, err)
+		d, _ := sim.ShortDDP(out)
+		assert.Equal(t, []byte{9}, d.Data)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		r, err := NewReader(capture(t))
+		require.NoError(t, err)
+		assert.Error(t, r.SelectInterface("lt3"))
+	})
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/tun"
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/macip"
+	"github.com/sfiera/multitalk/pkg/nbp"
+)
+
+var (
+	macipAddr = pflag.String("macip", "", "address and subnet of a MacIP gateway to serve from the bridge, e.g. 10.1.1.1/24")
+	macipPool = pflag.String("macip-pool", "", "addresses to assign to MacIP clients, e.g. 10.1.1.100-10.1.1.199 (default: rest of the subnet)")
+	macipDNS  = pflag.String("macip-dns", "", "name server to give MacIP clients")
+	macipTUN  = pflag.String("macip-tun", "macip0", "TUN device of the MacIP gateway")
+)
+
+// Returns a MacIP gateway, if one is configured. Its TUN device is
+// opened immediately, so that missing permissions are reported early.
+func macipService(log *zap.Logger) (*service, error) {
+	if *macipAddr == "" {
+		return nil, nil
+	}
+	prefix, err := netip.ParsePrefix(*macipAddr)
+	if err != nil {
+		return nil, fmt.Errorf("macip: %s", err.Error())
+	}
+	var pool macip.Pool
+	if *macipPool != "" {
+		pool, err = macip.ParsePool(*macipPool)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var dns netip.Addr
+	if *macipDNS != "" {
+		dns, err = netip.ParseAddr(*macipDNS)
+		if err != nil {
+			return nil, fmt.Errorf("macip dns: %s", err.Error())
+		}
+	}
+	gw, err := macip.NewGateway(prefix, pool, dns)
+	if err != nil {
+		return nil, err
+	}
+	object, err := objectName()
+	if err != nil {
+		return nil, err
+	}
+	dev, err := tun.Open(*macipTUN, prefix, macip.MTU)
+	if err != nil {
+		return nil, err
+	}
+
+	return &service{"macip gateway", func(ctx context.Context, node *bridge.Node, names *nbp.Responder) error {
+		defer dev.Close()
+		conn, err := node.ListenDDP(ctx, macip.Socket)
+		if err != nil {
+			return err
+		}
+		cfg, err := node.ListenDDP(ctx, 0)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		ep := atp.NewEndpoint(cfg)
+		e := nbp.Entity{Object: object, Type: macip.NBPType, Zone: nbp.ThisZone}
+		err = names.Register(e, cfg.LocalAddr().Socket)
+		if err != nil {
+			conn.Close()
+			ep.Close()
+			return err
+		}
+		log.With(zap.String("name", e.String())).
+			Info("serving macip", zap.Stringer("subnet", prefix), zap.String("tun", *macipTUN))
+		return gw.Serve(ctx, conn, ep, dev)
+	}}, nil
+}
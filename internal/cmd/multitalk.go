@@ -31,17 +31,28 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 
+	"github.com/sfiera/multitalk/internal/admin"
 	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/capture"
+	"github.com/sfiera/multitalk/internal/filter"
+	"github.com/sfiera/multitalk/internal/limit"
+	"github.com/sfiera/multitalk/internal/metrics"
 	"github.com/sfiera/multitalk/internal/raw"
 	"github.com/sfiera/multitalk/internal/serial"
 	"github.com/sfiera/multitalk/internal/tcp"
 	"github.com/sfiera/multitalk/internal/udp"
 	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/macroman"
+	"github.com/sfiera/multitalk/pkg/nbp"
+	"github.com/sfiera/multitalk/pkg/zip"
 )
 
 const (
@@ -49,14 +60,30 @@ const (
 )
 
 var (
-	ether   = pflag.StringArrayP("ethertalk", "e", []string{}, "interface to bridge via EtherTalk")
-	multi   = pflag.StringArrayP("multicast", "m", []string{}, "interface to bridge via UDP multicast")
-	tash    = pflag.StringArrayP("serial", "s", []string{}, "serial device to bridge via TashTalk")
-	client  = pflag.StringArrayP("tcp-client", "t", []string{}, "address to dial via TCP")
-	server  = pflag.StringArrayP("tcp-server", "T", []string{}, "address to listen via TCP")
-	network = pflag.Uint16P("network", "n", 0xff00, "network number for LToU bridging")
-	debug   = pflag.BoolP("debug", "d", false, "log packets")
-	version = pflag.BoolP("version", "v", false, "Display version & exit")
+	ether       = pflag.StringArrayP("ethertalk", "e", []string{}, "interface to bridge via EtherTalk")
+	ether1      = pflag.StringArray("ethertalk-phase1", []string{}, "interface to bridge via EtherTalk Phase 1")
+	multi       = pflag.StringArrayP("multicast", "m", []string{}, "interface to bridge via UDP multicast")
+	ipTalk      = pflag.StringArray("iptalk", []string{}, "address/subnet to bridge via IPTalk (KIP/CAP UDP), e.g. 192.168.1.200/24")
+	tash        = pflag.StringArrayP("serial", "s", []string{}, "serial device to bridge via TashTalk")
+	client      = pflag.StringArrayP("tcp-client", "t", []string{}, "address to dial via TCP")
+	server      = pflag.StringArrayP("tcp-server", "T", []string{}, "address to listen via TCP")
+	network     = pflag.Uint16P("network", "n", 0, "network number for LToU bridging (default: start of cable range)")
+	cable       = pflag.StringP("cable-range", "r", "", "cable range of the EtherTalk network to seed, e.g. 100-109")
+	zones       = pflag.StringArrayP("zone", "z", []string{}, "zone of the EtherTalk network (first is default)")
+	name        = pflag.String("name", "", "NBP object name of the router and MacIP gateway (default: host name)")
+	capt        = pflag.String("capture", "", "pcapng file to record all bridged packets to")
+	replay      = pflag.StringArray("replay", []string{}, "pcap or pcapng file of EtherTalk or LLAP packets to replay")
+	fast        = pflag.Bool("replay-fast", false, "replay packets as fast as possible, instead of with their original timing")
+	replayIntf  = pflag.String("replay-interface", "", "interface of pcapng replay files to replay (default: the first)")
+	filters     = pflag.StringArray("filter", []string{}, "rule for filtering packets on a port, e.g. 'tcp:* out deny nbp-type=LaserWriter'")
+	filterFile  = pflag.String("filter-file", "", "file of rules for filtering packets, one per line")
+	portRate    = pflag.String("port-rate", "", "packets per second that each port may send, e.g. 500 or 500/1000 for bursts of 1000")
+	nodeRate    = pflag.String("node-rate", "", "packets per second that each node may send")
+	bcastRate   = pflag.String("broadcast-rate", "", "broadcast and AARP packets per second that each node may send")
+	metricsAddr = pflag.String("metrics-addr", "", "address to serve Prometheus metrics on, e.g. localhost:9100")
+	adminAddr   = pflag.String("admin-addr", "", "local address to serve the admin API on, e.g. localhost:9101")
+	debug       = pflag.BoolP("debug", "d", false, "log packets")
+	version     = pflag.BoolP("version", "v", false, "Display version & exit")
 )
 
 func Main() {
@@ -76,28 +103,136 @@ func Main() {
 	}
 
 	g := bridge.NewGroup(log)
-	err = bridges(context.Background(), log, g)
+	switch pflag.Arg(0) {
+	case "":
+		err = run(context.Background(), log, g)
+	case "ping":
+		err = ping(log, g, pflag.Args()[1:])
+	case "print":
+		err = printFiles(log, g, pflag.Args()[1:])
+	default:
+		err = fmt.Errorf("unknown command %q", pflag.Arg(0))
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	g.Run()
 }
 
-func bridges(ctx context.Context, log *zap.Logger, grp *bridge.Group) error {
-	niface := len(*client) + len(*server) + len(*ether) + len(*multi) + len(*tash)
+func run(ctx context.Context, log *zap.Logger, grp *bridge.Group) error {
+	cfg, err := config()
+	if err != nil {
+		return err
+	}
+
+	svcs, err := services(log)
+	if err != nil {
+		return err
+	}
+
+	niface := interfaces()
 	if niface == 0 {
 		return fmt.Errorf("no interfaces specified")
-	} else if (niface == 1) && (len(*server) == 0) && !*debug {
+	} else if (niface == 1) && (len(*server) == 0) && (len(svcs) == 0) && !*debug {
 		return fmt.Errorf("only one interface specified")
 	}
 
+	var adm *admin.Server
+	if *adminAddr != "" {
+		adm = admin.NewServer()
+	}
+	startServices(ctx, log, grp, cfg, svcs)
+	err = bridges(ctx, log, grp, cfg, adm)
+	if err != nil {
+		return err
+	}
+	if *metricsAddr != "" {
+		err = serve(log, *metricsAddr, "/metrics", metrics.Default)
+		if err != nil {
+			return err
+		}
+	}
+	if adm != nil {
+		err = serve(log, *adminAddr, "/", adm)
+		if err != nil {
+			return err
+		}
+	}
+	grp.Run()
+	return nil
+}
+
+func interfaces() int {
+	return len(*client) + len(*server) + len(*ether) + len(*ether1) + len(*multi) + len(*ipTalk) + len(*tash) + len(*replay)
+}
+
+func bridges(
+	ctx context.Context,
+	log *zap.Logger,
+	grp *bridge.Group,
+	cfg bridge.Config,
+	adm *admin.Server,
+) error {
+	rules, err := filterRules()
+	if err != nil {
+		return err
+	}
+	limits, err := rateLimits()
+	if err != nil {
+		return err
+	}
+
+	var w *capture.Writer
+	if *capt != "" {
+		f, err := os.Create(*capt)
+		if err != nil {
+			return fmt.Errorf("open capture: %s", err.Error())
+		}
+		w = capture.NewWriter(f)
+	}
+
+	// Only one router seeds the cable range and registers names. The
+	// other LocalTalk ports are bridged onto the same network as proxies,
+	// so that the network doesn’t have duplicate routers or names.
+	seeded := false
+	extend := func(b bridge.Bridge, hwAddr []byte) bridge.ExtBridge {
+		c := cfg
+		if seeded {
+			c = bridge.Config{Network: cfg.Network}
+		}
+		seeded = true
+		return bridge.Extend(w.LocalTalk(bridge.PortName(b), b), c, hwAddr)
+	}
+	add := func(b bridge.ExtBridge) {
+		b = filter.Apply(rules, bridge.Metered(b))
+		grp.Add(adm.Track(limit.Apply(limits, b)).Start(ctx, log))
+	}
+	addTCPClient := func(addr string) error {
+		tcp, err := tcp.TCPClient(addr)
+		if err != nil {
+			return err
+		}
+		add(w.EtherTalk(bridge.PortName(tcp), tcp))
+		return nil
+	}
+	if adm != nil {
+		adm.AddTCPClient = addTCPClient
+	}
+
 	for _, dev := range *ether {
 		et, err := raw.EtherTalk(dev)
 		if err != nil {
 			return err
 		}
-		grp.Add(et.Start(ctx, log))
+		add(w.EtherTalk(bridge.PortName(et), et))
+	}
+
+	for _, dev := range *ether1 {
+		et, hwAddr, err := raw.EtherTalkPhase1(dev)
+		if err != nil {
+			return err
+		}
+		add(extend(et, hwAddr))
 	}
 
 	for _, dev := range *multi {
@@ -105,7 +240,15 @@ func bridges(ctx context.Context, log *zap.Logger, grp *bridge.Group) error {
 		if err != nil {
 			return err
 		}
-		grp.Add(bridge.Extend(m, ddp.Network(*network), hwAddr).Start(ctx, log))
+		add(extend(m, hwAddr))
+	}
+
+	for _, addr := range *ipTalk {
+		it, hwAddr, err := udp.IPTalk(addr)
+		if err != nil {
+			return err
+		}
+		add(extend(it, hwAddr))
 	}
 
 	for _, dev := range *tash {
@@ -113,15 +256,41 @@ func bridges(ctx context.Context, log *zap.Logger, grp *bridge.Group) error {
 		if err != nil {
 			return err
 		}
-		grp.Add(bridge.Extend(tt, ddp.Network(*network), hwAddr).Start(ctx, log))
+		add(extend(tt, hwAddr))
+	}
+
+	for i, file := range *replay {
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("open replay: %s", err.Error())
+		}
+		r, err := capture.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("open replay %s: %s", file, err.Error())
+		}
+		if *replayIntf != "" {
+			err = r.SelectInterface(*replayIntf)
+			if err != nil {
+				return fmt.Errorf("open replay %s: %s", file, err.Error())
+			}
+		}
+		r.Realtime = !*fast
+		r.Name = "replay:" + file
+		if r.IsEtherTalk() {
+			add(w.EtherTalk(r.Name, r.EtherTalk()))
+		} else {
+			// The replay has no hardware, so its router uses a
+			// locally administered address.
+			hwAddr := []byte{0x02, 0x00, 0x00, 0x00, 0x00, byte(i + 1)}
+			add(extend(r.LocalTalk(), hwAddr))
+		}
 	}
 
 	for _, s := range *client {
-		tcp, err := tcp.TCPClient(s)
+		err := addTCPClient(s)
 		if err != nil {
 			return err
 		}
-		grp.Add(tcp.Start(ctx, log))
 	}
 
 	for _, s := range *server {
@@ -129,8 +298,148 @@ func bridges(ctx context.Context, log *zap.Logger, grp *bridge.Group) error {
 		if err != nil {
 			return err
 		}
-		tcp.Serve(ctx, log, grp)
+		tcp.ServeFunc(ctx, log, func(_ net.Addr, b bridge.ExtBridge) {
+			add(w.EtherTalk(bridge.PortName(b), b))
+		})
+	}
+
+	return nil
+}
+
+// Returns the rules from --filter-file, followed by those from --filter.
+func filterRules() ([]filter.Rule, error) {
+	rules := []filter.Rule{}
+	if *filterFile != "" {
+		f, err := os.Open(*filterFile)
+		if err != nil {
+			return nil, fmt.Errorf("open filter file: %s", err.Error())
+		}
+		defer f.Close()
+		rules, err = filter.ParseFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %s", *filterFile, err.Error())
+		}
 	}
+	for _, s := range *filters {
+		r, err := filter.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
 
+// Returns the limits from --port-rate, --node-rate, and --broadcast-rate.
+func rateLimits() (limit.Config, error) {
+	cfg := limit.Config{}
+	for _, r := range []struct {
+		flag string
+		rate *limit.Rate
+	}{
+		{*portRate, &cfg.Port},
+		{*nodeRate, &cfg.Node},
+		{*bcastRate, &cfg.Broadcast},
+	} {
+		if r.flag == "" {
+			continue
+		}
+		var err error
+		*r.rate, err = limit.ParseRate(r.flag)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// Serves h on addr, at path.
+func serve(log *zap.Logger, addr, path string, h http.Handler) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %s", addr, err.Error())
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, h)
+	go func() {
+		err := http.Serve(l, mux)
+		log.With(zap.Error(err), zap.String("addr", addr)).Error("http server failed")
+	}()
 	return nil
 }
+
+func config() (bridge.Config, error) {
+	cfg := bridge.Config{
+		Network: ddp.Network(*network),
+		Zones:   *zones,
+	}
+
+	if *cable == "" {
+		if len(*zones) > 0 {
+			return cfg, fmt.Errorf("--zone requires --cable-range")
+		} else if cfg.Network == 0 {
+			// Without a seed router, LocalTalk nodes pose as
+			// extended nodes that haven’t found their network yet.
+			cfg.Network = ddp.StartupRange.Start
+		}
+		return cfg, nil
+	}
+
+	var err error
+	cfg.Range, err = ddp.ParseRange(*cable)
+	if err != nil {
+		return cfg, err
+	} else if len(*zones) == 0 {
+		return cfg, fmt.Errorf("--cable-range requires --zone")
+	}
+	for _, z := range *zones {
+		if !validName(z, zip.MaxZoneLength) {
+			return cfg, fmt.Errorf("invalid zone name %q", z)
+		}
+	}
+	if cfg.Network == 0 {
+		cfg.Network = cfg.Range.Start
+	}
+
+	cfg.Name, err = objectName()
+	return cfg, err
+}
+
+// Returns the NBP object name of multitalk’s own entities: the --name
+// flag, or else the host name.
+func objectName() (string, error) {
+	if *name != "" {
+		if !validName(*name, nbp.MaxNameLength) {
+			return "", fmt.Errorf("invalid NBP name %q", *name)
+		}
+		return *name, nil
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("get host name: %s", err.Error())
+	}
+	host, _, _ = strings.Cut(host, ".")
+	return truncateName(host, nbp.MaxNameLength), nil
+}
+
+// Returns as much of s as fits in max bytes of Mac OS Roman, skipping
+// characters that it can’t encode.
+func truncateName(s string, max int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if _, err := macroman.Encode(string(r)); err != nil {
+			continue
+		} else if n++; n > max {
+			break
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Returns true if s is a non-empty name that fits in max bytes of Mac OS Roman.
+func validName(s string, max int) bool {
+	data, err := macroman.Encode(s)
+	return err == nil && len(data) > 0 && len(data) <= max
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/nbp"
+	"github.com/sfiera/multitalk/pkg/pap"
+)
+
+var (
+	papName    = pflag.String("pap-server", "", "NBP object name of a virtual LaserWriter to serve from the bridge")
+	papStatus  = pflag.String("pap-status", pap.DefaultStatus, "status string of the virtual LaserWriter")
+	papSpool   = pflag.String("pap-spool", "", "directory to write the virtual LaserWriter’s jobs to")
+	papCommand = pflag.String("pap-command", "", "shell command to pipe each of the virtual LaserWriter’s jobs to")
+)
+
+// Returns a virtual LaserWriter, if one is configured.
+func papService(log *zap.Logger) (*service, error) {
+	if *papName == "" {
+		return nil, nil
+	}
+	handler, err := papHandler(log)
+	if err != nil {
+		return nil, err
+	}
+	e := nbp.Entity{Object: *papName, Type: pap.LaserWriter, Zone: nbp.ThisZone}
+	if !validName(e.Object, nbp.MaxNameLength) {
+		return nil, fmt.Errorf("invalid NBP name %q", e.Object)
+	}
+	s := pap.NewServer(handler)
+	err = s.SetStatus(*papStatus)
+	if err != nil {
+		return nil, fmt.Errorf("pap status: %s", err.Error())
+	}
+
+	return &service{"pap server", func(ctx context.Context, node *bridge.Node, names *nbp.Responder) error {
+		sls, err := node.ListenDDP(ctx, 0)
+		if err != nil {
+			return err
+		}
+		ep := atp.NewEndpoint(sls)
+		defer ep.Close()
+		err = names.Register(e, sls.LocalAddr().Socket)
+		if err != nil {
+			return err
+		}
+		log.With(zap.String("name", e.String())).
+			Info("serving pap", zap.Stringer("addr", sls.LocalAddr()))
+		return s.Serve(ctx, ep)
+	}}, nil
+}
+
+// Returns the handler for the virtual LaserWriter’s jobs.
+func papHandler(log *zap.Logger) (func(io.Reader) error, error) {
+	switch {
+	case *papSpool != "" && *papCommand != "":
+		return nil, fmt.Errorf("--pap-spool and --pap-command are exclusive")
+	case *papSpool != "":
+		info, err := os.Stat(*papSpool)
+		if err != nil {
+			return nil, fmt.Errorf("pap spool: %s", err.Error())
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("pap spool: %s is not a directory", *papSpool)
+		}
+		return func(job io.Reader) error {
+			return spool(log, *papSpool, job)
+		}, nil
+	case *papCommand != "":
+		return func(job io.Reader) error {
+			return pipe(log, *papCommand, job)
+		}, nil
+	}
+	return nil, fmt.Errorf("--pap-server requires --pap-spool or --pap-command")
+}
+
+// Writes a job to a new file in dir. Jobs that are aborted are removed.
+func spool(log *zap.Logger, dir string, job io.Reader) error {
+	f, err := os.CreateTemp(dir, time.Now().Format("20060102-150405-*.ps"))
+	if err != nil {
+		return fmt.Errorf("spool: %s", err.Error())
+	}
+	n, err := io.Copy(f, job)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("spool: %s", err.Error())
+	}
+	log.Info("spooled job",
+		zap.String("path", filepath.Clean(f.Name())),
+		zap.Int64("bytes", n))
+	return nil
+}
+
+// Runs command with a job as its standard input.
+func pipe(log *zap.Logger, command string, job io.Reader) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = job
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("pap command: %s", err.Error())
+	}
+	log.Info("piped job", zap.String("command", command))
+	return nil
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/pkg/aep"
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+const (
+	pingInterval   = time.Second
+	pingTimeout    = 2 * time.Second
+	acquireTimeout = 10 * time.Second
+
+	// Requests carry a sequence number, padded to this size.
+	pingDataSize = 32
+)
+
+var count = pflag.IntP("count", "c", 0, "number of echo requests to send with ping (default: until interrupted)")
+
+// Sends AEP echo requests to a node through the configured interfaces,
+// and prints round-trip times.
+func ping(log *zap.Logger, grp *bridge.Group, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: multitalk ping [flags] net.node")
+	}
+	dst, err := ddp.ParseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	cfg, err := config()
+	if err != nil {
+		return err
+	} else if interfaces() == 0 {
+		return fmt.Errorf("no interfaces specified")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	node := ownNode(cfg)
+	grp.Add(node.Start(ctx, log))
+	err = bridges(ctx, log, grp, cfg, nil)
+	if err != nil {
+		return err
+	}
+	go grp.Run()
+
+	actx, cancel := context.WithTimeout(ctx, acquireTimeout)
+	conn, err := node.ListenDDP(actx, 0)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	fmt.Printf("AEP %s from %s: %d data bytes\n", dst, conn.LocalAddr().Addr(), pingDataSize)
+
+	p := pinger{dst: dst, conn: conn, sent: map[uint16]time.Time{}}
+	p.run(ctx)
+	p.summarize()
+	return nil
+}
+
+type echo struct {
+	from ddp.Addr
+	pak  aep.Packet
+}
+
+type pinger struct {
+	dst  ddp.Addr
+	conn ddp.Conn
+
+	seq  uint16
+	sent map[uint16]time.Time
+	rtts []time.Duration
+}
+
+func (p *pinger) run(ctx context.Context) {
+	replies := make(chan echo)
+	go p.read(ctx, replies)
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	p.send()
+
+	var timeout <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			return
+		case <-ticker.C:
+			if *count > 0 && int(p.seq) >= *count {
+				ticker.Stop()
+				timeout = time.After(pingTimeout)
+				continue
+			}
+			p.send()
+		case e, ok := <-replies:
+			if !ok {
+				return
+			}
+			p.receive(e)
+			if *count > 0 && len(p.rtts) >= *count {
+				return
+			}
+		}
+	}
+}
+
+func (p *pinger) send() {
+	data := make([]byte, pingDataSize)
+	binary.BigEndian.PutUint16(data, p.seq)
+	payload, err := aep.Marshal(aep.Packet{Function: aep.Request, Data: data})
+	if err != nil {
+		return
+	}
+	to := ddp.SocketAddr{Network: p.dst.Network, Node: p.dst.Node, Socket: aep.Socket}
+	err = p.conn.WriteTo(payload, ddp.ProtoAEP, to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "seq=%d: %s\n", p.seq, err.Error())
+	}
+	p.sent[p.seq] = time.Now()
+	p.seq++
+}
+
+// Reads echo replies from the socket until it is closed.
+func (p *pinger) read(ctx context.Context, replies chan<- echo) {
+	defer close(replies)
+	buf := make([]byte, ddp.MaxDataSize)
+	for {
+		n, proto, from, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pak := aep.Packet{}
+		if proto != ddp.ProtoAEP || aep.Unmarshal(buf[:n], &pak) != nil {
+			continue
+		} else if pak.Function != aep.Reply || len(pak.Data) < 2 {
+			continue
+		}
+		select {
+		case replies <- echo{from.Addr(), pak}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *pinger) receive(e echo) {
+	seq := binary.BigEndian.Uint16(e.pak.Data)
+	start, ok := p.sent[seq]
+	if !ok {
+		return
+	}
+	delete(p.sent, seq)
+	rtt := time.Since(start)
+	p.rtts = append(p.rtts, rtt)
+	fmt.Printf("%d bytes from %s: seq=%d time=%s\n", len(e.pak.Data), e.from, seq, rtt.Round(10*time.Microsecond))
+}
+
+func (p *pinger) summarize() {
+	sent, received := int(p.seq), len(p.rtts)
+	fmt.Printf("--- %s ping statistics ---\n", p.dst)
+	loss := 0.0
+	if sent > 0 {
+		loss = 100 * float64(sent-received) / float64(sent)
+	}
+	fmt.Printf("%d requests sent, %d replies received, %.0f%% loss\n", sent, received, loss)
+	if received == 0 {
+		return
+	}
+
+	min, max, sum := p.rtts[0], p.rtts[0], time.Duration(0)
+	for _, rtt := range p.rtts {
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		sum += rtt
+	}
+	avg := sum / time.Duration(received)
+	round := 10 * time.Microsecond
+	fmt.Printf("round-trip min/avg/max = %s/%s/%s\n", min.Round(round), avg.Round(round), max.Round(round))
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/nbp"
+	"github.com/sfiera/multitalk/pkg/pap"
+)
+
+const (
+	lookupTimeout  = 10 * time.Second
+	statusInterval = 5 * time.Second
+)
+
+var printerName = pflag.String("printer", "", "NBP name of the printer to print to with print, e.g. 'Lab:LaserWriter@Office'")
+
+// Sends PostScript files, or standard input, to a printer through the
+// configured interfaces, one job per file.
+func printFiles(log *zap.Logger, grp *bridge.Group, args []string) error {
+	if *printerName == "" {
+		return fmt.Errorf("usage: multitalk print [flags] --printer name[:type][@zone] [file ...]")
+	}
+	name := *printerName
+	if obj, _, _ := strings.Cut(name, "@"); !strings.Contains(obj, ":") {
+		name = obj + ":" + pap.LaserWriter + name[len(obj):]
+	}
+	query, err := nbp.ParseEntity(name)
+	if err != nil {
+		return err
+	}
+	cfg, err := config()
+	if err != nil {
+		return err
+	} else if interfaces() == 0 {
+		return fmt.Errorf("no interfaces specified")
+	}
+	if query.Zone == nbp.ThisZone && len(cfg.Zones) > 0 {
+		query.Zone = cfg.Zones[0]
+	}
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	node := ownNode(cfg)
+	grp.Add(node.Start(ctx, log))
+	err = bridges(ctx, log, grp, cfg, nil)
+	if err != nil {
+		return err
+	}
+	go grp.Run()
+
+	sls, err := findPrinter(ctx, node, query)
+	if err != nil {
+		return err
+	}
+	ep, err := listenATP(ctx, node)
+	if err != nil {
+		return err
+	}
+	defer ep.Close()
+	status, err := listenATP(ctx, node)
+	if err != nil {
+		return err
+	}
+	defer status.Close()
+
+	for _, path := range args {
+		err := printFile(ctx, ep, status, sls, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the session listening socket of the printer named query.
+func findPrinter(ctx context.Context, node *bridge.Node, query nbp.Entity) (ddp.SocketAddr, error) {
+	actx, cancel := context.WithTimeout(ctx, acquireTimeout)
+	conn, err := node.ListenDDP(actx, 0)
+	cancel()
+	if err != nil {
+		return ddp.SocketAddr{}, err
+	}
+	lctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	found, err := nbp.Find(lctx, conn, query, 1)
+	if err != nil {
+		return ddp.SocketAddr{}, err
+	}
+	t := found[0]
+	sls := ddp.SocketAddr{Network: t.Addr.Network, Node: t.Addr.Node, Socket: t.Socket}
+	fmt.Fprintf(os.Stderr, "%s at %s\n", t.Entity, sls)
+	return sls, nil
+}
+
+// Opens an ATP endpoint on a dynamic socket of the node.
+func listenATP(ctx context.Context, node *bridge.Node) (*atp.Endpoint, error) {
+	actx, cancel := context.WithTimeout(ctx, acquireTimeout)
+	defer cancel()
+	conn, err := node.ListenDDP(actx, 0)
+	if err != nil {
+		return nil, err
+	}
+	return atp.NewEndpoint(conn), nil
+}
+
+// Sends one file to the printer as a job, reporting the printer’s status
+// as it changes. The printer’s output is copied to standard output.
+func printFile(ctx context.Context, ep, status *atp.Endpoint, sls ddp.SocketAddr, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	last := ""
+	report := func(s string) {
+		if s != last {
+			fmt.Fprintln(os.Stderr, s)
+			last = s
+		}
+	}
+	c, err := pap.Dial(ctx, ep, sls, os.Stdout, report)
+	if err != nil {
+		return err
+	}
+
+	jctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s, err := pap.GetStatus(jctx, status, sls)
+				if err == nil {
+					report(s)
+				}
+			case <-jctx.Done():
+				return
+			}
+		}
+	}()
+
+	n, err := io.Copy(c, r)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	err = c.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: sent %d bytes\n", path, n)
+	return nil
+}
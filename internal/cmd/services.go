@@ -0,0 +1,102 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/nbp"
+)
+
+// A service that runs on multitalk’s own node, and registers its names
+// with the node’s NBP responder.
+type service struct {
+	name string
+	run  func(ctx context.Context, node *bridge.Node, names *nbp.Responder) error
+}
+
+// Returns the services that are configured.
+func services(log *zap.Logger) ([]service, error) {
+	var svcs []service
+	for _, configured := range []func(*zap.Logger) (*service, error){papService, macipService} {
+		s, err := configured(log)
+		if err != nil {
+			return nil, err
+		} else if s != nil {
+			svcs = append(svcs, *s)
+		}
+	}
+	return svcs, nil
+}
+
+// Creates a node for multitalk’s own use. Without a seed router, the
+// node must share the LocalTalk network’s number, or it can’t exchange
+// packets with its nodes.
+func ownNode(cfg bridge.Config) *bridge.Node {
+	rng := cfg.Range
+	if rng.IsZero() {
+		rng = ddp.Range{Start: cfg.Network, End: cfg.Network}
+	}
+	return bridge.NewNode(rng)
+}
+
+// Starts services on a node of multitalk’s own, if any are configured.
+func startServices(ctx context.Context, log *zap.Logger, grp *bridge.Group, cfg bridge.Config, svcs []service) {
+	if len(svcs) == 0 {
+		return
+	}
+	node := ownNode(cfg)
+	grp.Add(node.Start(ctx, log))
+	go func() {
+		nis, err := node.ListenDDP(ctx, nbp.Socket)
+		if err != nil {
+			log.Error("nbp responder failed", zap.Error(err))
+			return
+		}
+		names := nbp.NewResponder(nis)
+		defer names.Close()
+
+		var wg sync.WaitGroup
+		for _, s := range svcs {
+			wg.Add(1)
+			go func(s service) {
+				defer wg.Done()
+				err := s.run(ctx, node, names)
+				if err != nil && ctx.Err() == nil {
+					log.Error(s.name+" failed", zap.Error(err))
+				}
+			}(s)
+		}
+		wg.Wait()
+	}()
+}
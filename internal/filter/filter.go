@@ -0,0 +1,437 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Filters the packets that cross the ports of a Group
+//
+// Each rule is one line of the form
+//
+//	PORT DIRECTION ACTION [KEY=VALUE ...]
+//
+// PORT is a port name, such as "ethertalk:eth0", in which * matches any
+// characters, as in "tcp:*". DIRECTION is "in" for packets that the port
+// receives, "out" for packets that it sends, or "both". ACTION is "allow"
+// or "deny". A rule applies to a packet if all of its conditions match:
+//
+//	ddp=TYPE[,TYPE...]      DDP type, such as nbp, atp, or 22
+//	aarp=OP[,OP...]         AARP opcode: request, response, or probe
+//	net=N[-M]               source or destination network
+//	node=N[-M]              source or destination node
+//	socket=N[-M]            source or destination DDP socket
+//	nbp-type=PATTERN        type of any entity in an NBP packet
+//
+// net, node, and socket also have src- and dst- forms, such as src-net.
+// Network and node conditions match the protocol addresses of AARP
+// packets too. The first rule that applies to a packet decides whether it
+// passes, and packets that no rule applies to pass.
+package filter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/nbp"
+)
+
+type (
+	Rule struct {
+		In, Out bool
+		Allow   bool
+
+		text  string
+		port  *regexp.Regexp
+		conds []cond
+	}
+
+	// A condition on a packet.
+	cond func(p *packet) bool
+
+	// A packet, decoded once for all conditions.
+	packet struct {
+		ddp  *ddp.ExtView
+		aarp *aarp.View
+	}
+
+	// An inclusive range of numbers.
+	span struct {
+		lo, hi uint64
+	}
+
+	filtered struct {
+		rules []Rule
+		b     bridge.ExtBridge
+	}
+)
+
+// Parses a rule.
+func Parse(s string) (Rule, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return Rule{}, fmt.Errorf("parse rule %q: want PORT DIRECTION ACTION", s)
+	}
+	r := Rule{text: strings.Join(fields, " ")}
+
+	pattern := strings.ReplaceAll(regexp.QuoteMeta(fields[0]), `\*`, ".*")
+	r.port = regexp.MustCompile("^" + pattern + "$")
+
+	switch fields[1] {
+	case "in":
+		r.In = true
+	case "out":
+		r.Out = true
+	case "both":
+		r.In, r.Out = true, true
+	default:
+		return Rule{}, fmt.Errorf("parse rule %q: unknown direction %q", s, fields[1])
+	}
+
+	switch fields[2] {
+	case "allow":
+		r.Allow = true
+	case "deny":
+		r.Allow = false
+	default:
+		return Rule{}, fmt.Errorf("parse rule %q: unknown action %q", s, fields[2])
+	}
+
+	for _, f := range fields[3:] {
+		c, err := parseCond(f)
+		if err != nil {
+			return Rule{}, fmt.Errorf("parse rule %q: %s", s, err.Error())
+		}
+		r.conds = append(r.conds, c)
+	}
+	return r, nil
+}
+
+// Parses rules from r, one per line. Blank lines and lines starting with
+// # are skipped.
+func ParseFile(r io.Reader) ([]Rule, error) {
+	rules := []Rule{}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		rules = append(rules, rule)
+	}
+	err := s.Err()
+	if err != nil {
+		return nil, fmt.Errorf("read rules: %s", err.Error())
+	}
+	return rules, nil
+}
+
+func (r Rule) String() string {
+	return r.text
+}
+
+// Returns true if the rule applies to port.
+func (r Rule) Port(port string) bool {
+	return r.port.MatchString(port)
+}
+
+func parseCond(s string) (cond, error) {
+	key, val, found := strings.Cut(s, "=")
+	if !found {
+		return nil, fmt.Errorf("condition %q: missing =", s)
+	}
+
+	switch key {
+	case "ddp":
+		types := map[uint8]bool{}
+		for _, v := range strings.Split(val, ",") {
+			t, err := parseProto(v)
+			if err != nil {
+				return nil, err
+			}
+			types[t] = true
+		}
+		return func(p *packet) bool {
+			return p.ddp != nil && types[p.ddp.Proto]
+		}, nil
+
+	case "aarp":
+		ops := map[aarp.Opcode]bool{}
+		for _, v := range strings.Split(val, ",") {
+			op, err := parseOpcode(v)
+			if err != nil {
+				return nil, err
+			}
+			ops[op] = true
+		}
+		return func(p *packet) bool {
+			return p.aarp != nil && ops[p.aarp.Opcode]
+		}, nil
+
+	case "nbp-type":
+		if val == "" {
+			return nil, fmt.Errorf("condition %q: empty type", s)
+		}
+		return func(p *packet) bool {
+			return p.nbpType(val)
+		}, nil
+	}
+
+	side, field, found := strings.Cut(key, "-")
+	if !found {
+		side, field = "", key
+	} else if side != "src" && side != "dst" {
+		return nil, fmt.Errorf("condition %q: unknown key %q", s, key)
+	}
+	src, dst := side != "dst", side != "src"
+
+	switch field {
+	case "net":
+		sp, err := parseSpan(val, 0xffff)
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet) bool {
+			s, d, ok := p.addrs()
+			return ok && ((src && sp.has(uint64(s.Network))) || (dst && sp.has(uint64(d.Network))))
+		}, nil
+	case "node":
+		sp, err := parseSpan(val, 0xff)
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet) bool {
+			s, d, ok := p.addrs()
+			return ok && ((src && sp.has(uint64(s.Node))) || (dst && sp.has(uint64(d.Node))))
+		}, nil
+	case "socket":
+		sp, err := parseSpan(val, 0xff)
+		if err != nil {
+			return nil, err
+		}
+		return func(p *packet) bool {
+			return p.ddp != nil &&
+				((src && sp.has(uint64(p.ddp.SrcSocket))) || (dst && sp.has(uint64(p.ddp.DstSocket))))
+		}, nil
+	}
+	return nil, fmt.Errorf("condition %q: unknown key %q", s, key)
+}
+
+func parseProto(s string) (uint8, error) {
+	for t := 0; t < 256; t++ {
+		if s == bridge.ProtoName(uint8(t)) {
+			return uint8(t), nil
+		}
+	}
+	t, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown DDP type %q", s)
+	}
+	return uint8(t), nil
+}
+
+func parseOpcode(s string) (aarp.Opcode, error) {
+	switch s {
+	case "request":
+		return aarp.RequestOp, nil
+	case "response":
+		return aarp.ResponseOp, nil
+	case "probe":
+		return aarp.ProbeOp, nil
+	}
+	return 0, fmt.Errorf("unknown AARP opcode %q", s)
+}
+
+// Parses a span of the form “100-109”, or a single number “100”.
+func parseSpan(s string, max uint64) (span, error) {
+	first, last, found := strings.Cut(s, "-")
+	if !found {
+		last = first
+	}
+	lo, err := strconv.ParseUint(first, 0, 64)
+	if err != nil || lo > max {
+		return span{}, fmt.Errorf("invalid range %q", s)
+	}
+	hi, err := strconv.ParseUint(last, 0, 64)
+	if err != nil || hi > max || hi < lo {
+		return span{}, fmt.Errorf("invalid range %q", s)
+	}
+	return span{lo, hi}, nil
+}
+
+func (sp span) has(n uint64) bool {
+	return sp.lo <= n && n <= sp.hi
+}
+
+func decode(pak ethertalk.Packet) *packet {
+	p := &packet{}
+	switch pak.SNAPProto {
+	case ethertalk.AARPProto:
+		a := aarp.View{}
+		if aarp.UnmarshalView(pak.Payload, &a) == nil {
+			p.aarp = &a
+		}
+	case ethertalk.AppleTalkProto:
+		d := ddp.ExtView{}
+		if ddp.ExtUnmarshalView(pak.Payload, &d) == nil {
+			p.ddp = &d
+		}
+	}
+	return p
+}
+
+// Returns the source and destination addresses of a DDP or AARP packet.
+func (p *packet) addrs() (src, dst ddp.Addr, ok bool) {
+	if p.ddp != nil {
+		return ddp.Addr{Network: p.ddp.SrcNet, Node: p.ddp.SrcNode},
+			ddp.Addr{Network: p.ddp.DstNet, Node: p.ddp.DstNode}, true
+	} else if p.aarp != nil {
+		return p.aarp.Src.Proto, p.aarp.Dst.Proto, true
+	}
+	return ddp.Addr{}, ddp.Addr{}, false
+}
+
+// Returns true if p is an NBP packet with an entity whose type matches
+// pattern.
+func (p *packet) nbpType(pattern string) bool {
+	if p.ddp == nil || p.ddp.Proto != ddp.ProtoNBP {
+		return false
+	}
+	n := nbp.Packet{}
+	if nbp.Unmarshal(p.ddp.Data, &n) != nil {
+		return false
+	}
+	for _, t := range n.Tuples {
+		if nbp.Match(pattern, t.Entity.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the rule applies to p.
+func (r Rule) match(p *packet) bool {
+	for _, c := range r.conds {
+		if !c(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the first of rules that applies to p allows it.
+func allow(rules []Rule, pak ethertalk.Packet) (bool, Rule) {
+	if len(rules) == 0 {
+		return true, Rule{}
+	}
+	p := decode(pak)
+	for _, r := range rules {
+		if r.match(p) {
+			return r.Allow, r
+		}
+	}
+	return true, Rule{}
+}
+
+// Drops the packets that b sends to and receives from the Group, if the
+// rules for b’s port deny them. Drops are counted in metrics.FilterDrops.
+func Apply(rules []Rule, b bridge.ExtBridge) bridge.ExtBridge {
+	if len(rules) == 0 {
+		return b
+	}
+	return &filtered{rules, b}
+}
+
+func (f *filtered) String() string      { return bridge.PortName(f.b) }
+func (f *filtered) Unwrap() interface{} { return f.b }
+
+func (f *filtered) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- ethertalk.Packet,
+	recv <-chan ethertalk.Packet,
+) {
+	// Ports such as TCP peers are named when they connect, so rules are
+	// chosen at Start.
+	port := f.String()
+	in, out := []Rule{}, []Rule{}
+	for _, r := range f.rules {
+		if !r.Port(port) {
+			continue
+		}
+		if r.In {
+			in = append(in, r)
+		}
+		if r.Out {
+			out = append(out, r)
+		}
+	}
+
+	s, r := f.b.Start(ctx, log)
+	if len(in) == 0 && len(out) == 0 {
+		return s, r
+	}
+	log = log.With(zap.String("port", port))
+	sendCh := make(chan ethertalk.Packet)
+	recvCh := make(chan ethertalk.Packet)
+	go func() {
+		defer close(s)
+		for pak := range sendCh {
+			if ok, rule := allow(out, pak); ok {
+				s <- pak
+			} else {
+				drop(log, port, "out", rule)
+			}
+		}
+	}()
+	go func() {
+		defer close(recvCh)
+		for pak := range r {
+			if ok, rule := allow(in, pak); ok {
+				recvCh <- pak
+			} else {
+				drop(log, port, "in", rule)
+			}
+		}
+	}()
+	return sendCh, recvCh
+}
+
+func drop(log *zap.Logger, port, dir string, rule Rule) {
+	log.Debug("filtered", zap.String("direction", dir), zap.Stringer("rule", rule))
+	metrics.FilterDrops.With(port, dir).Inc()
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package filter
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/internal/sim"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/aep"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/nbp"
+)
+
+var mac = ethernet.Addr{0x08, 0x00, 0x07, 0x12, 0x34, 0x56}
+
+type named struct {
+	*sim.Segment[ethertalk.Packet]
+	name string
+}
+
+func (n named) String() string { return n.name }
+
+func etherDDP(t *testing.T, src, dst ddp.Addr, socket ddp.Socket, proto uint8, data []byte) ethertalk.Packet {
+	out, err := ethertalk.AppleTalk(mac, ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			Size:   uint16(ddp.ExtHeaderSize + len(data)),
+			DstNet: dst.Network, DstNode: dst.Node, DstSocket: socket,
+			SrcNet: src.Network, SrcNode: src.Node, SrcSocket: socket,
+			Proto: proto,
+		},
+		Data: data,
+	})
+	require.NoError(t, err)
+	return *out
+}
+
+func lookup(t *testing.T, typ string) ethertalk.Packet {
+	data, err := nbp.Marshal(nbp.LookupReply(1, nbp.Tuple{
+		Addr:   ddp.Addr{Network: 100, Node: 5},
+		Socket: 0x80,
+		Entity: nbp.Entity{Object: "Printer", Type: typ, Zone: "*"},
+	}))
+	require.NoError(t, err)
+	return etherDDP(t, ddp.Addr{Network: 100, Node: 5}, ddp.Addr{Network: 200, Node: 1}, nbp.Socket, ddp.ProtoNBP, data)
+}
+
+func etherAARP(t *testing.T, a aarp.Packet) ethertalk.Packet {
+	out, err := ethertalk.AARP(mac, a)
+	require.NoError(t, err)
+	return *out
+}
+
+func TestParse(t *testing.T) {
+	r, err := Parse("tcp:*   out deny ddp=nbp,22 src-net=100-109")
+	require.NoError(t, err)
+	assert.Equal(t, "tcp:* out deny ddp=nbp,22 src-net=100-109", r.String())
+	assert.False(t, r.In)
+	assert.True(t, r.Out)
+	assert.False(t, r.Allow)
+	assert.True(t, r.Port("tcp:192.0.2.1:1234"))
+	assert.False(t, r.Port("ethertalk:eth0"))
+
+	for _, bad := range []string{
+		"tcp:* out",
+		"tcp:* sideways deny",
+		"tcp:* out drop",
+		"tcp:* out deny ddp",
+		"tcp:* out deny ddp=gopher",
+		"tcp:* out deny aarp=reply",
+		"tcp:* out deny node=300",
+		"tcp:* out deny net=9-1",
+		"tcp:* out deny via-net=1",
+		"tcp:* out deny color=red",
+	} {
+		_, err := Parse(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	rules, err := ParseFile(strings.NewReader("# Printers stay local.\n\n* both deny nbp-type=LaserWriter\n"))
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "* both deny nbp-type=LaserWriter", rules[0].String())
+
+	_, err = ParseFile(strings.NewReader("* both allow\n* both\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
+
+func TestAllow(t *testing.T) {
+	a := ddp.Addr{Network: 100, Node: 5}
+	b := ddp.Addr{Network: 200, Node: 1}
+	echo := etherDDP(t, a, b, aep.Socket, ddp.ProtoAEP, []byte{1})
+	probe := etherAARP(t, aarp.Probe(mac, a))
+
+	tests := []struct {
+		rule string
+		pak  ethertalk.Packet
+		deny bool
+	}{
+		{"* in deny", echo, true},
+		{"* in deny ddp=aep", echo, true},
+		{"* in deny ddp=4", echo, true},
+		{"* in deny ddp=nbp,atp", echo, false},
+		{"* in deny ddp=aep", probe, false},
+		{"* in deny socket=4", echo, true},
+		{"* in deny dst-socket=1-3", echo, false},
+		{"* in deny net=200", echo, true},
+		{"* in deny src-net=200", echo, false},
+		{"* in deny dst-net=150-250 dst-node=1", echo, true},
+		{"* in deny dst-net=150-250 dst-node=2", echo, false},
+		{"* in deny node=5", probe, true},
+		{"* in deny aarp=probe", probe, true},
+		{"* in deny aarp=request,response", probe, false},
+		{"* in deny aarp=probe", echo, false},
+		{"* in deny nbp-type=LaserWriter", lookup(t, "LaserWriter"), true},
+		{"* in deny nbp-type=laser≈", lookup(t, "LaserWriter"), true},
+		{"* in deny nbp-type=LaserWriter", lookup(t, "AFPServer"), false},
+		{"* in deny nbp-type=LaserWriter", echo, false},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.rule)
+		require.NoError(t, err, tt.rule)
+		ok, _ := allow([]Rule{r}, tt.pak)
+		assert.Equal(t, tt.deny, !ok, tt.rule)
+	}
+}
+
+func TestFirstMatch(t *testing.T) {
+	rules := []Rule{}
+	for _, s := range []string{
+		"* in allow nbp-type=LaserWriter src-net=100",
+		"* in deny nbp-type=LaserWriter",
+	} {
+		r, err := Parse(s)
+		require.NoError(t, err)
+		rules = append(rules, r)
+	}
+	ok, _ := allow(rules, lookup(t, "LaserWriter"))
+	assert.True(t, ok)
+	ok, rule := allow(rules[1:], lookup(t, "LaserWriter"))
+	assert.False(t, ok)
+	assert.Equal(t, "* in deny nbp-type=LaserWriter", rule.String())
+}
+
+func TestApply(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := sim.New(ctx, zap.NewNop())
+
+	rules := []Rule{}
+	for _, r := range []string{
+		"test:filter-lab in deny ddp=aep",
+		"test:filter-wan out deny nbp-type=LaserWriter",
+	} {
+		rule, err := Parse(r)
+		require.NoError(t, err)
+		rules = append(rules, rule)
+	}
+
+	labDrops := metrics.FilterDrops.With("test:filter-lab", "in").Value()
+	wanDrops := metrics.FilterDrops.With("test:filter-wan", "out").Value()
+	lab := named{sim.NewSegment[ethertalk.Packet](), "test:filter-lab"}
+	wan := named{sim.NewSegment[ethertalk.Packet](), "test:filter-wan"}
+	s.Add(Apply(rules, lab))
+	s.Add(Apply(rules, wan))
+
+	// Echoes from the lab and printers in the lab stay in the lab.
+	a := ddp.Addr{Network: 100, Node: 5}
+	b := ddp.Addr{Network: 200, Node: 1}
+	lab.Send(etherDDP(t, a, b, aep.Socket, ddp.ProtoAEP, []byte{1}))
+	lab.Send(lookup(t, "LaserWriter"))
+	lab.Send(lookup(t, "AFPServer"))
+	pak, err := wan.Recv()
+	require.NoError(t, err)
+	assert.Equal(lookup(t, "AFPServer"), pak)
+
+	// Echoes from elsewhere reach the lab.
+	wan.Send(etherDDP(t, b, a, aep.Socket, ddp.ProtoAEP, []byte{1}))
+	_, err = lab.Recv()
+	require.NoError(t, err)
+
+	assert.Equal(labDrops+1, metrics.FilterDrops.With("test:filter-lab", "in").Value())
+	assert.Equal(wanDrops+1, metrics.FilterDrops.With("test:filter-wan", "out").Value())
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Limits the rate of packets that each port sends into a Group
+//
+// Packets are counted against token buckets: one for the port, one for
+// each source node, and a stricter one for each source node’s broadcast
+// and AARP packets, which every other port receives. A packet that finds
+// any of its buckets empty is dropped, so that one misbehaving node can’t
+// flood slow links such as LocalTalk.
+package limit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+)
+
+const (
+	// Buckets for nodes are forgotten once they refill, if there are
+	// more than this many.
+	maxIdle = 256
+
+	// Nodes beyond this many share a single bucket, so that a flood
+	// from spoofed sources can’t use unbounded memory.
+	maxNodes = 4096
+)
+
+type (
+	// A sustained rate of packets per second, and a burst of packets that
+	// may exceed it. The zero Rate is unlimited.
+	Rate struct {
+		PerSecond float64
+		Burst     int
+	}
+
+	Config struct {
+		Port      Rate // All packets from the port
+		Node      Rate // All packets from each source node
+		Broadcast Rate // Broadcast and AARP packets from each source node
+	}
+
+	bucket struct {
+		tokens float64
+		last   time.Time
+	}
+
+	// Identifies a source node by its AppleTalk address, or by its
+	// Ethernet address if the packet can’t be unmarshaled.
+	node struct {
+		addr ddp.Addr
+		mac  ethernet.Addr
+	}
+
+	// Buckets for each source node, and one shared by the nodes that
+	// don’t fit.
+	nodeBuckets struct {
+		m        map[node]*bucket
+		overflow bucket
+	}
+
+	limiter struct {
+		cfg   Config
+		port  bucket
+		nodes nodeBuckets
+		bcast nodeBuckets
+	}
+
+	limited struct {
+		cfg Config
+		b   bridge.ExtBridge
+		now func() time.Time
+	}
+)
+
+// Parses a rate of the form “100”, for 100 packets per second with a
+// burst of 100, or “100/500”, for a burst of 500.
+func ParseRate(s string) (Rate, error) {
+	rate, burst, found := strings.Cut(s, "/")
+	r := Rate{}
+	var err error
+	r.PerSecond, err = strconv.ParseFloat(rate, 64)
+	if err != nil || r.PerSecond < 0 {
+		return Rate{}, fmt.Errorf("parse rate %q: invalid rate", s)
+	}
+	r.Burst = int(r.PerSecond)
+	if found {
+		r.Burst, err = strconv.Atoi(burst)
+		if err != nil || r.Burst < 1 {
+			return Rate{}, fmt.Errorf("parse rate %q: invalid burst", s)
+		}
+	} else if r.PerSecond > 0 && r.Burst < 1 {
+		r.Burst = 1
+	}
+	return r, nil
+}
+
+// Returns true if the rate is unlimited.
+func (r Rate) IsZero() bool {
+	return r.PerSecond == 0
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%g/%d", r.PerSecond, r.Burst)
+}
+
+// Returns true if the config has no limits.
+func (c Config) IsZero() bool {
+	return c.Port.IsZero() && c.Node.IsZero() && c.Broadcast.IsZero()
+}
+
+// Takes a token from the bucket at time now, returning false if it is
+// empty.
+func (b *bucket) take(r Rate, now time.Time) bool {
+	if !b.ready(r, now) {
+		return false
+	} else if b != nil && !r.IsZero() {
+		b.tokens--
+	}
+	return true
+}
+
+// Returns true if the bucket has a token at time now. A nil bucket
+// always does.
+func (b *bucket) ready(r Rate, now time.Time) bool {
+	if b == nil || r.IsZero() {
+		return true
+	}
+	b.refill(r, now)
+	return b.tokens >= 1
+}
+
+func (b *bucket) refill(r Rate, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(r.Burst)
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * r.PerSecond
+		if b.tokens > float64(r.Burst) {
+			b.tokens = float64(r.Burst)
+		}
+	}
+	b.last = now
+}
+
+func newLimiter(cfg Config) *limiter {
+	return &limiter{
+		cfg:   cfg,
+		nodes: nodeBuckets{m: map[node]*bucket{}},
+		bcast: nodeBuckets{m: map[node]*bucket{}},
+	}
+}
+
+// Returns "" if the packet is allowed, or else the limit it exceeds:
+// "port", "node", or "broadcast".
+func (l *limiter) allow(pak ethertalk.Packet, now time.Time) string {
+	src, broadcast := classify(pak)
+	var bcast *bucket
+	if broadcast {
+		bcast = l.bcast.get(src, l.cfg.Broadcast, now)
+	}
+	nodes := l.nodes.get(src, l.cfg.Node, now)
+
+	// Check every bucket before taking from any, so that a dropped
+	// packet doesn’t use up the budgets of the limits it didn’t exceed.
+	if !bcast.ready(l.cfg.Broadcast, now) {
+		return "broadcast"
+	} else if !nodes.ready(l.cfg.Node, now) {
+		return "node"
+	} else if !l.port.ready(l.cfg.Port, now) {
+		return "port"
+	}
+	bcast.take(l.cfg.Broadcast, now)
+	nodes.take(l.cfg.Node, now)
+	l.port.take(l.cfg.Port, now)
+	return ""
+}
+
+// Returns the bucket for src, or nil if r is unlimited.
+func (nb *nodeBuckets) get(src node, r Rate, now time.Time) *bucket {
+	if r.IsZero() {
+		return nil
+	} else if b, ok := nb.m[src]; ok {
+		return b
+	}
+	if len(nb.m) >= maxIdle {
+		forget(nb.m, r, now)
+	}
+	if len(nb.m) >= maxNodes {
+		return &nb.overflow
+	}
+	b := &bucket{}
+	nb.m[src] = b
+	return b
+}
+
+// Forgets the buckets that have refilled, since they behave the same as
+// new ones.
+func forget(buckets map[node]*bucket, r Rate, now time.Time) {
+	for n, b := range buckets {
+		b.refill(r, now)
+		if b.tokens >= float64(r.Burst) {
+			delete(buckets, n)
+		}
+	}
+}
+
+// Returns the source node of a packet, and whether every other port
+// receives it.
+func classify(pak ethertalk.Packet) (src node, broadcast bool) {
+	src.mac = pak.Src
+	broadcast = pak.Dst[0]&0x01 != 0
+	switch pak.SNAPProto {
+	case ethertalk.AARPProto:
+		a := aarp.View{}
+		if aarp.UnmarshalView(pak.Payload, &a) == nil {
+			src = node{addr: a.Src.Proto}
+		}
+		return src, true
+	case ethertalk.AppleTalkProto:
+		d := ddp.ExtView{}
+		if ddp.ExtUnmarshalView(pak.Payload, &d) == nil {
+			src = node{addr: ddp.Addr{Network: d.SrcNet, Node: d.SrcNode}}
+			broadcast = broadcast || d.DstNode == 0xff
+		}
+	}
+	return src, broadcast
+}
+
+// Drops the packets that b sends to the Group in excess of cfg. Drops are
+// counted in metrics.RateLimitDrops.
+func Apply(cfg Config, b bridge.ExtBridge) bridge.ExtBridge {
+	if cfg.IsZero() {
+		return b
+	}
+	return &limited{cfg, b, time.Now}
+}
+
+func (l *limited) String() string      { return bridge.PortName(l.b) }
+func (l *limited) Unwrap() interface{} { return l.b }
+
+func (l *limited) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- ethertalk.Packet,
+	recv <-chan ethertalk.Packet,
+) {
+	port := l.String()
+	log = log.With(zap.String("port", port))
+	lim := newLimiter(l.cfg)
+	s, r := l.b.Start(ctx, log)
+	recvCh := make(chan ethertalk.Packet)
+	go func() {
+		defer close(recvCh)
+		for pak := range r {
+			if limit := lim.allow(pak, l.now()); limit != "" {
+				log.Debug("rate limited", zap.String("limit", limit))
+				metrics.RateLimitDrops.With(port, limit).Inc()
+				continue
+			}
+			recvCh <- pak
+		}
+	}()
+	return s, recvCh
+}
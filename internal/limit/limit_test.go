@@ -0,0 +1,229 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/internal/sim"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/aep"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+)
+
+var (
+	mac = ethernet.Addr{0x08, 0x00, 0x07, 0x12, 0x34, 0x56}
+	t0  = time.Date(1991, 6, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type named struct {
+	*sim.Segment[ethertalk.Packet]
+	name string
+}
+
+func (n named) String() string { return n.name }
+
+// Returns an echo request from node src to dst on network 100, sent to
+// dst’s Ethernet address unless it is broadcast.
+func echo(t *testing.T, src, dst ddp.Node) ethertalk.Packet {
+	out, err := ethertalk.AppleTalk(mac, ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			Size:   ddp.ExtHeaderSize + 1,
+			DstNet: 100, DstNode: dst, DstSocket: aep.Socket,
+			SrcNet: 100, SrcNode: src, SrcSocket: aep.Socket,
+			Proto: ddp.ProtoAEP,
+		},
+		Data: []byte{1},
+	})
+	require.NoError(t, err)
+	if dst != 0xff {
+		out.Dst = ethernet.Addr{0x08, 0x00, 0x07, 0x00, 0x00, byte(dst)}
+	}
+	return *out
+}
+
+func probe(t *testing.T, src ddp.Node) ethertalk.Packet {
+	out, err := ethertalk.AARP(mac, aarp.Probe(mac, ddp.Addr{Network: 100, Node: src}))
+	require.NoError(t, err)
+	return *out
+}
+
+func TestParseRate(t *testing.T) {
+	r, err := ParseRate("100")
+	require.NoError(t, err)
+	assert.Equal(t, Rate{100, 100}, r)
+	r, err = ParseRate("0.5/3")
+	require.NoError(t, err)
+	assert.Equal(t, Rate{0.5, 3}, r)
+	r, err = ParseRate("0.5")
+	require.NoError(t, err)
+	assert.Equal(t, Rate{0.5, 1}, r)
+	r, err = ParseRate("0")
+	require.NoError(t, err)
+	assert.True(t, r.IsZero())
+
+	for _, bad := range []string{"", "fast", "-1", "10/0", "10/x"} {
+		_, err := ParseRate(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	r := Rate{PerSecond: 2, Burst: 3}
+	b := bucket{}
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.take(r, t0))
+	}
+	assert.False(t, b.take(r, t0))
+	assert.True(t, b.take(r, t0.Add(500*time.Millisecond)))
+	assert.False(t, b.take(r, t0.Add(500*time.Millisecond)))
+
+	// Refills stop at the burst.
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.take(r, t0.Add(time.Hour)))
+	}
+	assert.False(t, b.take(r, t0.Add(time.Hour)))
+}
+
+func TestLimiter(t *testing.T) {
+	assert := assert.New(t)
+	l := newLimiter(Config{
+		Port:      Rate{PerSecond: 10, Burst: 5},
+		Node:      Rate{PerSecond: 10, Burst: 3},
+		Broadcast: Rate{PerSecond: 1, Burst: 1},
+	})
+
+	// Each node has its own budget, but shares the port’s.
+	for i := 0; i < 3; i++ {
+		assert.Equal("", l.allow(echo(t, 5, 6), t0))
+	}
+	assert.Equal("node", l.allow(echo(t, 5, 6), t0))
+	assert.Equal("", l.allow(echo(t, 7, 6), t0))
+	assert.Equal("", l.allow(echo(t, 7, 6), t0))
+	assert.Equal("port", l.allow(echo(t, 7, 6), t0))
+
+	// Broadcasts and AARP share a stricter budget.
+	t1 := t0.Add(time.Second)
+	assert.Equal("", l.allow(echo(t, 8, 0xff), t1))
+	assert.Equal("broadcast", l.allow(probe(t, 8), t1))
+	assert.Equal("", l.allow(probe(t, 9), t1))
+	assert.Equal("", l.allow(echo(t, 8, 6), t1))
+}
+
+func TestForget(t *testing.T) {
+	l := newLimiter(Config{Node: Rate{PerSecond: 1, Burst: 1}})
+	for i := 0; i < maxIdle; i++ {
+		assert.Equal(t, "", l.allow(echo(t, ddp.Node(i), 0), t0))
+	}
+	assert.Equal(t, maxIdle, len(l.nodes.m))
+
+	// A new node makes room by forgetting the nodes that have refilled.
+	pak, err := ethertalk.AARP(mac, aarp.Probe(mac, ddp.Addr{Network: 200, Node: 1}))
+	require.NoError(t, err)
+	assert.Equal(t, "", l.allow(*pak, t0.Add(time.Second)))
+	assert.Equal(t, 1, len(l.nodes.m))
+}
+
+func TestDropTakesNothing(t *testing.T) {
+	assert := assert.New(t)
+	l := newLimiter(Config{
+		Port:      Rate{PerSecond: 1, Burst: 2},
+		Node:      Rate{PerSecond: 1, Burst: 1},
+		Broadcast: Rate{PerSecond: 0.001, Burst: 2},
+	})
+
+	// A broadcast dropped by the node limit keeps its broadcast and
+	// port budgets, so the node can broadcast again once it refills.
+	assert.Equal("", l.allow(echo(t, 5, 0xff), t0))
+	assert.Equal("node", l.allow(echo(t, 5, 0xff), t0))
+	assert.Equal("node", l.allow(echo(t, 5, 0xff), t0))
+	assert.Equal("", l.allow(echo(t, 5, 0xff), t0.Add(time.Second)))
+	assert.Equal("broadcast", l.allow(echo(t, 5, 0xff), t0.Add(2*time.Second)))
+}
+
+func TestMaxNodes(t *testing.T) {
+	assert := assert.New(t)
+	l := newLimiter(Config{Node: Rate{PerSecond: 0.001, Burst: 2}})
+	from := func(n int) ethertalk.Packet {
+		pak, err := ethertalk.AARP(mac, aarp.Probe(mac, ddp.Addr{Network: ddp.Network(n), Node: 1}))
+		require.NoError(t, err)
+		return *pak
+	}
+
+	// Buckets that haven’t refilled can’t be forgotten, so once the
+	// map is full, new nodes share one bucket.
+	for i := 0; i < maxNodes; i++ {
+		assert.Equal("", l.allow(from(i), t0))
+	}
+	assert.Equal(maxNodes, len(l.nodes.m))
+	assert.Equal("", l.allow(from(maxNodes), t0))
+	assert.Equal("", l.allow(from(maxNodes+1), t0))
+	assert.Equal("node", l.allow(from(maxNodes+2), t0))
+	assert.Equal(maxNodes, len(l.nodes.m))
+
+	// Nodes already in the map keep their own buckets.
+	assert.Equal("", l.allow(from(0), t0))
+}
+
+func TestApply(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := sim.New(ctx, zap.NewNop())
+
+	emu := named{sim.NewSegment[ethertalk.Packet](), "test:limit-emu"}
+	tt := sim.NewSegment[ethertalk.Packet]()
+	l := Apply(Config{Broadcast: Rate{PerSecond: 1, Burst: 2}}, emu).(*limited)
+	l.now = func() time.Time { return t0 }
+	s.Add(l)
+	s.Add(tt)
+
+	drops := metrics.RateLimitDrops.With("test:limit-emu", "broadcast").Value()
+	for i := 0; i < 5; i++ {
+		emu.Send(probe(t, 5))
+	}
+	emu.Send(echo(t, 5, 6))
+	for i := 0; i < 2; i++ {
+		pak, err := tt.Recv()
+		require.NoError(t, err)
+		assert.Equal(probe(t, 5), pak)
+	}
+	pak, err := tt.Recv()
+	require.NoError(t, err)
+	assert.Equal(echo(t, 5, 6), pak)
+	assert.Equal(drops+3, metrics.RateLimitDrops.With("test:limit-emu", "broadcast").Value())
+}
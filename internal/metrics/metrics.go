@@ -0,0 +1,270 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Exports counters in the Prometheus text format
+//
+// Only counters and gauges are supported, which is all that multitalk
+// exports. The metrics themselves are defined below, so that they are
+// documented in one place.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// A Registry is a set of metric families, which are written in the
+	// order that they were created.
+	Registry struct {
+		mu       sync.Mutex
+		families []*family
+	}
+
+	family struct {
+		name, help, kind string
+		labels           []string
+
+		mu     sync.Mutex
+		series map[string]*series
+	}
+
+	series struct {
+		values  []string
+		counter *Counter
+		gauge   *Gauge
+		fn      func() int64
+	}
+
+	// A value that only increases.
+	Counter struct{ v atomic.Int64 }
+
+	// A value that increases and decreases.
+	Gauge struct{ v atomic.Int64 }
+
+	// Counters with the same name, distinguished by their label values.
+	CounterVec struct{ f *family }
+)
+
+// The registry of the metrics below.
+var Default = &Registry{}
+
+var (
+	Packets = Default.NewCounterVec(
+		"multitalk_packets_total",
+		"Packets crossing each port, by direction and protocol.",
+		"port", "direction", "protocol")
+	Bytes = Default.NewCounterVec(
+		"multitalk_bytes_total",
+		"Bytes of DDP and AARP packets crossing each port, by direction and protocol.",
+		"port", "direction", "protocol")
+	UnmarshalErrors = Default.NewCounterVec(
+		"multitalk_unmarshal_errors_total",
+		"Packets received on each port that could not be unmarshaled.",
+		"port")
+	ConversionErrors = Default.NewCounterVec(
+		"multitalk_conversion_errors_total",
+		"Packets that a router could not convert from EtherTalk to LLAP.",
+		"port")
+	QueueDrops = Default.NewCounterVec(
+		"multitalk_queue_drops_total",
+		"Packets dropped because a router’s or serial port’s queue was full.",
+		"port")
+	FilterDrops = Default.NewCounterVec(
+		"multitalk_filter_drops_total",
+		"Packets dropped by filter rules, by port and direction.",
+		"port", "direction")
+	RateLimitDrops = Default.NewCounterVec(
+		"multitalk_rate_limit_drops_total",
+		"Packets from each port dropped for exceeding a rate limit, by limit.",
+		"port", "limit")
+	TCPPeers = Default.NewGauge(
+		"multitalk_tcp_peers",
+		"Connected TCP clients and servers.")
+	CRCErrors = Default.NewCounterVec(
+		"multitalk_tashtalk_crc_errors_total",
+		"Frames from a TashTalk adapter dropped for an invalid CRC.",
+		"port")
+)
+
+func (c *Counter) Inc()         { c.v.Add(1) }
+func (c *Counter) Add(n int)    { c.v.Add(int64(n)) }
+func (c *Counter) Value() int64 { return c.v.Load() }
+
+func (g *Gauge) Inc()         { g.v.Add(1) }
+func (g *Gauge) Dec()         { g.v.Add(-1) }
+func (g *Gauge) Value() int64 { return g.v.Load() }
+
+// Creates a family of counters with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.newFamily(name, help, "counter", labels)}
+}
+
+// Creates a gauge without labels.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	f := r.newFamily(name, help, "gauge", nil)
+	f.series[""] = &series{gauge: g}
+	return g
+}
+
+func (r *Registry) newFamily(name, help, kind string, labels []string) *family {
+	f := &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*series{},
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+	return f
+}
+
+// Returns the counter with the given label values, creating it if needed.
+// Panics if the number of values doesn’t match the number of labels.
+func (v *CounterVec) With(values ...string) *Counter {
+	f := v.f
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("%s: %d label values for %d labels", f.name, len(values), len(f.labels)))
+	}
+	key := strings.Join(values, "\x00")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: values, counter: &Counter{}}
+		f.series[key] = s
+	}
+	return s.counter
+}
+
+// Sets the counter with the given label values to be read from fn, for
+// values that are counted elsewhere.
+func (v *CounterVec) Func(fn func() int64, values ...string) {
+	f := v.f
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("%s: %d label values for %d labels", f.name, len(values), len(f.labels)))
+	}
+	key := strings.Join(values, "\x00")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.series[key] = &series{values: values, fn: fn}
+}
+
+// Calls fn with the label values and value of each counter.
+func (v *CounterVec) Each(fn func(values []string, value int64)) {
+	v.f.mu.Lock()
+	series := []*series{}
+	for _, s := range v.f.series {
+		series = append(series, s)
+	}
+	v.f.mu.Unlock()
+	for _, s := range series {
+		fn(s.values, s.value())
+	}
+}
+
+// Writes all metrics in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family{}, r.families...)
+	r.mu.Unlock()
+
+	for _, f := range families {
+		err := f.write(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *family) write(w io.Writer) error {
+	f.mu.Lock()
+	keys := []string{}
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := []*series{}
+	for _, k := range keys {
+		series = append(series, f.series[k])
+	}
+	f.mu.Unlock()
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range series {
+		b.WriteString(f.name)
+		if len(f.labels) > 0 {
+			b.WriteString("{")
+			for i, l := range f.labels {
+				if i > 0 {
+					b.WriteString(",")
+				}
+				fmt.Fprintf(b, "%s=\"%s\"", l, escape(s.values[i]))
+			}
+			b.WriteString("}")
+		}
+		fmt.Fprintf(b, " %d\n", s.value())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (s *series) value() int64 {
+	switch {
+	case s.counter != nil:
+		return s.counter.Value()
+	case s.gauge != nil:
+		return s.gauge.Value()
+	default:
+		return s.fn()
+	}
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// Serves the registry’s metrics, e.g. on /metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	r := &Registry{}
+	pkts := r.NewCounterVec("pkts_total", "Packets.", "port", "proto")
+	peers := r.NewGauge("peers", "Peers.")
+
+	pkts.With("b", "nbp").Inc()
+	pkts.With("a", "aarp").Add(3)
+	pkts.With("b", "nbp").Inc()
+	pkts.With(`"q\`, "x\ny").Inc()
+	pkts.Func(func() int64 { return 7 }, "c", "zip")
+	peers.Inc()
+	peers.Inc()
+	peers.Dec()
+
+	buf := &strings.Builder{}
+	require.NoError(t, r.Write(buf))
+	assert.Equal(t, strings.Join([]string{
+		"# HELP pkts_total Packets.",
+		"# TYPE pkts_total counter",
+		`pkts_total{port="\"q\\",proto="x\ny"} 1`,
+		`pkts_total{port="a",proto="aarp"} 3`,
+		`pkts_total{port="b",proto="nbp"} 2`,
+		`pkts_total{port="c",proto="zip"} 7`,
+		"# HELP peers Peers.",
+		"# TYPE peers gauge",
+		"peers 1",
+		"",
+	}, "\n"), buf.String())
+}
+
+func TestEach(t *testing.T) {
+	r := &Registry{}
+	pkts := r.NewCounterVec("pkts_total", "Packets.", "port", "proto")
+	pkts.With("a", "nbp").Add(2)
+	pkts.With("a", "zip").Add(3)
+	pkts.With("b", "nbp").Add(5)
+
+	sums := map[string]int64{}
+	pkts.Each(func(values []string, value int64) {
+		sums[values[0]] += value
+	})
+	assert.Equal(t, map[string]int64{"a": 5, "b": 5}, sums)
+}
+
+func TestWrongLabels(t *testing.T) {
+	r := &Registry{}
+	pkts := r.NewCounterVec("pkts_total", "Packets.", "port", "proto")
+	assert.Panics(t, func() { pkts.With("a") })
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := &Registry{}
+	r.NewGauge("peers", "Peers.")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "# HELP peers Peers.\n# TYPE peers gauge\npeers 0\n", w.Body.String())
+}
@@ -29,9 +29,11 @@
 package raw
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 
 	"github.com/google/gopacket"
@@ -39,6 +41,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
 	"github.com/sfiera/multitalk/pkg/ethernet"
 	"github.com/sfiera/multitalk/pkg/ethertalk"
 )
@@ -50,6 +53,10 @@ type (
 		mu          sync.Mutex
 		capturer    capturer
 		transmitter transmitter
+
+		// Hardware addresses seen sending on this network. Guarded
+		// by mu.
+		localAddrs map[ethernet.Addr]bool
 	}
 
 	capturer interface {
@@ -61,21 +68,27 @@ type (
 	}
 )
 
+const (
+	// Phase 2 frames are 802.3, so their type/length field is a length.
+	phase2Filter = "(atalk or aarp) and ether[12:2] <= 1500"
+	phase1Filter = "ether[12:2] = 0x809b or ether[12:2] = 0x80f3"
+)
+
 func EtherTalk(dev string) (bridge.ExtBridge, error) {
 	i, err := net.InterfaceByName(dev)
 	if err != nil {
 		return nil, fmt.Errorf("interface %s: %s", dev, err.Error())
 	}
 
-	b := &elap{dev: dev}
+	b := &elap{dev: dev, localAddrs: map[ethernet.Addr]bool{}}
 	copy(b.eth[:], i.HardwareAddr)
 
-	b.capturer, err = b.setupCapture(dev)
+	b.capturer, err = setupCapture(dev, phase2Filter)
 	if err != nil {
 		return nil, err
 	}
 
-	b.transmitter, err = b.setupTransmit(dev)
+	b.transmitter, err = setupTransmit(dev)
 	if err != nil {
 		return nil, err
 	}
@@ -99,13 +112,30 @@ func (b *elap) Start(ctx context.Context, log *zap.Logger) (
 	return sendCh, recvCh
 }
 
-func (b *elap) setupCapture(dev string) (capturer, error) {
+func (b *elap) String() string {
+	return "ethertalk:" + b.dev
+}
+
+// Returns the hardware addresses seen sending on this network, in order.
+func (b *elap) LocalAddrs() []ethernet.Addr {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	addrs := []ethernet.Addr{}
+	for addr := range b.localAddrs {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	return addrs
+}
+
+func setupCapture(dev, filter string) (capturer, error) {
 	capturer, err := pcap.OpenLive(dev, 4096, true, pcap.BlockForever)
 	if err != nil {
 		return nil, fmt.Errorf("open dev %s: %s", dev, err.Error())
 	}
 
-	filter := "atalk or aarp"
 	fp, err := capturer.CompileBPFFilter(filter)
 	if err != nil {
 		return nil, fmt.Errorf("compile filter %s: %s", filter, err.Error())
@@ -122,7 +152,6 @@ func (b *elap) setupCapture(dev string) (capturer, error) {
 func (b *elap) capture(log *zap.Logger, recvCh chan<- ethertalk.Packet) {
 	defer close(recvCh)
 
-	localAddrs := map[ethernet.Addr]bool{}
 	for {
 		data, ci, err := b.capturer.ReadPacketData()
 		if err != nil {
@@ -136,16 +165,16 @@ func (b *elap) capture(log *zap.Logger, recvCh chan<- ethertalk.Packet) {
 		err = ethertalk.Unmarshal(data, &packet)
 		if err != nil {
 			log.With(zap.Error(err)).Error("unmarshal failed")
+			metrics.UnmarshalErrors.With(b.String()).Inc()
 			continue
 		}
-		b.packet_handler(recvCh, packet, localAddrs)
+		b.packet_handler(recvCh, packet)
 	}
 }
 
 func (b *elap) packet_handler(
 	send chan<- ethertalk.Packet,
 	packet ethertalk.Packet,
-	localAddrs map[ethernet.Addr]bool,
 ) {
 	// Check to make sure the packet we just received wasn't sent
 	// by us (the bridge), otherwise this is how loops happen
@@ -157,18 +186,21 @@ func (b *elap) packet_handler(
 	// in the list of source addresses we've seen on our network.
 	// If it is, don't bother sending it over the bridge as the
 	// recipient is local.
-	if localAddrs[packet.Dst] {
+	b.mu.Lock()
+	if b.localAddrs[packet.Dst] {
+		b.mu.Unlock()
 		return
 	}
 
 	// Destination is remote, but originated locally, so we can add
 	// the source address to our list.
-	localAddrs[packet.Src] = true
+	b.localAddrs[packet.Src] = true
+	b.mu.Unlock()
 
 	send <- packet
 }
 
-func (b *elap) setupTransmit(dev string) (transmitter, error) {
+func setupTransmit(dev string) (transmitter, error) {
 	transmitter, err := pcap.OpenLive(dev, 1, false, 1000)
 	if err != nil {
 		return nil, fmt.Errorf("open dev %s: %s", dev, err.Error())
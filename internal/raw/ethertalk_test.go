@@ -0,0 +1,76 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package raw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+)
+
+func TestLocalAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dev := &fakeDev{make(chan []byte), make(chan []byte, 16)}
+	defer func() {
+		cancel()
+		close(dev.in)
+	}()
+	b := &elap{
+		dev:         "test",
+		eth:         bridgeEth,
+		capturer:    dev,
+		transmitter: dev,
+		localAddrs:  map[ethernet.Addr]bool{},
+	}
+	_, recv := b.Start(ctx, zap.NewNop())
+
+	macB := ethernet.Addr{0x08, 0x00, 0x07, 0x00, 0x00, 0x01}
+	for _, src := range []ethernet.Addr{macEth, macB, macEth} {
+		pak, err := ethertalk.AppleTalk(src, ddp.ExtPacket{
+			ExtHeader: ddp.ExtHeader{Size: ddp.ExtHeaderSize, DstNode: 0xff},
+		})
+		require.NoError(t, err)
+		data, err := ethertalk.Marshal(*pak)
+		require.NoError(t, err)
+		dev.in <- data
+		select {
+		case <-recv:
+		case <-time.After(time.Second):
+			t.Fatal("no packet received")
+		}
+	}
+	assert.Equal(t, []ethernet.Addr{macB, macEth}, b.LocalAddrs())
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package raw
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/amt"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+// Phase 1 EtherTalk carries LLAP frames inside Ethernet II, so it is
+// bridged as a LocalTalk network. AARP takes the place of LLAP’s ENQ
+// and ACK control frames, and is also used to map node IDs to hardware
+// addresses.
+type elap1 struct {
+	dev         string
+	eth         ethernet.Addr
+	mu          sync.Mutex
+	capturer    capturer
+	transmitter transmitter
+
+	// Maps nodes on this segment to their hardware addresses, and
+	// defends nodes on the far side of the bridge.
+	amt *amt.Table
+}
+
+// EtherTalkPhase1 bridges the Phase 1 EtherTalk network on dev.
+// The result must be extended with bridge.Extend before it can join a
+// bridge.Group alongside Phase 2 networks.
+func EtherTalkPhase1(dev string) (bridge.Bridge, []byte, error) {
+	i, err := net.InterfaceByName(dev)
+	if err != nil {
+		return nil, nil, fmt.Errorf("interface %s: %s", dev, err.Error())
+	}
+
+	b := &elap1{dev: dev, amt: amt.New(amt.DefaultMaxAge)}
+	copy(b.eth[:], i.HardwareAddr)
+
+	b.capturer, err = setupCapture(dev, phase1Filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b.transmitter, err = setupTransmit(dev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, i.HardwareAddr, nil
+}
+
+func (b *elap1) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- llap.Packet,
+	recv <-chan llap.Packet,
+) {
+	log = log.With(
+		zap.String("bridge", "raw1"),
+		zap.String("dev", b.dev),
+		zap.String("eth", b.eth.String()),
+	)
+	sendCh := make(chan llap.Packet)
+	recvCh := make(chan llap.Packet)
+	go b.capture(log, recvCh)
+	go b.transmit(log, sendCh)
+	return sendCh, recvCh
+}
+
+func (b *elap1) String() string {
+	return "ethertalk-phase1:" + b.dev
+}
+
+func (b *elap1) capture(log *zap.Logger, recvCh chan<- llap.Packet) {
+	defer close(recvCh)
+
+	for {
+		data, _, err := b.capturer.ReadPacketData()
+		if err != nil {
+			log.With(zap.Error(err)).Error("read packet failed")
+			return
+		}
+		packet := ethertalk.Phase1Packet{}
+		err = ethertalk.Phase1Unmarshal(data, &packet)
+		if err != nil {
+			log.With(zap.Error(err)).Error("unmarshal failed")
+			metrics.UnmarshalErrors.With(b.String()).Inc()
+			continue
+		} else if packet.Src == b.eth {
+			// Sent by us (the bridge); forwarding it would loop.
+			continue
+		}
+
+		switch packet.Type {
+		case ethertalk.Phase1AppleTalkType:
+			l := llap.Packet{}
+			err = llap.Unmarshal(packet.Payload, &l)
+			if err != nil {
+				log.With(zap.Error(err)).Error("unmarshal failed")
+				metrics.UnmarshalErrors.With(b.String()).Inc()
+				continue
+			}
+			b.amt.Glean(nodeAddr(l.SrcNode), packet.Src)
+			recvCh <- l
+
+		case ethertalk.Phase1AARPType:
+			a := aarp.Packet{}
+			err = aarp.Unmarshal(packet.Payload, &a)
+			if err != nil {
+				log.With(zap.Error(err)).Error("unmarshal failed")
+				metrics.UnmarshalErrors.With(b.String()).Inc()
+				continue
+			}
+			if l := b.aarpToLLAP(log, a); l != nil {
+				recvCh <- *l
+			}
+		}
+	}
+}
+
+func (b *elap1) aarpToLLAP(log *zap.Logger, a aarp.Packet) *llap.Packet {
+	// Phase 1 networks are nonextended, so only node IDs matter.
+	local := a
+	local.Src.Proto = nodeAddr(a.Src.Proto.Node)
+	local.Dst.Proto = nodeAddr(a.Dst.Proto.Node)
+	b.amt.Learn(local)
+
+	switch a.Opcode {
+	case aarp.ProbeOp:
+		if resp := b.amt.Respond(b.eth, local); resp != nil {
+			resp.Src.Proto.Network = a.Dst.Proto.Network
+			resp.Dst.Proto.Network = a.Src.Proto.Network
+			b.write(log, a.Src.Hardware, *resp)
+			return nil
+		}
+		return llap.Enq(a.Dst.Proto.Node, a.Src.Proto.Node)
+
+	case aarp.ResponseOp:
+		if a.Src.Proto != a.Dst.Proto {
+			return nil
+		}
+		return llap.Ack(a.Dst.Proto.Node, a.Src.Proto.Node)
+
+	case aarp.RequestOp:
+		if resp := b.amt.Respond(b.eth, local); resp != nil {
+			resp.Src.Proto.Network = a.Dst.Proto.Network
+			resp.Dst.Proto.Network = a.Src.Proto.Network
+			b.write(log, a.Src.Hardware, *resp)
+		}
+		return nil
+
+	default:
+		return nil
+	}
+}
+
+func (b *elap1) transmit(log *zap.Logger, ch <-chan llap.Packet) {
+	for packet := range ch {
+		switch packet.Kind {
+		case llap.TypeDDP, llap.TypeExtDDP:
+			b.amt.Defend(nodeAddr(packet.SrcNode))
+			out, err := ethertalk.Phase1AppleTalk(b.eth, b.resolve(log, packet), packet)
+			if err != nil {
+				log.With(zap.Error(err)).Error("marshal failed")
+				continue
+			}
+			b.writePacket(log, *out)
+
+		case llap.TypeEnq:
+			b.write(log, ethertalk.Phase1Broadcast, aarp.Probe(b.eth, nodeAddr(packet.DstNode)))
+
+		case llap.TypeAck:
+			dst, _ := b.amt.Lookup(nodeAddr(packet.DstNode))
+			b.write(log, ethertalk.Phase1Broadcast, aarp.Response(
+				aarp.AddrPair{Hardware: b.eth, Proto: nodeAddr(packet.SrcNode)},
+				aarp.AddrPair{Hardware: dst, Proto: nodeAddr(packet.DstNode)},
+			))
+		}
+	}
+}
+
+// Returns the hardware address for the packet’s destination node. If it
+// is not known, returns the broadcast address, which other nodes will
+// discard based on the LLAP header, and requests the node’s address.
+func (b *elap1) resolve(log *zap.Logger, packet llap.Packet) ethernet.Addr {
+	dst := nodeAddr(packet.DstNode)
+	if hw, ok := b.amt.Lookup(dst); ok {
+		return hw
+	} else if b.amt.Miss(dst) {
+		b.write(log, ethertalk.Phase1Broadcast, aarp.Request(
+			aarp.AddrPair{Hardware: b.eth, Proto: nodeAddr(packet.SrcNode)},
+			dst,
+		))
+	}
+	return ethertalk.Phase1Broadcast
+}
+
+func (b *elap1) write(log *zap.Logger, dst ethernet.Addr, a aarp.Packet) {
+	out, err := ethertalk.Phase1AARP(b.eth, dst, a)
+	if err != nil {
+		log.With(zap.Error(err)).Error("marshal failed")
+		return
+	}
+	b.writePacket(log, *out)
+}
+
+func (b *elap1) writePacket(log *zap.Logger, packet ethertalk.Phase1Packet) {
+	bin, err := ethertalk.Phase1Marshal(packet)
+	if err != nil {
+		log.With(zap.Error(err)).Error("marshal failed")
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err = b.transmitter.WritePacketData(bin)
+	if err != nil {
+		log.With(zap.Error(err)).Error("write packet")
+	}
+}
+
+func nodeAddr(node ddp.Node) ddp.Addr {
+	return ddp.Addr{Node: node}
+}
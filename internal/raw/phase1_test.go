@@ -0,0 +1,251 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package raw
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/amt"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+var (
+	bridgeEth = ethernet.Addr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	macEth    = ethernet.Addr{0x08, 0x00, 0x07, 0x12, 0x34, 0x56}
+)
+
+// Reads frames from a channel, as if from a network device.
+type fakeDev struct {
+	in  chan []byte
+	out chan []byte
+}
+
+func (d *fakeDev) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ok := <-d.in
+	if !ok {
+		return nil, gopacket.CaptureInfo{}, fmt.Errorf("closed")
+	}
+	return data, gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)}, nil
+}
+
+func (d *fakeDev) WritePacketData(data []byte) error {
+	d.out <- append([]byte{}, data...)
+	return nil
+}
+
+type phase1Test struct {
+	t    *testing.T
+	dev  *fakeDev
+	send chan<- llap.Packet
+	recv <-chan llap.Packet
+}
+
+func startPhase1(t *testing.T) *phase1Test {
+	ctx, cancel := context.WithCancel(context.Background())
+	dev := &fakeDev{make(chan []byte), make(chan []byte, 16)}
+	t.Cleanup(func() {
+		cancel()
+		close(dev.in)
+	})
+	b := &elap1{
+		dev:         "test",
+		eth:         bridgeEth,
+		capturer:    dev,
+		transmitter: dev,
+		amt:         amt.New(amt.DefaultMaxAge),
+	}
+	send, recv := b.Start(ctx, zap.NewNop())
+	return &phase1Test{t, dev, send, recv}
+}
+
+// Sends a frame to the bridge from the Ethernet side.
+func (p *phase1Test) capture(pak *ethertalk.Phase1Packet) {
+	data, err := ethertalk.Phase1Marshal(*pak)
+	require.NoError(p.t, err)
+	p.dev.in <- data
+}
+
+func (p *phase1Test) captureAARP(a aarp.Packet) {
+	pak, err := ethertalk.Phase1AARP(a.Src.Hardware, ethertalk.Phase1Broadcast, a)
+	require.NoError(p.t, err)
+	p.capture(pak)
+}
+
+// Returns the next frame written by the bridge to the Ethernet side.
+func (p *phase1Test) written() (ethertalk.Phase1Packet, bool) {
+	select {
+	case data := <-p.dev.out:
+		pak := ethertalk.Phase1Packet{}
+		require.NoError(p.t, ethertalk.Phase1Unmarshal(data, &pak))
+		return pak, true
+	case <-time.After(time.Second):
+		p.t.Error("no frame written")
+		return ethertalk.Phase1Packet{}, false
+	}
+}
+
+func (p *phase1Test) writtenAARP() (ethernet.Addr, aarp.Packet, bool) {
+	pak, ok := p.written()
+	if !ok || !assert.Equal(p.t, ethertalk.Phase1AARPType, pak.Type) {
+		return ethernet.Addr{}, aarp.Packet{}, false
+	}
+	a := aarp.Packet{}
+	require.NoError(p.t, aarp.Unmarshal(pak.Payload, &a))
+	return pak.Dst, a, true
+}
+
+// Returns the next LLAP packet passed from the Ethernet side to the bridge.
+func (p *phase1Test) received() (llap.Packet, bool) {
+	select {
+	case l := <-p.recv:
+		return l, true
+	case <-time.After(time.Second):
+		p.t.Error("no packet received")
+		return llap.Packet{}, false
+	}
+}
+
+func (p *phase1Test) nothingReceived() {
+	select {
+	case l := <-p.recv:
+		p.t.Errorf("unexpected packet %v", l)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func ddpPacket(t *testing.T, dst, src ddp.Node) llap.Packet {
+	l, err := llap.AppleTalk(dst, src, ddp.Packet{
+		Header: ddp.Header{Size: ddp.HeaderSize + 2, DstSocket: 4, SrcSocket: 4, Proto: ddp.ProtoAEP},
+		Data:   []byte{0x01, 0x00},
+	})
+	require.NoError(t, err)
+	return *l
+}
+
+func TestPhase1ProbeToEnq(t *testing.T) {
+	p := startPhase1(t)
+	p.captureAARP(aarp.Probe(macEth, ddp.Addr{Node: 9}))
+	if l, ok := p.received(); ok {
+		assert.Equal(t, *llap.Enq(9, 9), l)
+	}
+}
+
+func TestPhase1DefendProbe(t *testing.T) {
+	assert := assert.New(t)
+	p := startPhase1(t)
+
+	// Node 5 is on the far side of the bridge.
+	p.send <- ddpPacket(t, 0xff, 5)
+	pak, ok := p.written()
+	if ok {
+		assert.Equal(ethertalk.Phase1Broadcast, pak.Dst)
+	}
+
+	p.captureAARP(aarp.Probe(macEth, ddp.Addr{Network: 3, Node: 5}))
+	if dst, a, ok := p.writtenAARP(); ok {
+		assert.Equal(macEth, dst)
+		assert.Equal(aarp.ResponseOp, a.Opcode)
+		assert.Equal(aarp.AddrPair{Hardware: bridgeEth, Proto: ddp.Addr{Network: 3, Node: 5}}, a.Src)
+		assert.Equal(macEth, a.Dst.Hardware)
+	}
+	p.nothingReceived()
+}
+
+func TestPhase1ResponseToAck(t *testing.T) {
+	p := startPhase1(t)
+
+	// An answer to a request is not an ACK.
+	other := aarp.AddrPair{Hardware: bridgeEth, Proto: ddp.Addr{Node: 2}}
+	p.captureAARP(aarp.Response(aarp.AddrPair{Hardware: macEth, Proto: ddp.Addr{Node: 9}}, other))
+	p.nothingReceived()
+
+	// An answer to a probe is.
+	self := aarp.AddrPair{Hardware: macEth, Proto: ddp.Addr{Node: 9}}
+	p.captureAARP(aarp.Response(self, aarp.AddrPair{Proto: ddp.Addr{Node: 9}}))
+	if l, ok := p.received(); ok {
+		assert.Equal(t, *llap.Ack(9, 9), l)
+	}
+}
+
+func TestPhase1EnqAndAck(t *testing.T) {
+	assert := assert.New(t)
+	p := startPhase1(t)
+
+	p.send <- *llap.Enq(7, 7)
+	if dst, a, ok := p.writtenAARP(); ok {
+		assert.Equal(ethertalk.Phase1Broadcast, dst)
+		assert.Equal(aarp.Probe(bridgeEth, ddp.Addr{Node: 7}), a)
+	}
+
+	p.send <- *llap.Ack(7, 7)
+	if dst, a, ok := p.writtenAARP(); ok {
+		assert.Equal(ethertalk.Phase1Broadcast, dst)
+		assert.Equal(aarp.ResponseOp, a.Opcode)
+		assert.Equal(aarp.AddrPair{Hardware: bridgeEth, Proto: ddp.Addr{Node: 7}}, a.Src)
+	}
+}
+
+func TestPhase1Resolve(t *testing.T) {
+	assert := assert.New(t)
+	p := startPhase1(t)
+
+	// Unknown: request the address, and broadcast meanwhile.
+	p.send <- ddpPacket(t, 9, 5)
+	if dst, a, ok := p.writtenAARP(); ok {
+		assert.Equal(ethertalk.Phase1Broadcast, dst)
+		assert.Equal(aarp.Request(aarp.AddrPair{Hardware: bridgeEth, Proto: ddp.Addr{Node: 5}}, ddp.Addr{Node: 9}), a)
+	}
+	if pak, ok := p.written(); ok {
+		assert.Equal(ethertalk.Phase1AppleTalkType, pak.Type)
+		assert.Equal(ethertalk.Phase1Broadcast, pak.Dst)
+	}
+
+	// Learned from a DDP packet sent by the node.
+	out, err := ethertalk.Phase1AppleTalk(macEth, bridgeEth, ddpPacket(t, 5, 9))
+	require.NoError(t, err)
+	p.capture(out)
+	if l, ok := p.received(); ok {
+		assert.Equal(ddpPacket(t, 5, 9), l)
+	}
+	p.send <- ddpPacket(t, 9, 5)
+	if pak, ok := p.written(); ok {
+		assert.Equal(macEth, pak.Dst)
+	}
+}
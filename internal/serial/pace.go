@@ -0,0 +1,131 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package serial
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/llap"
+	"github.com/sfiera/multitalk/pkg/localtalk"
+)
+
+// Data frames that may wait for the bus before more are dropped.
+const maxQueue = 64
+
+type (
+	// Queues frames for the bus, with control frames ahead of data frames.
+	queue struct {
+		mu      sync.Mutex
+		cond    sync.Cond
+		control []llap.Packet
+		data    []llap.Packet
+		closed  bool
+	}
+
+	// Spaces frames as a LocalTalk bus would, so that the TashTalk
+	// adapter isn’t sent frames faster than it can transmit them.
+	pacer struct {
+		now   func() time.Time
+		sleep func(time.Duration)
+		free  time.Time // When the bus is next free
+	}
+)
+
+func newQueue() *queue {
+	q := &queue{}
+	q.cond.L = &q.mu
+	return q
+}
+
+// Returns true if the frame is part of a dialog’s handshake.
+func isControl(pak llap.Packet) bool {
+	return pak.Kind.IsControl()
+}
+
+// Adds a frame to the queue, returning false if it was dropped because
+// the queue is full.
+func (q *queue) push(pak llap.Packet) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if isControl(pak) {
+		q.control = append(q.control, pak)
+	} else if len(q.data) < maxQueue {
+		q.data = append(q.data, pak)
+	} else {
+		return false
+	}
+	q.cond.Signal()
+	return true
+}
+
+// Stops the queue. Frames already queued can still be popped.
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Removes the next frame from the queue, waiting for one if it is empty.
+// Returns false once the queue is closed and empty.
+func (q *queue) pop() (llap.Packet, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.control) == 0 && len(q.data) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	var pak llap.Packet
+	switch {
+	case len(q.control) > 0:
+		pak, q.control = q.control[0], q.control[1:]
+	case len(q.data) > 0:
+		pak, q.data = q.data[0], q.data[1:]
+	default:
+		return pak, false
+	}
+	return pak, true
+}
+
+func newPacer() *pacer {
+	return &pacer{now: time.Now, sleep: time.Sleep}
+}
+
+// Waits until the bus is free, then reserves it for the frame of cmd, a
+// TashTalk frame command, and the gap after it.
+func (p *pacer) wait(cmd []byte) {
+	now := p.now()
+	if now.Before(p.free) {
+		p.sleep(p.free.Sub(now))
+		now = p.free
+	}
+	n := len(cmd) - 2
+	bits := localtalk.FrameBitsFCS(cmd[1:n], uint16(cmd[n])|uint16(cmd[n+1])<<8)
+	busy := time.Duration(bits) * time.Second / localtalk.BitRate
+	p.free = now.Add(busy + localtalk.InterdialogGap)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package serial
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/llap"
+	"github.com/sfiera/multitalk/pkg/localtalk"
+	"github.com/sfiera/multitalk/pkg/tash"
+)
+
+func data(n byte) llap.Packet {
+	return llap.Packet{
+		Header:  llap.Header{DstNode: 0xff, SrcNode: ddp.Node(n), Kind: llap.TypeDDP},
+		Payload: []byte{0x00, 0x07, 0x01, 0x02, 0x03, 0x04, 0x05},
+	}
+}
+
+func TestQueue(t *testing.T) {
+	assert := assert.New(t)
+	q := newQueue()
+	require.True(t, q.push(data(1)))
+	require.True(t, q.push(*llap.Enq(2, 2)))
+	require.True(t, q.push(data(3)))
+	require.True(t, q.push(*llap.Ack(4, 4)))
+
+	// Control frames go first, then data frames in order.
+	for _, want := range []llap.Packet{*llap.Enq(2, 2), *llap.Ack(4, 4), data(1), data(3)} {
+		pak, ok := q.pop()
+		require.True(t, ok)
+		assert.Equal(want, pak)
+	}
+
+	// Data frames are dropped when the queue is full; control frames
+	// aren’t.
+	for i := 0; i < maxQueue; i++ {
+		require.True(t, q.push(data(byte(i))))
+	}
+	assert.False(q.push(data(0)))
+	assert.True(q.push(*llap.Enq(1, 1)))
+
+	q.close()
+	for i := 0; i < maxQueue+1; i++ {
+		_, ok := q.pop()
+		require.True(t, ok)
+	}
+	_, ok := q.pop()
+	assert.False(ok)
+}
+
+func TestQueueWait(t *testing.T) {
+	q := newQueue()
+	popped := make(chan llap.Packet)
+	go func() {
+		pak, _ := q.pop()
+		popped <- pak
+	}()
+	q.push(data(1))
+	select {
+	case pak := <-popped:
+		assert.Equal(t, data(1), pak)
+	case <-time.After(time.Second):
+		t.Fatal("pop didn’t wake")
+	}
+}
+
+func TestPacer(t *testing.T) {
+	t0 := time.Date(1991, 6, 1, 0, 0, 0, 0, time.UTC)
+	now := t0
+	slept := []time.Duration{}
+	p := &pacer{
+		now:   func() time.Time { return now },
+		sleep: func(d time.Duration) { slept = append(slept, d); now = now.Add(d) },
+	}
+	frame, err := llap.Marshal(data(1))
+	require.NoError(t, err)
+	busy := localtalk.FrameTime(frame) + localtalk.InterdialogGap
+	cmd, err := tash.AppendFrame(nil, data(1))
+	require.NoError(t, err)
+
+	// A burst is spaced out by the frames’ time on the bus.
+	p.wait(cmd)
+	p.wait(cmd)
+	p.wait(cmd)
+	assert.Equal(t, []time.Duration{busy, busy}, slept)
+
+	// An idle bus is free immediately.
+	now = now.Add(time.Second)
+	p.wait(cmd)
+	assert.Len(t, slept, 2)
+}
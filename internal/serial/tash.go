@@ -34,6 +34,7 @@ import (
 	"io"
 
 	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
 	"github.com/sfiera/multitalk/pkg/llap"
 	"github.com/sfiera/multitalk/pkg/tash"
 	"github.com/tarm/serial"
@@ -72,6 +73,9 @@ func (t *tt) Start(ctx context.Context, log *zap.Logger) (
 		zap.String("bridge", "udp"),
 		zap.String("device", t.device),
 	)
+	metrics.CRCErrors.Func(func() int64 {
+		return int64(t.dec.CRCErrors())
+	}, t.String())
 	sendInCh, sendOutCh := pipe(make(chan llap.Packet))
 	recvInCh, recvOutCh := pipe(make(chan llap.Packet))
 	go t.read(ctx, log, recvOutCh)
@@ -79,13 +83,40 @@ func (t *tt) Start(ctx context.Context, log *zap.Logger) (
 	return sendOutCh, recvInCh
 }
 
+func (t *tt) String() string {
+	return "serial:" + t.device
+}
+
 func (t *tt) write(
 	ctx context.Context,
 	log *zap.Logger,
 	llapCh <-chan llap.Packet,
 ) {
-	for packet := range llapCh {
-		err := t.enc.Encode(packet)
+	q := newQueue()
+	go func() {
+		defer q.close()
+		for packet := range llapCh {
+			if !q.push(packet) {
+				metrics.QueueDrops.With(t.String()).Inc()
+			}
+		}
+	}()
+
+	p := newPacer()
+	var cmd []byte
+	for {
+		packet, ok := q.pop()
+		if !ok {
+			return
+		}
+		var err error
+		cmd, err = tash.AppendFrame(cmd[:0], packet)
+		if err != nil {
+			log.With(zap.Error(err)).Error("send failed")
+			continue
+		}
+		p.wait(cmd)
+		err = t.enc.WriteFrame(cmd)
 		if err != nil {
 			log.With(zap.Error(err)).Error("send failed")
 		}
@@ -0,0 +1,227 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Simulates networks joined by a bridge.Group, for testing bridges and
+// routers without any real network.
+//
+// Each simulated network is a Segment: packets sent on it with Send are
+// received by the Group, and packets that the Group sends to it are
+// read back with Recv or Expect.
+package sim
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+// Default time to wait for a packet.
+const DefaultTimeout = 5 * time.Second
+
+type (
+	// A Sim is a Group of simulated networks.
+	Sim struct {
+		ctx  context.Context
+		log  *zap.Logger
+		grp  *bridge.Group
+		macs byte
+	}
+
+	// A simulated network. A Segment[llap.Packet] is a bridge.Bridge,
+	// and a Segment[ethertalk.Packet] is a bridge.ExtBridge.
+	Segment[T any] struct {
+		// Time to wait for a packet in Recv and Expect.
+		Timeout time.Duration
+
+		in   chan T
+		done <-chan struct{}
+
+		mu     sync.Mutex
+		out    []T
+		notify chan struct{}
+	}
+
+	LocalTalk = Segment[llap.Packet]
+	EtherTalk = Segment[ethertalk.Packet]
+)
+
+// Starts a Group, which runs until ctx is done.
+func New(ctx context.Context, log *zap.Logger) *Sim {
+	s := &Sim{ctx: ctx, log: log, grp: bridge.NewGroup(log)}
+	go s.grp.Run()
+	return s
+}
+
+// Adds an EtherTalk network to the Group.
+func (s *Sim) EtherTalk() *EtherTalk {
+	seg := NewSegment[ethertalk.Packet]()
+	s.grp.Add(seg.Start(s.ctx, s.log))
+	return seg
+}
+
+// Adds a LocalTalk network to the Group, through a router with cfg.
+// Returns the network, and the Ethernet address of the router.
+func (s *Sim) LocalTalk(cfg bridge.Config) (*LocalTalk, ethernet.Addr) {
+	s.macs++
+	mac := ethernet.Addr{0x02, 0x00, 0x00, 0x00, 0x00, s.macs}
+	seg := NewSegment[llap.Packet]()
+	s.grp.Add(bridge.Extend(seg, cfg, mac[:]).Start(s.ctx, s.log))
+	return seg, mac
+}
+
+// Adds another member to the Group.
+func (s *Sim) Add(b bridge.ExtBridge) {
+	s.grp.Add(b.Start(s.ctx, s.log))
+}
+
+// Returns the Group, for members that add themselves.
+func (s *Sim) Group() *bridge.Group {
+	return s.grp
+}
+
+// Creates a Segment that is not yet part of a Group.
+func NewSegment[T any]() *Segment[T] {
+	return &Segment[T]{
+		Timeout: DefaultTimeout,
+		in:      make(chan T),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+func (s *Segment[T]) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- T,
+	recv <-chan T,
+) {
+	sendCh := make(chan T)
+	s.done = ctx.Done()
+	go s.capture(sendCh)
+	return sendCh, s.in
+}
+
+// Queues packets from the Group, so that it never blocks.
+func (s *Segment[T]) capture(sendCh <-chan T) {
+	for packet := range sendCh {
+		s.mu.Lock()
+		s.out = append(s.out, packet)
+		s.mu.Unlock()
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Sends a packet on the network, as if from one of its nodes.
+func (s *Segment[T]) Send(packet T) {
+	select {
+	case s.in <- packet:
+	case <-s.done:
+	}
+}
+
+// Returns the next packet sent to the network.
+func (s *Segment[T]) Recv() (T, error) {
+	return s.Expect(func(T) bool { return true })
+}
+
+// Returns the next packet sent to the network for which match returns
+// true, discarding packets before it.
+func (s *Segment[T]) Expect(match func(T) bool) (T, error) {
+	timeout := time.After(s.Timeout)
+	for {
+		s.mu.Lock()
+		for len(s.out) > 0 {
+			packet := s.out[0]
+			s.out = s.out[1:]
+			if match(packet) {
+				s.mu.Unlock()
+				return packet, nil
+			}
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-timeout:
+			var zero T
+			return zero, fmt.Errorf("no packet after %s", s.Timeout)
+		}
+	}
+}
+
+// Discards packets sent to the network so far.
+func (s *Segment[T]) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out = nil
+}
+
+// Returns the AARP packet in an EtherTalk packet, if it is one.
+func AARP(packet ethertalk.Packet) (aarp.Packet, bool) {
+	a := aarp.Packet{}
+	if packet.SNAPProto != ethertalk.AARPProto || aarp.Unmarshal(packet.Payload, &a) != nil {
+		return a, false
+	}
+	return a, true
+}
+
+// Returns the DDP packet in an EtherTalk packet, if it is one.
+func DDP(packet ethertalk.Packet) (ddp.ExtPacket, bool) {
+	ext := ddp.ExtPacket{}
+	if packet.SNAPProto != ethertalk.AppleTalkProto || ddp.ExtUnmarshal(packet.Payload, &ext) != nil {
+		return ext, false
+	}
+	return ext, true
+}
+
+// Returns the short-form DDP packet in an LLAP packet, if it is one.
+func ShortDDP(packet llap.Packet) (ddp.Packet, bool) {
+	d := ddp.Packet{}
+	if packet.Kind != llap.TypeDDP || ddp.Unmarshal(packet.Payload, &d) != nil {
+		return d, false
+	}
+	return d, true
+}
+
+// Returns the extended DDP packet in an LLAP packet, if it is one.
+func ExtDDP(packet llap.Packet) (ddp.ExtPacket, bool) {
+	ext := ddp.ExtPacket{}
+	if packet.Kind != llap.TypeExtDDP || ddp.ExtUnmarshal(packet.Payload, &ext) != nil {
+		return ext, false
+	}
+	return ext, true
+}
@@ -39,6 +39,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
 	"github.com/sfiera/multitalk/pkg/ethertalk"
 )
 
@@ -66,6 +67,20 @@ func (c *client) Start(ctx context.Context, log *zap.Logger) (
 	return sendCh, recvCh
 }
 
+func (c *client) String() string {
+	return "tcp:" + c.conn.RemoteAddr().String()
+}
+
+func (c *client) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Disconnects from the peer. The client leaves its Group once its
+// connection is closed.
+func (c *client) Close() error {
+	return c.conn.Close()
+}
+
 func (c *client) transmit(ctx context.Context, log *zap.Logger, sendCh <-chan ethertalk.Packet) {
 	for packet := range sendCh {
 		bin, err := ethertalk.Marshal(packet)
@@ -84,6 +99,8 @@ func (c *client) capture(ctx context.Context, log *zap.Logger, recvCh chan<- eth
 		<-ctx.Done()
 		c.conn.Close()
 	}()
+	metrics.TCPPeers.Inc()
+	defer metrics.TCPPeers.Dec()
 	log = log.With(zap.Stringer("remoteAddr", c.conn.RemoteAddr()))
 
 	for {
@@ -116,6 +133,7 @@ func (c *client) capture(ctx context.Context, log *zap.Logger, recvCh chan<- eth
 		err = ethertalk.Unmarshal(data, &packet)
 		if err != nil {
 			log.With(zap.Error(err)).Error("unmarshal failed")
+			metrics.UnmarshalErrors.With(c.String()).Inc()
 			continue
 		}
 
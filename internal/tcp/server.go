@@ -51,6 +51,18 @@ func TCPServer(listen string) (*server, error) {
 }
 
 func (s *server) Serve(ctx context.Context, log *zap.Logger, grp *bridge.Group) {
+	s.ServeFunc(ctx, log, func(_ net.Addr, b bridge.ExtBridge) {
+		grp.Add(b.Start(ctx, log))
+	})
+}
+
+// Like Serve, but passes each connection to accept instead of adding it
+// to a Group.
+func (s *server) ServeFunc(
+	ctx context.Context,
+	log *zap.Logger,
+	accept func(remote net.Addr, b bridge.ExtBridge),
+) {
 	go func() {
 		for {
 			c, err := s.listen.Accept()
@@ -61,7 +73,7 @@ func (s *server) Serve(ctx context.Context, log *zap.Logger, grp *bridge.Group)
 				zap.String("bridge", "tcp"),
 				zap.Stringer("remoteAddr", c.RemoteAddr()),
 			).Info("opened")
-			grp.Add((&client{c}).Start(ctx, log))
+			accept(c.RemoteAddr(), &client{c})
 		}
 	}()
 }
@@ -0,0 +1,83 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package tcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/sim"
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+)
+
+func TestClientServer(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := zap.NewNop()
+
+	s := sim.New(ctx, log)
+	et := s.EtherTalk()
+	srv, err := TCPServer("127.0.0.1:0")
+	require.NoError(t, err)
+	srv.Serve(ctx, log, s.Group())
+
+	c, err := TCPClient(srv.listen.Addr().String())
+	require.NoError(t, err)
+	send, recv := c.Start(ctx, log)
+
+	mac := ethernet.Addr{0x08, 0x00, 0x07, 0x12, 0x34, 0x56}
+	probe, err := ethertalk.AARP(mac, aarp.Probe(mac, ddp.Addr{Network: 100, Node: 5}))
+	require.NoError(t, err)
+
+	// From the client, through the server, to the Group.
+	send <- *probe
+	out, err := et.Recv()
+	if assert.NoError(err) {
+		assert.Equal(probe.EthHeader, out.EthHeader)
+		assert.Equal(probe.Payload, out.Payload)
+	}
+
+	// And back.
+	et.Send(*probe)
+	select {
+	case out := <-recv:
+		assert.Equal(probe.EthHeader, out.EthHeader)
+		assert.Equal(probe.Payload, out.Payload)
+	case <-time.After(sim.DefaultTimeout):
+		t.Error("no packet from server")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Opens TUN devices, for exchanging IP datagrams with the host’s network
+// stack.
+package tun
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Opens the TUN device name, creating it if needed, gives it the address
+// and subnet of prefix, sets its MTU, and brings it up. Each read or
+// write is one IP datagram.
+func Open(name string, prefix netip.Prefix, mtu int) (io.ReadWriteCloser, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open tun %s: %s", name, err.Error())
+	}
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("open tun %s: %s", name, err.Error())
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	err = unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr)
+	if err == nil {
+		err = configure(ifr.Name(), prefix, mtu)
+	}
+	if err == nil {
+		err = unix.SetNonblock(fd, true)
+	}
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("open tun %s: %s", name, err.Error())
+	}
+	return os.NewFile(uintptr(fd), "/dev/net/tun"), nil
+}
+
+// Sets the address, netmask, and MTU of an interface, and brings it up.
+func configure(name string, prefix netip.Prefix, mtu int) error {
+	s, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(s)
+
+	addr := prefix.Addr().As4()
+	mask := net.CIDRMask(prefix.Bits(), 32)
+	for _, req := range []struct {
+		op   uint
+		addr []byte
+	}{
+		{unix.SIOCSIFADDR, addr[:]},
+		{unix.SIOCSIFNETMASK, mask},
+	} {
+		ifr, err := unix.NewIfreq(name)
+		if err != nil {
+			return err
+		} else if err = ifr.SetInet4Addr(req.addr); err != nil {
+			return err
+		} else if err = unix.IoctlIfreq(s, req.op, ifr); err != nil {
+			return err
+		}
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	ifr.SetUint32(uint32(mtu))
+	err = unix.IoctlIfreq(s, unix.SIOCSIFMTU, ifr)
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlIfreq(s, unix.SIOCGIFFLAGS, ifr)
+	if err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP | unix.IFF_RUNNING)
+	return unix.IoctlIfreq(s, unix.SIOCSIFFLAGS, ifr)
+}
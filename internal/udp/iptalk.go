@@ -0,0 +1,281 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package udp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/iptalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+	"go.uber.org/zap"
+)
+
+type ipTalk struct {
+	prefix netip.Prefix
+	conns  map[ddp.Socket]*net.UDPConn
+	acks   chan ddp.Node // Nodes whose probes should be answered
+	done   chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	peers map[ddp.Node]netip.Addr
+}
+
+// Communicates with IPTalk (KIP or CAP) hosts on the subnet of prefix,
+// such as 192.168.1.200/24, whose address is this bridge’s own.
+//
+// As in KIP, each host’s node number is the last byte of its address,
+// so the subnet must be at least /24. Nodes other than the bridge’s own
+// are taken to be in use, so a router on the bridge acquires the node of
+// its own address, if it is between 128 and 253.
+func IPTalk(prefix string) (bridge.Bridge, []byte, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("iptalk: %s", err.Error())
+	} else if !p.Addr().Is4() || p.Bits() < 24 || p.Bits() > 30 {
+		return nil, nil, fmt.Errorf("iptalk: invalid subnet %s", prefix)
+	}
+
+	b := &ipTalk{
+		prefix: p,
+		conns:  map[ddp.Socket]*net.UDPConn{},
+		acks:   make(chan ddp.Node, 16),
+		done:   make(chan struct{}),
+		peers:  map[ddp.Node]netip.Addr{},
+	}
+	for s := ddp.Socket(1); s < 0xff; s++ {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: int(iptalk.Port(s))})
+		if err != nil {
+			b.close()
+			return nil, nil, fmt.Errorf("iptalk: listen: %s", err.Error())
+		}
+		b.conns[s] = conn
+	}
+
+	// The bridge has no hardware, so its router uses a locally
+	// administered address derived from its IP address.
+	ip := p.Addr().As4()
+	hwAddr := []byte{0x02, 0x00, ip[0], ip[1], ip[2], ip[3]}
+	return b, hwAddr, nil
+}
+
+func (b *ipTalk) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- llap.Packet,
+	recv <-chan llap.Packet,
+) {
+	log = log.With(
+		zap.String("bridge", "iptalk"),
+		zap.Stringer("addr", b.prefix),
+	)
+	sendInCh, sendOutCh := pipe(make(chan llap.Packet))
+	recvInCh, recvOutCh := pipe(make(chan llap.Packet))
+	go func() {
+		<-ctx.Done()
+		b.close()
+	}()
+	go b.capture(log, recvOutCh)
+	go b.transmit(log, sendInCh)
+	return sendOutCh, recvInCh
+}
+
+func (b *ipTalk) String() string {
+	return "iptalk:" + b.prefix.String()
+}
+
+func (b *ipTalk) close() {
+	b.once.Do(func() {
+		close(b.done)
+		for _, conn := range b.conns {
+			conn.Close()
+		}
+	})
+}
+
+// Returns the node of the bridge’s own address.
+func (b *ipTalk) self() ddp.Node {
+	return ddp.Node(b.prefix.Addr().As4()[3])
+}
+
+// Returns the address of the host with node, or of all hosts if node is
+// the broadcast node.
+func (b *ipTalk) resolve(node ddp.Node) netip.Addr {
+	if node == 0xff {
+		ip := b.prefix.Masked().Addr().As4()
+		for i := b.prefix.Bits(); i < 32; i++ {
+			ip[i/8] |= 0x80 >> (i % 8)
+		}
+		return netip.AddrFrom4(ip)
+	}
+
+	b.mu.Lock()
+	ip, ok := b.peers[node]
+	b.mu.Unlock()
+	if ok {
+		return ip
+	}
+	ip4 := b.prefix.Addr().As4()
+	ip4[3] = uint8(node)
+	return netip.AddrFrom4(ip4)
+}
+
+// Notes that node is the host at ip.
+func (b *ipTalk) learn(node ddp.Node, ip netip.Addr) {
+	if node == 0 || node == 0xff {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.peers[node] = ip
+}
+
+func (b *ipTalk) transmit(
+	log *zap.Logger,
+	llapCh <-chan llap.Packet,
+) {
+	for packet := range llapCh {
+		var d ddp.ExtPacket
+		switch packet.Kind {
+		case llap.TypeDDP:
+			short := ddp.Packet{}
+			if ddp.Unmarshal(packet.Payload, &short) != nil {
+				continue
+			}
+			d = ddp.ShortToExt(short, 0, packet.DstNode, packet.SrcNode)
+		case llap.TypeExtDDP:
+			if ddp.ExtUnmarshal(packet.Payload, &d) != nil {
+				continue
+			}
+		case llap.TypeEnq:
+			// Every node but the bridge’s own belongs to another
+			// host on the subnet, so answer probes for them.
+			if packet.DstNode != b.self() && packet.DstNode != 0xff {
+				select {
+				case b.acks <- packet.DstNode:
+				default:
+				}
+			}
+			continue
+		default:
+			continue // Other control frames have no IP equivalent
+		}
+
+		conn, ok := b.conns[d.SrcSocket]
+		if !ok {
+			continue
+		}
+		data, err := iptalk.Marshal(iptalk.AppleTalk(packet.DstNode, packet.SrcNode, d))
+		if err != nil {
+			log.With(zap.Error(err)).Error("marshal failed")
+			continue
+		}
+		to := netip.AddrPortFrom(b.resolve(packet.DstNode), iptalk.Port(d.DstSocket))
+		_, err = conn.WriteToUDPAddrPort(data, to)
+		if err != nil {
+			log.With(zap.Error(err)).Error("send failed")
+		}
+	}
+}
+
+func (b *ipTalk) capture(
+	log *zap.Logger,
+	recvCh chan<- llap.Packet,
+) {
+	var wg sync.WaitGroup
+	for _, conn := range b.conns {
+		wg.Add(1)
+		go func(conn *net.UDPConn) {
+			defer wg.Done()
+			b.read(log, conn, recvCh)
+		}(conn)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.answer(recvCh)
+	}()
+	wg.Wait()
+	close(recvCh)
+}
+
+// Answers probes on behalf of other hosts, until the bridge is closed.
+func (b *ipTalk) answer(recvCh chan<- llap.Packet) {
+	for {
+		select {
+		case node := <-b.acks:
+			select {
+			case recvCh <- *llap.Ack(node, node):
+			case <-b.done:
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *ipTalk) read(
+	log *zap.Logger,
+	conn *net.UDPConn,
+	recvCh chan<- llap.Packet,
+) {
+	bin := make([]byte, 700)
+	for {
+		n, addr, err := conn.ReadFromUDPAddrPort(bin)
+		if err != nil {
+			return
+		}
+		from := addr.Addr().Unmap()
+		if from == b.prefix.Addr() {
+			continue // A broadcast from this bridge
+		}
+
+		packet := iptalk.Packet{}
+		err = iptalk.Unmarshal(bin[:n], &packet)
+		if err != nil {
+			metrics.UnmarshalErrors.With(b.String()).Inc()
+			continue
+		}
+		if b.prefix.Contains(from) {
+			b.learn(packet.SrcNode, from)
+		}
+
+		out, err := llap.ExtAppleTalk(packet.DstNode, packet.SrcNode, packet.DDP)
+		if err != nil {
+			log.With(zap.Error(err)).Error("marshal failed")
+			continue
+		}
+		recvCh <- *out
+	}
+}
@@ -35,6 +35,7 @@ import (
 	"os"
 
 	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/metrics"
 	"github.com/sfiera/multitalk/pkg/llap"
 	"github.com/sfiera/multitalk/pkg/ltou"
 	"go.uber.org/zap"
@@ -81,6 +82,10 @@ func (b *multicast) Start(ctx context.Context, log *zap.Logger) (
 	return sendOutCh, recvInCh
 }
 
+func (b *multicast) String() string {
+	return "multicast:" + b.iface.Name
+}
+
 func (b *multicast) transmit(
 	ctx context.Context,
 	log *zap.Logger,
@@ -125,6 +130,7 @@ func (b *multicast) capture(
 		packet := ltou.Packet{}
 		err = ltou.Unmarshal(bin[:n], &packet)
 		if err != nil {
+			metrics.UnmarshalErrors.With(b.String()).Inc()
 			continue
 		}
 
@@ -0,0 +1,88 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package aarp
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+// Length of a packet.
+const PacketSize = 28
+
+// A packet parsed in place. AARP packets have no variable-length fields,
+// so a View holds a complete copy of the packet.
+type View Packet
+
+// Unmarshals a packet from bytes. Unlike Unmarshal, does not allocate.
+func UnmarshalView(data []byte, v *View) error {
+	if len(data) != PacketSize {
+		return fmt.Errorf("read aarp: length mismatch (%d != %d)", len(data), PacketSize)
+	}
+	v.Header = Header{
+		Hardware:     Hardware(binary.BigEndian.Uint16(data[0:2])),
+		Proto:        Proto(binary.BigEndian.Uint16(data[2:4])),
+		HardwareSize: data[4],
+		ProtoSize:    data[5],
+	}
+	if v.Header != EthernetLLAPBridging {
+		return fmt.Errorf("read aarp header: not eth-llap bridging")
+	}
+	v.Opcode = Opcode(binary.BigEndian.Uint16(data[6:8]))
+	v.Src = viewAddrPair(data[8:18])
+	v.Dst = viewAddrPair(data[18:28])
+	return nil
+}
+
+func viewAddrPair(data []byte) (p AddrPair) {
+	copy(p.Hardware[:], data[0:6])
+	p.Proto = ddp.Addr{
+		Network: ddp.Network(binary.BigEndian.Uint16(data[7:9])),
+		Node:    ddp.Node(data[9]),
+	}
+	return p
+}
+
+// Appends a marshaled packet to buf, and returns the extended buffer.
+func AppendMarshal(buf []byte, pak Packet) ([]byte, error) {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(pak.Hardware))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(pak.Proto))
+	buf = append(buf, pak.HardwareSize, pak.ProtoSize)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(pak.Opcode))
+	buf = appendAddrPair(buf, pak.Src)
+	return appendAddrPair(buf, pak.Dst), nil
+}
+
+func appendAddrPair(buf []byte, p AddrPair) []byte {
+	buf = append(buf, p.Hardware[:]...)
+	buf = append(buf, 0)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(p.Proto.Network))
+	return append(buf, uint8(p.Proto.Node))
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package aarp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var probe = unhex("0001809b0604" + "0003" +
+	"080007b4b1ce" + "00ff005f" +
+	"000000000000" + "00ff005f")
+
+func TestUnmarshalView(t *testing.T) {
+	expected := Packet{}
+	require.NoError(t, Unmarshal(probe, &expected))
+	v := View{}
+	require.NoError(t, UnmarshalView(probe, &v))
+	assert.Equal(t, expected, Packet(v))
+
+	out, err := AppendMarshal(nil, Packet(v))
+	require.NoError(t, err)
+	assert.Equal(t, probe, out)
+}
+
+func TestUnmarshalViewError(t *testing.T) {
+	cases := []struct {
+		name, hex, err string
+	}{{
+		"empty",
+		"",
+		"read aarp: length mismatch (0 != 28)",
+	}, {
+		"excess",
+		"0001809b0604" + "0003" + "080007b4b1ce" + "00ff005f" + "000000000000" + "00ff005f" + "00",
+		"read aarp: length mismatch (29 != 28)",
+	}, {
+		"not_bridging",
+		"0002809b0604" + "0003" + "080007b4b1ce" + "00ff005f" + "000000000000" + "00ff005f",
+		"read aarp header: not eth-llap bridging",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := View{}
+			err := UnmarshalView(unhex(c.hex), &v)
+			if assert.Error(t, err) {
+				assert.Equal(t, c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestViewAllocs(t *testing.T) {
+	v := View{}
+	buf := make([]byte, 0, PacketSize)
+	allocs := testing.AllocsPerRun(100, func() {
+		UnmarshalView(probe, &v)
+		buf, _ = AppendMarshal(buf[:0], Packet(v))
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := Packet{}
+		Unmarshal(probe, &p)
+	}
+}
+
+func BenchmarkUnmarshalView(b *testing.B) {
+	b.ReportAllocs()
+	v := View{}
+	for i := 0; i < b.N; i++ {
+		UnmarshalView(probe, &v)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	b.ReportAllocs()
+	p := Packet{}
+	Unmarshal(probe, &p)
+	for i := 0; i < b.N; i++ {
+		Marshal(p)
+	}
+}
+
+func BenchmarkAppendMarshal(b *testing.B) {
+	b.ReportAllocs()
+	p := Packet{}
+	Unmarshal(probe, &p)
+	buf := make([]byte, 0, PacketSize)
+	for i := 0; i < b.N; i++ {
+		buf, _ = AppendMarshal(buf[:0], p)
+	}
+}
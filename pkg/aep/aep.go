@@ -0,0 +1,86 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Encodes and decodes AEP (AppleTalk Echo Protocol) packets.
+package aep
+
+import (
+	"fmt"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+const (
+	// Socket of the echoer on every node.
+	Socket = ddp.Socket(4)
+
+	// Maximum length of echoed data.
+	MaxDataSize = 585
+)
+
+type Function uint8
+
+const (
+	Request = Function(1)
+	Reply   = Function(2)
+)
+
+type Packet struct {
+	Function Function
+	Data     []byte
+}
+
+// Unmarshals a packet from bytes.
+func Unmarshal(data []byte, pak *Packet) error {
+	if len(data) < 1 {
+		return fmt.Errorf("read aep header: EOF")
+	}
+	pak.Function = Function(data[0])
+	switch pak.Function {
+	case Request, Reply:
+	default:
+		return fmt.Errorf("read aep header: unknown function %d", pak.Function)
+	}
+	if len(data)-1 > MaxDataSize {
+		return fmt.Errorf("read aep: data too long (%d > %d)", len(data)-1, MaxDataSize)
+	}
+	pak.Data = data[1:]
+	return nil
+}
+
+// Marshals a packet to bytes.
+func Marshal(pak Packet) ([]byte, error) {
+	if len(pak.Data) > MaxDataSize {
+		return nil, fmt.Errorf("write aep: data too long (%d > %d)", len(pak.Data), MaxDataSize)
+	}
+	return append([]byte{byte(pak.Function)}, pak.Data...), nil
+}
+
+// Returns the reply to an echo request.
+func (pak Packet) Echo() Packet {
+	return Packet{Function: Reply, Data: pak.Data}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package aep
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalNoError(t *testing.T) {
+	cases := []struct {
+		name, hex string
+		expected  Packet
+	}{{
+		"Request",
+		"01" + "00010203",
+		Packet{Function: Request, Data: unhex("00010203")},
+	}, {
+		"Reply",
+		"02" + "00010203",
+		Packet{Function: Reply, Data: unhex("00010203")},
+	}, {
+		"Empty",
+		"01",
+		Packet{Function: Request, Data: []byte{}},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			p := Packet{}
+			err := Unmarshal(unhex(c.hex), &p)
+			if assert.NoError(err) {
+				assert.Equal(c.expected, p)
+			}
+			data, err := Marshal(c.expected)
+			if assert.NoError(err) {
+				assert.Equal(unhex(c.hex), data)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		err  string
+	}{{
+		"empty",
+		[]byte{},
+		"read aep header: EOF",
+	}, {
+		"unknown",
+		[]byte{3},
+		"read aep header: unknown function 3",
+	}, {
+		"long_request",
+		append([]byte{1}, make([]byte, 586)...),
+		"read aep: data too long (586 > 585)",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			err := Unmarshal(c.data, &Packet{})
+			if assert.Error(err) {
+				assert.Equal(c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestEcho(t *testing.T) {
+	assert := assert.New(t)
+	req := Packet{Function: Request, Data: []byte("ping")}
+	assert.Equal(Packet{Function: Reply, Data: []byte("ping")}, req.Echo())
+}
+
+func unhex(s string) []byte {
+	data := []byte{}
+	for i := 0; i < len(s); i += 2 {
+		n, err := strconv.ParseUint(s[i:i+2], 16, 8)
+		if err != nil {
+			panic(err)
+		}
+		data = append(data, byte(n))
+	}
+	return data
+}
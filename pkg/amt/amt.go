@@ -0,0 +1,232 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Package amt maintains an AARP address mapping table (AMT).
+//
+// The table maps AppleTalk protocol addresses to Ethernet hardware
+// addresses. Entries are gleaned from received DDP and AARP packets and
+// age out if they are not refreshed. The table also tracks addresses
+// owned by the local station, so that AARP probes and requests for them
+// can be defended.
+package amt
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+)
+
+const (
+	// Default time after which an entry that has not been refreshed
+	// is discarded.
+	DefaultMaxAge = 60 * time.Second
+
+	// Minimum time between two AARP requests for the same address.
+	RequestInterval = time.Second
+)
+
+type (
+	// A Table is an AARP address mapping table.
+	// It is safe for concurrent use.
+	Table struct {
+		mu       sync.Mutex
+		maxAge   time.Duration
+		entries  map[ddp.Addr]entry
+		requests map[ddp.Addr]time.Time
+		owned    map[ddp.Addr]bool
+
+		// Now returns the current time. It may be replaced for testing.
+		Now func() time.Time
+	}
+
+	entry struct {
+		hw      ethernet.Addr
+		updated time.Time
+	}
+)
+
+// New returns an empty table whose entries expire after maxAge.
+func New(maxAge time.Duration) *Table {
+	return &Table{
+		maxAge:   maxAge,
+		entries:  map[ddp.Addr]entry{},
+		requests: map[ddp.Addr]time.Time{},
+		owned:    map[ddp.Addr]bool{},
+		Now:      time.Now,
+	}
+}
+
+// Glean records that proto is reachable at hw.
+//
+// Broadcast and unset addresses are ignored, as are addresses owned by
+// the local station.
+func (t *Table) Glean(proto ddp.Addr, hw ethernet.Addr) {
+	if proto.Node == 0 || proto.Node == 0xff || hw == (ethernet.Addr{}) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.owned[proto] {
+		return
+	}
+	t.entries[proto] = entry{hw, t.Now()}
+	delete(t.requests, proto)
+}
+
+// Lookup returns the hardware address for proto, if a live entry exists.
+func (t *Table) Lookup(proto ddp.Addr) (ethernet.Addr, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[proto]
+	if !ok {
+		return ethernet.Addr{}, false
+	} else if t.Now().Sub(e.updated) > t.maxAge {
+		delete(t.entries, proto)
+		return ethernet.Addr{}, false
+	}
+	return e.hw, true
+}
+
+// Remove discards any entry for proto.
+func (t *Table) Remove(proto ddp.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, proto)
+}
+
+// Expire discards all entries that have not been refreshed within the
+// table’s maximum age.
+func (t *Table) Expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.Now()
+	for proto, e := range t.entries {
+		if now.Sub(e.updated) > t.maxAge {
+			delete(t.entries, proto)
+		}
+	}
+	for proto, sent := range t.requests {
+		if now.Sub(sent) > RequestInterval {
+			delete(t.requests, proto)
+		}
+	}
+}
+
+// Miss reports a failed lookup for proto. It returns true if an AARP
+// request should be sent for it, which is at most once per
+// RequestInterval.
+func (t *Table) Miss(proto ddp.Addr) bool {
+	if proto.Node == 0 || proto.Node == 0xff {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.Now()
+	if sent, ok := t.requests[proto]; ok && now.Sub(sent) < RequestInterval {
+		return false
+	}
+	t.requests[proto] = now
+	return true
+}
+
+// Defend marks proto as owned by the local station.
+func (t *Table) Defend(proto ddp.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.owned[proto] = true
+	delete(t.entries, proto)
+}
+
+// Release stops defending proto.
+func (t *Table) Release(proto ddp.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.owned, proto)
+}
+
+// Owns returns true if proto is owned by the local station.
+func (t *Table) Owns(proto ddp.Addr) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.owned[proto]
+}
+
+// Owned returns the addresses owned by the local station, in order.
+func (t *Table) Owned() []ddp.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	addrs := []ddp.Addr{}
+	for proto := range t.owned {
+		addrs = append(addrs, proto)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i].Network != addrs[j].Network {
+			return addrs[i].Network < addrs[j].Network
+		}
+		return addrs[i].Node < addrs[j].Node
+	})
+	return addrs
+}
+
+// Learn updates the table from a received AARP packet.
+//
+// The sender of a request or response is gleaned, as is the target of a
+// response. A probe means that its address is about to be claimed by
+// a new station, so any existing entry for it is discarded.
+func (t *Table) Learn(pak aarp.Packet) {
+	switch pak.Opcode {
+	case aarp.RequestOp:
+		t.Glean(pak.Src.Proto, pak.Src.Hardware)
+	case aarp.ResponseOp:
+		t.Glean(pak.Src.Proto, pak.Src.Hardware)
+		t.Glean(pak.Dst.Proto, pak.Dst.Hardware)
+	case aarp.ProbeOp:
+		t.Remove(pak.Dst.Proto)
+	}
+}
+
+// Respond returns the response that the local station at hw should send
+// for a received AARP packet, if any.
+//
+// Requests and probes for owned addresses are answered; everything else
+// is ignored.
+func (t *Table) Respond(hw ethernet.Addr, pak aarp.Packet) *aarp.Packet {
+	switch pak.Opcode {
+	case aarp.RequestOp, aarp.ProbeOp:
+		if !t.Owns(pak.Dst.Proto) {
+			return nil
+		}
+		resp := aarp.Response(aarp.AddrPair{Hardware: hw, Proto: pak.Dst.Proto}, pak.Src)
+		return &resp
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package amt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+)
+
+var (
+	hostA = ethernet.Addr{0x08, 0x00, 0x07, 0xb4, 0xb1, 0xce}
+	hostB = ethernet.Addr{0x08, 0x00, 0x07, 0x12, 0x34, 0x56}
+	local = ethernet.Addr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+
+	addrA = ddp.Addr{Network: 65280, Node: 95}
+	addrB = ddp.Addr{Network: 65280, Node: 96}
+)
+
+func newTable() (*Table, *time.Time) {
+	now := time.Unix(0, 0)
+	t := New(10 * time.Second)
+	t.Now = func() time.Time { return now }
+	return t, &now
+}
+
+func TestGleanAndAge(t *testing.T) {
+	assert := assert.New(t)
+	tbl, now := newTable()
+
+	_, ok := tbl.Lookup(addrA)
+	assert.False(ok)
+
+	tbl.Glean(addrA, hostA)
+	hw, ok := tbl.Lookup(addrA)
+	assert.True(ok)
+	assert.Equal(hostA, hw)
+
+	*now = now.Add(5 * time.Second)
+	tbl.Glean(addrA, hostB)
+	*now = now.Add(8 * time.Second)
+	hw, ok = tbl.Lookup(addrA)
+	assert.True(ok)
+	assert.Equal(hostB, hw)
+
+	*now = now.Add(3 * time.Second)
+	_, ok = tbl.Lookup(addrA)
+	assert.False(ok)
+}
+
+func TestGleanIgnored(t *testing.T) {
+	cases := []struct {
+		name  string
+		proto ddp.Addr
+		hw    ethernet.Addr
+	}{
+		{"broadcast", ddp.Addr{Network: 65280, Node: 255}, hostA},
+		{"any", ddp.Addr{Network: 65280, Node: 0}, hostA},
+		{"no_hardware", addrA, ethernet.Addr{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tbl, _ := newTable()
+			tbl.Glean(c.proto, c.hw)
+			_, ok := tbl.Lookup(c.proto)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	assert := assert.New(t)
+	tbl, now := newTable()
+	tbl.Glean(addrA, hostA)
+	*now = now.Add(6 * time.Second)
+	tbl.Glean(addrB, hostB)
+	*now = now.Add(6 * time.Second)
+	tbl.Expire()
+	assert.NotContains(tbl.entries, addrA)
+	assert.Contains(tbl.entries, addrB)
+}
+
+func TestMiss(t *testing.T) {
+	assert := assert.New(t)
+	tbl, now := newTable()
+	assert.True(tbl.Miss(addrA))
+	assert.False(tbl.Miss(addrA))
+	assert.True(tbl.Miss(addrB))
+	*now = now.Add(RequestInterval)
+	assert.True(tbl.Miss(addrA))
+	assert.False(tbl.Miss(ddp.Addr{Network: 65280, Node: 255}))
+}
+
+func TestLearn(t *testing.T) {
+	assert := assert.New(t)
+	tbl, _ := newTable()
+
+	tbl.Learn(aarp.Request(aarp.AddrPair{Hardware: hostA, Proto: addrA}, addrB))
+	hw, ok := tbl.Lookup(addrA)
+	assert.True(ok)
+	assert.Equal(hostA, hw)
+
+	tbl.Learn(aarp.Response(
+		aarp.AddrPair{Hardware: hostB, Proto: addrB},
+		aarp.AddrPair{Hardware: hostA, Proto: addrA},
+	))
+	hw, ok = tbl.Lookup(addrB)
+	assert.True(ok)
+	assert.Equal(hostB, hw)
+
+	tbl.Learn(aarp.Probe(hostA, addrB))
+	_, ok = tbl.Lookup(addrB)
+	assert.False(ok)
+}
+
+func TestRespond(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    aarp.Packet
+		expected *aarp.Packet
+	}{{
+		"probe_owned",
+		aarp.Probe(hostA, addrB),
+		&aarp.Packet{
+			Header: aarp.EthernetLLAPBridging,
+			Body: aarp.Body{
+				Opcode: aarp.ResponseOp,
+				Src:    aarp.AddrPair{Hardware: local, Proto: addrB},
+				Dst:    aarp.AddrPair{Hardware: hostA, Proto: addrB},
+			},
+		},
+	}, {
+		"request_owned",
+		aarp.Request(aarp.AddrPair{Hardware: hostA, Proto: addrA}, addrB),
+		&aarp.Packet{
+			Header: aarp.EthernetLLAPBridging,
+			Body: aarp.Body{
+				Opcode: aarp.ResponseOp,
+				Src:    aarp.AddrPair{Hardware: local, Proto: addrB},
+				Dst:    aarp.AddrPair{Hardware: hostA, Proto: addrA},
+			},
+		},
+	}, {
+		"probe_other",
+		aarp.Probe(hostA, addrA),
+		nil,
+	}, {
+		"response",
+		aarp.Response(
+			aarp.AddrPair{Hardware: hostA, Proto: addrB},
+			aarp.AddrPair{Hardware: local, Proto: addrB},
+		),
+		nil,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tbl, _ := newTable()
+			tbl.Defend(addrB)
+			assert.Equal(t, c.expected, tbl.Respond(local, c.input))
+		})
+	}
+}
+
+func TestDefend(t *testing.T) {
+	assert := assert.New(t)
+	tbl, _ := newTable()
+	tbl.Glean(addrA, hostA)
+	tbl.Defend(addrA)
+	assert.True(tbl.Owns(addrA))
+	_, ok := tbl.Lookup(addrA)
+	assert.False(ok)
+
+	tbl.Glean(addrA, hostB)
+	_, ok = tbl.Lookup(addrA)
+	assert.False(ok)
+
+	tbl.Defend(addrB)
+	assert.Equal([]ddp.Addr{addrA, addrB}, tbl.Owned())
+
+	tbl.Release(addrA)
+	assert.False(tbl.Owns(addrA))
+	assert.Equal([]ddp.Addr{addrB}, tbl.Owned())
+}
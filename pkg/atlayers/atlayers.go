@@ -0,0 +1,399 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Implements gopacket layers for AppleTalk, so that multitalk’s codecs
+// can be used with gopacket.NewPacket and gopacket.DecodingLayerParser.
+//
+// Importing the package registers its decoders with gopacket: LocalTalk
+// captures (layers.LinkTypeLTalk) decode as LLAP, EtherTalk frames (SNAP
+// type 0x809b) decode as DDP, and UDP port 1954 decodes as LToU. DDP
+// payloads decode as NBP, ATP, RTMP, or ZIP by DDP type.
+package atlayers
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/llap"
+	"github.com/sfiera/multitalk/pkg/ltou"
+	"github.com/sfiera/multitalk/pkg/nbp"
+	"github.com/sfiera/multitalk/pkg/rtmp"
+	"github.com/sfiera/multitalk/pkg/zip"
+)
+
+// SNAP protocol of AppleTalk (DDP) packets on EtherTalk.
+const EthernetTypeAppleTalk = layers.EthernetType(0x809b)
+
+var (
+	LayerTypeLToU        = register(0, "LToU", decodeLToU)
+	LayerTypeLLAP        = register(1, "LLAP", decodeLLAP)
+	LayerTypeShortDDP    = register(2, "ShortDDP", decodeShortDDP)
+	LayerTypeDDP         = register(3, "DDP", decodeDDP)
+	LayerTypeNBP         = register(4, "NBP", decodeNBP)
+	LayerTypeATP         = register(5, "ATP", decodeATP)
+	LayerTypeRTMP        = register(6, "RTMP", decodeRTMP)
+	LayerTypeRTMPRequest = register(7, "RTMPRequest", decodeRTMPRequest)
+	LayerTypeZIP         = register(8, "ZIP", decodeZIP)
+)
+
+// First of the layer type numbers that the package registers.
+const layerTypeBase = 1954
+
+func register(n int, name string, decode func([]byte, gopacket.PacketBuilder) error) gopacket.LayerType {
+	return gopacket.RegisterLayerType(layerTypeBase+n, gopacket.LayerTypeMetadata{
+		Name:    name,
+		Decoder: gopacket.DecodeFunc(decode),
+	})
+}
+
+func init() {
+	layers.LinkTypeMetadata[layers.LinkTypeLTalk] = layers.EnumMetadata{
+		DecodeWith: LayerTypeLLAP,
+		Name:       "LTalk",
+		LayerType:  LayerTypeLLAP,
+	}
+	layers.EthernetTypeMetadata[EthernetTypeAppleTalk] = layers.EnumMetadata{
+		DecodeWith: LayerTypeDDP,
+		Name:       "AppleTalk",
+		LayerType:  LayerTypeDDP,
+	}
+	layers.RegisterUDPPortLayerType(layers.UDPPort(ltou.MulticastAddr.Port), LayerTypeLToU)
+}
+
+type (
+	// The header of an LToU packet, which carries an LLAP frame.
+	LToU struct {
+		layers.BaseLayer
+		ltou.Header
+	}
+
+	// An LLAP frame, which carries a DDP packet unless it is a control
+	// frame.
+	LLAP struct {
+		layers.BaseLayer
+		llap.Header
+	}
+
+	// A short-form DDP packet, as sent between nodes on a LocalTalk
+	// network.
+	ShortDDP struct {
+		layers.BaseLayer
+		ddp.Header
+	}
+
+	// An extended DDP packet, as sent on EtherTalk and between networks.
+	DDP struct {
+		layers.BaseLayer
+		ddp.ExtHeader
+	}
+
+	NBP struct {
+		layers.BaseLayer
+		nbp.Packet
+	}
+
+	// An ATP packet. Its payload is the request or response data.
+	ATP struct {
+		layers.BaseLayer
+		atp.Packet
+	}
+
+	// An RTMP Data or Response packet.
+	RTMP struct {
+		layers.BaseLayer
+		rtmp.Packet
+	}
+
+	// An RTMP Request packet.
+	RTMPRequest struct {
+		layers.BaseLayer
+		Function uint8
+	}
+
+	ZIP struct {
+		layers.BaseLayer
+		Packet zip.Packet
+	}
+)
+
+// Returns the layer type of the payload of a DDP packet of type proto.
+func ddpLayerType(proto uint8) gopacket.LayerType {
+	switch proto {
+	case ddp.ProtoNBP:
+		return LayerTypeNBP
+	case ddp.ProtoATP:
+		return LayerTypeATP
+	case ddp.ProtoRTMPResp:
+		return LayerTypeRTMP
+	case ddp.ProtoRTMPReq:
+		return LayerTypeRTMPRequest
+	case ddp.ProtoZIP:
+		return LayerTypeZIP
+	default:
+		return gopacket.LayerTypePayload
+	}
+}
+
+// Returns the packet of a DDP header whose length field is size, and
+// whether it was cut short. Ethernet padding after the packet is dropped.
+func ddpPacket(data []byte, size uint16, df gopacket.DecodeFeedback) []byte {
+	n := int(size & 0x03ff)
+	if n > len(data) {
+		df.SetTruncated()
+		return data
+	}
+	return data[:n]
+}
+
+func (l *LToU) LayerType() gopacket.LayerType { return LayerTypeLToU }
+
+func (l *LToU) CanDecode() gopacket.LayerClass { return LayerTypeLToU }
+
+func (l *LToU) NextLayerType() gopacket.LayerType { return LayerTypeLLAP }
+
+func (l *LToU) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 4 {
+		df.SetTruncated()
+		return fmt.Errorf("read ltou header: short packet (%d < 4)", len(data))
+	}
+	l.Pid = binary.BigEndian.Uint32(data[:4])
+	l.Contents, l.Payload = data[:4], data[4:]
+	return nil
+}
+
+func (l *LLAP) LayerType() gopacket.LayerType { return LayerTypeLLAP }
+
+func (l *LLAP) CanDecode() gopacket.LayerClass { return LayerTypeLLAP }
+
+func (l *LLAP) NextLayerType() gopacket.LayerType {
+	switch {
+	case l.Kind == llap.TypeDDP:
+		return LayerTypeShortDDP
+	case l.Kind == llap.TypeExtDDP:
+		return LayerTypeDDP
+	case len(l.Payload) > 0:
+		return gopacket.LayerTypePayload
+	default:
+		return gopacket.LayerTypeZero
+	}
+}
+
+func (l *LLAP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	v := llap.View{}
+	err := llap.UnmarshalView(data, &v)
+	if err != nil {
+		return err
+	}
+	l.Header = v.Header
+	l.Contents, l.Payload = data[:len(data)-len(v.Payload)], v.Payload
+	return nil
+}
+
+func (l *ShortDDP) LayerType() gopacket.LayerType { return LayerTypeShortDDP }
+
+func (l *ShortDDP) CanDecode() gopacket.LayerClass { return LayerTypeShortDDP }
+
+func (l *ShortDDP) NextLayerType() gopacket.LayerType { return ddpLayerType(l.Proto) }
+
+func (l *ShortDDP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < ddp.HeaderSize {
+		df.SetTruncated()
+		return fmt.Errorf("read ddp header: short packet (%d < %d)", len(data), ddp.HeaderSize)
+	}
+	v := ddp.View{}
+	err := ddp.UnmarshalView(ddpPacket(data, binary.BigEndian.Uint16(data), df), &v)
+	if err != nil {
+		return err
+	}
+	l.Header = v.Header
+	l.Contents, l.Payload = data[:ddp.HeaderSize], v.Data
+	return nil
+}
+
+func (l *DDP) LayerType() gopacket.LayerType { return LayerTypeDDP }
+
+func (l *DDP) CanDecode() gopacket.LayerClass { return LayerTypeDDP }
+
+func (l *DDP) NextLayerType() gopacket.LayerType { return ddpLayerType(l.Proto) }
+
+func (l *DDP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < ddp.ExtHeaderSize {
+		df.SetTruncated()
+		return fmt.Errorf("read ddp header: short packet (%d < %d)", len(data), ddp.ExtHeaderSize)
+	}
+	v := ddp.ExtView{}
+	err := ddp.ExtUnmarshalView(ddpPacket(data, binary.BigEndian.Uint16(data), df), &v)
+	if err != nil {
+		return err
+	}
+	l.ExtHeader = v.ExtHeader
+	l.Contents, l.Payload = data[:ddp.ExtHeaderSize], v.Data
+	return nil
+}
+
+func (l *NBP) LayerType() gopacket.LayerType { return LayerTypeNBP }
+
+func (l *NBP) CanDecode() gopacket.LayerClass { return LayerTypeNBP }
+
+func (l *NBP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+func (l *NBP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	err := nbp.Unmarshal(data, &l.Packet)
+	if err != nil {
+		return err
+	}
+	l.Contents, l.Payload = data, nil
+	return nil
+}
+
+func (l *ATP) LayerType() gopacket.LayerType { return LayerTypeATP }
+
+func (l *ATP) CanDecode() gopacket.LayerClass { return LayerTypeATP }
+
+func (l *ATP) NextLayerType() gopacket.LayerType {
+	if len(l.Payload) > 0 {
+		return gopacket.LayerTypePayload
+	}
+	return gopacket.LayerTypeZero
+}
+
+func (l *ATP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	err := atp.Unmarshal(data, &l.Packet)
+	if err != nil {
+		return err
+	}
+	n := len(data) - len(l.Data)
+	l.Contents, l.Payload = data[:n], data[n:]
+	return nil
+}
+
+func (l *RTMP) LayerType() gopacket.LayerType { return LayerTypeRTMP }
+
+func (l *RTMP) CanDecode() gopacket.LayerClass { return LayerTypeRTMP }
+
+func (l *RTMP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+func (l *RTMP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	err := rtmp.Unmarshal(data, &l.Packet)
+	if err != nil {
+		return err
+	}
+	l.Contents, l.Payload = data, nil
+	return nil
+}
+
+func (l *RTMPRequest) LayerType() gopacket.LayerType { return LayerTypeRTMPRequest }
+
+func (l *RTMPRequest) CanDecode() gopacket.LayerClass { return LayerTypeRTMPRequest }
+
+func (l *RTMPRequest) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+func (l *RTMPRequest) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) != 1 {
+		return fmt.Errorf("read rtmp request: invalid length %d", len(data))
+	}
+	l.Function = data[0]
+	l.Contents, l.Payload = data, nil
+	return nil
+}
+
+func (l *ZIP) LayerType() gopacket.LayerType { return LayerTypeZIP }
+
+func (l *ZIP) CanDecode() gopacket.LayerClass { return LayerTypeZIP }
+
+func (l *ZIP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+func (l *ZIP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	pak, err := zip.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	l.Packet = pak
+	l.Contents, l.Payload = data, nil
+	return nil
+}
+
+// A layer that decodes from bytes, and says what follows it.
+type decodingLayer interface {
+	gopacket.DecodingLayer
+	gopacket.Layer
+}
+
+// Decodes data as layer, and hands its payload to the next decoder.
+func decode(layer decodingLayer, data []byte, p gopacket.PacketBuilder) error {
+	err := layer.DecodeFromBytes(data, p)
+	if err != nil {
+		return err
+	}
+	p.AddLayer(layer)
+	next := layer.NextLayerType()
+	if next == gopacket.LayerTypeZero {
+		return nil
+	}
+	return p.NextDecoder(next)
+}
+
+func decodeLToU(data []byte, p gopacket.PacketBuilder) error {
+	return decode(&LToU{}, data, p)
+}
+
+func decodeLLAP(data []byte, p gopacket.PacketBuilder) error {
+	return decode(&LLAP{}, data, p)
+}
+
+func decodeShortDDP(data []byte, p gopacket.PacketBuilder) error {
+	return decode(&ShortDDP{}, data, p)
+}
+
+func decodeDDP(data []byte, p gopacket.PacketBuilder) error {
+	return decode(&DDP{}, data, p)
+}
+
+func decodeNBP(data []byte, p gopacket.PacketBuilder) error {
+	return decode(&NBP{}, data, p)
+}
+
+func decodeATP(data []byte, p gopacket.PacketBuilder) error {
+	return decode(&ATP{}, data, p)
+}
+
+func decodeRTMP(data []byte, p gopacket.PacketBuilder) error {
+	return decode(&RTMP{}, data, p)
+}
+
+func decodeRTMPRequest(data []byte, p gopacket.PacketBuilder) error {
+	return decode(&RTMPRequest{}, data, p)
+}
+
+func decodeZIP(data []byte, p gopacket.PacketBuilder) error {
+	return decode(&ZIP{}, data, p)
+}
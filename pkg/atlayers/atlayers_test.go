@@ -0,0 +1,243 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package atlayers
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+	"github.com/sfiera/multitalk/pkg/llap"
+	"github.com/sfiera/multitalk/pkg/ltou"
+	"github.com/sfiera/multitalk/pkg/nbp"
+	"github.com/sfiera/multitalk/pkg/rtmp"
+	"github.com/sfiera/multitalk/pkg/zip"
+)
+
+func marshal[T any](t *testing.T, f func(T) ([]byte, error), pak T) []byte {
+	data, err := f(pak)
+	require.NoError(t, err)
+	return data
+}
+
+// Returns an LLAP frame carrying a short DDP packet.
+func shortFrame(t *testing.T, socket ddp.Socket, proto uint8, data []byte) []byte {
+	pak, err := llap.AppleTalk(10, 50, ddp.Packet{
+		Header: ddp.Header{
+			Size:      uint16(ddp.HeaderSize + len(data)),
+			DstSocket: socket, SrcSocket: socket,
+			Proto: proto,
+		},
+		Data: data,
+	})
+	require.NoError(t, err)
+	return marshal(t, llap.Marshal, *pak)
+}
+
+func layerTypes(p gopacket.Packet) (types []gopacket.LayerType) {
+	for _, l := range p.Layers() {
+		types = append(types, l.LayerType())
+	}
+	return types
+}
+
+var lookup = nbp.Lookup(7, ddp.Addr{Network: 0, Node: 50}, nbp.Socket, nbp.Entity{
+	Object: "=", Type: "LaserWriter", Zone: "*",
+})
+
+func TestLocalTalkNBP(t *testing.T) {
+	assert := assert.New(t)
+	data := shortFrame(t, nbp.Socket, ddp.ProtoNBP, marshal(t, nbp.Marshal, lookup))
+
+	p := gopacket.NewPacket(data, layers.LinkTypeLTalk, gopacket.Default)
+	require.Nil(t, p.ErrorLayer())
+	assert.Equal([]gopacket.LayerType{LayerTypeLLAP, LayerTypeShortDDP, LayerTypeNBP}, layerTypes(p))
+
+	l := p.Layer(LayerTypeLLAP).(*LLAP)
+	assert.Equal(llap.Header{DstNode: 10, SrcNode: 50, Kind: llap.TypeDDP}, l.Header)
+	d := p.Layer(LayerTypeShortDDP).(*ShortDDP)
+	assert.Equal(nbp.Socket, d.DstSocket)
+	assert.Equal(uint8(ddp.ProtoNBP), d.Proto)
+	n := p.Layer(LayerTypeNBP).(*NBP)
+	assert.Equal(lookup, n.Packet)
+}
+
+func TestLocalTalkControl(t *testing.T) {
+	data := marshal(t, llap.Marshal, *llap.Enq(10, 10))
+	p := gopacket.NewPacket(data, layers.LinkTypeLTalk, gopacket.Default)
+	require.Nil(t, p.ErrorLayer())
+	assert.Equal(t, []gopacket.LayerType{LayerTypeLLAP}, layerTypes(p))
+	assert.Equal(t, llap.TypeEnq, p.Layer(LayerTypeLLAP).(*LLAP).Kind)
+}
+
+func TestLocalTalkRTMP(t *testing.T) {
+	assert := assert.New(t)
+
+	resp := rtmp.Packet{
+		Router: ddp.Addr{Network: 5, Node: 10},
+		Tuples: []rtmp.Tuple{{Range: ddp.Range{Start: 6, End: 6}, Distance: 1}},
+	}
+	data := shortFrame(t, rtmp.Socket, ddp.ProtoRTMPResp, marshal(t, rtmp.Marshal, resp))
+	p := gopacket.NewPacket(data, layers.LinkTypeLTalk, gopacket.Default)
+	require.Nil(t, p.ErrorLayer())
+	require.NotNil(t, p.Layer(LayerTypeRTMP))
+	assert.Equal(resp, p.Layer(LayerTypeRTMP).(*RTMP).Packet)
+
+	data = shortFrame(t, rtmp.Socket, ddp.ProtoRTMPReq, []byte{rtmp.FuncRDRSplit})
+	p = gopacket.NewPacket(data, layers.LinkTypeLTalk, gopacket.Default)
+	require.Nil(t, p.ErrorLayer())
+	require.NotNil(t, p.Layer(LayerTypeRTMPRequest))
+	assert.Equal(rtmp.FuncRDRSplit, p.Layer(LayerTypeRTMPRequest).(*RTMPRequest).Function)
+
+	data = shortFrame(t, rtmp.Socket, ddp.ProtoRTMPReq, []byte{1, 2})
+	p = gopacket.NewPacket(data, layers.LinkTypeLTalk, gopacket.Default)
+	assert.NotNil(p.ErrorLayer())
+}
+
+func TestLocalTalkTruncated(t *testing.T) {
+	data := shortFrame(t, nbp.Socket, ddp.ProtoNBP, marshal(t, nbp.Marshal, lookup))
+	p := gopacket.NewPacket(data[:len(data)-4], layers.LinkTypeLTalk, gopacket.Default)
+	assert.NotNil(t, p.ErrorLayer())
+	assert.True(t, p.Metadata().Truncated)
+}
+
+func TestEtherTalkZIP(t *testing.T) {
+	assert := assert.New(t)
+
+	info := &zip.GetNetInfo{Zone: "Twilight"}
+	data := marshal(t, zip.Marshal, zip.Packet(info))
+	pak, err := ethertalk.AppleTalk(ethernet.Addr{0x08, 0x00, 0x07, 0x01, 0x02, 0x03}, ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			Size:   uint16(ddp.ExtHeaderSize + len(data)),
+			DstNet: 0, DstNode: 0xff, DstSocket: zip.Socket,
+			SrcNet: 0xff00, SrcNode: 50, SrcSocket: zip.Socket,
+			Proto: ddp.ProtoZIP,
+		},
+		Data: data,
+	})
+	require.NoError(t, err)
+
+	p := gopacket.NewPacket(marshal(t, ethertalk.Marshal, *pak), layers.LinkTypeEthernet, gopacket.Default)
+	require.Nil(t, p.ErrorLayer())
+	assert.Equal([]gopacket.LayerType{
+		layers.LayerTypeEthernet, layers.LayerTypeLLC, layers.LayerTypeSNAP,
+		LayerTypeDDP, LayerTypeZIP,
+	}, layerTypes(p))
+
+	d := p.Layer(LayerTypeDDP).(*DDP)
+	assert.Equal(ddp.Network(0xff00), d.SrcNet)
+	assert.Equal(ddp.Node(50), d.SrcNode)
+	assert.Equal(data, d.Payload)
+	assert.Equal(info, p.Layer(LayerTypeZIP).(*ZIP).Packet)
+}
+
+func TestLToU(t *testing.T) {
+	assert := assert.New(t)
+
+	frame := marshal(t, ltou.Marshal, *ltou.Enq(1234, 10, 10))
+	ip := &layers.IPv4{
+		Version: 4, TTL: 1, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.IPv4(192, 168, 0, 2), DstIP: ltou.MulticastAddr.IP,
+	}
+	udp := &layers.UDP{SrcPort: 1954, DstPort: 1954}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload(frame)))
+
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	require.Nil(t, p.ErrorLayer())
+	assert.Equal([]gopacket.LayerType{
+		layers.LayerTypeIPv4, layers.LayerTypeUDP, LayerTypeLToU, LayerTypeLLAP,
+	}, layerTypes(p))
+	assert.Equal(uint32(1234), p.Layer(LayerTypeLToU).(*LToU).Pid)
+	assert.Equal(llap.TypeEnq, p.Layer(LayerTypeLLAP).(*LLAP).Kind)
+}
+
+func TestDecodingLayerParser(t *testing.T) {
+	assert := assert.New(t)
+
+	req := atp.Packet{Function: atp.TReq, Bitmap: 0x01, TID: 42, Data: []byte("status")}
+	data := shortFrame(t, 0x80, ddp.ProtoATP, marshal(t, atp.Marshal, req))
+
+	var (
+		l  LLAP
+		d  ShortDDP
+		a  ATP
+		pl gopacket.Payload
+	)
+	parser := gopacket.NewDecodingLayerParser(LayerTypeLLAP, &l, &d, &a, &pl)
+	decoded := []gopacket.LayerType{}
+	require.NoError(t, parser.DecodeLayers(data, &decoded))
+	assert.Equal([]gopacket.LayerType{
+		LayerTypeLLAP, LayerTypeShortDDP, LayerTypeATP, gopacket.LayerTypePayload,
+	}, decoded)
+	assert.Equal(uint16(42), a.TID)
+	assert.Equal([]byte("status"), []byte(pl))
+}
+
+func TestPcap(t *testing.T) {
+	assert := assert.New(t)
+
+	frames := [][]byte{
+		marshal(t, llap.Marshal, *llap.Enq(50, 50)),
+		shortFrame(t, nbp.Socket, ddp.ProtoNBP, marshal(t, nbp.Marshal, lookup)),
+		shortFrame(t, rtmp.Socket, ddp.ProtoRTMPReq, []byte{rtmp.FuncRequest}),
+	}
+	buf := &bytes.Buffer{}
+	w := pcapgo.NewWriter(buf)
+	require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeLTalk))
+	for i, data := range frames {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(0, 0).Add(time.Duration(i) * time.Millisecond),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		require.NoError(t, w.WritePacket(ci, data))
+	}
+
+	r, err := pcapgo.NewReader(buf)
+	require.NoError(t, err)
+	last := []gopacket.LayerType{}
+	for p := range gopacket.NewPacketSource(r, r.LinkType()).Packets() {
+		require.Nil(t, p.ErrorLayer())
+		last = append(last, p.Layers()[len(p.Layers())-1].LayerType())
+	}
+	assert.Equal([]gopacket.LayerType{LayerTypeLLAP, LayerTypeNBP, LayerTypeRTMPRequest}, last)
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Encodes and decodes ATP (AppleTalk Transaction Protocol) packets, and
+// runs ATP transactions over a DDP socket.
+//
+// A transaction is a request, answered by a response of up to eight
+// packets. The requester retransmits the request until all response
+// packets arrive. In exactly-once (XO) transactions, the responder
+// remembers its response until the requester releases it, so that
+// a retransmitted request is answered without being repeated.
+package atp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	HeaderSize = 8
+
+	// Maximum length of the data in each packet.
+	MaxDataSize = 578
+
+	// Maximum number of packets in a response.
+	MaxResponses = 8
+
+	functionMask = 0xc0
+	flagXO       = 0x20
+	flagEOM      = 0x10
+	flagSTS      = 0x08
+	timeoutMask  = 0x07
+)
+
+type Function uint8
+
+const (
+	TReq  = Function(0x40) // Transaction request
+	TResp = Function(0x80) // Transaction response
+	TRel  = Function(0xc0) // Transaction release
+)
+
+// How long an exactly-once responder keeps a response, if it is not
+// released. Sent in XO requests.
+type TRelTimeout uint8
+
+const (
+	TRel30s = TRelTimeout(iota)
+	TRel1m
+	TRel2m
+	TRel4m
+	TRel8m
+)
+
+type (
+	Packet struct {
+		Function Function
+
+		// Set in exactly-once requests.
+		XO bool
+		// Set in the last packet of a response.
+		EOM bool
+		// Set in responses to ask for an immediate retransmission of the
+		// request, with the bitmap of response packets still needed.
+		STS bool
+		// Set in exactly-once requests.
+		TRelTimeout TRelTimeout
+
+		// In requests, the response packets still needed. In responses,
+		// the sequence number of the packet, from 0 to 7.
+		Bitmap   uint8
+		Sequence uint8
+
+		TID       uint16
+		UserBytes [4]byte
+		Data      []byte
+	}
+
+	header struct {
+		Control   uint8
+		Info      uint8
+		TID       uint16
+		UserBytes [4]byte
+	}
+)
+
+// Returns how long a responder keeps an unreleased response.
+func (t TRelTimeout) Duration() time.Duration {
+	if t > TRel8m {
+		t = TRel30s
+	}
+	return 30 * time.Second << t
+}
+
+// Unmarshals a packet from bytes.
+func Unmarshal(data []byte, pak *Packet) error {
+	r := bytes.NewReader(data)
+
+	h := header{}
+	err := binary.Read(r, binary.BigEndian, &h)
+	if err != nil {
+		return fmt.Errorf("read atp header: %s", err.Error())
+	}
+
+	pak.Function = Function(h.Control & functionMask)
+	pak.XO = (h.Control & flagXO) != 0
+	pak.EOM = (h.Control & flagEOM) != 0
+	pak.STS = (h.Control & flagSTS) != 0
+	pak.TRelTimeout = 0
+	pak.Bitmap = 0
+	pak.Sequence = 0
+	switch pak.Function {
+	case TReq:
+		pak.Bitmap = h.Info
+		if pak.XO {
+			pak.TRelTimeout = TRelTimeout(h.Control & timeoutMask)
+		}
+	case TResp:
+		pak.Sequence = h.Info
+		if pak.Sequence >= MaxResponses {
+			return fmt.Errorf("read atp header: invalid sequence %d", pak.Sequence)
+		}
+	case TRel:
+		pak.Bitmap = h.Info
+	default:
+		return fmt.Errorf("read atp header: unknown function $%02x", uint8(pak.Function))
+	}
+	pak.TID = h.TID
+	pak.UserBytes = h.UserBytes
+
+	pak.Data, err = io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read atp: %s", err.Error())
+	} else if len(pak.Data) > MaxDataSize {
+		return fmt.Errorf("read atp: data too long (%d > %d)", len(pak.Data), MaxDataSize)
+	}
+	return nil
+}
+
+// Marshals a packet to bytes.
+func Marshal(pak Packet) ([]byte, error) {
+	h := header{
+		Control:   uint8(pak.Function),
+		TID:       pak.TID,
+		UserBytes: pak.UserBytes,
+	}
+	if pak.XO {
+		h.Control |= flagXO
+	}
+	if pak.EOM {
+		h.Control |= flagEOM
+	}
+	if pak.STS {
+		h.Control |= flagSTS
+	}
+
+	switch pak.Function {
+	case TReq:
+		h.Info = pak.Bitmap
+		if pak.XO {
+			h.Control |= uint8(pak.TRelTimeout) & timeoutMask
+		}
+	case TResp:
+		if pak.Sequence >= MaxResponses {
+			return nil, fmt.Errorf("write atp header: invalid sequence %d", pak.Sequence)
+		}
+		h.Info = pak.Sequence
+	case TRel:
+		h.Info = pak.Bitmap
+	default:
+		return nil, fmt.Errorf("write atp header: unknown function $%02x", uint8(pak.Function))
+	}
+
+	if len(pak.Data) > MaxDataSize {
+		return nil, fmt.Errorf("write atp: data too long (%d > %d)", len(pak.Data), MaxDataSize)
+	}
+	w := bytes.NewBuffer(make([]byte, 0, HeaderSize+len(pak.Data)))
+	binary.Write(w, binary.BigEndian, h)
+	w.Write(pak.Data)
+	return w.Bytes(), nil
+}
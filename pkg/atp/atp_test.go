@@ -0,0 +1,168 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package atp
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalNoError(t *testing.T) {
+	cases := []struct {
+		name, hex string
+		expected  Packet
+	}{{
+		"TReq",
+		"40010001" + "08000001", // ZIP GetZoneList, from index 1
+		Packet{
+			Function:  TReq,
+			Bitmap:    0x01,
+			TID:       1,
+			UserBytes: [4]byte{0x08, 0x00, 0x00, 0x01},
+			Data:      []byte{},
+		},
+	}, {
+		"TReq_XO",
+		"62ff1234" + "01020000" + "0008", // PAP OpenConn
+		Packet{
+			Function:    TReq,
+			XO:          true,
+			TRelTimeout: TRel2m,
+			Bitmap:      0xff,
+			TID:         0x1234,
+			UserBytes:   [4]byte{0x01, 0x02, 0x00, 0x00},
+			Data:        unhex("0008"),
+		},
+	}, {
+		"TResp",
+		"90001234" + "00000002" + "054e6f727468" + "05536f757468",
+		Packet{
+			Function:  TResp,
+			EOM:       true,
+			Sequence:  0,
+			TID:       0x1234,
+			UserBytes: [4]byte{0x00, 0x00, 0x00, 0x02},
+			Data:      unhex("054e6f727468" + "05536f757468"),
+		},
+	}, {
+		"TResp_STS",
+		"88031234" + "00000000",
+		Packet{
+			Function: TResp,
+			STS:      true,
+			Sequence: 3,
+			TID:      0x1234,
+			Data:     []byte{},
+		},
+	}, {
+		"TRel",
+		"c0001234" + "01020000",
+		Packet{
+			Function:  TRel,
+			TID:       0x1234,
+			UserBytes: [4]byte{0x01, 0x02, 0x00, 0x00},
+			Data:      []byte{},
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			p := Packet{}
+			err := Unmarshal(unhex(c.hex), &p)
+			if assert.NoError(err) {
+				assert.Equal(c.expected, p)
+			}
+			data, err := Marshal(c.expected)
+			if assert.NoError(err) {
+				assert.Equal(unhex(c.hex), data)
+			}
+		})
+	}
+}
+
+func TestUnmarshalError(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		err  string
+	}{{
+		"empty",
+		[]byte{},
+		"read atp header: EOF",
+	}, {
+		"short",
+		unhex("400100"),
+		"read atp header: unexpected EOF",
+	}, {
+		"function",
+		unhex("00010001" + "00000000"),
+		"read atp header: unknown function $00",
+	}, {
+		"sequence",
+		unhex("80081234" + "00000000"),
+		"read atp header: invalid sequence 8",
+	}, {
+		"long",
+		append(unhex("80001234"+"00000000"), make([]byte, 579)...),
+		"read atp: data too long (579 > 578)",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			err := Unmarshal(c.data, &Packet{})
+			if assert.Error(err) {
+				assert.Equal(c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestTRelTimeout(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(30*time.Second, TRel30s.Duration())
+	assert.Equal(time.Minute, TRel1m.Duration())
+	assert.Equal(8*time.Minute, TRel8m.Duration())
+	assert.Equal(30*time.Second, TRelTimeout(7).Duration())
+}
+
+func unhex(s string) []byte {
+	data := []byte{}
+	for i := 0; i < len(s); i += 2 {
+		n, err := strconv.ParseUint(s[i:i+2], 16, 8)
+		if err != nil {
+			panic(err)
+		}
+		data = append(data, byte(n))
+	}
+	return data
+}
@@ -0,0 +1,451 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package atp
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+const (
+	// Defaults for RequestOptions.
+	DefaultRetryInterval = 2 * time.Second
+	DefaultRetries       = 8
+
+	// Requests are retransmitted until the context is done.
+	RetryForever = -1
+
+	queueSize = 16
+)
+
+type (
+	// The request or one response packet of a transaction, without the
+	// fields that ATP itself uses.
+	Message struct {
+		UserBytes [4]byte
+		Data      []byte
+	}
+
+	RequestOptions struct {
+		// Makes the transaction exactly-once.
+		ExactlyOnce bool
+
+		// How long the responder keeps the response of an exactly-once
+		// transaction, if the requester does not release it.
+		TRelTimeout TRelTimeout
+
+		// Number of response packets to accept. If zero, MaxResponses.
+		Responses int
+
+		// Time to wait for a response before retransmitting the request.
+		// If zero, DefaultRetryInterval.
+		RetryInterval time.Duration
+
+		// Number of retransmissions before giving up. If zero,
+		// DefaultRetries. If RetryForever, retransmits until the context
+		// is done.
+		Retries int
+	}
+
+	// An Endpoint sends and answers transactions on a DDP socket.
+	Endpoint struct {
+		conn ddp.Conn
+
+		requests chan *Transaction
+		done     chan struct{}
+
+		mu      sync.Mutex
+		tid     uint16
+		pending map[txKey]chan<- Packet
+		cache   map[txKey]*cached
+		err     error
+	}
+
+	// A request received by an Endpoint. It must be answered with Respond.
+	Transaction struct {
+		From        ddp.SocketAddr
+		ExactlyOnce bool
+		Request     Message
+
+		// Number of response packets that the requester accepts. If the
+		// request was retransmitted, some of these may already have been
+		// received.
+		Responses int
+
+		e      *Endpoint
+		tid    uint16
+		bitmap uint8
+	}
+
+	// Identifies a transaction by the socket at the other end, and
+	// its TID.
+	txKey struct {
+		peer ddp.SocketAddr
+		tid  uint16
+	}
+
+	// The state of an exactly-once transaction on the responder.
+	// Until the transaction is answered, responses is nil.
+	cached struct {
+		bitmap    uint8
+		responses []Packet
+		timeout   time.Duration
+		timer     *time.Timer
+	}
+)
+
+// Creates an Endpoint on conn, and starts reading from it.
+func NewEndpoint(conn ddp.Conn) *Endpoint {
+	e := &Endpoint{
+		conn:     conn,
+		requests: make(chan *Transaction, queueSize),
+		done:     make(chan struct{}),
+		pending:  map[txKey]chan<- Packet{},
+		cache:    map[txKey]*cached{},
+	}
+	go e.read()
+	return e
+}
+
+// Closes the Endpoint and its socket.
+func (e *Endpoint) Close() error {
+	err := e.conn.Close()
+	<-e.done
+	return err
+}
+
+// Returns the address of the Endpoint’s socket.
+func (e *Endpoint) LocalAddr() ddp.SocketAddr {
+	return e.conn.LocalAddr()
+}
+
+// Sends a request, and waits for the response.
+//
+// Returns the packets of the response, up to the one marked as the end
+// of the message, or all accepted packets, if none is.
+func (e *Endpoint) Request(
+	ctx context.Context,
+	to ddp.SocketAddr,
+	req Message,
+	opts RequestOptions,
+) ([]Message, error) {
+	count := opts.Responses
+	if count == 0 {
+		count = MaxResponses
+	} else if count < 0 || count > MaxResponses {
+		return nil, fmt.Errorf("atp request: invalid response count %d", count)
+	}
+	interval := opts.RetryInterval
+	if interval == 0 {
+		interval = DefaultRetryInterval
+	}
+	retries := opts.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+
+	ch := make(chan Packet, MaxResponses)
+	tid, err := e.register(to, ch)
+	if err != nil {
+		return nil, err
+	}
+	defer e.unregister(txKey{to, tid})
+
+	treq := Packet{
+		Function:    TReq,
+		XO:          opts.ExactlyOnce,
+		TRelTimeout: opts.TRelTimeout,
+		Bitmap:      uint8(1<<count - 1),
+		TID:         tid,
+		UserBytes:   req.UserBytes,
+		Data:        req.Data,
+	}
+	err = e.send(to, treq)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	resp := make([]Message, count)
+	for {
+		select {
+		case pak := <-ch:
+			bit := uint8(1) << pak.Sequence
+			if (treq.Bitmap & bit) == 0 {
+				continue // Duplicate, or beyond the end of the message.
+			}
+			resp[pak.Sequence] = Message{pak.UserBytes, pak.Data}
+			treq.Bitmap &^= bit
+			if pak.EOM {
+				treq.Bitmap &= bit - 1
+				resp = resp[:pak.Sequence+1]
+			}
+
+			if treq.Bitmap == 0 {
+				if treq.XO {
+					e.send(to, Packet{Function: TRel, Bitmap: treq.Bitmap, TID: tid, UserBytes: req.UserBytes})
+				}
+				return resp, nil
+			} else if pak.STS {
+				err = e.send(to, treq)
+				if err != nil {
+					return nil, err
+				}
+				timer.Reset(interval)
+			}
+
+		case <-timer.C:
+			if retries == 0 {
+				return nil, fmt.Errorf("atp request to %s: timed out", to)
+			} else if retries > 0 {
+				retries--
+			}
+			err = e.send(to, treq)
+			if err != nil {
+				return nil, err
+			}
+			timer.Reset(interval)
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case <-e.done:
+			return nil, ddp.ErrClosed
+		}
+	}
+}
+
+// Allocates a transaction ID for a request.
+// Responses are accepted only from to.
+func (e *Endpoint) register(to ddp.SocketAddr, ch chan<- Packet) (uint16, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return 0, e.err
+	}
+	for {
+		e.tid++
+		key := txKey{to, e.tid}
+		if _, ok := e.pending[key]; !ok {
+			e.pending[key] = ch
+			return e.tid, nil
+		}
+	}
+}
+
+func (e *Endpoint) unregister(key txKey) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.pending, key)
+}
+
+// Waits for a request from another socket.
+func (e *Endpoint) Accept(ctx context.Context) (*Transaction, error) {
+	select {
+	case t := <-e.requests:
+		return t, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-e.done:
+		return nil, ddp.ErrClosed
+	}
+}
+
+// Sends the response to a transaction.
+//
+// Only the packets that the requester asked for are sent: if the
+// response is longer than t.Responses, it is truncated. For
+// exactly-once transactions, the response is kept until the requester
+// releases it, and sent again if the request is retransmitted.
+func (t *Transaction) Respond(resp []Message) error {
+	if len(resp) == 0 || len(resp) > MaxResponses {
+		return fmt.Errorf("atp respond: invalid response count %d", len(resp))
+	}
+	paks := make([]Packet, len(resp))
+	for i, m := range resp {
+		if len(m.Data) > MaxDataSize {
+			return fmt.Errorf("atp respond: data too long (%d > %d)", len(m.Data), MaxDataSize)
+		}
+		paks[i] = Packet{
+			Function:  TResp,
+			EOM:       i == len(resp)-1,
+			Sequence:  uint8(i),
+			TID:       t.tid,
+			UserBytes: m.UserBytes,
+			Data:      m.Data,
+		}
+	}
+
+	bitmap := t.bitmap
+	if t.ExactlyOnce {
+		e := t.e
+		e.mu.Lock()
+		c, ok := e.cache[txKey{t.From, t.tid}]
+		if ok {
+			c.responses = paks
+			bitmap = c.bitmap
+			c.timer.Reset(c.timeout)
+		}
+		e.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("atp respond: transaction released")
+		}
+	}
+	return t.e.sendResponses(t.From, paks, bitmap)
+}
+
+func (e *Endpoint) sendResponses(to ddp.SocketAddr, paks []Packet, bitmap uint8) error {
+	for _, pak := range paks {
+		if (bitmap & (1 << pak.Sequence)) == 0 {
+			continue
+		}
+		err := e.send(to, pak)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Endpoint) send(to ddp.SocketAddr, pak Packet) error {
+	data, err := Marshal(pak)
+	if err != nil {
+		return err
+	}
+	return e.conn.WriteTo(data, ddp.ProtoATP, to)
+}
+
+// Reads packets from the socket until it is closed.
+func (e *Endpoint) read() {
+	defer close(e.done)
+	buf := make([]byte, ddp.MaxDataSize)
+	for {
+		n, proto, from, err := e.conn.ReadFrom(buf)
+		if err != nil {
+			e.close(err)
+			return
+		}
+		pak := Packet{}
+		if proto != ddp.ProtoATP || Unmarshal(buf[:n], &pak) != nil {
+			continue
+		}
+
+		switch pak.Function {
+		case TReq:
+			e.receiveRequest(from, pak)
+		case TResp:
+			e.receiveResponse(from, pak)
+		case TRel:
+			e.release(txKey{from, pak.TID})
+		}
+	}
+}
+
+func (e *Endpoint) close(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.err = err
+	for _, c := range e.cache {
+		c.timer.Stop()
+	}
+	e.cache = map[txKey]*cached{}
+}
+
+func (e *Endpoint) receiveRequest(from ddp.SocketAddr, pak Packet) {
+	key := txKey{from, pak.TID}
+	if pak.XO {
+		e.mu.Lock()
+		c, ok := e.cache[key]
+		if ok {
+			// A retransmission. Answer it from the cache, if answered.
+			c.bitmap = pak.Bitmap
+			resp := c.responses
+			e.mu.Unlock()
+			e.sendResponses(from, resp, pak.Bitmap)
+			return
+		}
+		timeout := pak.TRelTimeout.Duration()
+		e.cache[key] = &cached{
+			bitmap:  pak.Bitmap,
+			timeout: timeout,
+			timer:   time.AfterFunc(timeout, func() { e.release(key) }),
+		}
+		e.mu.Unlock()
+	}
+
+	t := &Transaction{
+		From:        from,
+		ExactlyOnce: pak.XO,
+		Request:     Message{pak.UserBytes, pak.Data},
+		Responses:   bits.Len8(pak.Bitmap),
+		e:           e,
+		tid:         pak.TID,
+		bitmap:      pak.Bitmap,
+	}
+	select {
+	case e.requests <- t:
+	default:
+		// Not accepted in time. Forget it, so a retransmission is seen
+		// as new.
+		e.release(key)
+	}
+}
+
+func (e *Endpoint) receiveResponse(from ddp.SocketAddr, pak Packet) {
+	e.mu.Lock()
+	ch, ok := e.pending[txKey{from, pak.TID}]
+	if !ok {
+		// The request may have been sent to network 0, “this network”.
+		from.Network = 0
+		ch, ok = e.pending[txKey{from, pak.TID}]
+	}
+	e.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- pak:
+	default:
+	}
+}
+
+// Forgets the response to an exactly-once transaction.
+func (e *Endpoint) release(key txKey) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.cache[key]; ok {
+		c.timer.Stop()
+		delete(e.cache, key)
+	}
+}
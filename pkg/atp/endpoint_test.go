@@ -0,0 +1,300 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package atp
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+var (
+	clientAddr = ddp.SocketAddr{Network: 100, Node: 1, Socket: 200}
+	serverAddr = ddp.SocketAddr{Network: 100, Node: 2, Socket: 201}
+
+	fastRetry = RequestOptions{RetryInterval: 10 * time.Millisecond}
+)
+
+func endpoints(t *testing.T, lo *ddp.Loopback) (client, server *Endpoint) {
+	c, err := lo.Listen(clientAddr)
+	require.NoError(t, err)
+	s, err := lo.Listen(serverAddr)
+	require.NoError(t, err)
+	client, server = NewEndpoint(c), NewEndpoint(s)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// Answers every request with the given response, and counts requests.
+func serve(server *Endpoint, delay time.Duration, resp []Message) *int32 {
+	var count int32
+	go func() {
+		for {
+			t, err := server.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&count, 1)
+			time.Sleep(delay)
+			t.Respond(resp)
+		}
+	}()
+	return &count
+}
+
+// Drops the first response packet with the given sequence number.
+func dropOnce(seq uint8) func(from, to ddp.SocketAddr, proto uint8, data []byte) bool {
+	var dropped int32
+	return func(from, to ddp.SocketAddr, proto uint8, data []byte) bool {
+		pak := Packet{}
+		if Unmarshal(data, &pak) != nil || pak.Function != TResp || pak.Sequence != seq {
+			return false
+		}
+		return atomic.CompareAndSwapInt32(&dropped, 0, 1)
+	}
+}
+
+func messages(data ...string) []Message {
+	msgs := []Message{}
+	for i, d := range data {
+		msgs = append(msgs, Message{UserBytes: [4]byte{0, 0, 0, uint8(i)}, Data: []byte(d)})
+	}
+	return msgs
+}
+
+func TestRequest(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	client, server := endpoints(t, lo)
+
+	go func() {
+		tx, err := server.Accept(context.Background())
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(clientAddr, tx.From)
+		assert.False(tx.ExactlyOnce)
+		assert.Equal(Message{UserBytes: [4]byte{1, 2, 3, 4}, Data: []byte("req")}, tx.Request)
+		assert.Equal(MaxResponses, tx.Responses)
+		assert.NoError(tx.Respond(messages("a", "b", "c")))
+	}()
+
+	req := Message{UserBytes: [4]byte{1, 2, 3, 4}, Data: []byte("req")}
+	resp, err := client.Request(context.Background(), serverAddr, req, RequestOptions{})
+	if assert.NoError(err) {
+		assert.Equal(messages("a", "b", "c"), resp)
+	}
+}
+
+func TestResponseFromOtherSocket(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	client, server := endpoints(t, lo)
+	other, err := lo.Listen(ddp.SocketAddr{Network: 100, Node: 3, Socket: 201})
+	require.NoError(t, err)
+	defer other.Close()
+
+	go func() {
+		tx, err := server.Accept(context.Background())
+		if !assert.NoError(err) {
+			return
+		}
+		// Another socket answers first, with the same TID.
+		data, err := Marshal(Packet{Function: TResp, EOM: true, TID: tx.tid, Data: []byte("other")})
+		if assert.NoError(err) {
+			assert.NoError(other.WriteTo(data, ddp.ProtoATP, clientAddr))
+		}
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(tx.Respond(messages("a")))
+	}()
+
+	resp, err := client.Request(context.Background(), serverAddr, Message{}, RequestOptions{})
+	if assert.NoError(err) {
+		assert.Equal(messages("a"), resp)
+	}
+}
+
+func TestResponseToThisNetwork(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	client, server := endpoints(t, lo)
+	serve(server, 0, messages("a"))
+
+	// Sent to network 0, answered from network 100.
+	to := serverAddr
+	to.Network = 0
+	resp, err := client.Request(context.Background(), to, Message{}, fastRetry)
+	if assert.NoError(err) {
+		assert.Equal(messages("a"), resp)
+	}
+}
+
+func TestExactlyOnceRetransmit(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	var released int32
+	drop := dropOnce(1)
+	lo.Drop = func(from, to ddp.SocketAddr, proto uint8, data []byte) bool {
+		if pak := (Packet{}); Unmarshal(data, &pak) == nil && pak.Function == TRel {
+			atomic.AddInt32(&released, 1)
+		}
+		return drop(from, to, proto, data)
+	}
+	client, server := endpoints(t, lo)
+	count := serve(server, 0, messages("a", "b", "c"))
+
+	opts := fastRetry
+	opts.ExactlyOnce = true
+	resp, err := client.Request(context.Background(), serverAddr, Message{}, opts)
+	if assert.NoError(err) {
+		assert.Equal(messages("a", "b", "c"), resp)
+	}
+
+	// The lost packet was resent from the cache, without a second
+	// request reaching the server, and the cache was released.
+	assert.Equal(int32(1), atomic.LoadInt32(count))
+	assert.Eventually(func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return len(server.cache) == 0 && atomic.LoadInt32(&released) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestExactlyOnceDuplicate(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	client, server := endpoints(t, lo)
+	count := serve(server, 50*time.Millisecond, messages("a"))
+
+	// Several retransmissions arrive before the server responds.
+	opts := fastRetry
+	opts.ExactlyOnce = true
+	resp, err := client.Request(context.Background(), serverAddr, Message{}, opts)
+	if assert.NoError(err) {
+		assert.Equal(messages("a"), resp)
+	}
+	assert.Equal(int32(1), atomic.LoadInt32(count))
+}
+
+func TestAtLeastOnceRetransmit(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	lo.Drop = dropOnce(0)
+	client, server := endpoints(t, lo)
+	count := serve(server, 0, messages("a", "b"))
+
+	resp, err := client.Request(context.Background(), serverAddr, Message{}, fastRetry)
+	if assert.NoError(err) {
+		assert.Equal(messages("a", "b"), resp)
+	}
+	assert.Equal(int32(2), atomic.LoadInt32(count))
+}
+
+func TestTimeout(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	var sent int32
+	lo.Drop = func(from, to ddp.SocketAddr, proto uint8, data []byte) bool {
+		atomic.AddInt32(&sent, 1)
+		return true
+	}
+	client, _ := endpoints(t, lo)
+
+	opts := fastRetry
+	opts.Retries = 2
+	_, err := client.Request(context.Background(), serverAddr, Message{}, opts)
+	if assert.Error(err) {
+		assert.Equal("atp request to 100.2:201: timed out", err.Error())
+	}
+	assert.Equal(int32(3), atomic.LoadInt32(&sent))
+}
+
+func TestRetryForever(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	lo.Drop = func(from, to ddp.SocketAddr, proto uint8, data []byte) bool { return true }
+	client, _ := endpoints(t, lo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	opts := fastRetry
+	opts.Retries = RetryForever
+	_, err := client.Request(ctx, serverAddr, Message{}, opts)
+	assert.Equal(context.DeadlineExceeded, err)
+}
+
+func TestClose(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	client, _ := endpoints(t, lo)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.Close()
+	}()
+	_, err := client.Request(context.Background(), ddp.SocketAddr{Network: 100, Node: 3, Socket: 1}, Message{}, RequestOptions{})
+	assert.Equal(ddp.ErrClosed, err)
+
+	_, err = client.Request(context.Background(), serverAddr, Message{}, RequestOptions{})
+	assert.Equal(ddp.ErrClosed, err)
+}
+
+func TestRespondCount(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	client, server := endpoints(t, lo)
+
+	go func() {
+		tx, err := server.Accept(context.Background())
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(2, tx.Responses)
+		err = tx.Respond(messages("a", "b", "c", "d", "e", "f", "g", "h", "i"))
+		if assert.Error(err) {
+			assert.Equal("atp respond: invalid response count 9", err.Error())
+		}
+		assert.NoError(tx.Respond(messages("a", "b", "c")))
+	}()
+
+	// Only the packets that fit are sent.
+	opts := RequestOptions{Responses: 2}
+	resp, err := client.Request(context.Background(), serverAddr, Message{}, opts)
+	if assert.NoError(err) {
+		assert.Equal(messages("a", "b"), resp)
+	}
+}
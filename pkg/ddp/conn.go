@@ -0,0 +1,181 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package ddp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Returned by operations on a closed Conn.
+var ErrClosed = errors.New("socket closed")
+
+type (
+	// The address of a DDP socket.
+	SocketAddr struct {
+		Network Network
+		Node    Node
+		Socket  Socket
+	}
+
+	// A Conn is a DDP socket, for exchanging datagrams with other sockets.
+	// Its methods may be called concurrently.
+	Conn interface {
+		// Reads the next datagram sent to the socket into p.
+		// Returns the length of its data, its DDP type, and its sender.
+		ReadFrom(p []byte) (n int, proto uint8, from SocketAddr, err error)
+
+		// Sends a datagram of type proto from the socket.
+		WriteTo(p []byte, proto uint8, to SocketAddr) error
+
+		// Returns the address of the socket.
+		LocalAddr() SocketAddr
+
+		// Closes the socket. Blocked reads return ErrClosed.
+		Close() error
+	}
+)
+
+func (a SocketAddr) String() string {
+	return fmt.Sprintf("%d.%d:%d", a.Network, a.Node, a.Socket)
+}
+
+// Returns the address of the socket’s node.
+func (a SocketAddr) Addr() Addr {
+	return Addr{a.Network, a.Node}
+}
+
+type (
+	// A Loopback delivers datagrams between Conns in memory, as if they
+	// were on a single network. It is intended for tests.
+	Loopback struct {
+		// If set, called for each datagram. If it returns true, the
+		// datagram is dropped.
+		Drop func(from, to SocketAddr, proto uint8, data []byte) bool
+
+		mu    sync.Mutex
+		conns map[SocketAddr]*loopbackConn
+	}
+
+	loopbackConn struct {
+		lo     *Loopback
+		addr   SocketAddr
+		recv   chan datagram
+		closed chan struct{}
+		once   sync.Once
+	}
+
+	datagram struct {
+		from  SocketAddr
+		proto uint8
+		data  []byte
+	}
+)
+
+const loopbackQueueSize = 64
+
+func NewLoopback() *Loopback {
+	return &Loopback{conns: map[SocketAddr]*loopbackConn{}}
+}
+
+// Listen opens a socket at addr.
+func (lo *Loopback) Listen(addr SocketAddr) (Conn, error) {
+	lo.mu.Lock()
+	defer lo.mu.Unlock()
+	if _, ok := lo.conns[addr]; ok {
+		return nil, fmt.Errorf("listen %s: socket in use", addr)
+	}
+	c := &loopbackConn{
+		lo:     lo,
+		addr:   addr,
+		recv:   make(chan datagram, loopbackQueueSize),
+		closed: make(chan struct{}),
+	}
+	lo.conns[addr] = c
+	return c, nil
+}
+
+func (lo *Loopback) deliver(from, to SocketAddr, proto uint8, data []byte) {
+	if lo.Drop != nil && lo.Drop(from, to, proto, data) {
+		return
+	}
+	if to.Network == 0 {
+		to.Network = from.Network // “This network”
+	}
+	lo.mu.Lock()
+	defer lo.mu.Unlock()
+	for addr, c := range lo.conns {
+		if addr.Socket != to.Socket || addr.Network != to.Network {
+			continue
+		} else if to.Node != 0xff && addr.Node != to.Node {
+			continue
+		}
+		d := datagram{from, proto, append([]byte{}, data...)}
+		select {
+		case c.recv <- d:
+		default:
+		}
+	}
+}
+
+func (c *loopbackConn) ReadFrom(p []byte) (int, uint8, SocketAddr, error) {
+	select {
+	case d := <-c.recv:
+		return copy(p, d.data), d.proto, d.from, nil
+	case <-c.closed:
+		return 0, 0, SocketAddr{}, ErrClosed
+	}
+}
+
+func (c *loopbackConn) WriteTo(p []byte, proto uint8, to SocketAddr) error {
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+	if len(p) > MaxDataSize {
+		return fmt.Errorf("write to %s: data too long (%d > %d)", to, len(p), MaxDataSize)
+	}
+	c.lo.deliver(c.addr, to, proto, p)
+	return nil
+}
+
+func (c *loopbackConn) LocalAddr() SocketAddr {
+	return c.addr
+}
+
+func (c *loopbackConn) Close() error {
+	c.once.Do(func() {
+		c.lo.mu.Lock()
+		delete(c.lo.conns, c.addr)
+		c.lo.mu.Unlock()
+		close(c.closed)
+	})
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package ddp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopback(t *testing.T) {
+	assert := assert.New(t)
+	lo := NewLoopback()
+	a, err := lo.Listen(SocketAddr{100, 1, 4})
+	require.NoError(t, err)
+	b, err := lo.Listen(SocketAddr{100, 2, 4})
+	require.NoError(t, err)
+	_, err = lo.Listen(SocketAddr{100, 2, 4})
+	if assert.Error(err) {
+		assert.Equal("listen 100.2:4: socket in use", err.Error())
+	}
+
+	// Unicast
+	assert.NoError(a.WriteTo([]byte("ping"), ProtoAEP, b.LocalAddr()))
+	buf := make([]byte, MaxDataSize)
+	n, proto, from, err := b.ReadFrom(buf)
+	if assert.NoError(err) {
+		assert.Equal("ping", string(buf[:n]))
+		assert.Equal(uint8(ProtoAEP), proto)
+		assert.Equal(a.LocalAddr(), from)
+	}
+
+	// Broadcast
+	assert.NoError(b.WriteTo([]byte("all"), ProtoAEP, SocketAddr{100, 0xff, 4}))
+	n, _, from, err = a.ReadFrom(buf)
+	if assert.NoError(err) {
+		assert.Equal("all", string(buf[:n]))
+		assert.Equal(b.LocalAddr(), from)
+	}
+	n, _, _, err = b.ReadFrom(buf)
+	if assert.NoError(err) {
+		assert.Equal("all", string(buf[:n]))
+	}
+
+	assert.NoError(a.Close())
+	_, _, _, err = a.ReadFrom(buf)
+	assert.Equal(ErrClosed, err)
+	assert.Equal(ErrClosed, a.WriteTo([]byte{}, ProtoAEP, b.LocalAddr()))
+}
@@ -36,10 +36,13 @@ import (
 )
 
 const (
-	lengthMask = uint16(0x03ff)
+	lengthMask = uint16(0x03ff) // The rest of Size is the hop count
 
-	headerSize    = 5
-	extHeaderSize = 13
+	HeaderSize    = 5
+	ExtHeaderSize = 13
+
+	// Maximum length of the data in a packet.
+	MaxDataSize = 586
 )
 
 type (
@@ -76,6 +79,7 @@ const (
 	ProtoRTMPReq  = 0x05
 	ProtoZIP      = 0x06
 	ProtoADSP     = 0x07
+	ProtoIP       = 0x16
 )
 
 // Unmarshals a packet from bytes.
@@ -87,7 +91,7 @@ func Unmarshal(data []byte, pak *Packet) error {
 		return fmt.Errorf("read ddp header: %s", err.Error())
 	}
 
-	pak.Data = make([]byte, (pak.Size&lengthMask)-headerSize)
+	pak.Data = make([]byte, (pak.Size&lengthMask)-HeaderSize)
 	n, err := r.Read(pak.Data)
 	if err != nil {
 		return fmt.Errorf("read ddp: %s", err.Error())
@@ -130,7 +134,7 @@ func ExtUnmarshal(data []byte, pak *ExtPacket) error {
 		return fmt.Errorf("read ddp header: %s", err.Error())
 	}
 
-	pak.Data = make([]byte, (pak.Size&lengthMask)-extHeaderSize)
+	pak.Data = make([]byte, (pak.Size&lengthMask)-ExtHeaderSize)
 	n, err := r.Read(pak.Data)
 	if err != nil {
 		return fmt.Errorf("read ddp: %s", err.Error())
@@ -164,13 +168,34 @@ func ExtMarshal(pak ExtPacket) ([]byte, error) {
 	return w.Bytes(), nil
 }
 
+// Returns the number of routers that the packet has passed through.
+func (h ExtHeader) Hops() uint8 {
+	return uint8(h.Size>>10) & 0x0f
+}
+
+// Computes the DDP checksum of data.
+//
+// For a packet, data begins after the checksum field of the header.
+// A result of zero means “no checksum”, so it is returned as 0xffff.
+func Checksum(data []byte) uint16 {
+	sum := uint16(0)
+	for _, b := range data {
+		sum += uint16(b)
+		sum = (sum << 1) | (sum >> 15)
+	}
+	if sum == 0 {
+		return 0xffff
+	}
+	return sum
+}
+
 // Converts an extended packet to a short-form packet.
 //
 // Discards the network and node information.
 func ExtToShort(ext ExtPacket) Packet {
 	return Packet{
 		Header: Header{
-			Size:      ext.Size - extHeaderSize + headerSize,
+			Size:      ext.Size - ExtHeaderSize + HeaderSize,
 			DstSocket: ext.DstSocket,
 			SrcSocket: ext.SrcSocket,
 			Proto:     ext.Proto,
@@ -183,7 +208,7 @@ func ExtToShort(ext ExtPacket) Packet {
 func ShortToExt(pak Packet, network Network, dstNode, srcNode Node) ExtPacket {
 	return ExtPacket{
 		ExtHeader: ExtHeader{
-			Size:      pak.Size - headerSize + extHeaderSize,
+			Size:      pak.Size - HeaderSize + ExtHeaderSize,
 			DstNet:    network,
 			DstNode:   dstNode,
 			DstSocket: pak.DstSocket,
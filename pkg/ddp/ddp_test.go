@@ -37,8 +37,8 @@ import (
 
 func TestSizes(t *testing.T) {
 	assert := assert.New(t)
-	assert.Equal(binary.Size(Header{}), headerSize)
-	assert.Equal(binary.Size(ExtHeader{}), extHeaderSize)
+	assert.Equal(binary.Size(Header{}), HeaderSize)
+	assert.Equal(binary.Size(ExtHeader{}), ExtHeaderSize)
 }
 
 func TestExtUnmarshalNoError(t *testing.T) {
@@ -96,6 +96,15 @@ func TestExtUnmarshalNoError(t *testing.T) {
 	}
 }
 
+func TestHops(t *testing.T) {
+	assert := assert.New(t)
+	p := ExtPacket{}
+	if assert.NoError(ExtUnmarshal(unhex("0c150000"+"0000ff00ff5f0606"+"06"+"050000000000012a"), &p)) {
+		assert.Equal(uint8(3), p.Hops())
+		assert.Equal(8, len(p.Data))
+	}
+}
+
 func TestError(t *testing.T) {
 
 	cases := []struct {
@@ -118,6 +127,88 @@ func TestError(t *testing.T) {
 	}
 }
 
+func TestChecksum(t *testing.T) {
+	cases := []struct {
+		name, hex string
+		expected  uint16
+	}{
+		{"empty", "", 0xffff},
+		{"one", "01", 0x0002},
+		{"rotate", "8000", 0x0200},
+		{"carry", "ff" + "ff" + "ff" + "ff" + "ff" + "ff" + "ff" + "ff", 0xfc03},
+		{"zone", "4e4f525448", 0x1320},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, Checksum(unhex(c.hex)))
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected Range
+		err      string
+	}{
+		{"100-109", Range{100, 109}, ""},
+		{"5", Range{5, 5}, ""},
+		{"0x10-0x1f", Range{16, 31}, ""},
+		{"0", Range{}, `parse range "0": invalid range`},
+		{"109-100", Range{}, `parse range "109-100": invalid range`},
+		{"65280-65534", Range{}, `parse range "65280-65534": invalid range`},
+		{"a-b", Range{}, `parse range "a-b": strconv.ParseUint: parsing "a": invalid syntax`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			assert := assert.New(t)
+			r, err := ParseRange(c.input)
+			if c.err != "" {
+				if assert.Error(err) {
+					assert.Equal(c.err, err.Error())
+				}
+			} else if assert.NoError(err) {
+				assert.Equal(c.expected, r)
+				assert.True(r.Contains(r.Start))
+				assert.True(r.Contains(r.End))
+				assert.False(r.Contains(r.End + 1))
+			}
+		})
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected Addr
+		err      string
+	}{
+		{"100.5", Addr{100, 5}, ""},
+		{"65280.254", Addr{65280, 254}, ""},
+		{"0.255", Addr{0, 255}, ""},
+		{"100", Addr{}, `parse addr "100": missing node`},
+		{"100.256", Addr{}, `parse addr "100.256": strconv.ParseUint: parsing "256": value out of range`},
+		{"x.5", Addr{}, `parse addr "x.5": strconv.ParseUint: parsing "x": invalid syntax`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			assert := assert.New(t)
+			a, err := ParseAddr(c.input)
+			if c.err != "" {
+				if assert.Error(err) {
+					assert.Equal(c.err, err.Error())
+				}
+			} else if assert.NoError(err) {
+				assert.Equal(c.expected, a)
+				assert.Equal(c.input, a.String())
+			}
+		})
+	}
+}
+
 func unhex(s string) []byte {
 	data := []byte{}
 	for i := 0; i < len(s); i += 2 {
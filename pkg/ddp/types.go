@@ -27,6 +27,12 @@
 
 package ddp
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type (
 	Network uint16
 	Node    uint8
@@ -36,4 +42,70 @@ type (
 		Network Network
 		Node    Node
 	}
+
+	// An inclusive range of network numbers, such as the cable range
+	// of an extended network.
+	Range struct {
+		Start, End Network
+	}
 )
+
+// Networks that nodes on extended networks use while they discover
+// their cable range.
+var StartupRange = Range{0xff00, 0xfffe}
+
+// Returns true if n is within the range.
+func (r Range) Contains(n Network) bool {
+	return r.Start <= n && n <= r.End
+}
+
+// Returns true if the range is unset.
+func (r Range) IsZero() bool {
+	return r == Range{}
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// Parses a range of the form “100-109”, or a single network “100”.
+func ParseRange(s string) (Range, error) {
+	start, end, found := strings.Cut(s, "-")
+	if !found {
+		end = start
+	}
+	first, err := strconv.ParseUint(start, 0, 16)
+	if err != nil {
+		return Range{}, fmt.Errorf("parse range %q: %s", s, err.Error())
+	}
+	last, err := strconv.ParseUint(end, 0, 16)
+	if err != nil {
+		return Range{}, fmt.Errorf("parse range %q: %s", s, err.Error())
+	}
+	r := Range{Network(first), Network(last)}
+	if r.Start == 0 || r.End < r.Start || r.End >= StartupRange.Start {
+		return Range{}, fmt.Errorf("parse range %q: invalid range", s)
+	}
+	return r, nil
+}
+
+func (a Addr) String() string {
+	return fmt.Sprintf("%d.%d", a.Network, a.Node)
+}
+
+// Parses an address of the form “100.5”.
+func ParseAddr(s string) (Addr, error) {
+	net, node, found := strings.Cut(s, ".")
+	if !found {
+		return Addr{}, fmt.Errorf("parse addr %q: missing node", s)
+	}
+	n, err := strconv.ParseUint(net, 0, 16)
+	if err != nil {
+		return Addr{}, fmt.Errorf("parse addr %q: %s", s, err.Error())
+	}
+	m, err := strconv.ParseUint(node, 0, 8)
+	if err != nil {
+		return Addr{}, fmt.Errorf("parse addr %q: %s", s, err.Error())
+	}
+	return Addr{Network(n), Node(m)}, nil
+}
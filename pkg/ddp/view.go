@@ -0,0 +1,109 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package ddp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type (
+	// A short-form packet parsed in place. Data refers to the buffer it
+	// was parsed from, which must not change while the View is in use.
+	View Packet
+
+	// An extended packet parsed in place. Data refers to the buffer it
+	// was parsed from, which must not change while the ExtView is in use.
+	ExtView ExtPacket
+)
+
+// Unmarshals a packet from bytes without copying them. Unlike Unmarshal,
+// does not allocate, and rejects packets whose length field is shorter
+// than the header.
+func UnmarshalView(data []byte, v *View) error {
+	if len(data) < HeaderSize {
+		return fmt.Errorf("read ddp header: short packet (%d < %d)", len(data), HeaderSize)
+	}
+	v.Header = Header{
+		Size:      binary.BigEndian.Uint16(data[0:2]),
+		DstSocket: Socket(data[2]),
+		SrcSocket: Socket(data[3]),
+		Proto:     data[4],
+	}
+	if n := int(v.Size & lengthMask); n != len(data) || n < HeaderSize {
+		return fmt.Errorf("read ddp: length mismatch (%d != %d)", n, len(data))
+	}
+	v.Data = data[HeaderSize:]
+	return nil
+}
+
+// Unmarshals a packet from bytes without copying them. Unlike
+// ExtUnmarshal, does not allocate, and rejects packets whose length field
+// is shorter than the header.
+func ExtUnmarshalView(data []byte, v *ExtView) error {
+	if len(data) < ExtHeaderSize {
+		return fmt.Errorf("read ddp header: short packet (%d < %d)", len(data), ExtHeaderSize)
+	}
+	v.ExtHeader = ExtHeader{
+		Size:      binary.BigEndian.Uint16(data[0:2]),
+		Cksum:     binary.BigEndian.Uint16(data[2:4]),
+		DstNet:    Network(binary.BigEndian.Uint16(data[4:6])),
+		SrcNet:    Network(binary.BigEndian.Uint16(data[6:8])),
+		DstNode:   Node(data[8]),
+		SrcNode:   Node(data[9]),
+		DstSocket: Socket(data[10]),
+		SrcSocket: Socket(data[11]),
+		Proto:     data[12],
+	}
+	if n := int(v.Size & lengthMask); n != len(data) || n < ExtHeaderSize {
+		return fmt.Errorf("read ddp: length mismatch (%d != %d)", n, len(data))
+	}
+	v.Data = data[ExtHeaderSize:]
+	return nil
+}
+
+// Appends a marshaled packet to buf, and returns the extended buffer.
+func AppendMarshal(buf []byte, pak Packet) ([]byte, error) {
+	buf = binary.BigEndian.AppendUint16(buf, pak.Size)
+	buf = append(buf, uint8(pak.DstSocket), uint8(pak.SrcSocket), pak.Proto)
+	return append(buf, pak.Data...), nil
+}
+
+// Appends a marshaled packet to buf, and returns the extended buffer.
+func ExtAppendMarshal(buf []byte, pak ExtPacket) ([]byte, error) {
+	buf = binary.BigEndian.AppendUint16(buf, pak.Size)
+	buf = binary.BigEndian.AppendUint16(buf, pak.Cksum)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(pak.DstNet))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(pak.SrcNet))
+	buf = append(buf,
+		uint8(pak.DstNode), uint8(pak.SrcNode),
+		uint8(pak.DstSocket), uint8(pak.SrcSocket),
+		pak.Proto,
+	)
+	return append(buf, pak.Data...), nil
+}
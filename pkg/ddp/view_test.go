@@ -0,0 +1,137 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package ddp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	shortNBP = unhex("001e" + "0202" + "02" + "2101ff005ffd00034661620b576f726b73746174696f6e012a")
+	extNBP   = unhex("00260000" + "0000ff00ff5f02fd" + "02" +
+		"2101ff005ffd00034661620b576f726b73746174696f6e012a")
+)
+
+func TestUnmarshalView(t *testing.T) {
+	expected := Packet{}
+	require.NoError(t, Unmarshal(shortNBP, &expected))
+	v := View{}
+	require.NoError(t, UnmarshalView(shortNBP, &v))
+	assert.Equal(t, expected, Packet(v))
+
+	out, err := AppendMarshal(nil, Packet(v))
+	require.NoError(t, err)
+	assert.Equal(t, shortNBP, out)
+}
+
+func TestExtUnmarshalView(t *testing.T) {
+	expected := ExtPacket{}
+	require.NoError(t, ExtUnmarshal(extNBP, &expected))
+	v := ExtView{}
+	require.NoError(t, ExtUnmarshalView(extNBP, &v))
+	assert.Equal(t, expected, ExtPacket(v))
+
+	// The data is not copied.
+	assert.Same(t, &extNBP[ExtHeaderSize], &v.Data[0])
+
+	prefix := []byte{0xaa}
+	out, err := ExtAppendMarshal(prefix, ExtPacket(v))
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0xaa}, extNBP...), out)
+}
+
+func TestUnmarshalViewError(t *testing.T) {
+	cases := []struct {
+		name, hex, err string
+	}{
+		{"Empty", "", "read ddp header: short packet (0 < 13)"},
+		{"ShortSize", "0005000000000000000000000006", "read ddp: length mismatch (5 != 14)"},
+		{"Truncated", "00150000" + "0000ff00ff5f0606" + "06" + "0500", "read ddp: length mismatch (21 != 15)"},
+		{"Excess", "000d0000" + "0000ff00ff5f0606" + "06" + "05", "read ddp: length mismatch (13 != 14)"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := ExtView{}
+			err := ExtUnmarshalView(unhex(c.hex), &v)
+			if assert.Error(t, err) {
+				assert.Equal(t, c.err, err.Error())
+			}
+		})
+	}
+
+	v := View{}
+	assert.Error(t, UnmarshalView(unhex("0004"+"0202"), &v))
+}
+
+func TestViewAllocs(t *testing.T) {
+	v := ExtView{}
+	buf := make([]byte, 0, 600)
+	allocs := testing.AllocsPerRun(100, func() {
+		ExtUnmarshalView(extNBP, &v)
+		buf, _ = ExtAppendMarshal(buf[:0], ExtPacket(v))
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func BenchmarkExtUnmarshal(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := ExtPacket{}
+		ExtUnmarshal(extNBP, &p)
+	}
+}
+
+func BenchmarkExtUnmarshalView(b *testing.B) {
+	b.ReportAllocs()
+	v := ExtView{}
+	for i := 0; i < b.N; i++ {
+		ExtUnmarshalView(extNBP, &v)
+	}
+}
+
+func BenchmarkExtMarshal(b *testing.B) {
+	b.ReportAllocs()
+	p := ExtPacket{}
+	ExtUnmarshal(extNBP, &p)
+	for i := 0; i < b.N; i++ {
+		ExtMarshal(p)
+	}
+}
+
+func BenchmarkExtAppendMarshal(b *testing.B) {
+	b.ReportAllocs()
+	p := ExtPacket{}
+	ExtUnmarshal(extNBP, &p)
+	buf := make([]byte, 0, 600)
+	for i := 0; i < b.N; i++ {
+		buf, _ = ExtAppendMarshal(buf[:0], p)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package ethertalk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+const (
+	Phase1AppleTalkType = uint16(0x809B)
+	Phase1AARPType      = uint16(0x80F3)
+
+	Phase1HeaderSize = 14
+
+	llapHeaderSize = 3
+	aarpSize       = 28
+)
+
+// Phase 1 EtherTalk uses the ordinary Ethernet broadcast address, not
+// AppleTalkBroadcast.
+var Phase1Broadcast = ethernet.Addr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+type (
+	Phase1Header struct {
+		Dst, Src ethernet.Addr
+		Type     uint16
+	}
+
+	// Combines all data in an EtherTalk Phase 1 packet.
+	//
+	// EtherTalk Phase 1 packets contain:
+	//   * an Ethernet II header, whose type is either Phase1AppleTalkType
+	//     or Phase1AARPType,
+	//   * the payload: for AppleTalk, an LLAP header followed by a short
+	//     or extended DDP packet; for AARP, an AARP packet, and
+	//   * optionally, padding up to the minimum Ethernet frame size.
+	Phase1Packet struct {
+		Phase1Header
+		Payload []byte // marshaled llap.Packet or aarp.Packet
+		Pad     []byte
+	}
+)
+
+// Unmarshals a Phase 1 packet from bytes.
+//
+// Ethernet II frames have no length field, so the length of the payload
+// is inferred from its contents, and anything after it is padding.
+func Phase1Unmarshal(data []byte, pak *Phase1Packet) error {
+	r := bytes.NewReader(data)
+
+	err := binary.Read(r, binary.BigEndian, &pak.Phase1Header)
+	if err != nil {
+		return fmt.Errorf("read eth header: %s", err.Error())
+	}
+
+	rest := data[Phase1HeaderSize:]
+	size := len(rest)
+	switch pak.Type {
+	case Phase1AppleTalkType:
+		if len(rest) < llapHeaderSize {
+			return fmt.Errorf("read llap header: incomplete data (%d < %d)", len(rest), llapHeaderSize)
+		}
+		switch llap.Type(rest[2]) {
+		case llap.TypeDDP, llap.TypeExtDDP:
+			if len(rest) < llapHeaderSize+2 {
+				return fmt.Errorf("read ddp header: incomplete data (%d < %d)", len(rest), llapHeaderSize+2)
+			}
+			size = llapHeaderSize + int(binary.BigEndian.Uint16(rest[3:])&0x03ff)
+		}
+	case Phase1AARPType:
+		size = aarpSize
+	default:
+		return fmt.Errorf("read eth header: not AppleTalk (type %04x)", pak.Type)
+	}
+
+	pak.Payload = make([]byte, size)
+	n, err := r.Read(pak.Payload)
+	if err != nil {
+		return fmt.Errorf("read data: %s", err.Error())
+	} else if n < len(pak.Payload) {
+		return fmt.Errorf("read data: incomplete data (%d < %d)", n, len(pak.Payload))
+	}
+
+	pak.Pad, err = ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read padding: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Marshals a Phase 1 packet to bytes.
+func Phase1Marshal(pak Phase1Packet) ([]byte, error) {
+	w := bytes.NewBuffer([]byte{})
+	err := binary.Write(w, binary.BigEndian, pak.Phase1Header)
+	if err != nil {
+		return nil, fmt.Errorf("write eth header: %s", err.Error())
+	}
+
+	n, err := w.Write(pak.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("write data: %s", err.Error())
+	} else if n < len(pak.Payload) {
+		return nil, fmt.Errorf("write data: incomplete data (%d < %d)", n, len(pak.Payload))
+	}
+
+	n, err = w.Write(pak.Pad)
+	if err != nil {
+		return nil, fmt.Errorf("write padding: %s", err.Error())
+	} else if n < len(pak.Pad) {
+		return nil, fmt.Errorf("write padding: incomplete data (%d < %d)", n, len(pak.Pad))
+	}
+
+	return w.Bytes(), nil
+}
+
+func Phase1AppleTalk(src, dst ethernet.Addr, payload llap.Packet) (*Phase1Packet, error) {
+	data, err := llap.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal llap: %s", err.Error())
+	}
+	return &Phase1Packet{
+		Phase1Header: Phase1Header{
+			Dst:  dst,
+			Src:  src,
+			Type: Phase1AppleTalkType,
+		},
+		Payload: data,
+	}, nil
+}
+
+func Phase1AARP(src, dst ethernet.Addr, payload aarp.Packet) (*Phase1Packet, error) {
+	data, err := aarp.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal aarp: %s", err.Error())
+	}
+	return &Phase1Packet{
+		Phase1Header: Phase1Header{
+			Dst:  dst,
+			Src:  src,
+			Type: Phase1AARPType,
+		},
+		Payload: data,
+	}, nil
+}
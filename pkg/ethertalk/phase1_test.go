@@ -0,0 +1,138 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package ethertalk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sfiera/multitalk/pkg/aarp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+func TestPhase1UnmarshalNoError(t *testing.T) {
+	cases := []struct {
+		name, hex string
+		expected  Phase1Packet
+	}{{
+		"AARP",
+		"ffffffffffff" + "080007b4b1ce" + "80f3" + // Ethernet header
+			"0001809b06040003080007b4b1ce0000005f0000000000000000005f" + // AARP payload
+			"000000000000000000000000000000000000", // Padding
+		Phase1Packet{
+			Phase1Header: Phase1Header{
+				Dst:  Phase1Broadcast,
+				Src:  ethernet.Addr{0x08, 0x00, 0x07, 0xb4, 0xb1, 0xce},
+				Type: Phase1AARPType,
+			},
+			Payload: unhex("0001809b06040003080007b4b1ce0000005f0000000000000000005f"),
+			Pad:     unhex("000000000000000000000000000000000000"),
+		},
+	}, {
+		"NBP",
+		"ffffffffffff" + "080007b4b1ce" + "809b" + // Ethernet header
+			"ff5f01" + // LLAP header
+			"001e02fd02" + // Short DDP header
+			"2101ff005ffd00034661620b576f726b73746174696f6e012a" + // NBP payload
+			"00000000000000000000000000", // Padding
+		Phase1Packet{
+			Phase1Header: Phase1Header{
+				Dst:  Phase1Broadcast,
+				Src:  ethernet.Addr{0x08, 0x00, 0x07, 0xb4, 0xb1, 0xce},
+				Type: Phase1AppleTalkType,
+			},
+			Payload: unhex("ff5f01" + "001e02fd02" + "2101ff005ffd00034661620b576f726b73746174696f6e012a"),
+			Pad:     unhex("00000000000000000000000000"),
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			p := Phase1Packet{}
+			if assert.NoError(Phase1Unmarshal(unhex(c.hex), &p)) {
+				assert.Equal(c.expected, p)
+			}
+			data, err := Phase1Marshal(p)
+			if assert.NoError(err) {
+				assert.Equal(unhex(c.hex), data)
+			}
+		})
+	}
+}
+
+func TestPhase1Error(t *testing.T) {
+	cases := []struct {
+		name, hex, err string
+	}{{
+		"empty",
+		"",
+		"read eth header: EOF",
+	}, {
+		"snap",
+		"090007ffffff" + "080007b4b1ce" + "0010" +
+			"aaaa03" + "080007809b",
+		"read eth header: not AppleTalk (type 0010)",
+	}, {
+		"incomplete",
+		"ffffffffffff" + "080007b4b1ce" + "809b" +
+			"ff5f01" + "001e02fd02",
+		"read data: incomplete data (8 < 33)",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			p := Phase1Packet{}
+			err := Phase1Unmarshal(unhex(c.hex), &p)
+			if assert.Error(err) {
+				assert.Equal(c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestPhase1Constructors(t *testing.T) {
+	assert := assert.New(t)
+	src := ethernet.Addr{0x08, 0x00, 0x07, 0xb4, 0xb1, 0xce}
+
+	pak, err := Phase1AppleTalk(src, Phase1Broadcast, *llap.Enq(95, 95))
+	if assert.NoError(err) {
+		assert.Equal(Phase1AppleTalkType, pak.Type)
+		assert.Equal(unhex("5f5f81"), pak.Payload)
+	}
+
+	pak, err = Phase1AARP(src, Phase1Broadcast, aarp.Probe(src, ddp.Addr{Node: 95}))
+	if assert.NoError(err) {
+		assert.Equal(Phase1AARPType, pak.Type)
+		assert.Equal(unhex("0001809b06040003080007b4b1ce0000005f0000000000000000005f"), pak.Payload)
+	}
+}
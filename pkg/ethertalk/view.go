@@ -0,0 +1,79 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package ethertalk
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Length of the headers before the payload.
+const headersSize = 14 + LinkHeaderSize + SNAPProtoSize
+
+// A packet parsed in place. Payload and Pad refer to the buffer it was
+// parsed from, which must not change while the View is in use.
+type View Packet
+
+// Unmarshals a packet from bytes without copying them. Unlike Unmarshal,
+// does not allocate, and rejects packets whose length field is shorter
+// than the LLC and SNAP headers.
+func UnmarshalView(data []byte, v *View) error {
+	if len(data) < headersSize {
+		return fmt.Errorf("read eth header: short packet (%d < %d)", len(data), headersSize)
+	}
+	copy(v.Dst[:], data[0:6])
+	copy(v.Src[:], data[6:12])
+	v.Size = binary.BigEndian.Uint16(data[12:14])
+
+	v.LinkHeader = LinkHeader{data[14], data[15], data[16]}
+	if v.LinkHeader != SNAP {
+		return fmt.Errorf("read link header: not SNAP")
+	}
+	copy(v.OUI[:], data[17:20])
+	v.Proto = binary.BigEndian.Uint16(data[20:22])
+
+	end := 14 + int(v.Size)
+	if end < headersSize || end > len(data) {
+		return fmt.Errorf("read data: length mismatch (%d > %d)", end, len(data))
+	}
+	v.Payload = data[headersSize:end]
+	v.Pad = data[end:]
+	return nil
+}
+
+// Appends a marshaled packet to buf, and returns the extended buffer.
+func AppendMarshal(buf []byte, pak Packet) ([]byte, error) {
+	buf = append(buf, pak.Dst[:]...)
+	buf = append(buf, pak.Src[:]...)
+	buf = binary.BigEndian.AppendUint16(buf, pak.Size)
+	buf = append(buf, pak.DSAP, pak.SSAP, pak.Control)
+	buf = append(buf, pak.OUI[:]...)
+	buf = binary.BigEndian.AppendUint16(buf, pak.Proto)
+	buf = append(buf, pak.Payload...)
+	return append(buf, pak.Pad...), nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package ethertalk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var zipFrame = unhex("090007ffffff" + "080007b4b1ce" + "001d" +
+	"aaaa03" + "080007809b" +
+	"001500000000ff00ff5f060606050000000000012a" +
+	"080007b4b1ce080007b4b1ce809b417070")
+
+func TestUnmarshalView(t *testing.T) {
+	expected := Packet{}
+	require.NoError(t, Unmarshal(zipFrame, &expected))
+	v := View{}
+	require.NoError(t, UnmarshalView(zipFrame, &v))
+	assert.Equal(t, expected, Packet(v))
+
+	out, err := AppendMarshal(nil, Packet(v))
+	require.NoError(t, err)
+	assert.Equal(t, zipFrame, out)
+}
+
+func TestUnmarshalViewError(t *testing.T) {
+	cases := []struct {
+		name, hex, err string
+	}{{
+		"empty",
+		"",
+		"read eth header: short packet (0 < 22)",
+	}, {
+		"incomplete",
+		"090007ffffff" + "080007b4b1ce" + "0010" +
+			"aaaa03" + "080007809b" +
+			"0000",
+		"read data: length mismatch (30 > 24)",
+	}, {
+		"too_short",
+		"090007ffffff" + "080007b4b1ce" + "0004" +
+			"aaaa03" + "080007809b",
+		"read data: length mismatch (18 > 22)",
+	}, {
+		"not_snap",
+		"090007ffffff" + "080007b4b1ce" + "0010" +
+			"ffffff" + "080007809b",
+		"read link header: not SNAP",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := View{}
+			err := UnmarshalView(unhex(c.hex), &v)
+			if assert.Error(t, err) {
+				assert.Equal(t, c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestViewAllocs(t *testing.T) {
+	v := View{}
+	buf := make([]byte, 0, 1600)
+	allocs := testing.AllocsPerRun(100, func() {
+		UnmarshalView(zipFrame, &v)
+		buf, _ = AppendMarshal(buf[:0], Packet(v))
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := Packet{}
+		Unmarshal(zipFrame, &p)
+	}
+}
+
+func BenchmarkUnmarshalView(b *testing.B) {
+	b.ReportAllocs()
+	v := View{}
+	for i := 0; i < b.N; i++ {
+		UnmarshalView(zipFrame, &v)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	b.ReportAllocs()
+	p := Packet{}
+	Unmarshal(zipFrame, &p)
+	for i := 0; i < b.N; i++ {
+		Marshal(p)
+	}
+}
+
+func BenchmarkAppendMarshal(b *testing.B) {
+	b.ReportAllocs()
+	p := Packet{}
+	Unmarshal(zipFrame, &p)
+	buf := make([]byte, 0, 1600)
+	for i := 0; i < b.N; i++ {
+		buf, _ = AppendMarshal(buf[:0], p)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Encodes and decodes IPTalk packets, which carry DDP over UDP.
+//
+// IPTalk is the encapsulation of KIP (Kinetics IP) and CAP (Columbia
+// AppleTalk Package) gateways. Each UDP datagram holds an LLAP-style
+// header followed by a long-form DDP packet, and is sent between UDP
+// ports that correspond to the packet’s DDP sockets.
+package iptalk
+
+import (
+	"fmt"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+const (
+	// UDP port of well-known DDP socket 0. Well-known socket s is on
+	// port WellKnownPort+s.
+	WellKnownPort = 768
+
+	// UDP port of dynamic DDP socket 0. Dynamic socket s is on port
+	// DynamicPort+s.
+	DynamicPort = 16384
+
+	// First dynamic DDP socket.
+	firstDynamic = ddp.Socket(128)
+
+	// Length of the header before the DDP packet.
+	HeaderSize = 3
+)
+
+type (
+	Header struct {
+		DstNode, SrcNode ddp.Node
+		Kind             llap.Type // Always llap.TypeExtDDP
+	}
+
+	Packet struct {
+		Header
+		DDP ddp.ExtPacket
+	}
+)
+
+// Returns the UDP port of a DDP socket.
+func Port(socket ddp.Socket) uint16 {
+	if socket < firstDynamic {
+		return WellKnownPort + uint16(socket)
+	}
+	return DynamicPort + uint16(socket)
+}
+
+// Returns the DDP socket of a UDP port, or false if the port doesn’t
+// correspond to a valid socket.
+func Socket(port uint16) (ddp.Socket, bool) {
+	switch {
+	case port > WellKnownPort && port < WellKnownPort+uint16(firstDynamic):
+		return ddp.Socket(port - WellKnownPort), true
+	case port >= DynamicPort+uint16(firstDynamic) && port < DynamicPort+0xff:
+		return ddp.Socket(port - DynamicPort), true
+	}
+	return 0, false
+}
+
+// Unmarshals a packet from bytes.
+func Unmarshal(data []byte, pak *Packet) error {
+	if len(data) < HeaderSize {
+		return fmt.Errorf("read iptalk header: invalid length %d", len(data))
+	}
+	pak.Header = Header{
+		DstNode: ddp.Node(data[0]),
+		SrcNode: ddp.Node(data[1]),
+		Kind:    llap.Type(data[2]),
+	}
+	if pak.Kind != llap.TypeExtDDP {
+		return fmt.Errorf("read iptalk header: unsupported type 0x%02x", uint8(pak.Kind))
+	}
+	err := ddp.ExtUnmarshal(data[HeaderSize:], &pak.DDP)
+	if err != nil {
+		return fmt.Errorf("read iptalk body: %s", err.Error())
+	}
+	return nil
+}
+
+// Marshals a packet to bytes.
+func Marshal(pak Packet) ([]byte, error) {
+	if pak.Kind != llap.TypeExtDDP {
+		return nil, fmt.Errorf("write iptalk header: unsupported type 0x%02x", uint8(pak.Kind))
+	}
+	payload, err := ddp.ExtMarshal(pak.DDP)
+	if err != nil {
+		return nil, fmt.Errorf("write iptalk body: %s", err.Error())
+	}
+	data := make([]byte, 0, HeaderSize+len(payload))
+	data = append(data, uint8(pak.DstNode), uint8(pak.SrcNode), uint8(pak.Kind))
+	return append(data, payload...), nil
+}
+
+// Creates a packet that carries payload between nodes.
+func AppleTalk(dstNode, srcNode ddp.Node, payload ddp.ExtPacket) Packet {
+	return Packet{
+		Header: Header{
+			DstNode: dstNode,
+			SrcNode: srcNode,
+			Kind:    llap.TypeExtDDP,
+		},
+		DDP: payload,
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package iptalk
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/llap"
+)
+
+func TestPort(t *testing.T) {
+	cases := []struct {
+		socket ddp.Socket
+		port   uint16
+	}{
+		{1, 769},     // RTMP
+		{2, 770},     // NBP
+		{4, 772},     // AEP
+		{6, 774},     // ZIP
+		{72, 840},    // MacIP
+		{127, 895},   // Last well-known
+		{128, 16512}, // First dynamic
+		{254, 16638}, // Last dynamic
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.port, Port(c.socket), "socket %d", c.socket)
+		socket, ok := Socket(c.port)
+		assert.True(t, ok, "port %d", c.port)
+		assert.Equal(t, c.socket, socket, "port %d", c.port)
+	}
+
+	for _, port := range []uint16{0, 548, 768, 896, 16384, 16511, 16639} {
+		_, ok := Socket(port)
+		assert.False(t, ok, "port %d", port)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	data := unhex(
+		"ff0502" + // 5 to 255, long DDP
+			"00150000" + // Size and checksum
+			"0000ff00ff050606" + // 65280.5:6 to 0.255:6
+			"06" + // ZIP
+			"050000000000012a", // ZIP payload
+	)
+	expected := AppleTalk(0xff, 0x05, ddp.ExtPacket{
+		ExtHeader: ddp.ExtHeader{
+			Size:      21,
+			SrcNet:    65280,
+			DstNode:   255,
+			SrcNode:   5,
+			DstSocket: 6,
+			SrcSocket: 6,
+			Proto:     ddp.ProtoZIP,
+		},
+		Data: unhex("050000000000012a"),
+	})
+
+	var pak Packet
+	require.NoError(t, Unmarshal(data, &pak))
+	assert.Equal(t, expected, pak)
+
+	out, err := Marshal(expected)
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+}
+
+func TestUnmarshalError(t *testing.T) {
+	cases := []struct {
+		name, hex string
+	}{
+		{"Empty", ""},
+		{"ShortHeader", "ff05"},
+		{"ShortDDP", "ff0501" + "000b0604"},
+		{"Enq", "fefe81"},
+		{"Truncated", "ff0502" + "00150000" + "0000ff00ff050606" + "06"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var pak Packet
+			assert.Error(t, Unmarshal(unhex(c.hex), &pak))
+		})
+	}
+
+	_, err := Marshal(Packet{Header: Header{Kind: llap.TypeDDP}})
+	assert.Error(t, err)
+}
+
+func unhex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -42,6 +42,11 @@ const (
 	TypeExtDDP = Type(0x02)
 	TypeEnq    = Type(0x81)
 	TypeAck    = Type(0x82)
+	TypeRTS    = Type(0x84)
+	TypeCTS    = Type(0x85)
+
+	// Largest payload of a data frame.
+	MaxPayloadSize = 600
 )
 
 type (
@@ -58,6 +63,35 @@ type (
 	}
 )
 
+// Returns true if frames of type t are control frames, which carry no
+// payload.
+func (t Type) IsControl() bool {
+	return t&0x80 != 0
+}
+
+// Returns an error if the packet is not a legal LLAP frame: if a control
+// frame carries a payload, a data frame’s payload is too long, or an RTS
+// or CTS frame, which only precede directed data frames, is broadcast.
+// Frames of unknown types aren’t checked, so that they pass through.
+func (pak Packet) Validate() error {
+	switch pak.Kind {
+	case TypeDDP, TypeExtDDP:
+		if len(pak.Payload) > MaxPayloadSize {
+			return fmt.Errorf("packet too long: %d > %d", len(pak.Payload), MaxPayloadSize)
+		}
+	case TypeRTS, TypeCTS:
+		if pak.DstNode == 0xff {
+			return fmt.Errorf("control frame packet to broadcast node")
+		}
+		fallthrough
+	case TypeEnq, TypeAck:
+		if len(pak.Payload) != 0 {
+			return fmt.Errorf("control frame packet with payload")
+		}
+	}
+	return nil
+}
+
 func Unmarshal(data []byte, pak *Packet) error {
 	r := bytes.NewReader(data)
 	err := binary.Read(r, binary.BigEndian, &pak.Header)
@@ -70,15 +104,25 @@ func Unmarshal(data []byte, pak *Packet) error {
 		return fmt.Errorf("read udp body: %s", err.Error())
 	} else if len(payload) > 0 {
 		pak.Payload = payload
+	} else {
+		pak.Payload = nil
 	}
 
+	err = pak.Validate()
+	if err != nil {
+		return fmt.Errorf("read llap: %s", err.Error())
+	}
 	return nil
 }
 
 func Marshal(pak Packet) ([]byte, error) {
+	err := pak.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("write llap: %s", err.Error())
+	}
 	buf := bytes.NewBuffer([]byte{})
 
-	err := binary.Write(buf, binary.BigEndian, pak.Header)
+	err = binary.Write(buf, binary.BigEndian, pak.Header)
 	if err != nil {
 		return nil, fmt.Errorf("write udp header: %s", err.Error())
 	}
@@ -113,6 +157,29 @@ func Ack(dstNode, srcNode ddp.Node) *Packet {
 	}
 }
 
+// Creates a request to send a directed data frame to dstNode.
+func RTS(dstNode, srcNode ddp.Node) *Packet {
+	return &Packet{
+		Header: Header{
+			DstNode: dstNode,
+			SrcNode: srcNode,
+			Kind:    TypeRTS,
+		},
+	}
+}
+
+// Creates a reply to an RTS, clearing dstNode to send its data frame to
+// srcNode.
+func CTS(dstNode, srcNode ddp.Node) *Packet {
+	return &Packet{
+		Header: Header{
+			DstNode: dstNode,
+			SrcNode: srcNode,
+			Kind:    TypeCTS,
+		},
+	}
+}
+
 func AppleTalk(dstNode, srcNode ddp.Node, payload ddp.Packet) (*Packet, error) {
 	data, err := ddp.Marshal(payload)
 	if err != nil {
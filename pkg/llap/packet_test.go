@@ -0,0 +1,97 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package llap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshal(t *testing.T) {
+	pak := Packet{}
+	require.NoError(t, Unmarshal([]byte{0x02, 0x01, 0x01, 0x00, 0x07, 0x00, 0x00, 0x04, 0x04, 0x04}, &pak))
+	assert.Equal(t, Header{DstNode: 2, SrcNode: 1, Kind: TypeDDP}, pak.Header)
+	assert.Equal(t, []byte{0x00, 0x07, 0x00, 0x00, 0x04, 0x04, 0x04}, pak.Payload)
+
+	// A control frame leaves no payload behind.
+	require.NoError(t, Unmarshal([]byte{0x01, 0x02, 0x84}, &pak))
+	assert.Equal(t, *RTS(1, 2), pak)
+	require.NoError(t, Unmarshal([]byte{0x02, 0x01, 0x85}, &pak))
+	assert.Equal(t, *CTS(2, 1), pak)
+
+	// Frames of unknown types pass through.
+	require.NoError(t, Unmarshal([]byte{0x02, 0x01, 0x83}, &pak))
+	assert.Equal(t, Packet{Header: Header{DstNode: 2, SrcNode: 1, Kind: 0x83}}, pak)
+	require.NoError(t, Unmarshal([]byte{0x02, 0x01, 0x0d, 0x01, 0x02}, &pak))
+	assert.Equal(t, Packet{Header{2, 1, 0x0d}, []byte{0x01, 0x02}}, pak)
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		pak  Packet
+		err  string
+	}{
+		{"Enq", *Enq(5, 5), ""},
+		{"Ack", *Ack(5, 5), ""},
+		{"RTS", *RTS(5, 6), ""},
+		{"CTS", *CTS(6, 5), ""},
+		{"Data", Packet{Header{0xff, 5, TypeDDP}, make([]byte, MaxPayloadSize)}, ""},
+		{"TooLong", Packet{Header{0xff, 5, TypeExtDDP}, make([]byte, MaxPayloadSize+1)}, "packet too long: 601 > 600"},
+		{"ControlPayload", Packet{Header{5, 6, TypeRTS}, []byte{1}}, "control frame packet with payload"},
+		{"BroadcastRTS", *RTS(0xff, 6), "control frame packet to broadcast node"},
+		{"BroadcastCTS", *CTS(0xff, 6), "control frame packet to broadcast node"},
+		{"UnknownControl", Packet{Header{5, 6, 0x83}, nil}, ""},
+		{"UnknownData", Packet{Header{5, 6, 0x0d}, []byte{1}}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.pak.Validate()
+			if c.err == "" {
+				assert.NoError(t, err)
+				_, err = Marshal(c.pak)
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Equal(t, c.err, err.Error())
+				_, err = Marshal(c.pak)
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestIsControl(t *testing.T) {
+	for _, k := range []Type{TypeEnq, TypeAck, TypeRTS, TypeCTS} {
+		assert.True(t, k.IsControl(), "$%02x", uint8(k))
+	}
+	for _, k := range []Type{TypeDDP, TypeExtDDP} {
+		assert.False(t, k.IsControl(), "$%02x", uint8(k))
+	}
+}
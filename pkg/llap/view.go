@@ -0,0 +1,73 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package llap
+
+import (
+	"fmt"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+// Length of the header.
+const headerSize = 3
+
+// A packet parsed in place. Payload refers to the buffer it was parsed
+// from, which must not change while the View is in use.
+type View Packet
+
+// Unmarshals a packet from bytes without copying them. Like Unmarshal,
+// rejects packets that are not legal LLAP frames, but does not allocate.
+func UnmarshalView(data []byte, v *View) error {
+	if len(data) < headerSize {
+		return fmt.Errorf("read llap header: short packet (%d < %d)", len(data), headerSize)
+	}
+	v.Header = Header{
+		DstNode: ddp.Node(data[0]),
+		SrcNode: ddp.Node(data[1]),
+		Kind:    Type(data[2]),
+	}
+	v.Payload = nil
+	if len(data) > headerSize {
+		v.Payload = data[headerSize:]
+	}
+	err := Packet(*v).Validate()
+	if err != nil {
+		return fmt.Errorf("read llap: %s", err.Error())
+	}
+	return nil
+}
+
+// Appends a marshaled packet to buf, and returns the extended buffer.
+func AppendMarshal(buf []byte, pak Packet) ([]byte, error) {
+	err := pak.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("write llap: %s", err.Error())
+	}
+	buf = append(buf, uint8(pak.DstNode), uint8(pak.SrcNode), uint8(pak.Kind))
+	return append(buf, pak.Payload...), nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package llap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var echo = []byte{0x02, 0x01, 0x01, 0x00, 0x07, 0x04, 0x04, 0x04, 0x01, 0x2a}
+
+func TestUnmarshalView(t *testing.T) {
+	expected := Packet{}
+	require.NoError(t, Unmarshal(echo, &expected))
+	v := View{}
+	require.NoError(t, UnmarshalView(echo, &v))
+	assert.Equal(t, expected, Packet(v))
+
+	out, err := AppendMarshal(nil, Packet(v))
+	require.NoError(t, err)
+	assert.Equal(t, echo, out)
+
+	// A control frame leaves no payload behind.
+	require.NoError(t, UnmarshalView([]byte{0x01, 0x02, 0x85}, &v))
+	assert.Equal(t, *CTS(1, 2), Packet(v))
+}
+
+func TestUnmarshalViewError(t *testing.T) {
+	v := View{}
+	err := UnmarshalView([]byte{0x01, 0x02}, &v)
+	if assert.Error(t, err) {
+		assert.Equal(t, "read llap header: short packet (2 < 3)", err.Error())
+	}
+	err = UnmarshalView([]byte{0xff, 0x02, 0x84}, &v)
+	if assert.Error(t, err) {
+		assert.Equal(t, "read llap: control frame packet to broadcast node", err.Error())
+	}
+
+	_, err = AppendMarshal(nil, Packet{Header: Header{DstNode: 1, SrcNode: 2, Kind: TypeEnq}, Payload: []byte{1}})
+	assert.Error(t, err)
+}
+
+func TestViewAllocs(t *testing.T) {
+	v := View{}
+	buf := make([]byte, 0, 603)
+	allocs := testing.AllocsPerRun(100, func() {
+		UnmarshalView(echo, &v)
+		buf, _ = AppendMarshal(buf[:0], Packet(v))
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := Packet{}
+		Unmarshal(echo, &p)
+	}
+}
+
+func BenchmarkUnmarshalView(b *testing.B) {
+	b.ReportAllocs()
+	v := View{}
+	for i := 0; i < b.N; i++ {
+		UnmarshalView(echo, &v)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	b.ReportAllocs()
+	p := Packet{}
+	Unmarshal(echo, &p)
+	for i := 0; i < b.N; i++ {
+		Marshal(p)
+	}
+}
+
+func BenchmarkAppendMarshal(b *testing.B) {
+	b.ReportAllocs()
+	p := Packet{}
+	Unmarshal(echo, &p)
+	buf := make([]byte, 0, 603)
+	for i := 0; i < b.N; i++ {
+		buf, _ = AppendMarshal(buf[:0], p)
+	}
+}
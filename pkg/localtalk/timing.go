@@ -0,0 +1,83 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package localtalk
+
+import (
+	"time"
+)
+
+const (
+	// Bits per second on a LocalTalk bus.
+	BitRate = 230400
+
+	// Minimum idle time between dialogs, such as two data frames.
+	InterdialogGap = 400 * time.Microsecond
+
+	// Maximum idle time between frames of a dialog, such as an RTS and
+	// its CTS.
+	InterframeGap = 200 * time.Microsecond
+
+	leadFlags  = 2  // Flags before each frame
+	trailFlags = 1  // Flags after each frame
+	abortBits  = 12 // Ones after the trailing flags
+)
+
+// Returns the number of bits that an LLAP frame occupies on the bus. The
+// frame is the LLAP header and payload; the count includes its flags, its
+// FCS, the zeroes stuffed after each run of five ones, and the abort
+// sequence that ends it.
+func FrameBits(frame []byte) int {
+	return FrameBitsFCS(frame, SumCRC(frame))
+}
+
+// Like FrameBits, for a frame whose FCS has already been computed.
+func FrameBitsFCS(frame []byte, fcs uint16) int {
+	bits := 8 * (leadFlags + len(frame) + 2 + trailFlags)
+	ones := 0
+	stuff := func(b byte) {
+		for i := 0; i < 8; i++ {
+			if b&(1<<i) == 0 {
+				ones = 0
+			} else if ones++; ones == 5 {
+				bits++
+				ones = 0
+			}
+		}
+	}
+	for _, b := range frame {
+		stuff(b)
+	}
+	stuff(byte(fcs))
+	stuff(byte(fcs >> 8))
+	return bits + abortBits
+}
+
+// Returns the time that an LLAP frame occupies the bus.
+func FrameTime(frame []byte) time.Duration {
+	return time.Duration(FrameBits(frame)) * time.Second / BitRate
+}
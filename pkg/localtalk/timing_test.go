@@ -0,0 +1,55 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package localtalk
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameBits(t *testing.T) {
+	// An ENQ has no payload, so it is 3 bytes and a 2-byte FCS between
+	// 3 flags, plus the abort sequence and any stuffed bits.
+	enq := []byte{0x80, 0x80, 0x81}
+	assert.GreaterOrEqual(t, FrameBits(enq), 76)
+	assert.LessOrEqual(t, FrameBits(enq), 76+8)
+
+	// Runs of ones are stuffed with a zero after every fifth.
+	zeroes := make([]byte, 100)
+	ones := bytes.Repeat([]byte{0xff}, 100)
+	assert.GreaterOrEqual(t, FrameBits(ones)-FrameBits(zeroes), 800/5-16)
+}
+
+func TestFrameTime(t *testing.T) {
+	// A full DDP frame takes about 21 ms.
+	frame := append([]byte{0x80, 0x80, 0x02}, make([]byte, 600)...)
+	assert.InDelta(t, float64(21*time.Millisecond), float64(FrameTime(frame)), float64(time.Millisecond))
+}
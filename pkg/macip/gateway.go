@@ -0,0 +1,329 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package macip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+// How long an address must go unused before it can be assigned to
+// another client, once the pool is exhausted.
+const LeaseIdle = 10 * time.Minute
+
+type (
+	// A range of IPv4 addresses, inclusive.
+	Pool struct {
+		Start, End netip.Addr
+	}
+
+	// A Gateway assigns IP addresses to MacIP clients from a pool, and
+	// moves IP datagrams between them and an IP network.
+	Gateway struct {
+		prefix     netip.Prefix
+		pool       Pool
+		nameserver netip.Addr
+		now        func() time.Time
+
+		mu     sync.Mutex
+		leases map[netip.Addr]*lease
+	}
+
+	// The client that uses an address.
+	lease struct {
+		node ddp.Addr
+		used time.Time
+	}
+)
+
+// Parses a pool of the form “10.1.1.10-10.1.1.50”.
+func ParsePool(s string) (Pool, error) {
+	start, end, found := strings.Cut(s, "-")
+	if !found {
+		return Pool{}, fmt.Errorf("parse pool %q: missing end", s)
+	}
+	var p Pool
+	var err error
+	p.Start, err = netip.ParseAddr(start)
+	if err != nil {
+		return Pool{}, fmt.Errorf("parse pool %q: %s", s, err.Error())
+	}
+	p.End, err = netip.ParseAddr(end)
+	if err != nil {
+		return Pool{}, fmt.Errorf("parse pool %q: %s", s, err.Error())
+	}
+	return p, nil
+}
+
+// Returns true if ip is in the pool.
+func (p Pool) contains(ip netip.Addr) bool {
+	return !ip.Less(p.Start) && !p.End.Less(ip)
+}
+
+func (p Pool) String() string {
+	return fmt.Sprintf("%s-%s", p.Start, p.End)
+}
+
+// Creates a Gateway whose own address and subnet are prefix, such as
+// 10.1.1.1/24. Clients are assigned addresses from pool, or if it is
+// zero, from the rest of the subnet. They are told to use nameserver,
+// which may be invalid if there is none.
+//
+// Clients may only send from their assigned addresses, or from static
+// addresses in the subnet but outside the pool. A static address belongs
+// to the first client to use it, until it is idle for LeaseIdle.
+func NewGateway(prefix netip.Prefix, pool Pool, nameserver netip.Addr) (*Gateway, error) {
+	if !prefix.Addr().Is4() || prefix.Bits() > 30 {
+		return nil, fmt.Errorf("macip gateway: invalid subnet %s", prefix)
+	}
+	subnet := prefix.Masked()
+	if pool == (Pool{}) {
+		pool = Pool{subnet.Addr().Next(), broadcast(prefix).Prev()}
+	} else if !subnet.Contains(pool.Start) || !subnet.Contains(pool.End) || pool.End.Less(pool.Start) {
+		return nil, fmt.Errorf("macip gateway: invalid pool %s for %s", pool, subnet)
+	}
+	if nameserver.IsValid() && !nameserver.Is4() {
+		return nil, fmt.Errorf("macip gateway: invalid nameserver %s", nameserver)
+	}
+	return &Gateway{
+		prefix:     prefix,
+		pool:       pool,
+		nameserver: nameserver,
+		now:        time.Now,
+		leases:     map[netip.Addr]*lease{},
+	}, nil
+}
+
+// Returns the broadcast address of prefix’s subnet.
+func broadcast(prefix netip.Prefix) netip.Addr {
+	b := prefix.Masked().Addr().As4()
+	for i := prefix.Bits(); i < 32; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	return netip.AddrFrom4(b)
+}
+
+// Returns the netmask of prefix’s subnet.
+func netmask(prefix netip.Prefix) netip.Addr {
+	var b [4]byte
+	for i := 0; i < prefix.Bits(); i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	return netip.AddrFrom4(b)
+}
+
+// Returns the client configuration for ip.
+func (g *Gateway) config(fn Function, ip netip.Addr) Config {
+	return Config{
+		Function:   fn,
+		IP:         ip,
+		Nameserver: g.nameserver,
+		Broadcast:  broadcast(g.prefix),
+		Netmask:    netmask(g.prefix),
+	}
+}
+
+// Returns an address for node: the one it already uses, if any, or else
+// a free one. Returns false if the pool is exhausted.
+func (g *Gateway) assign(node ddp.Addr) (netip.Addr, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	for ip, l := range g.leases {
+		if l.node == node {
+			l.used = now
+			return ip, true
+		}
+	}
+
+	var idle netip.Addr
+	for ip := g.pool.Start; !g.pool.End.Less(ip); ip = ip.Next() {
+		if ip == g.prefix.Addr() {
+			continue
+		}
+		l, ok := g.leases[ip]
+		if !ok {
+			idle = ip
+			break
+		} else if now.Sub(l.used) >= LeaseIdle && (!idle.IsValid() || l.used.Before(g.leases[idle].used)) {
+			idle = ip
+		}
+	}
+	if !idle.IsValid() {
+		return idle, false
+	}
+	g.leases[idle] = &lease{node, now}
+	return idle, true
+}
+
+// Returns true if node, which sent a datagram from ip, may use it: if ip
+// is leased to node, or if it is a static address outside the pool that
+// no other node has used recently, which is then leased to node.
+func (g *Gateway) claim(ip netip.Addr, node ddp.Addr) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	l, ok := g.leases[ip]
+	if ok && l.node == node {
+		l.used = now
+		return true
+	} else if g.pool.contains(ip) || (ok && now.Sub(l.used) < LeaseIdle) {
+		return false
+	}
+	g.leases[ip] = &lease{node, now}
+	return true
+}
+
+// Returns the node that uses ip, if any.
+func (g *Gateway) lookup(ip netip.Addr) (ddp.Addr, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	l, ok := g.leases[ip]
+	if !ok {
+		return ddp.Addr{}, false
+	}
+	l.used = g.now()
+	return l.node, true
+}
+
+// Returns true if ip may be used by a client.
+func (g *Gateway) isClient(ip netip.Addr) bool {
+	return g.prefix.Contains(ip) && ip != g.prefix.Addr() &&
+		ip != g.prefix.Masked().Addr() && ip != broadcast(g.prefix)
+}
+
+// Runs the gateway until ctx is done, or until one of its sockets or tun
+// fails.
+//
+// Configuration requests are answered on ep. IP datagrams are exchanged
+// with clients on conn, which should be bound to Socket, and with the IP
+// network on tun, each of whose reads and writes is one datagram. Serve
+// closes conn and ep when it returns; tun is left to the caller.
+func (g *Gateway) Serve(ctx context.Context, conn ddp.Conn, ep *atp.Endpoint, tun io.ReadWriter) error {
+	defer conn.Close()
+	defer ep.Close()
+	errs := make(chan error, 3)
+	go func() { errs <- g.serveConfig(ctx, ep) }()
+	go func() { errs <- g.fromDDP(conn, tun) }()
+	go func() { errs <- g.fromTUN(conn, tun) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Answers configuration requests.
+func (g *Gateway) serveConfig(ctx context.Context, ep *atp.Endpoint) error {
+	for {
+		t, err := ep.Accept(ctx)
+		if err != nil {
+			return err
+		}
+		req := Config{}
+		if Unmarshal(t.Request.Data, &req) != nil {
+			continue
+		}
+		var reply Config
+		switch req.Function {
+		case Assign:
+			ip, ok := g.assign(t.From.Addr())
+			if !ok {
+				continue
+			}
+			reply = g.config(Assign, ip)
+		case Server:
+			reply = g.config(Server, g.prefix.Addr())
+		default:
+			continue
+		}
+		data, err := Marshal(reply)
+		if err != nil {
+			continue
+		}
+		t.Respond([]atp.Message{{Data: data}})
+	}
+}
+
+// Moves datagrams from clients to the IP network, or to other clients.
+func (g *Gateway) fromDDP(conn ddp.Conn, tun io.Writer) error {
+	buf := make([]byte, ddp.MaxDataSize)
+	for {
+		n, proto, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		src, dst, ok := addrs(buf[:n])
+		if proto != ddp.ProtoIP || !ok || !g.isClient(src) || !g.claim(src, from.Addr()) {
+			continue
+		}
+		if node, ok := g.lookup(dst); ok {
+			err = conn.WriteTo(buf[:n], ddp.ProtoIP, ddp.SocketAddr{Network: node.Network, Node: node.Node, Socket: Socket})
+			if err != nil {
+				return fmt.Errorf("write ddp: %s", err.Error())
+			}
+			continue
+		}
+		_, err = tun.Write(buf[:n])
+		if err != nil {
+			return fmt.Errorf("write tun: %s", err.Error())
+		}
+	}
+}
+
+// Moves datagrams from the IP network to clients.
+func (g *Gateway) fromTUN(conn ddp.Conn, tun io.Reader) error {
+	buf := make([]byte, 65535)
+	for {
+		n, err := tun.Read(buf)
+		if err != nil {
+			return err
+		}
+		_, dst, ok := addrs(buf[:n])
+		if !ok || n > MTU {
+			continue
+		}
+		node, ok := g.lookup(dst)
+		if !ok {
+			continue
+		}
+		err = conn.WriteTo(buf[:n], ddp.ProtoIP, ddp.SocketAddr{Network: node.Network, Node: node.Node, Socket: Socket})
+		if err != nil {
+			return fmt.Errorf("write ddp: %s", err.Error())
+		}
+	}
+}
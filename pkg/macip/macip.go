@@ -0,0 +1,127 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Encodes and decodes MacIP configuration messages, and runs a MacIP
+// gateway, which carries IPv4 datagrams between AppleTalk and an IP
+// network.
+//
+// MacIP clients, such as MacTCP, find a gateway by looking up the NBP
+// type IPGATEWAY. They ask its configuration socket for an IP address
+// with an ATP request, then send IP datagrams to the gateway’s IP socket
+// as DDP packets of type ddp.ProtoIP. The gateway sends datagrams for a
+// client to the client’s IP socket in the same way.
+package macip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+const (
+	// DDP socket of IP datagrams, on both gateways and clients.
+	Socket = ddp.Socket(72)
+
+	// NBP type of gateways’ configuration sockets.
+	NBPType = "IPGATEWAY"
+
+	// Largest IP datagram that fits in a DDP packet.
+	MTU = ddp.MaxDataSize
+
+	// Length of a configuration message.
+	ConfigSize = 20
+)
+
+type Function uint32
+
+const (
+	// Asks the gateway to assign an IP address to the client.
+	Assign = Function(1)
+
+	// Asks for the gateway’s own configuration, for clients with
+	// static addresses.
+	Server = Function(3)
+)
+
+// The data of a configuration request or reply. Requests carry only the
+// function; replies carry the client’s IP configuration.
+type Config struct {
+	Function   Function
+	IP         netip.Addr
+	Nameserver netip.Addr
+	Broadcast  netip.Addr
+	Netmask    netip.Addr
+}
+
+// Unmarshals a configuration message from bytes. Addresses that are
+// missing or zero are left invalid.
+func Unmarshal(data []byte, c *Config) error {
+	if len(data) < 4 {
+		return fmt.Errorf("read macip config: invalid length %d", len(data))
+	}
+	c.Function = Function(binary.BigEndian.Uint32(data))
+	data = data[4:]
+	for _, a := range []*netip.Addr{&c.IP, &c.Nameserver, &c.Broadcast, &c.Netmask} {
+		*a = netip.Addr{}
+		if len(data) < 4 {
+			continue
+		}
+		if ip := netip.AddrFrom4(*(*[4]byte)(data[:4])); !ip.IsUnspecified() {
+			*a = ip
+		}
+		data = data[4:]
+	}
+	return nil
+}
+
+// Marshals a configuration message to bytes.
+func Marshal(c Config) ([]byte, error) {
+	data := make([]byte, 4, ConfigSize)
+	binary.BigEndian.PutUint32(data, uint32(c.Function))
+	for _, a := range []netip.Addr{c.IP, c.Nameserver, c.Broadcast, c.Netmask} {
+		if !a.IsValid() {
+			a = netip.IPv4Unspecified()
+		} else if !a.Is4() {
+			return nil, fmt.Errorf("write macip config: %s is not IPv4", a)
+		}
+		b := a.As4()
+		data = append(data, b[:]...)
+	}
+	return data, nil
+}
+
+// Returns the source and destination of an IPv4 datagram.
+func addrs(data []byte) (src, dst netip.Addr, ok bool) {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return src, dst, false
+	}
+	src = netip.AddrFrom4(*(*[4]byte)(data[12:16]))
+	dst = netip.AddrFrom4(*(*[4]byte)(data[16:20]))
+	return src, dst, true
+}
@@ -0,0 +1,332 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package macip
+
+import (
+	"context"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+var (
+	subnet    = netip.MustParsePrefix("10.1.1.1/24")
+	dns       = netip.MustParseAddr("10.0.0.53")
+	remote    = netip.MustParseAddr("93.184.216.34")
+	gwNode    = ddp.Addr{Network: 100, Node: 2}
+	macNode   = ddp.Addr{Network: 100, Node: 7}
+	otherNode = ddp.Addr{Network: 100, Node: 8}
+)
+
+func ip(s string) netip.Addr {
+	return netip.MustParseAddr(s)
+}
+
+// Returns an IPv4 datagram from src to dst, with only a header.
+func datagram(src, dst netip.Addr) []byte {
+	data := make([]byte, 20)
+	data[0] = 0x45
+	data[3] = 20
+	s, d := src.As4(), dst.As4()
+	copy(data[12:], s[:])
+	copy(data[16:], d[:])
+	return data
+}
+
+// A TUN device whose datagrams are passed through channels.
+type fakeTUN struct {
+	in, out chan []byte
+}
+
+func (f *fakeTUN) Read(p []byte) (int, error) {
+	data, ok := <-f.in
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(p, data), nil
+}
+
+func (f *fakeTUN) Write(p []byte) (int, error) {
+	f.out <- append([]byte{}, p...)
+	return len(p), nil
+}
+
+func TestMarshal(t *testing.T) {
+	c := Config{
+		Function:   Assign,
+		IP:         ip("10.1.1.2"),
+		Nameserver: dns,
+		Broadcast:  ip("10.1.1.255"),
+		Netmask:    ip("255.255.255.0"),
+	}
+	data, err := Marshal(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0, 0, 0, 1,
+		10, 1, 1, 2,
+		10, 0, 0, 53,
+		10, 1, 1, 255,
+		255, 255, 255, 0,
+	}, data)
+	got := Config{}
+	require.NoError(t, Unmarshal(data, &got))
+	assert.Equal(t, c, got)
+
+	// Requests carry only the function.
+	require.NoError(t, Unmarshal([]byte{0, 0, 0, 3}, &got))
+	assert.Equal(t, Config{Function: Server}, got)
+	assert.Error(t, Unmarshal([]byte{0, 0, 3}, &got))
+
+	_, err = Marshal(Config{IP: ip("fe80::1")})
+	assert.Error(t, err)
+}
+
+func TestNewGateway(t *testing.T) {
+	g, err := NewGateway(subnet, Pool{}, netip.Addr{})
+	require.NoError(t, err)
+	assert.Equal(t, Pool{ip("10.1.1.1"), ip("10.1.1.254")}, g.pool)
+	assert.Equal(t, Config{
+		Function:  Server,
+		IP:        ip("10.1.1.1"),
+		Broadcast: ip("10.1.1.255"),
+		Netmask:   ip("255.255.255.0"),
+	}, g.config(Server, ip("10.1.1.1")))
+
+	pool, err := ParsePool("10.1.1.100-10.1.1.150")
+	require.NoError(t, err)
+	_, err = NewGateway(subnet, pool, dns)
+	assert.NoError(t, err)
+
+	for _, s := range []string{"10.1.2.100-10.1.2.150", "10.1.1.150-10.1.1.100"} {
+		pool, err := ParsePool(s)
+		require.NoError(t, err)
+		_, err = NewGateway(subnet, pool, dns)
+		assert.Error(t, err, s)
+	}
+	_, err = ParsePool("10.1.1.100")
+	assert.Error(t, err)
+	_, err = NewGateway(netip.MustParsePrefix("10.1.1.1/31"), Pool{}, dns)
+	assert.Error(t, err)
+}
+
+func TestAssign(t *testing.T) {
+	assert := assert.New(t)
+	g, err := NewGateway(subnet, Pool{ip("10.1.1.1"), ip("10.1.1.3")}, dns)
+	require.NoError(t, err)
+	now := time.Unix(0, 0)
+	g.now = func() time.Time { return now }
+
+	// The gateway’s own address is skipped.
+	node := func(n ddp.Node) ddp.Addr { return ddp.Addr{Network: 100, Node: n} }
+	a, ok := g.assign(node(1))
+	assert.True(ok)
+	assert.Equal(ip("10.1.1.2"), a)
+	b, ok := g.assign(node(2))
+	assert.True(ok)
+	assert.Equal(ip("10.1.1.3"), b)
+
+	// A node keeps its address.
+	a, ok = g.assign(node(1))
+	assert.True(ok)
+	assert.Equal(ip("10.1.1.2"), a)
+
+	// Once the pool is exhausted, idle addresses are reassigned.
+	_, ok = g.assign(node(3))
+	assert.False(ok)
+	now = now.Add(LeaseIdle)
+	g.lookup(ip("10.1.1.2"))
+	c, ok := g.assign(node(3))
+	assert.True(ok)
+	assert.Equal(ip("10.1.1.3"), c)
+	n, ok := g.lookup(ip("10.1.1.3"))
+	assert.True(ok)
+	assert.Equal(node(3), n)
+}
+
+func TestGateway(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lo := ddp.NewLoopback()
+	listen := func(addr ddp.Addr, socket ddp.Socket) ddp.Conn {
+		conn, err := lo.Listen(ddp.SocketAddr{Network: addr.Network, Node: addr.Node, Socket: socket})
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	g, err := NewGateway(subnet, Pool{ip("10.1.1.2"), ip("10.1.1.49")}, dns)
+	require.NoError(t, err)
+	tun := &fakeTUN{in: make(chan []byte), out: make(chan []byte, 1)}
+	defer close(tun.in)
+	gwConfig := atp.NewEndpoint(listen(gwNode, 130))
+	go g.Serve(ctx, listen(gwNode, Socket), gwConfig, tun)
+
+	mac := listen(macNode, Socket)
+	other := listen(otherNode, Socket)
+	macConfig := atp.NewEndpoint(listen(macNode, 200))
+	defer macConfig.Close()
+
+	req, err := Marshal(Config{Function: Assign})
+	require.NoError(t, err)
+	resp, err := macConfig.Request(ctx, ddp.SocketAddr{Network: 100, Node: 2, Socket: 130},
+		atp.Message{Data: req}, atp.RequestOptions{Responses: 1})
+	require.NoError(t, err)
+	c := Config{}
+	require.NoError(t, Unmarshal(resp[0].Data, &c))
+	assert.Equal(g.config(Assign, ip("10.1.1.2")), c)
+
+	read := func(conn ddp.Conn) []byte {
+		buf := make([]byte, ddp.MaxDataSize)
+		n, proto, from, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(uint8(ddp.ProtoIP), proto)
+		assert.Equal(ddp.SocketAddr{Network: 100, Node: 2, Socket: Socket}, from)
+		return buf[:n]
+	}
+
+	// Datagrams from clients go to the IP network.
+	out := datagram(c.IP, remote)
+	require.NoError(t, mac.WriteTo(out, ddp.ProtoIP, ddp.SocketAddr{Network: 100, Node: 2, Socket: Socket}))
+	assert.Equal(out, <-tun.out)
+
+	// Datagrams from the IP network go to the client with the address.
+	in := datagram(remote, c.IP)
+	tun.in <- datagram(remote, ip("10.1.1.99"))
+	tun.in <- in
+	assert.Equal(in, read(mac))
+
+	// A client with a static address is learned from its datagrams, and
+	// clients can reach each other through the gateway.
+	static := ip("10.1.1.50")
+	hello := datagram(static, c.IP)
+	require.NoError(t, other.WriteTo(hello, ddp.ProtoIP, ddp.SocketAddr{Network: 100, Node: 2, Socket: Socket}))
+	assert.Equal(hello, read(mac))
+	reply := datagram(c.IP, static)
+	require.NoError(t, mac.WriteTo(reply, ddp.ProtoIP, ddp.SocketAddr{Network: 100, Node: 2, Socket: Socket}))
+	assert.Equal(reply, read(other))
+
+	// Datagrams from addresses outside the subnet are dropped.
+	require.NoError(t, mac.WriteTo(datagram(remote, c.IP), ddp.ProtoIP, ddp.SocketAddr{Network: 100, Node: 2, Socket: Socket}))
+	select {
+	case <-tun.out:
+		t.Error("spoofed datagram was forwarded")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestGatewaySpoofing(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lo := ddp.NewLoopback()
+	listen := func(addr ddp.Addr, socket ddp.Socket) ddp.Conn {
+		conn, err := lo.Listen(ddp.SocketAddr{Network: addr.Network, Node: addr.Node, Socket: socket})
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	gw := ddp.SocketAddr{Network: gwNode.Network, Node: gwNode.Node, Socket: Socket}
+
+	g, err := NewGateway(subnet, Pool{ip("10.1.1.2"), ip("10.1.1.49")}, dns)
+	require.NoError(t, err)
+	leased, ok := g.assign(macNode)
+	require.True(t, ok)
+	tun := &fakeTUN{in: make(chan []byte), out: make(chan []byte, 1)}
+	defer close(tun.in)
+	go g.Serve(ctx, listen(gwNode, Socket), atp.NewEndpoint(listen(gwNode, 130)), tun)
+	mac := listen(macNode, Socket)
+	other := listen(otherNode, Socket)
+
+	// The other node can’t send from the leased address, from an
+	// unassigned address in the pool, or from a static address that the
+	// first node uses.
+	static := ip("10.1.1.50")
+	require.NoError(t, mac.WriteTo(datagram(static, remote), ddp.ProtoIP, gw))
+	assert.Equal(datagram(static, remote), <-tun.out)
+	for _, src := range []netip.Addr{leased, ip("10.1.1.3"), static} {
+		require.NoError(t, other.WriteTo(datagram(src, remote), ddp.ProtoIP, gw))
+		select {
+		case <-tun.out:
+			t.Errorf("datagram spoofed from %s was forwarded", src)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// Datagrams to both addresses still reach the first node.
+	for _, dst := range []netip.Addr{leased, static} {
+		tun.in <- datagram(remote, dst)
+		buf := make([]byte, ddp.MaxDataSize)
+		n, _, _, err := mac.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(datagram(remote, dst), buf[:n])
+	}
+}
+
+// A TUN device that can no longer be written.
+type deadTUN struct{ fakeTUN }
+
+func (*deadTUN) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestGatewayTUNFailure(t *testing.T) {
+	lo := ddp.NewLoopback()
+	conn, err := lo.Listen(ddp.SocketAddr{Network: gwNode.Network, Node: gwNode.Node, Socket: Socket})
+	require.NoError(t, err)
+	cfg, err := lo.Listen(ddp.SocketAddr{Network: gwNode.Network, Node: gwNode.Node, Socket: 130})
+	require.NoError(t, err)
+	mac, err := lo.Listen(ddp.SocketAddr{Network: macNode.Network, Node: macNode.Node, Socket: Socket})
+	require.NoError(t, err)
+	defer mac.Close()
+
+	g, err := NewGateway(subnet, Pool{}, dns)
+	require.NoError(t, err)
+	leased, ok := g.assign(macNode)
+	require.True(t, ok)
+	tun := &deadTUN{fakeTUN{in: make(chan []byte)}}
+	defer close(tun.in)
+	errs := make(chan error, 1)
+	go func() { errs <- g.Serve(context.Background(), conn, atp.NewEndpoint(cfg), tun) }()
+
+	gw := ddp.SocketAddr{Network: gwNode.Network, Node: gwNode.Node, Socket: Socket}
+	require.NoError(t, mac.WriteTo(datagram(leased, remote), ddp.ProtoIP, gw))
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, "write tun: io: read/write on closed pipe")
+	case <-time.After(time.Second):
+		t.Error("gateway ignored the failed write")
+	}
+}
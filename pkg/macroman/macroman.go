@@ -0,0 +1,122 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Converts between Mac OS Roman, used for names throughout AppleTalk,
+// and Go’s UTF-8 strings.
+package macroman
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Unicode equivalents of bytes 0x80 through 0xff.
+var high = [128]rune{
+	'\u00c4', '\u00c5', '\u00c7', '\u00c9', '\u00d1', '\u00d6', '\u00dc', '\u00e1', // 80
+	'\u00e0', '\u00e2', '\u00e4', '\u00e3', '\u00e5', '\u00e7', '\u00e9', '\u00e8', // 88
+	'\u00ea', '\u00eb', '\u00ed', '\u00ec', '\u00ee', '\u00ef', '\u00f1', '\u00f3', // 90
+	'\u00f2', '\u00f4', '\u00f6', '\u00f5', '\u00fa', '\u00f9', '\u00fb', '\u00fc', // 98
+	'\u2020', '\u00b0', '\u00a2', '\u00a3', '\u00a7', '\u2022', '\u00b6', '\u00df', // a0
+	'\u00ae', '\u00a9', '\u2122', '\u00b4', '\u00a8', '\u2260', '\u00c6', '\u00d8', // a8
+	'\u221e', '\u00b1', '\u2264', '\u2265', '\u00a5', '\u00b5', '\u2202', '\u2211', // b0
+	'\u220f', '\u03c0', '\u222b', '\u00aa', '\u00ba', '\u03a9', '\u00e6', '\u00f8', // b8
+	'\u00bf', '\u00a1', '\u00ac', '\u221a', '\u0192', '\u2248', '\u2206', '\u00ab', // c0
+	'\u00bb', '\u2026', '\u00a0', '\u00c0', '\u00c3', '\u00d5', '\u0152', '\u0153', // c8
+	'\u2013', '\u2014', '\u201c', '\u201d', '\u2018', '\u2019', '\u00f7', '\u25ca', // d0
+	'\u00ff', '\u0178', '\u2044', '\u20ac', '\u2039', '\u203a', '\ufb01', '\ufb02', // d8
+	'\u2021', '\u00b7', '\u201a', '\u201e', '\u2030', '\u00c2', '\u00ca', '\u00c1', // e0
+	'\u00cb', '\u00c8', '\u00cd', '\u00ce', '\u00cf', '\u00cc', '\u00d3', '\u00d4', // e8
+	'\uf8ff', '\u00d2', '\u00da', '\u00db', '\u00d9', '\u0131', '\u02c6', '\u02dc', // f0
+	'\u00af', '\u02d8', '\u02d9', '\u02da', '\u00b8', '\u02dd', '\u02db', '\u02c7', // f8
+}
+
+var reverse = map[rune]byte{}
+
+func init() {
+	for i, r := range high {
+		reverse[r] = byte(0x80 + i)
+	}
+}
+
+// Decode converts Mac OS Roman bytes to a UTF-8 string.
+func Decode(data []byte) string {
+	b := strings.Builder{}
+	b.Grow(len(data))
+	for _, c := range data {
+		if c < 0x80 {
+			b.WriteByte(c)
+		} else {
+			b.WriteRune(high[c-0x80])
+		}
+	}
+	return b.String()
+}
+
+// Encode converts a UTF-8 string to Mac OS Roman bytes.
+//
+// Returns an error if the string contains a character that has no
+// Mac OS Roman equivalent.
+func Encode(s string) ([]byte, error) {
+	data := make([]byte, 0, len(s))
+	for i, r := range s {
+		if r < 0x80 {
+			data = append(data, byte(r))
+		} else if c, ok := reverse[r]; ok {
+			data = append(data, c)
+		} else if r == utf8.RuneError {
+			return nil, fmt.Errorf("invalid UTF-8 at offset %d", i)
+		} else {
+			return nil, fmt.Errorf("no Mac OS Roman equivalent for %q", r)
+		}
+	}
+	return data, nil
+}
+
+// Len returns the length of s once encoded as Mac OS Roman.
+func Len(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// EqualFold reports whether s and t are equal under AppleTalk’s
+// case-insensitive comparison, which also applies to accented letters.
+func EqualFold(s, t string) bool {
+	return strings.EqualFold(s, t)
+}
+
+// ToUpper returns s with all letters upper-cased, where the upper-case
+// letter exists in Mac OS Roman.
+func ToUpper(s string) string {
+	return strings.Map(func(r rune) rune {
+		u := unicode.ToUpper(r)
+		if _, ok := reverse[u]; ok || u < 0x80 {
+			return u
+		}
+		return r
+	}, s)
+}
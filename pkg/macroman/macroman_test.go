@@ -0,0 +1,94 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package macroman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	s := Decode(data)
+	assert.Equal(256, Len(s))
+	enc, err := Encode(s)
+	if assert.NoError(err) {
+		assert.Equal(data, enc)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"ascii", []byte("LaserWriter"), "LaserWriter"},
+		{"accents", []byte{0x43, 0x61, 0x66, 0x8e}, "Café"},
+		{"wildcard", []byte{0xc5}, "≈"},
+		{"apple", []byte{0xf0}, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, Decode(c.data))
+		})
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	cases := []struct {
+		name, input, err string
+	}{
+		{"unmapped", "日本", `no Mac OS Roman equivalent for '日'`},
+		{"invalid", "a\xffb", `invalid UTF-8 at offset 1`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			_, err := Encode(c.input)
+			if assert.Error(err) {
+				assert.Equal(c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestFold(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("CAFÉ", ToUpper("café"))
+	assert.Equal("ß", ToUpper("ß"))
+	assert.True(EqualFold("Café", "CAFÉ"))
+	assert.False(EqualFold("Cafe", "CAFÉ"))
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Runs an AppleTalk bridge inside a Go program, and opens DDP sockets on
+// it.
+//
+// A Group joins networks together. Its members are ExtBridges, which
+// carry EtherTalk packets: EtherTalk and TCP transports are ExtBridges
+// already, and LocalTalk transports are made into them by a router,
+// configured with RouterOptions. A Node in the group is an AppleTalk
+// node of the program’s own, with an address on the extended network:
+//
+//	grp := multitalk.NewGroup(log)
+//	et, err := multitalk.EtherTalk(multitalk.EtherTalkOptions{Device: "eth0"})
+//	...
+//	grp.Add(ctx, et)
+//	node := grp.NewNode(ctx, ddp.Range{})
+//	go grp.Run()
+//	conn, err := node.ListenDDP(ctx, 0)
+package multitalk
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+type (
+	// A LocalTalk network, which exchanges LLAP packets. Implement
+	// it to bring another kind of LocalTalk network into a Group,
+	// through Extend.
+	Bridge = bridge.Bridge
+
+	// A network that exchanges EtherTalk packets. Implement it to add
+	// another kind of network to a Group.
+	//
+	// Start starts the network, and returns channels for sending
+	// packets to it and receiving packets from it. The network closes
+	// recv when it stops.
+	ExtBridge = bridge.ExtBridge
+
+	// A Group forwards packets between its members.
+	Group struct {
+		log *zap.Logger
+		grp *bridge.Group
+	}
+
+	// An EtherTalk node in a Group, for sending and receiving DDP
+	// packets.
+	Node struct {
+		node *bridge.Node
+	}
+)
+
+// Creates an empty Group.
+func NewGroup(log *zap.Logger) *Group {
+	return &Group{log, bridge.NewGroup(log)}
+}
+
+// Starts b, and adds it to the Group. It runs until ctx is done.
+func (g *Group) Add(ctx context.Context, b ExtBridge) {
+	g.grp.Add(b.Start(ctx, g.log))
+}
+
+// Forwards packets between the members of the Group. Does not return.
+func (g *Group) Run() {
+	g.grp.Run()
+}
+
+// Adds a Node to the Group, which acquires an address in rng.
+//
+// If rng is zero, the node asks a router for the network’s cable range.
+// If no router answers, the node keeps an address in the startup range.
+func (g *Group) NewNode(ctx context.Context, rng ddp.Range) *Node {
+	n := bridge.NewNode(rng)
+	g.grp.Add(n.Start(ctx, g.log))
+	return &Node{n}
+}
+
+// Returns the node’s address, waiting until it is acquired.
+func (n *Node) Addr(ctx context.Context) (ddp.Addr, error) {
+	return n.node.Addr(ctx)
+}
+
+// Opens a DDP socket on the node, waiting until the node has acquired
+// its address. If sock is zero, a free socket is chosen from the
+// dynamically-assigned range, 128–254.
+func (n *Node) ListenDDP(ctx context.Context, sock ddp.Socket) (ddp.Conn, error) {
+	return n.node.ListenDDP(ctx, sock)
+}
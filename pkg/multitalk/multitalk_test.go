@@ -0,0 +1,126 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package multitalk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethertalk"
+)
+
+// An ExtBridge whose packets are read and written by the test.
+type tap struct {
+	send chan ethertalk.Packet
+	recv chan ethertalk.Packet
+}
+
+func (t *tap) Start(ctx context.Context, log *zap.Logger) (
+	send chan<- ethertalk.Packet,
+	recv <-chan ethertalk.Packet,
+) {
+	return t.send, t.recv
+}
+
+func TestRouterOptions(t *testing.T) {
+	assert := assert.New(t)
+	rng := ddp.Range{Start: 100, End: 109}
+	zones := []string{"Lab"}
+	for _, c := range []struct {
+		opts RouterOptions
+		want ddp.Network
+	}{
+		{RouterOptions{}, ddp.StartupRange.Start},
+		{RouterOptions{Range: rng, Zones: zones}, 100},
+		{RouterOptions{Network: 5, Range: rng, Zones: zones}, 5},
+	} {
+		cfg, err := c.opts.config()
+		if assert.NoError(err) {
+			assert.Equal(c.want, cfg.Network)
+		}
+	}
+
+	// A seeded range needs a default zone.
+	_, err := RouterOptions{Range: rng}.config()
+	assert.Error(err)
+	_, err = Extend(nil, nil, RouterOptions{Network: 5, Range: rng})
+	assert.Error(err)
+	_, err = TashTalk(TashTalkOptions{Device: "/nonexistent", Router: RouterOptions{Range: rng}})
+	assert.EqualError(err, "router options: range requires zones")
+}
+
+func TestGroup(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	grp := NewGroup(zap.NewNop())
+	tp := &tap{make(chan ethertalk.Packet, 256), make(chan ethertalk.Packet)}
+	grp.Add(ctx, tp)
+	rng := ddp.Range{Start: 5, End: 5}
+	a, b := grp.NewNode(ctx, rng), grp.NewNode(ctx, rng)
+	go grp.Run()
+
+	ca, err := a.ListenDDP(ctx, 0)
+	require.NoError(t, err)
+	defer ca.Close()
+	cb, err := b.ListenDDP(ctx, 0)
+	require.NoError(t, err)
+	defer cb.Close()
+
+	require.NoError(t, ca.WriteTo([]byte("hello"), ddp.ProtoAEP, cb.LocalAddr()))
+	buf := make([]byte, ddp.MaxDataSize)
+	n, proto, from, err := cb.ReadFrom(buf)
+	if assert.NoError(err) {
+		assert.Equal("hello", string(buf[:n]))
+		assert.Equal(uint8(ddp.ProtoAEP), proto)
+		assert.Equal(ca.LocalAddr(), from)
+	}
+
+	// Other members see the nodes’ packets.
+	for {
+		var pak ethertalk.Packet
+		select {
+		case pak = <-tp.send:
+		case <-ctx.Done():
+			t.Fatal("packet not forwarded")
+		}
+		ext := ddp.ExtPacket{}
+		if pak.SNAPProto != ethertalk.AppleTalkProto || ddp.ExtUnmarshal(pak.Payload, &ext) != nil {
+			continue
+		}
+		assert.Equal([]byte("hello"), ext.Data)
+		break
+	}
+}
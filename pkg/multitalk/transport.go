@@ -0,0 +1,215 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package multitalk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sfiera/multitalk/internal/bridge"
+	"github.com/sfiera/multitalk/internal/raw"
+	"github.com/sfiera/multitalk/internal/serial"
+	"github.com/sfiera/multitalk/internal/tcp"
+	"github.com/sfiera/multitalk/internal/udp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+type (
+	// Configures the router between a LocalTalk network and the Group.
+	RouterOptions struct {
+		// Network number of the LocalTalk network. If zero, the start
+		// of Range, or without a Range, the start of the startup range.
+		Network ddp.Network
+
+		// Cable range of the extended network to seed. If zero, the
+		// router only proxies for LocalTalk nodes, and provides no
+		// routing services.
+		//
+		// If Network is within Range, LocalTalk nodes appear as nodes
+		// of the extended network. Otherwise, the router advertises a
+		// route between the two.
+		//
+		// Only one router in a Group should seed a range.
+		Range ddp.Range
+
+		// Zones of the extended network. The first is the default zone,
+		// which is also the zone of the LocalTalk network. Required
+		// with Range.
+		Zones []string
+
+		// Object name under which the router registers its own NBP
+		// names, “Name:multitalk” and “Name:AppleTalk Router”. If
+		// empty, no names are registered.
+		Name string
+	}
+
+	// Phase 2 EtherTalk, on a raw network interface.
+	EtherTalkOptions struct {
+		Device string // Network interface, e.g. "eth0"
+	}
+
+	// Phase 1 EtherTalk, on a raw network interface.
+	EtherTalkPhase1Options struct {
+		Device string // Network interface, e.g. "eth0"
+		Router RouterOptions
+	}
+
+	// LocalTalk-over-UDP (LToU) multicast.
+	MulticastOptions struct {
+		Interface string // Network interface, e.g. "eth0"
+		Router    RouterOptions
+	}
+
+	// IPTalk (KIP or CAP) hosts, which carry DDP over UDP.
+	IPTalkOptions struct {
+		// Address and subnet of this host on the IPTalk network, e.g.
+		// "192.168.1.200/24". A host’s node number is the last byte of
+		// its address.
+		Addr   string
+		Router RouterOptions
+	}
+
+	// LocalTalk, through a TashTalk adapter.
+	TashTalkOptions struct {
+		Device string // Serial device, e.g. "/dev/ttyUSB0"
+		Router RouterOptions
+	}
+
+	// A connection to another multitalk’s TCP server.
+	TCPClientOptions struct {
+		Addr string // host:port
+	}
+
+	// A TCP server, for other multitalk instances to connect to.
+	TCPServerOptions struct {
+		Addr string // host:port to listen on
+	}
+)
+
+func (o RouterOptions) config() (bridge.Config, error) {
+	cfg := bridge.Config{
+		Network: o.Network,
+		Range:   o.Range,
+		Zones:   o.Zones,
+		Name:    o.Name,
+	}
+	if !cfg.Range.IsZero() && len(cfg.Zones) == 0 {
+		return cfg, fmt.Errorf("router options: range requires zones")
+	}
+	if cfg.Network != 0 {
+		return cfg, nil
+	} else if !cfg.Range.IsZero() {
+		cfg.Network = cfg.Range.Start
+	} else {
+		cfg.Network = ddp.StartupRange.Start
+	}
+	return cfg, nil
+}
+
+// Routes between the LocalTalk network b and a Group. hwAddr is the
+// Ethernet address that the router uses in the Group.
+func Extend(b Bridge, hwAddr []byte, opts RouterOptions) (ExtBridge, error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	return bridge.Extend(b, cfg, hwAddr), nil
+}
+
+// Opens a Phase 2 EtherTalk network.
+func EtherTalk(opts EtherTalkOptions) (ExtBridge, error) {
+	return raw.EtherTalk(opts.Device)
+}
+
+// Opens a Phase 1 EtherTalk network, with a router to the Group.
+func EtherTalkPhase1(opts EtherTalkPhase1Options) (ExtBridge, error) {
+	cfg, err := opts.Router.config()
+	if err != nil {
+		return nil, err
+	}
+	b, hwAddr, err := raw.EtherTalkPhase1(opts.Device)
+	if err != nil {
+		return nil, err
+	}
+	return bridge.Extend(b, cfg, hwAddr), nil
+}
+
+// Opens an LToU multicast network, with a router to the Group.
+func Multicast(opts MulticastOptions) (ExtBridge, error) {
+	cfg, err := opts.Router.config()
+	if err != nil {
+		return nil, err
+	}
+	b, hwAddr, err := udp.Multicast(opts.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return bridge.Extend(b, cfg, hwAddr), nil
+}
+
+// Opens an IPTalk network, with a router to the Group.
+func IPTalk(opts IPTalkOptions) (ExtBridge, error) {
+	cfg, err := opts.Router.config()
+	if err != nil {
+		return nil, err
+	}
+	b, hwAddr, err := udp.IPTalk(opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return bridge.Extend(b, cfg, hwAddr), nil
+}
+
+// Opens a TashTalk adapter, with a router to the Group.
+func TashTalk(opts TashTalkOptions) (ExtBridge, error) {
+	cfg, err := opts.Router.config()
+	if err != nil {
+		return nil, err
+	}
+	b, hwAddr, err := serial.TashTalk(opts.Device)
+	if err != nil {
+		return nil, err
+	}
+	return bridge.Extend(b, cfg, hwAddr), nil
+}
+
+// Connects to a TCP server.
+func TCPClient(opts TCPClientOptions) (ExtBridge, error) {
+	return tcp.TCPClient(opts.Addr)
+}
+
+// Listens for TCP connections, and adds each one to the Group until ctx
+// is done.
+func (g *Group) ServeTCP(ctx context.Context, opts TCPServerOptions) error {
+	s, err := tcp.TCPServer(opts.Addr)
+	if err != nil {
+		return err
+	}
+	s.Serve(ctx, g.log, g.grp)
+	return nil
+}
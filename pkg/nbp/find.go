@@ -0,0 +1,146 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package nbp
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+// How often Find repeats its requests.
+const LookupInterval = time.Second
+
+// Looks up entities that match query, from conn, which is closed once the
+// lookup is done.
+//
+// The lookup is broadcast on conn’s network, so that nodes there reply
+// directly. If query names a zone, it is also broadcast to the network’s
+// routers as a BrRq, so that they look it up in the zone. Returns the
+// distinct tuples in the replies, once max have been found or ctx is done.
+func Find(ctx context.Context, conn ddp.Conn, query Entity, max int) ([]Tuple, error) {
+	id := uint8(rand.Intn(256))
+	local := conn.LocalAddr()
+	paks := []Packet{Lookup(id, local.Addr(), local.Socket, query)}
+	if query.Zone != ThisZone {
+		paks = append(paks, BroadcastRequest(id, local.Addr(), local.Socket, query))
+	}
+	var reqs [][]byte
+	for _, pak := range paks {
+		data, err := Marshal(pak)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("lookup %s: %s", query, err.Error())
+		}
+		reqs = append(reqs, data)
+	}
+
+	replies := make(chan []Tuple)
+	go readReplies(conn, id, replies)
+	defer func() {
+		conn.Close()
+		for range replies {
+		}
+	}()
+
+	broadcast := ddp.SocketAddr{Node: 0xff, Socket: Socket}
+	send := func() error {
+		for _, data := range reqs {
+			err := conn.WriteTo(data, ddp.ProtoNBP, broadcast)
+			if err != nil {
+				return fmt.Errorf("lookup %s: %s", query, err.Error())
+			}
+		}
+		return nil
+	}
+	err := send()
+	if err != nil {
+		return nil, err
+	}
+	ticker := time.NewTicker(LookupInterval)
+	defer ticker.Stop()
+
+	type key struct {
+		addr       ddp.Addr
+		socket     ddp.Socket
+		enumerator uint8
+	}
+	seen := map[key]bool{}
+	var found []Tuple
+	for {
+		select {
+		case <-ctx.Done():
+			if len(found) == 0 {
+				return nil, fmt.Errorf("lookup %s: not found", query)
+			}
+			return found, nil
+		case <-ticker.C:
+			err := send()
+			if err != nil {
+				return nil, err
+			}
+		case tuples, ok := <-replies:
+			if !ok {
+				return found, nil
+			}
+			for _, t := range tuples {
+				k := key{t.Addr, t.Socket, t.Enumerator}
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+				found = append(found, t)
+				if len(found) >= max {
+					return found, nil
+				}
+			}
+		}
+	}
+}
+
+// Reads the tuples of replies to request id from conn, until it is closed.
+func readReplies(conn ddp.Conn, id uint8, replies chan<- []Tuple) {
+	defer close(replies)
+	buf := make([]byte, ddp.MaxDataSize)
+	for {
+		n, proto, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pak := Packet{}
+		if proto != ddp.ProtoNBP || Unmarshal(buf[:n], &pak) != nil {
+			continue
+		} else if pak.Function != LkUpReply || pak.ID != id {
+			continue
+		}
+		replies <- pak.Tuples
+	}
+}
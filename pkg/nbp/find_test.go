@@ -0,0 +1,78 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package nbp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+func TestFind(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	for i, name := range []string{"Lab", "Office"} {
+		nis, err := lo.Listen(ddp.SocketAddr{Network: 100, Node: ddp.Node(2 + i), Socket: Socket})
+		require.NoError(t, err)
+		r := NewResponder(nis)
+		defer r.Close()
+		require.NoError(t, r.Register(Entity{Object: name, Type: "LaserWriter"}, 130))
+	}
+	listen := func() ddp.Conn {
+		conn, err := lo.Listen(ddp.SocketAddr{Network: 100, Node: 1, Socket: 200})
+		require.NoError(t, err)
+		return conn
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	found, err := Find(ctx, listen(), Entity{Object: "Office", Type: "LaserWriter", Zone: ThisZone}, 1)
+	require.NoError(t, err)
+	assert.Equal([]Tuple{{
+		Addr:   ddp.Addr{Network: 100, Node: 3},
+		Socket: 130,
+		Entity: Entity{Object: "Office", Type: "LaserWriter", Zone: ThisZone},
+	}}, found)
+
+	// Replies are collected until ctx is done, without duplicates.
+	ctx, cancel = context.WithTimeout(context.Background(), 3*LookupInterval/2)
+	defer cancel()
+	found, err = Find(ctx, listen(), Entity{Object: Wildcard, Type: "LaserWriter", Zone: ThisZone}, 10)
+	require.NoError(t, err)
+	assert.Len(found, 2)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = Find(ctx, listen(), Entity{Object: "Home", Type: "LaserWriter", Zone: ThisZone}, 1)
+	assert.Error(err)
+}
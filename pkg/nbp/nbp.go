@@ -0,0 +1,294 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Encodes and decodes NBP (Name Binding Protocol) packets.
+package nbp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/macroman"
+)
+
+const (
+	// Socket of the Names Information Socket (NIS) on every node.
+	Socket = ddp.Socket(2)
+
+	// Maximum length of each part of an entity name.
+	MaxNameLength = 32
+
+	// Maximum number of tuples in a packet.
+	MaxTuples = 15
+
+	// Matches any object or type, when it is the whole name.
+	Wildcard = "="
+
+	// Matches zero or more characters, when it appears once in a name.
+	// Encoded as 0xc5 in Mac OS Roman.
+	Approx = "≈"
+
+	// Refers to the zone of the requesting node.
+	ThisZone = "*"
+)
+
+type Function uint8
+
+const (
+	BrRq      = Function(1) // Broadcast request, sent to a router
+	LkUp      = Function(2) // Lookup, sent to nodes on a network
+	LkUpReply = Function(3) // Lookup reply
+	FwdReq    = Function(4) // Forward request, sent between routers
+)
+
+type (
+	// Names an NBP-visible entity, as “object:type@zone”.
+	//
+	// Names are stored as UTF-8, and converted to and from Mac OS Roman
+	// when marshaled.
+	Entity struct {
+		Object, Type, Zone string
+	}
+
+	// Binds an entity name to the socket that it is available on.
+	//
+	// In requests, the address is where replies are to be sent, and the
+	// entity name is the one to look up, possibly with wildcards.
+	Tuple struct {
+		Addr       ddp.Addr
+		Socket     ddp.Socket
+		Enumerator uint8
+		Entity     Entity
+	}
+
+	Packet struct {
+		Function Function
+		ID       uint8
+		Tuples   []Tuple
+	}
+
+	tupleHeader struct {
+		Addr       ddp.Addr
+		Socket     ddp.Socket
+		Enumerator uint8
+	}
+)
+
+// Unmarshals a packet from bytes.
+func Unmarshal(data []byte, pak *Packet) error {
+	r := bytes.NewReader(data)
+
+	header := [2]byte{}
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return fmt.Errorf("read nbp header: %s", err.Error())
+	}
+	pak.Function = Function(header[0] >> 4)
+	pak.ID = header[1]
+	count := int(header[0] & 0x0f)
+	switch pak.Function {
+	case BrRq, LkUp, LkUpReply, FwdReq:
+	default:
+		return fmt.Errorf("read nbp header: unknown function %d", pak.Function)
+	}
+
+	pak.Tuples = make([]Tuple, count)
+	for i := range pak.Tuples {
+		err = readTuple(r, &pak.Tuples[i])
+		if err != nil {
+			return fmt.Errorf("read nbp tuple: %s", err.Error())
+		}
+	}
+
+	_, err = r.ReadByte()
+	if err != io.EOF {
+		return fmt.Errorf("read nbp: excess data")
+	}
+	return nil
+}
+
+func readTuple(r *bytes.Reader, t *Tuple) error {
+	h := tupleHeader{}
+	err := binary.Read(r, binary.BigEndian, &h)
+	if err != nil {
+		return err
+	}
+	t.Addr = h.Addr
+	t.Socket = h.Socket
+	t.Enumerator = h.Enumerator
+
+	for _, s := range []*string{&t.Entity.Object, &t.Entity.Type, &t.Entity.Zone} {
+		*s, err = readString(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	} else if n > MaxNameLength {
+		return "", fmt.Errorf("name too long (%d > %d)", n, MaxNameLength)
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return "", err
+	}
+	return macroman.Decode(data), nil
+}
+
+// Marshals a packet to bytes.
+func Marshal(pak Packet) ([]byte, error) {
+	if len(pak.Tuples) > MaxTuples {
+		return nil, fmt.Errorf("write nbp header: too many tuples (%d > %d)", len(pak.Tuples), MaxTuples)
+	}
+	w := bytes.NewBuffer([]byte{
+		byte(pak.Function<<4) | byte(len(pak.Tuples)),
+		pak.ID,
+	})
+
+	for _, t := range pak.Tuples {
+		binary.Write(w, binary.BigEndian, tupleHeader{t.Addr, t.Socket, t.Enumerator})
+		for _, s := range []string{t.Entity.Object, t.Entity.Type, t.Entity.Zone} {
+			err := writeString(w, s)
+			if err != nil {
+				return nil, fmt.Errorf("write nbp tuple: %s", err.Error())
+			}
+		}
+	}
+
+	return w.Bytes(), nil
+}
+
+func writeString(w *bytes.Buffer, s string) error {
+	data, err := macroman.Encode(s)
+	if err != nil {
+		return err
+	} else if len(data) > MaxNameLength {
+		return fmt.Errorf("name too long (%d > %d)", len(data), MaxNameLength)
+	}
+	w.WriteByte(byte(len(data)))
+	w.Write(data)
+	return nil
+}
+
+// NBP packet asking a router to look up `query`, with replies to `from`.
+func BroadcastRequest(id uint8, from ddp.Addr, socket ddp.Socket, query Entity) Packet {
+	return request(BrRq, id, from, socket, query)
+}
+
+// NBP packet asking nodes to reply if they have entities matching `query`.
+func Lookup(id uint8, from ddp.Addr, socket ddp.Socket, query Entity) Packet {
+	return request(LkUp, id, from, socket, query)
+}
+
+// NBP packet asking a router to look up `query` on its networks.
+func ForwardRequest(id uint8, from ddp.Addr, socket ddp.Socket, query Entity) Packet {
+	return request(FwdReq, id, from, socket, query)
+}
+
+// NBP packet replying to a request with ID `id` with matching entities.
+func LookupReply(id uint8, tuples ...Tuple) Packet {
+	return Packet{
+		Function: LkUpReply,
+		ID:       id,
+		Tuples:   tuples,
+	}
+}
+
+func request(fn Function, id uint8, from ddp.Addr, socket ddp.Socket, query Entity) Packet {
+	return Packet{
+		Function: fn,
+		ID:       id,
+		Tuples: []Tuple{{
+			Addr:   from,
+			Socket: socket,
+			Entity: query,
+		}},
+	}
+}
+
+// Parses an entity name of the form “object:type@zone”.
+// If the zone is omitted, it is ThisZone.
+func ParseEntity(s string) (Entity, error) {
+	e := Entity{Zone: ThisZone}
+	if at := strings.LastIndex(s, "@"); at >= 0 {
+		s, e.Zone = s[:at], s[at+1:]
+	}
+	var found bool
+	e.Object, e.Type, found = strings.Cut(s, ":")
+	if !found {
+		return Entity{}, fmt.Errorf("parse entity %q: missing type", s)
+	}
+	for _, part := range []string{e.Object, e.Type, e.Zone} {
+		if part == "" || macroman.Len(part) > MaxNameLength {
+			return Entity{}, fmt.Errorf("parse entity %q: invalid name %q", s, part)
+		}
+	}
+	return e, nil
+}
+
+func (e Entity) String() string {
+	return fmt.Sprintf("%s:%s@%s", e.Object, e.Type, e.Zone)
+}
+
+// Returns true if the entity’s object and type match those of `query`,
+// which may contain wildcards. Zones are not compared, since NBP
+// delivers lookups only to nodes in the requested zone.
+func (e Entity) Matches(query Entity) bool {
+	return Match(query.Object, e.Object) && Match(query.Type, e.Type)
+}
+
+// Returns true if `name` matches `pattern`, ignoring case.
+//
+// A pattern of Wildcard matches any name. Otherwise, the first Approx
+// in the pattern matches zero or more characters.
+func Match(pattern, name string) bool {
+	if pattern == Wildcard {
+		return true
+	}
+	before, after, found := strings.Cut(pattern, Approx)
+	if !found {
+		return macroman.EqualFold(pattern, name)
+	}
+
+	nb, na := len([]rune(before)), len([]rune(after))
+	runes := []rune(name)
+	if nb+na > len(runes) {
+		return false
+	}
+	return macroman.EqualFold(before, string(runes[:nb])) &&
+		macroman.EqualFold(after, string(runes[len(runes)-na:]))
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package nbp
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+func TestUnmarshalNoError(t *testing.T) {
+	cases := []struct {
+		name, hex string
+		expected  Packet
+	}{{
+		"LkUp",
+		"2101ff005ffd00034661620b576f726b73746174696f6e012a", // From the NBP capture in ddp_test.go
+		Lookup(1, ddp.Addr{Network: 65280, Node: 95}, 253, Entity{"Fab", "Workstation", "*"}),
+	}, {
+		"BrRq",
+		"1107006480fd0001c50b4c61736572577269746572012a",
+		BroadcastRequest(7, ddp.Addr{Network: 100, Node: 128}, 253, Entity{"≈", "LaserWriter", "*"}),
+	}, {
+		"FwdReq",
+		"4107006480fd00013d013d054e4f525448",
+		ForwardRequest(7, ddp.Addr{Network: 100, Node: 128}, 253, Entity{"=", "=", "NORTH"}),
+	}, {
+		"LkUpReply",
+		"3207" +
+			"00650a8001034661620b576f726b73746174696f6e012a" +
+			"00650a4f020b4d6163696e746f736820c60b4c617365725772697465720141",
+		LookupReply(7,
+			Tuple{
+				Addr:       ddp.Addr{Network: 101, Node: 10},
+				Socket:     128,
+				Enumerator: 1,
+				Entity:     Entity{"Fab", "Workstation", "*"},
+			},
+			Tuple{
+				Addr:       ddp.Addr{Network: 101, Node: 10},
+				Socket:     79,
+				Enumerator: 2,
+				Entity:     Entity{"Macintosh ∆", "LaserWriter", "A"},
+			},
+		),
+	}, {
+		"LkUpReply_empty",
+		"3007",
+		Packet{Function: LkUpReply, ID: 7, Tuples: []Tuple{}},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			p := Packet{}
+			err := Unmarshal(unhex(c.hex), &p)
+			if assert.NoError(err) {
+				assert.Equal(c.expected, p)
+			}
+			data, err := Marshal(c.expected)
+			if assert.NoError(err) {
+				assert.Equal(unhex(c.hex), data)
+			}
+		})
+	}
+}
+
+func TestUnmarshalError(t *testing.T) {
+	cases := []struct {
+		name, hex, err string
+	}{{
+		"empty",
+		"",
+		"read nbp header: EOF",
+	}, {
+		"unknown",
+		"5107",
+		"read nbp header: unknown function 5",
+	}, {
+		"missing_tuple",
+		"2101",
+		"read nbp tuple: EOF",
+	}, {
+		"truncated_name",
+		"2101ff005ffd0003466162",
+		"read nbp tuple: EOF",
+	}, {
+		"long_name",
+		"2101ff005ffd0021",
+		"read nbp tuple: name too long (33 > 32)",
+	}, {
+		"excess",
+		"2001ff",
+		"read nbp: excess data",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			err := Unmarshal(unhex(c.hex), &Packet{})
+			if assert.Error(err) {
+				assert.Equal(c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestMarshalError(t *testing.T) {
+	cases := []struct {
+		name string
+		pak  Packet
+		err  string
+	}{{
+		"unencodable",
+		Lookup(1, ddp.Addr{}, 253, Entity{"☃", "=", "*"}),
+		"write nbp tuple: no Mac OS Roman equivalent for '☃'",
+	}, {
+		"long_name",
+		Lookup(1, ddp.Addr{}, 253, Entity{"=", "=", "abcdefghijklmnopqrstuvwxyz0123456"}),
+		"write nbp tuple: name too long (33 > 32)",
+	}, {
+		"too_many_tuples",
+		LookupReply(1, make([]Tuple, 16)...),
+		"write nbp header: too many tuples (16 > 15)",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			_, err := Marshal(c.pak)
+			if assert.Error(err) {
+				assert.Equal(c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestParseEntity(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected Entity
+		err      string
+	}{
+		{"Fab:Workstation@*", Entity{"Fab", "Workstation", "*"}, ""},
+		{"Fab:Workstation", Entity{"Fab", "Workstation", "*"}, ""},
+		{"=:LaserWriter@North Wing", Entity{"=", "LaserWriter", "North Wing"}, ""},
+		{"a@b:c@d", Entity{"a@b", "c", "d"}, ""},
+		{"Fab", Entity{}, `parse entity "Fab": missing type`},
+		{":Workstation", Entity{}, `parse entity ":Workstation": invalid name ""`},
+		{"Fab:Workstation@", Entity{}, `parse entity "Fab:Workstation": invalid name ""`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			assert := assert.New(t)
+			e, err := ParseEntity(c.in)
+			if c.err == "" {
+				if assert.NoError(err) {
+					assert.Equal(c.expected, e)
+				}
+			} else if assert.Error(err) {
+				assert.Equal(c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestEntityString(t *testing.T) {
+	assert.Equal(t, "Fab:Workstation@*", Entity{"Fab", "Workstation", "*"}.String())
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		expected      bool
+	}{
+		{"=", "Fab", true},
+		{"=", "", true},
+		{"Fab", "Fab", true},
+		{"fab", "FAB", true},
+		{"café", "CAFÉ", true},
+		{"Fab", "Fabulous", false},
+		{"Fab≈", "Fabulous", true},
+		{"≈ous", "Fabulous", true},
+		{"F≈s", "Fabulous", true},
+		{"f≈S", "Fabulous", true},
+		{"≈", "", true},
+		{"Fab≈Fab", "Fab", false},
+		{"F≈x", "Fabulous", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pattern+"/"+c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, Match(c.pattern, c.name))
+		})
+	}
+
+	e := Entity{"Fab", "Workstation", "North"}
+	assert.True(t, e.Matches(Entity{"=", "Workstation", "*"}))
+	assert.True(t, e.Matches(Entity{"fab", "work≈", "South"}))
+	assert.False(t, e.Matches(Entity{"=", "LaserWriter", "*"}))
+}
+
+func unhex(s string) []byte {
+	data := []byte{}
+	for i := 0; i < len(s); i += 2 {
+		n, err := strconv.ParseUint(s[i:i+2], 16, 8)
+		if err != nil {
+			panic(err)
+		}
+		data = append(data, byte(n))
+	}
+	return data
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package nbp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/macroman"
+)
+
+type (
+	// A Responder answers lookups for the entities registered on a node,
+	// from the node’s Names Information Socket.
+	Responder struct {
+		conn ddp.Conn
+		done chan struct{}
+
+		mu    sync.Mutex
+		names []registered
+	}
+
+	registered struct {
+		entity Entity
+		socket ddp.Socket
+	}
+)
+
+// Creates a Responder on conn, which should be bound to Socket, and
+// starts reading from it.
+func NewResponder(conn ddp.Conn) *Responder {
+	r := &Responder{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	go r.read()
+	return r
+}
+
+// Closes the Responder and its socket.
+func (r *Responder) Close() error {
+	err := r.conn.Close()
+	<-r.done
+	return err
+}
+
+// Registers e as available on socket, so that lookups find it. The zone
+// of e is ignored: entities are registered in the node’s zone.
+func (r *Responder) Register(e Entity, socket ddp.Socket) error {
+	for _, s := range []string{e.Object, e.Type} {
+		data, err := macroman.Encode(s)
+		if err != nil {
+			return fmt.Errorf("register %s: %s", e, err.Error())
+		} else if len(data) == 0 || len(data) > MaxNameLength {
+			return fmt.Errorf("register %s: invalid name length %d", e, len(data))
+		} else if s == Wildcard || strings.Contains(s, Approx) {
+			return fmt.Errorf("register %s: name contains wildcards", e)
+		}
+	}
+	e.Zone = ThisZone
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, n := range r.names {
+		if n.entity.Matches(e) {
+			return fmt.Errorf("register %s: name in use", e)
+		}
+	}
+	r.names = append(r.names, registered{e, socket})
+	return nil
+}
+
+// Removes e, if it is registered.
+func (r *Responder) Unregister(e Entity) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, n := range r.names {
+		if n.entity.Matches(e) {
+			r.names = append(r.names[:i], r.names[i+1:]...)
+			return
+		}
+	}
+}
+
+// Reads lookups from the socket until it is closed.
+func (r *Responder) read() {
+	defer close(r.done)
+	buf := make([]byte, ddp.MaxDataSize)
+	for {
+		n, proto, _, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pak := Packet{}
+		if proto != ddp.ProtoNBP || Unmarshal(buf[:n], &pak) != nil {
+			continue
+		} else if pak.Function != LkUp || len(pak.Tuples) != 1 {
+			continue
+		}
+		r.answer(pak)
+	}
+}
+
+// Replies to a LkUp with the registered names that match it.
+func (r *Responder) answer(pak Packet) {
+	query := pak.Tuples[0]
+	local := r.conn.LocalAddr()
+	reply := LookupReply(pak.ID)
+
+	r.mu.Lock()
+	for i, n := range r.names {
+		if len(reply.Tuples) == MaxTuples {
+			break
+		} else if !n.entity.Matches(query.Entity) {
+			continue
+		}
+		reply.Tuples = append(reply.Tuples, Tuple{
+			Addr:       local.Addr(),
+			Socket:     n.socket,
+			Enumerator: uint8(i),
+			Entity:     n.entity,
+		})
+	}
+	r.mu.Unlock()
+	if len(reply.Tuples) == 0 {
+		return
+	}
+
+	data, err := Marshal(reply)
+	if err != nil {
+		return
+	}
+	to := ddp.SocketAddr{Network: query.Addr.Network, Node: query.Addr.Node, Socket: query.Socket}
+	r.conn.WriteTo(data, ddp.ProtoNBP, to)
+}
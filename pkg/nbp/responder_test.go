@@ -0,0 +1,102 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package nbp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+func TestResponder(t *testing.T) {
+	assert := assert.New(t)
+	lo := ddp.NewLoopback()
+	nis, err := lo.Listen(ddp.SocketAddr{Network: 100, Node: 2, Socket: Socket})
+	require.NoError(t, err)
+	r := NewResponder(nis)
+	defer r.Close()
+	client, err := lo.Listen(ddp.SocketAddr{Network: 100, Node: 1, Socket: 200})
+	require.NoError(t, err)
+	defer client.Close()
+
+	printer := Entity{Object: "Lab", Type: "LaserWriter", Zone: "Office"}
+	require.NoError(t, r.Register(printer, 130))
+	require.NoError(t, r.Register(Entity{Object: "Lab", Type: "Spooler"}, 131))
+	assert.Error(r.Register(Entity{Object: "lab", Type: "laserwriter"}, 132))
+	assert.Error(r.Register(Entity{Object: "=", Type: "LaserWriter"}, 132))
+	assert.Error(r.Register(Entity{Object: "Lab≈", Type: "LaserWriter"}, 132))
+	assert.Error(r.Register(Entity{Object: "", Type: "LaserWriter"}, 132))
+
+	lookup := func(query Entity) []Tuple {
+		data, err := Marshal(Lookup(7, ddp.Addr{Network: 100, Node: 1}, 200, query))
+		require.NoError(t, err)
+		require.NoError(t, client.WriteTo(data, ddp.ProtoNBP, ddp.SocketAddr{Node: 0xff, Socket: Socket}))
+
+		replies := make(chan Packet)
+		go func() {
+			buf := make([]byte, ddp.MaxDataSize)
+			n, _, _, err := client.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pak := Packet{}
+			if Unmarshal(buf[:n], &pak) == nil {
+				replies <- pak
+			}
+		}()
+		select {
+		case pak := <-replies:
+			assert.Equal(LkUpReply, pak.Function)
+			assert.Equal(uint8(7), pak.ID)
+			return pak.Tuples
+		case <-time.After(100 * time.Millisecond):
+			client.Close()
+			return nil
+		}
+	}
+
+	assert.Equal([]Tuple{{
+		Addr:   ddp.Addr{Network: 100, Node: 2},
+		Socket: 130,
+		Entity: Entity{Object: "Lab", Type: "LaserWriter", Zone: ThisZone},
+	}}, lookup(Entity{Object: "=", Type: "LaserWriter", Zone: ThisZone}))
+	assert.Len(lookup(Entity{Object: "Lab", Type: "=", Zone: ThisZone}), 2)
+
+	r.Unregister(printer)
+	assert.Equal([]Tuple{{
+		Addr:       ddp.Addr{Network: 100, Node: 2},
+		Socket:     131,
+		Enumerator: 0,
+		Entity:     Entity{Object: "Lab", Type: "Spooler", Zone: ThisZone},
+	}}, lookup(Entity{Object: "=", Type: "=", Zone: ThisZone}))
+	assert.Empty(lookup(Entity{Object: "=", Type: "LaserWriter", Zone: ThisZone}))
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package pap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+// How long Dial waits before asking a busy printer again.
+const busyInterval = 2 * time.Second
+
+// A Conn is a session with a printer, from the workstation’s side. Data
+// written to it is sent to the printer as a job.
+type Conn struct {
+	session
+	e   *atp.Endpoint
+	ctx context.Context
+
+	reads chan *atp.Transaction // The printer’s SendData requests
+	done  chan error            // The result of reading the printer’s output
+}
+
+// Returns the status of the printer whose session listening socket is
+// sls, asking from e.
+func GetStatus(ctx context.Context, e *atp.Endpoint, sls ddp.SocketAddr) (string, error) {
+	resp, err := e.Request(ctx, sls, message(Header{Function: SendStatus}, nil), atp.RequestOptions{Responses: 1})
+	if err != nil {
+		return "", fmt.Errorf("pap status: %s", err.Error())
+	} else if h := ParseHeader(resp[0].UserBytes); h.Function != Status {
+		return "", fmt.Errorf("pap status: unexpected function %d", h.Function)
+	}
+	return UnmarshalStatus(resp[0].Data)
+}
+
+// Opens a session with the printer whose session listening socket is
+// sls. The session uses e, which must not be used for anything else
+// until the session is closed.
+//
+// While the printer is busy, Dial asks again until ctx is done, passing
+// the printer’s status to busy, if it isn’t nil. Once the session is
+// open, the printer’s output, such as PostScript error messages, is
+// written to output. The session ends early if ctx is done.
+func Dial(
+	ctx context.Context,
+	e *atp.Endpoint,
+	sls ddp.SocketAddr,
+	output io.Writer,
+	busy func(status string),
+) (*Conn, error) {
+	id := uint8(rand.Intn(256))
+	start := time.Now()
+	var resp OpenConnResponse
+	for {
+		wait := time.Since(start) / (250 * time.Millisecond)
+		if wait > 0xffff {
+			wait = 0xffff
+		}
+		req := OpenConnRequest{
+			Socket:      e.LocalAddr().Socket,
+			FlowQuantum: FlowQuantum,
+			WaitTime:    uint16(wait),
+		}
+		msgs, err := e.Request(ctx, sls, message(Header{ConnID: id, Function: OpenConn}, MarshalOpenConn(req)),
+			atp.RequestOptions{ExactlyOnce: true, Responses: 1})
+		if err != nil {
+			return nil, fmt.Errorf("pap open: %s", err.Error())
+		} else if h := ParseHeader(msgs[0].UserBytes); h.Function != OpenConnReply || h.ConnID != id {
+			return nil, fmt.Errorf("pap open: unexpected function %d", h.Function)
+		} else if err := UnmarshalOpenConnReply(msgs[0].Data, &resp); err != nil {
+			return nil, fmt.Errorf("pap open: %s", err.Error())
+		} else if resp.Result == ResultOK {
+			break
+		}
+
+		if busy != nil {
+			busy(resp.Status)
+		}
+		select {
+		case <-time.After(busyInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("pap open: %s", ctx.Err().Error())
+		}
+	}
+
+	quantum := int(resp.FlowQuantum)
+	if quantum < 1 || quantum > atp.MaxResponses {
+		quantum = atp.MaxResponses
+	}
+	c := &Conn{
+		session: session{
+			id:      id,
+			peer:    ddp.SocketAddr{Network: sls.Network, Node: sls.Node, Socket: resp.Socket},
+			quantum: quantum,
+			heard:   make(chan struct{}, 1),
+		},
+		e:     e,
+		reads: make(chan *atp.Transaction, 1),
+		done:  make(chan error, 1),
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	go c.accept()
+	go c.tickle(c.ctx, e, TickleInterval)
+	go c.watch(c.ctx, ConnTimeout)
+	go func() {
+		c.done <- c.receive(c.ctx, e, output, SendDataInterval)
+	}()
+	return c, nil
+}
+
+// Handles the printer’s requests, until the session ends.
+func (c *Conn) accept() {
+	for {
+		t, err := c.e.Accept(c.ctx)
+		if err != nil {
+			return
+		}
+		h := ParseHeader(t.Request.UserBytes)
+		if t.From.Addr() != c.peer.Addr() || h.ConnID != c.id {
+			continue
+		}
+		c.touch()
+		switch h.Function {
+		case SendData:
+			// A retransmission replaces the request it repeats.
+			select {
+			case <-c.reads:
+			default:
+			}
+			c.reads <- t
+		case CloseConn:
+			t.Respond([]atp.Message{message(Header{ConnID: c.id, Function: CloseConnReply}, nil)})
+			c.mu.Lock()
+			c.closed = true
+			c.mu.Unlock()
+			c.cancel()
+			return
+		}
+	}
+}
+
+// Waits for the printer to ask for data.
+func (c *Conn) next() (*atp.Transaction, error) {
+	select {
+	case t := <-c.reads:
+		return t, nil
+	case <-c.ctx.Done():
+		return nil, fmt.Errorf("session closed")
+	}
+}
+
+// Sends data to the printer, as it asks for it.
+func (c *Conn) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		t, err := c.next()
+		if err != nil {
+			return n, fmt.Errorf("pap write: %s", err.Error())
+		}
+		count := t.Responses
+		if count > c.quantum {
+			count = c.quantum
+		}
+		var resp []atp.Message
+		for len(resp) < count && n < len(p) {
+			size := len(p) - n
+			if size > MaxDataSize {
+				size = MaxDataSize
+			}
+			resp = append(resp, message(Header{ConnID: c.id, Function: Data}, p[n:n+size]))
+			n += size
+		}
+		err = t.Respond(resp)
+		if err != nil {
+			return n, fmt.Errorf("pap write: %s", err.Error())
+		}
+	}
+	return n, nil
+}
+
+// Ends the job, waits for the printer to finish its output, and closes
+// the session.
+func (c *Conn) Close() error {
+	defer c.cancel()
+	err := c.endJob()
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if !closed {
+		c.close(c.e)
+	}
+	if err != nil {
+		return fmt.Errorf("pap close: %s", err.Error())
+	}
+	return nil
+}
+
+// Sends the end of the job, and waits for the end of the printer’s output.
+func (c *Conn) endJob() error {
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	err = t.Respond([]atp.Message{message(Header{ConnID: c.id, Function: Data, EOF: true}, nil)})
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-c.done:
+		return err
+	case <-c.ctx.Done():
+		return fmt.Errorf("session closed")
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Encodes and decodes PAP (Printer Access Protocol) messages, and runs
+// PAP sessions over ATP.
+//
+// A workstation opens a session with a printer’s session listening
+// socket (SLS), which it finds through NBP. Each side then reads from the
+// other by sending SendData requests, which are answered with up to a
+// flow quantum of Data packets; the last packet of a stream is marked
+// EOF. Both sides send Tickles, so that a session whose other side has
+// gone away times out.
+package pap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/macroman"
+)
+
+const (
+	// NBP type of PostScript printers.
+	LaserWriter = "LaserWriter"
+
+	// Maximum length of the data in each Data packet.
+	MaxDataSize = 512
+
+	// Number of Data packets that each side can accept per SendData, up
+	// to atp.MaxResponses.
+	FlowQuantum = 8
+
+	// Maximum length of a status string.
+	MaxStatusLength = 255
+
+	// How often each side tickles the other.
+	TickleInterval = 60 * time.Second
+
+	// How long a session lasts without hearing from the other side.
+	ConnTimeout = 2 * time.Minute
+
+	// How often SendData requests are retransmitted.
+	SendDataInterval = 15 * time.Second
+
+	// Results of OpenConn.
+	ResultOK   = uint16(0x0000)
+	ResultBusy = uint16(0xffff)
+)
+
+type Function uint8
+
+const (
+	OpenConn       = Function(1)
+	OpenConnReply  = Function(2)
+	SendData       = Function(3)
+	Data           = Function(4)
+	Tickle         = Function(5)
+	CloseConn      = Function(6)
+	CloseConnReply = Function(7)
+	SendStatus     = Function(8)
+	Status         = Function(9)
+)
+
+type (
+	// The PAP header, carried in the ATP user bytes of every message.
+	Header struct {
+		ConnID   uint8
+		Function Function
+
+		// In SendData, the sequence number, from 1 to 65535, or 0 if
+		// the request is unsequenced.
+		Sequence uint16
+
+		// In Data, set in the last packet of the stream.
+		EOF bool
+	}
+
+	// The data of an OpenConn request.
+	OpenConnRequest struct {
+		Socket      ddp.Socket // The workstation’s responding socket
+		FlowQuantum uint8
+		WaitTime    uint16 // Quarter-seconds that the workstation has waited
+	}
+
+	// The data of an OpenConnReply.
+	OpenConnResponse struct {
+		Socket      ddp.Socket // The printer’s responding socket
+		FlowQuantum uint8
+		Result      uint16
+		Status      string
+	}
+)
+
+// Returns h as ATP user bytes.
+func (h Header) UserBytes() [4]byte {
+	b := [4]byte{h.ConnID, byte(h.Function)}
+	if h.Function == Data {
+		if h.EOF {
+			b[2] = 1
+		}
+	} else {
+		binary.BigEndian.PutUint16(b[2:], h.Sequence)
+	}
+	return b
+}
+
+// Returns the PAP header in ATP user bytes.
+func ParseHeader(b [4]byte) Header {
+	h := Header{ConnID: b[0], Function: Function(b[1])}
+	if h.Function == Data {
+		h.EOF = b[2] != 0
+	} else {
+		h.Sequence = binary.BigEndian.Uint16(b[2:])
+	}
+	return h
+}
+
+// Returns the message for h and data.
+func message(h Header, data []byte) atp.Message {
+	return atp.Message{UserBytes: h.UserBytes(), Data: data}
+}
+
+// Returns the sequence number after seq, skipping 0.
+func nextSequence(seq uint16) uint16 {
+	seq++
+	if seq == 0 {
+		seq = 1
+	}
+	return seq
+}
+
+// Unmarshals an OpenConn request from bytes.
+func UnmarshalOpenConn(data []byte, req *OpenConnRequest) error {
+	if len(data) != 4 {
+		return fmt.Errorf("read pap open: invalid length %d", len(data))
+	}
+	req.Socket = ddp.Socket(data[0])
+	req.FlowQuantum = data[1]
+	req.WaitTime = binary.BigEndian.Uint16(data[2:])
+	return nil
+}
+
+// Marshals an OpenConn request to bytes.
+func MarshalOpenConn(req OpenConnRequest) []byte {
+	data := []byte{byte(req.Socket), req.FlowQuantum, 0, 0}
+	binary.BigEndian.PutUint16(data[2:], req.WaitTime)
+	return data
+}
+
+// Unmarshals an OpenConnReply from bytes.
+func UnmarshalOpenConnReply(data []byte, resp *OpenConnResponse) error {
+	if len(data) < 4 {
+		return fmt.Errorf("read pap open reply: invalid length %d", len(data))
+	}
+	resp.Socket = ddp.Socket(data[0])
+	resp.FlowQuantum = data[1]
+	resp.Result = binary.BigEndian.Uint16(data[2:])
+	var err error
+	resp.Status, err = readStatus(data[4:])
+	if err != nil {
+		return fmt.Errorf("read pap open reply: %s", err.Error())
+	}
+	return nil
+}
+
+// Marshals an OpenConnReply to bytes.
+func MarshalOpenConnReply(resp OpenConnResponse) ([]byte, error) {
+	data := []byte{byte(resp.Socket), resp.FlowQuantum, 0, 0}
+	binary.BigEndian.PutUint16(data[2:], resp.Result)
+	data, err := appendStatus(data, resp.Status)
+	if err != nil {
+		return nil, fmt.Errorf("write pap open reply: %s", err.Error())
+	}
+	return data, nil
+}
+
+// Unmarshals the status string of a Status message from bytes.
+func UnmarshalStatus(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", fmt.Errorf("read pap status: invalid length %d", len(data))
+	}
+	status, err := readStatus(data[4:])
+	if err != nil {
+		return "", fmt.Errorf("read pap status: %s", err.Error())
+	}
+	return status, nil
+}
+
+// Marshals the status string of a Status message to bytes.
+func MarshalStatus(status string) ([]byte, error) {
+	data, err := appendStatus([]byte{0, 0, 0, 0}, status)
+	if err != nil {
+		return nil, fmt.Errorf("write pap status: %s", err.Error())
+	}
+	return data, nil
+}
+
+func readStatus(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", nil
+	} else if int(data[0]) > len(data)-1 {
+		return "", fmt.Errorf("incomplete status (%d > %d)", data[0], len(data)-1)
+	}
+	return macroman.Decode(data[1 : 1+data[0]]), nil
+}
+
+func appendStatus(data []byte, status string) ([]byte, error) {
+	s, err := macroman.Encode(status)
+	if err != nil {
+		return nil, err
+	} else if len(s) > MaxStatusLength {
+		return nil, fmt.Errorf("status too long (%d > %d)", len(s), MaxStatusLength)
+	}
+	data = append(data, byte(len(s)))
+	return append(data, s...), nil
+}
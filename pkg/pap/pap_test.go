@@ -0,0 +1,270 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package pap
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+var (
+	printerAddr = ddp.SocketAddr{Network: 100, Node: 2, Socket: 130}
+	wsAddr      = ddp.SocketAddr{Network: 100, Node: 1, Socket: 200}
+	otherAddr   = ddp.SocketAddr{Network: 100, Node: 3, Socket: 200}
+
+	fast = atp.RequestOptions{ExactlyOnce: true, RetryInterval: 10 * time.Millisecond}
+)
+
+func endpoint(t *testing.T, lo *ddp.Loopback, addr ddp.SocketAddr) *atp.Endpoint {
+	c, err := lo.Listen(addr)
+	require.NoError(t, err)
+	e := atp.NewEndpoint(c)
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestHeader(t *testing.T) {
+	for _, h := range []Header{
+		{ConnID: 5, Function: SendData, Sequence: 0x1234},
+		{ConnID: 5, Function: Data, EOF: true},
+		{ConnID: 5, Function: Data},
+		{Function: SendStatus},
+	} {
+		assert.Equal(t, h, ParseHeader(h.UserBytes()))
+	}
+	assert.Equal(t, [4]byte{5, 4, 1, 0}, Header{ConnID: 5, Function: Data, EOF: true}.UserBytes())
+	assert.Equal(t, uint16(1), nextSequence(0xffff))
+}
+
+func TestMarshal(t *testing.T) {
+	req := OpenConnRequest{Socket: 200, FlowQuantum: 8, WaitTime: 12}
+	got := OpenConnRequest{}
+	require.NoError(t, UnmarshalOpenConn(MarshalOpenConn(req), &got))
+	assert.Equal(t, req, got)
+
+	resp := OpenConnResponse{Socket: 130, FlowQuantum: 8, Result: ResultBusy, Status: "status: busy"}
+	data, err := MarshalOpenConnReply(resp)
+	require.NoError(t, err)
+	gotResp := OpenConnResponse{}
+	require.NoError(t, UnmarshalOpenConnReply(data, &gotResp))
+	assert.Equal(t, resp, gotResp)
+
+	data, err = MarshalStatus("status: printing")
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0, 0, 0, 0, 16}, "status: printing"...), data)
+	status, err := UnmarshalStatus(data)
+	require.NoError(t, err)
+	assert.Equal(t, "status: printing", status)
+
+	_, err = UnmarshalStatus([]byte{0, 0, 0, 0, 20, 'x'})
+	assert.Error(t, err)
+}
+
+func TestServer(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lo := ddp.NewLoopback()
+	printer := endpoint(t, lo, printerAddr)
+	ws := endpoint(t, lo, wsAddr)
+	other := endpoint(t, lo, otherAddr)
+
+	jobs := make(chan []byte, 1)
+	s := NewServer(func(job io.Reader) error {
+		data, err := io.ReadAll(job)
+		jobs <- data
+		return err
+	})
+	s.retry = 10 * time.Millisecond
+	require.NoError(t, s.SetStatus("status: ready"))
+	assert.Error(s.SetStatus(strings.Repeat("x", 256)))
+	go s.Serve(ctx, printer)
+
+	// Status is answered without a session.
+	resp, err := ws.Request(ctx, printerAddr, message(Header{Function: SendStatus}, nil), fast)
+	require.NoError(t, err)
+	status, err := UnmarshalStatus(resp[0].Data)
+	require.NoError(t, err)
+	assert.Equal("status: ready", status)
+
+	open := func(e *atp.Endpoint) OpenConnResponse {
+		req := MarshalOpenConn(OpenConnRequest{Socket: e.LocalAddr().Socket, FlowQuantum: 8})
+		resp, err := e.Request(ctx, printerAddr, message(Header{ConnID: 5, Function: OpenConn}, req), fast)
+		require.NoError(t, err)
+		assert.Equal(Header{ConnID: 5, Function: OpenConnReply}, ParseHeader(resp[0].UserBytes))
+		reply := OpenConnResponse{}
+		require.NoError(t, UnmarshalOpenConnReply(resp[0].Data, &reply))
+		return reply
+	}
+	assert.Equal(OpenConnResponse{Socket: 130, FlowQuantum: 8, Status: "status: ready"}, open(ws))
+	assert.Equal(ResultBusy, open(other).Result)
+
+	// The workstation reads from the printer until the job is done.
+	eof := make(chan bool)
+	go func() {
+		resp, err := ws.Request(ctx, printerAddr, message(Header{ConnID: 5, Function: SendData, Sequence: 1}, nil), fast)
+		eof <- err == nil && ParseHeader(resp[0].UserBytes).EOF
+	}()
+
+	// The printer reads the job from the workstation.
+	for _, chunks := range [][]atp.Message{{
+		message(Header{ConnID: 5, Function: Data}, []byte("%!PS\n")),
+		message(Header{ConnID: 5, Function: Data}, []byte("showpage\n")),
+	}, {
+		message(Header{ConnID: 5, Function: Data, EOF: true}, []byte("%%EOF\n")),
+	}} {
+		for {
+			tx, err := ws.Accept(ctx)
+			require.NoError(t, err)
+			h := ParseHeader(tx.Request.UserBytes)
+			if h.Function == Tickle {
+				continue
+			}
+			assert.Equal(SendData, h.Function)
+			assert.Equal(8, tx.Responses)
+			require.NoError(t, tx.Respond(chunks))
+			break
+		}
+	}
+	select {
+	case job := <-jobs:
+		assert.Equal("%!PS\nshowpage\n%%EOF\n", string(job))
+	case <-time.After(time.Second):
+		t.Fatal("no job")
+	}
+	assert.True(<-eof)
+
+	_, err = ws.Request(ctx, printerAddr, message(Header{ConnID: 5, Function: CloseConn}, nil), fast)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return s.find(wsAddr.Addr(), 5) == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(ResultOK, open(other).Result)
+}
+
+func TestServerTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lo := ddp.NewLoopback()
+	printer := endpoint(t, lo, printerAddr)
+	ws := endpoint(t, lo, wsAddr)
+
+	aborted := make(chan error, 1)
+	s := NewServer(func(job io.Reader) error {
+		_, err := io.ReadAll(job)
+		aborted <- err
+		return err
+	})
+	s.timeout = 50 * time.Millisecond
+	go s.Serve(ctx, printer)
+
+	req := MarshalOpenConn(OpenConnRequest{Socket: wsAddr.Socket, FlowQuantum: 8})
+	_, err := ws.Request(ctx, printerAddr, message(Header{ConnID: 5, Function: OpenConn}, req), fast)
+	require.NoError(t, err)
+
+	// The workstation goes away, so the job is aborted and the printer
+	// closes the session.
+	select {
+	case err := <-aborted:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("session didn’t time out")
+	}
+	for {
+		tx, err := ws.Accept(ctx)
+		require.NoError(t, err)
+		if ParseHeader(tx.Request.UserBytes).Function == CloseConn {
+			require.NoError(t, tx.Respond([]atp.Message{
+				message(Header{ConnID: 5, Function: CloseConnReply}, nil),
+			}))
+			break
+		}
+	}
+	require.Eventually(t, func() bool {
+		return s.find(wsAddr.Addr(), 5) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClient(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lo := ddp.NewLoopback()
+	printer := endpoint(t, lo, printerAddr)
+	ws := endpoint(t, lo, wsAddr)
+	other := endpoint(t, lo, otherAddr)
+
+	jobs := make(chan string, 1)
+	s := NewServer(func(job io.Reader) error {
+		data, err := io.ReadAll(job)
+		jobs <- string(data)
+		return err
+	})
+	require.NoError(t, s.SetStatus("status: printing"))
+	go s.Serve(ctx, printer)
+
+	status, err := GetStatus(ctx, ws, printerAddr)
+	require.NoError(t, err)
+	assert.Equal("status: printing", status)
+
+	var output strings.Builder
+	c, err := Dial(ctx, ws, printerAddr, &output, nil)
+	require.NoError(t, err)
+
+	// While the job prints, the printer is busy.
+	var busy []string
+	dctx, dcancel := context.WithTimeout(ctx, busyInterval/2)
+	defer dcancel()
+	_, err = Dial(dctx, other, printerAddr, io.Discard, func(status string) {
+		busy = append(busy, status)
+	})
+	assert.Error(err)
+	assert.Equal([]string{"status: printing"}, busy)
+
+	// Enough data for several SendData requests.
+	job := "%!PS\n" + strings.Repeat("0 0 moveto\n", 1000) + "showpage\n"
+	n, err := io.Copy(c, strings.NewReader(job))
+	require.NoError(t, err)
+	assert.Equal(int64(len(job)), n)
+	require.NoError(t, c.Close())
+	assert.Equal(job, <-jobs)
+	assert.Empty(output.String())
+
+	require.Eventually(t, func() bool {
+		return s.find(wsAddr.Addr(), c.id) == nil
+	}, time.Second, 10*time.Millisecond)
+}
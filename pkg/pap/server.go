@@ -0,0 +1,264 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package pap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+// Default status of a Server.
+const DefaultStatus = "status: idle"
+
+type (
+	// A Server answers PAP sessions on its session listening socket, as
+	// a printer. It prints one job at a time: while one session is open,
+	// workstations that try to open another are told the printer is busy.
+	Server struct {
+		handler func(job io.Reader) error
+
+		tickle, timeout, retry time.Duration
+
+		mu     sync.Mutex
+		status string
+		sess   *session
+	}
+)
+
+// Creates a Server, which calls handler with each job’s data as it
+// arrives. If handler returns an error, the session is closed before the
+// workstation finishes sending.
+func NewServer(handler func(job io.Reader) error) *Server {
+	return &Server{
+		handler: handler,
+		tickle:  TickleInterval,
+		timeout: ConnTimeout,
+		retry:   SendDataInterval,
+		status:  DefaultStatus,
+	}
+}
+
+// Sets the string that status queries are answered with. It must fit in
+// MaxStatusLength bytes of Mac OS Roman.
+func (s *Server) SetStatus(status string) error {
+	_, err := MarshalStatus(status)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+	return nil
+}
+
+func (s *Server) getStatus() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Answers requests on e, which is the server’s session listening
+// socket, until ctx is done or e is closed.
+func (s *Server) Serve(ctx context.Context, e *atp.Endpoint) error {
+	for {
+		t, err := e.Accept(ctx)
+		if err != nil {
+			return err
+		}
+		s.handle(ctx, e, t)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, e *atp.Endpoint, t *atp.Transaction) {
+	h := ParseHeader(t.Request.UserBytes)
+	switch h.Function {
+	case SendStatus:
+		data, err := MarshalStatus(s.getStatus())
+		if err == nil {
+			t.Respond([]atp.Message{message(Header{Function: Status}, data)})
+		}
+		return
+	case OpenConn:
+		s.open(ctx, e, t, h)
+		return
+	}
+
+	sess := s.find(t.From.Addr(), h.ConnID)
+	if sess != nil {
+		sess.touch()
+	}
+	switch h.Function {
+	case SendData:
+		if sess != nil {
+			sess.hold(t)
+		}
+	case CloseConn:
+		t.Respond([]atp.Message{message(Header{ConnID: h.ConnID, Function: CloseConnReply}, nil)})
+		if sess != nil {
+			sess.mu.Lock()
+			sess.closed = true
+			sess.mu.Unlock()
+			sess.cancel()
+		}
+	}
+}
+
+// Opens a session, unless one is open already.
+func (s *Server) open(ctx context.Context, e *atp.Endpoint, t *atp.Transaction, h Header) {
+	req := OpenConnRequest{}
+	if UnmarshalOpenConn(t.Request.Data, &req) != nil {
+		return
+	}
+	resp := OpenConnResponse{
+		Socket:      e.LocalAddr().Socket,
+		FlowQuantum: FlowQuantum,
+		Result:      ResultOK,
+	}
+
+	s.mu.Lock()
+	resp.Status = s.status
+	var sess *session
+	if s.sess != nil {
+		resp.Result = ResultBusy
+	} else {
+		quantum := int(req.FlowQuantum)
+		if quantum < 1 || quantum > atp.MaxResponses {
+			quantum = atp.MaxResponses
+		}
+		sess = &session{
+			id:      h.ConnID,
+			peer:    ddp.SocketAddr{Network: t.From.Network, Node: t.From.Node, Socket: req.Socket},
+			quantum: quantum,
+			heard:   make(chan struct{}, 1),
+		}
+		ctx, sess.cancel = context.WithCancel(ctx)
+		s.sess = sess
+	}
+	s.mu.Unlock()
+
+	data, err := MarshalOpenConnReply(resp)
+	if err == nil {
+		err = t.Respond([]atp.Message{message(Header{ConnID: h.ConnID, Function: OpenConnReply}, data)})
+	}
+	if sess == nil {
+		return
+	} else if err != nil {
+		s.end(sess)
+		return
+	}
+	go s.run(ctx, e, sess)
+}
+
+// Returns the open session with a workstation, if any.
+func (s *Server) find(addr ddp.Addr, id uint8) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sess != nil && s.sess.peer.Addr() == addr && s.sess.id == id {
+		return s.sess
+	}
+	return nil
+}
+
+func (s *Server) end(sess *session) {
+	sess.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sess == sess {
+		s.sess = nil
+	}
+}
+
+// Reads a job from the workstation, and passes it to the handler.
+func (s *Server) run(ctx context.Context, e *atp.Endpoint, sess *session) {
+	defer s.end(sess)
+	go sess.tickle(ctx, e, s.tickle)
+	go sess.watch(ctx, s.timeout)
+
+	r, w := io.Pipe()
+	handled := make(chan error, 1)
+	go func() {
+		err := s.handler(r)
+		r.CloseWithError(fmt.Errorf("job aborted"))
+		if err != nil {
+			sess.cancel()
+		}
+		handled <- err
+	}()
+	err := sess.receive(ctx, e, w, s.retry)
+	w.CloseWithError(err)
+	if herr := <-handled; err == nil {
+		err = herr
+	}
+
+	if err == nil {
+		// Tell the workstation that the job is done, and wait for it to
+		// close the session.
+		sess.finish()
+		<-ctx.Done()
+	}
+
+	sess.mu.Lock()
+	closed := sess.closed
+	sess.mu.Unlock()
+	if !closed {
+		sess.close(e)
+	}
+}
+
+// Holds a SendData from the workstation until the job is done. A
+// retransmission replaces the request it repeats.
+func (sess *session) hold(t *atp.Transaction) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.eof {
+		sess.respondEOF(t)
+		return
+	}
+	sess.read = t
+}
+
+// Answers the workstation’s SendData with the end of the stream.
+func (sess *session) finish() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.eof = true
+	if sess.read != nil {
+		sess.respondEOF(sess.read)
+		sess.read = nil
+	}
+}
+
+func (sess *session) respondEOF(t *atp.Transaction) {
+	t.Respond([]atp.Message{message(Header{ConnID: sess.id, Function: Data, EOF: true}, nil)})
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package pap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sfiera/multitalk/pkg/atp"
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+// One side of a session. Each side reads from the other, and tickles it.
+type session struct {
+	id      uint8
+	peer    ddp.SocketAddr // The other side’s responding socket
+	quantum int            // The other side’s flow quantum
+	cancel  context.CancelFunc
+	heard   chan struct{}
+
+	mu     sync.Mutex
+	read   *atp.Transaction // The other side’s unanswered SendData
+	eof    bool             // Set once the stream to the other side ends
+	closed bool             // Set once the other side has closed
+}
+
+// Reads the other side’s data into w, until the end of the stream.
+func (sess *session) receive(ctx context.Context, e *atp.Endpoint, w io.Writer, retry time.Duration) error {
+	opts := atp.RequestOptions{
+		ExactlyOnce:   true,
+		Responses:     FlowQuantum,
+		RetryInterval: retry,
+		Retries:       atp.RetryForever,
+	}
+	for seq := uint16(1); ; seq = nextSequence(seq) {
+		req := message(Header{ConnID: sess.id, Function: SendData, Sequence: seq}, nil)
+		resp, err := e.Request(ctx, sess.peer, req, opts)
+		if err != nil {
+			return err
+		}
+		sess.touch()
+		for _, m := range resp {
+			h := ParseHeader(m.UserBytes)
+			if h.ConnID != sess.id || h.Function != Data {
+				return fmt.Errorf("pap receive: unexpected function %d", h.Function)
+			}
+			_, err = w.Write(m.Data)
+			if err != nil {
+				return err
+			} else if h.EOF {
+				return nil
+			}
+		}
+	}
+}
+
+// Notes that the other side was heard from.
+func (sess *session) touch() {
+	select {
+	case sess.heard <- struct{}{}:
+	default:
+	}
+}
+
+// Ends the session if the other side isn’t heard from for timeout.
+func (sess *session) watch(ctx context.Context, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-sess.heard:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			sess.cancel()
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Tickles the other side every interval, until ctx is done. Tickles
+// aren’t answered; they only keep the session open.
+func (sess *session) tickle(ctx context.Context, e *atp.Endpoint, interval time.Duration) {
+	req := message(Header{ConnID: sess.id, Function: Tickle}, nil)
+	e.Request(ctx, sess.peer, req, atp.RequestOptions{
+		Responses:     1,
+		RetryInterval: interval,
+		Retries:       atp.RetryForever,
+	})
+}
+
+// Tells the other side that the session is closed.
+func (sess *session) close(e *atp.Endpoint) {
+	ctx, cancel := context.WithTimeout(context.Background(), atp.DefaultRetryInterval)
+	defer cancel()
+	req := message(Header{ConnID: sess.id, Function: CloseConn}, nil)
+	e.Request(ctx, sess.peer, req, atp.RequestOptions{ExactlyOnce: true, Responses: 1, Retries: 1})
+}
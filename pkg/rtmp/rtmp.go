@@ -0,0 +1,195 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Encodes and decodes RTMP (Routing Table Maintenance Protocol) packets.
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+const (
+	// Socket on which routers send and receive RTMP packets.
+	Socket = ddp.Socket(1)
+
+	// Version indicator that ends nonextended headers and extended tuples.
+	Version = uint8(0x82)
+
+	idLength     = 8
+	extendedFlag = 0x80
+	distanceMask = 0x1f
+)
+
+// Functions of an RTMP Request packet (DDP type ddp.ProtoRTMPReq).
+const (
+	FuncRequest    = uint8(1) // Request an RTMP Response
+	FuncRDRSplit   = uint8(2) // Route data request, with split horizon
+	FuncRDRNoSplit = uint8(3) // Route data request, without split horizon
+)
+
+type (
+	// A routing tuple. Nonextended networks have a single network,
+	// with Range.Start equal to Range.End.
+	Tuple struct {
+		Range    ddp.Range
+		Extended bool
+		Distance uint8
+	}
+
+	// An RTMP Data or Response packet (DDP type ddp.ProtoRTMPResp).
+	//
+	// If the router is on an extended network, the first tuple is
+	// the cable range of that network, with a distance of 0.
+	// Otherwise, a nonextended header precedes the tuples.
+	//
+	// Responses to FuncRequest contain only the header, and so have
+	// at most one tuple.
+	Packet struct {
+		Router   ddp.Addr
+		Extended bool
+		Tuples   []Tuple
+	}
+
+	header struct {
+		Network  ddp.Network
+		IDLength uint8
+		Node     ddp.Node
+	}
+)
+
+// Unmarshals a packet from bytes.
+func Unmarshal(data []byte, pak *Packet) error {
+	r := bytes.NewReader(data)
+
+	h := header{}
+	err := binary.Read(r, binary.BigEndian, &h)
+	if err != nil {
+		return fmt.Errorf("read rtmp header: %s", err.Error())
+	} else if h.IDLength != idLength {
+		return fmt.Errorf("read rtmp header: invalid ID length %d", h.IDLength)
+	}
+	pak.Router = ddp.Addr{Network: h.Network, Node: h.Node}
+	pak.Extended = true
+	pak.Tuples = nil
+
+	rest := data[4:]
+	if len(rest) >= 3 && rest[0] == 0 && rest[1] == 0 {
+		if rest[2] != Version {
+			return fmt.Errorf("read rtmp header: invalid version $%02x", rest[2])
+		}
+		pak.Extended = false
+		r.Seek(3, io.SeekCurrent)
+	} else if len(rest) == 0 {
+		pak.Extended = false
+	}
+
+	for r.Len() > 0 {
+		tuple, err := readTuple(r)
+		if err != nil {
+			return err
+		}
+		pak.Tuples = append(pak.Tuples, tuple)
+	}
+
+	if pak.Extended && pak.Tuples[0].Distance != 0 {
+		return fmt.Errorf("read rtmp header: invalid distance %d", pak.Tuples[0].Distance)
+	}
+	return nil
+}
+
+func readTuple(r *bytes.Reader) (Tuple, error) {
+	t := Tuple{}
+	fields := struct {
+		Network  ddp.Network
+		Distance uint8
+	}{}
+	err := binary.Read(r, binary.BigEndian, &fields)
+	if err != nil {
+		return t, fmt.Errorf("read rtmp tuple: %s", err.Error())
+	}
+	t.Range = ddp.Range{Start: fields.Network, End: fields.Network}
+	t.Distance = fields.Distance & distanceMask
+	if (fields.Distance & extendedFlag) == 0 {
+		return t, nil
+	}
+
+	ext := struct {
+		End     ddp.Network
+		Version uint8
+	}{}
+	err = binary.Read(r, binary.BigEndian, &ext)
+	if err != nil {
+		return t, fmt.Errorf("read rtmp tuple: %s", err.Error())
+	} else if ext.Version != Version {
+		return t, fmt.Errorf("read rtmp tuple: invalid version $%02x", ext.Version)
+	}
+	t.Range.End = ext.End
+	t.Extended = true
+	return t, nil
+}
+
+// Marshals a packet to bytes.
+func Marshal(pak Packet) ([]byte, error) {
+	w := bytes.NewBuffer([]byte{})
+	err := binary.Write(w, binary.BigEndian, header{
+		Network:  pak.Router.Network,
+		IDLength: idLength,
+		Node:     pak.Router.Node,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("write rtmp header: %s", err.Error())
+	}
+
+	if pak.Extended {
+		if len(pak.Tuples) == 0 || !pak.Tuples[0].Extended {
+			return nil, fmt.Errorf("write rtmp header: missing extended tuple")
+		}
+	} else if len(pak.Tuples) > 0 {
+		w.Write([]byte{0x00, 0x00, Version})
+	}
+
+	for _, t := range pak.Tuples {
+		if t.Distance > distanceMask {
+			return nil, fmt.Errorf("write rtmp tuple: invalid distance %d", t.Distance)
+		}
+		binary.Write(w, binary.BigEndian, t.Range.Start)
+		if t.Extended {
+			w.WriteByte(t.Distance | extendedFlag)
+			binary.Write(w, binary.BigEndian, t.Range.End)
+			w.WriteByte(Version)
+		} else {
+			w.WriteByte(t.Distance)
+		}
+	}
+
+	return w.Bytes(), nil
+}
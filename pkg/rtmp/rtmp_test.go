@@ -0,0 +1,152 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rtmp
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+)
+
+func TestUnmarshalNoError(t *testing.T) {
+	cases := []struct {
+		name, hex string
+		expected  Packet
+	}{{
+		"extended_data",
+		"0064" + "08" + "fe" + // Router 100.254
+			"0064" + "80" + "006d" + "82" + // 100-109, distance 0
+			"0005" + "01" + // 5, distance 1
+			"00c8" + "82" + "00c9" + "82", // 200-201, distance 2
+		Packet{
+			Router:   ddp.Addr{Network: 100, Node: 254},
+			Extended: true,
+			Tuples: []Tuple{
+				{Range: ddp.Range{Start: 100, End: 109}, Extended: true, Distance: 0},
+				{Range: ddp.Range{Start: 5, End: 5}, Extended: false, Distance: 1},
+				{Range: ddp.Range{Start: 200, End: 201}, Extended: true, Distance: 2},
+			},
+		},
+	}, {
+		"nonextended_data",
+		"0005" + "08" + "fe" + // Router 5.254
+			"0000" + "82" + // Nonextended header
+			"0005" + "00" + // 5, distance 0
+			"0064" + "81" + "006d" + "82", // 100-109, distance 1
+		Packet{
+			Router:   ddp.Addr{Network: 5, Node: 254},
+			Extended: false,
+			Tuples: []Tuple{
+				{Range: ddp.Range{Start: 5, End: 5}, Extended: false, Distance: 0},
+				{Range: ddp.Range{Start: 100, End: 109}, Extended: true, Distance: 1},
+			},
+		},
+	}, {
+		"nonextended_response",
+		"0005" + "08" + "fe",
+		Packet{
+			Router: ddp.Addr{Network: 5, Node: 254},
+		},
+	}, {
+		"extended_response",
+		"0064" + "08" + "fe" + "0064" + "80" + "006d" + "82",
+		Packet{
+			Router:   ddp.Addr{Network: 100, Node: 254},
+			Extended: true,
+			Tuples: []Tuple{
+				{Range: ddp.Range{Start: 100, End: 109}, Extended: true, Distance: 0},
+			},
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			p := Packet{}
+			if assert.NoError(Unmarshal(unhex(c.hex), &p)) {
+				assert.Equal(c.expected, p)
+			}
+			data, err := Marshal(c.expected)
+			if assert.NoError(err) {
+				assert.Equal(unhex(c.hex), data)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	cases := []struct {
+		name, hex, err string
+	}{{
+		"empty",
+		"",
+		"read rtmp header: EOF",
+	}, {
+		"id_length",
+		"0064" + "10" + "fe",
+		"read rtmp header: invalid ID length 16",
+	}, {
+		"version",
+		"0005" + "08" + "fe" + "0000" + "81",
+		"read rtmp header: invalid version $81",
+	}, {
+		"incomplete_tuple",
+		"0064" + "08" + "fe" + "0064" + "80" + "00",
+		"read rtmp tuple: unexpected EOF",
+	}, {
+		"distance",
+		"0064" + "08" + "fe" + "0064" + "81" + "006d" + "82",
+		"read rtmp header: invalid distance 1",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			p := Packet{}
+			err := Unmarshal(unhex(c.hex), &p)
+			if assert.Error(err) {
+				assert.Equal(c.err, err.Error())
+			}
+		})
+	}
+}
+
+func unhex(s string) []byte {
+	data := []byte{}
+	for i := 0; i < len(s); i += 2 {
+		n, err := strconv.ParseUint(s[i:i+2], 16, 8)
+		if err != nil {
+			panic(err)
+		}
+		data = append(data, byte(n))
+	}
+	return data
+}
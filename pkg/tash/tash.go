@@ -37,6 +37,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sync/atomic"
 
 	"github.com/sfiera/multitalk/pkg/ddp"
 	"github.com/sfiera/multitalk/pkg/llap"
@@ -57,7 +58,8 @@ const (
 
 // A Decoder translates TashTalk serial input to LLAP packets.
 type Decoder struct {
-	r io.ByteReader
+	crcErrors uint64 // accessed atomically
+	r         io.ByteReader
 }
 
 // NewDecoder returns a Decoder with r as its input.
@@ -112,6 +114,7 @@ func (d *Decoder) Decode(pak *llap.Packet) (err error) {
 
 		data := buf.Bytes()
 		if localtalk.SumCRC(data) != localtalk.ValidCRC {
+			atomic.AddUint64(&d.crcErrors, 1)
 			buf.Reset()
 			continue
 		}
@@ -125,6 +128,12 @@ func (d *Decoder) Decode(pak *llap.Packet) (err error) {
 	}
 }
 
+// CRCErrors returns the number of packets that the decoder has dropped
+// for an invalid CRC. It is safe to call while decoding.
+func (d *Decoder) CRCErrors() uint64 {
+	return atomic.LoadUint64(&d.crcErrors)
+}
+
 // An Encoder translates LLAP packets to TashTalk serial output.
 type Encoder struct {
 	w     io.Writer
@@ -161,39 +170,69 @@ func (e *Encoder) Reset() error {
 // If an error occurs while encoding the packet, the packet is not
 // sent, but the stream is assumed to remain valid.
 func (e *Encoder) Encode(pak llap.Packet) error {
-	if !e.ready {
-		err := e.Reset()
-		if err != nil {
-			return err
-		}
+	err := e.ensureReady()
+	if err != nil {
+		return err
+	}
+	cmd, err := AppendFrame(nil, pak)
+	if err != nil {
+		return err
 	}
+	return e.write(cmd)
+}
 
+// AppendFrame appends the TashTalk frame command that sends the packet
+// to buf, and returns the extended buffer. The command is the frame
+// command byte, the packet, and its CRC.
+func AppendFrame(buf []byte, pak llap.Packet) ([]byte, error) {
+	if err := pak.Validate(); err != nil {
+		return nil, err
+	}
 	switch pak.Kind {
 	case llap.TypeDDP, llap.TypeExtDDP:
 		if len(pak.Payload) < 2 {
-			return fmt.Errorf("invalid DDP packet length: %d", len(pak.Payload))
+			return nil, fmt.Errorf("invalid DDP packet length: %d", len(pak.Payload))
 		}
 		inferredLength := binary.BigEndian.Uint16(pak.Payload[:2]) & 0x03ff
 		if int(inferredLength) != len(pak.Payload) {
-			return fmt.Errorf("DDP packet length mismatch: %d vs. %d", len(pak.Payload), inferredLength)
-		}
-	case llap.TypeEnq, llap.TypeAck:
-		if len(pak.Payload) != 0 {
-			return fmt.Errorf("control frame packet with payload")
+			return nil, fmt.Errorf("DDP packet length mismatch: %d vs. %d", len(pak.Payload), inferredLength)
 		}
+	case llap.TypeEnq, llap.TypeAck, llap.TypeRTS, llap.TypeCTS:
 	default:
-		return fmt.Errorf("invalid packet type: $%02x", pak.Kind)
+		// TashTalk only sends the frames of LLAP’s own types.
+		return nil, fmt.Errorf("invalid packet type: $%02x", uint8(pak.Kind))
+	}
+
+	start := len(buf)
+	buf = append(buf, commandFrame)
+	buf, err := llap.AppendMarshal(buf, pak)
+	if err != nil {
+		return nil, err
 	}
+	fcs := localtalk.SumCRC(buf[start+1:])
+	return append(buf, byte(fcs), byte(fcs>>8)), nil
+}
 
-	marshaled, err := llap.Marshal(pak)
+// WriteFrame sends a frame command from AppendFrame to the decoder’s
+// output, resetting it first if it is not in a ready state, as Encode
+// does.
+func (e *Encoder) WriteFrame(cmd []byte) error {
+	err := e.ensureReady()
 	if err != nil {
 		return err
 	}
-	fcs := localtalk.SumCRC(marshaled)
-	data := []byte{commandFrame}
-	data = append(data, marshaled...)
-	data = append(data, byte(fcs), byte(fcs>>8))
-	_, err = e.w.Write(data)
+	return e.write(cmd)
+}
+
+func (e *Encoder) ensureReady() error {
+	if e.ready {
+		return nil
+	}
+	return e.Reset()
+}
+
+func (e *Encoder) write(cmd []byte) error {
+	_, err := e.w.Write(cmd)
 	if err != nil {
 		e.ready = false
 		return err
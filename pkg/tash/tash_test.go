@@ -291,6 +291,16 @@ func TestDecode(t *testing.T) {
 	}
 }
 
+func TestDecodeCRCErrors(t *testing.T) {
+	data := `020181eaea00fd` + `0201812dff00fd` + `01f1e100fd` + `020181eaea00fd` + `010200fa`
+	d := NewDecoder(bytes.NewBuffer([]byte(unhex(data))))
+	pak := llap.Packet{}
+	assert.NoError(t, d.Decode(&pak))
+	assert.Equal(t, uint64(1), d.CRCErrors())
+	assert.Equal(t, io.EOF, d.Decode(&pak))
+	assert.Equal(t, uint64(2), d.CRCErrors())
+}
+
 const reset = `0000000000000000000000000000000000000000000000000000000000000000` +
 	`0000000000000000000000000000000000000000000000000000000000000000` +
 	`0000000000000000000000000000000000000000000000000000000000000000` +
@@ -339,6 +349,13 @@ func TestEncode(t *testing.T) {
 			},
 		}},
 		want: reset + `010201812dff`,
+	}, {
+		name: "rts-cts-packets",
+		packets: []llap.Packet{
+			*llap.RTS(2, 1),
+			*llap.CTS(1, 2),
+		},
+		want: reset + `0102018480a8` + `01010285057c`,
 	}, {
 		name: "small_data-packet",
 		packets: []llap.Packet{{
@@ -487,6 +504,21 @@ func TestEncode(t *testing.T) {
 				}
 			}
 			assert.Equal(unhex(tt.want), buf.Bytes())
+
+			// Frame commands can also be built in a reused buffer.
+			buf.Reset()
+			e = NewEncoder(&buf)
+			var cmd []byte
+			for _, pak := range tt.packets {
+				var err error
+				cmd, err = AppendFrame(cmd[:0], pak)
+				if err != nil {
+					panic(err)
+				} else if err := e.WriteFrame(cmd); err != nil {
+					panic(err)
+				}
+			}
+			assert.Equal(unhex(tt.want), buf.Bytes())
 		})
 	}
 }
@@ -528,6 +560,38 @@ func TestEncodeBadPackets(t *testing.T) {
 			Payload: []byte{0x00, 0x02},
 		},
 		wantErr: `control frame packet with payload`,
+	}, {
+		name: "non-empty-cts",
+		packet: llap.Packet{
+			Header: llap.Header{
+				DstNode: 2,
+				SrcNode: 1,
+				Kind:    llap.TypeCTS,
+			},
+			Payload: []byte{0x00, 0x02},
+		},
+		wantErr: `control frame packet with payload`,
+	}, {
+		name: "broadcast-rts",
+		packet: llap.Packet{
+			Header: llap.Header{
+				DstNode: 0xff,
+				SrcNode: 1,
+				Kind:    llap.TypeRTS,
+			},
+		},
+		wantErr: `control frame packet to broadcast node`,
+	}, {
+		name: "too-long",
+		packet: llap.Packet{
+			Header: llap.Header{
+				DstNode: 2,
+				SrcNode: 1,
+				Kind:    llap.TypeExtDDP,
+			},
+			Payload: make([]byte, 601),
+		},
+		wantErr: `packet too long: 601 > 600`,
 	}, {
 		name: "length-mismatch",
 		packet: llap.Packet{
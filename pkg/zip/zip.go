@@ -0,0 +1,355 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Encodes and decodes ZIP (Zone Information Protocol) packets.
+//
+// Only the ZIP packets carried directly in DDP are handled here.
+// Commands carried by ATP (GetMyZone, GetZoneList and GetLocalZones)
+// are not.
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+	"github.com/sfiera/multitalk/pkg/macroman"
+)
+
+const (
+	// Socket on which routers send and receive ZIP packets.
+	Socket = ddp.Socket(6)
+
+	// Maximum length of a zone name.
+	MaxZoneLength = 32
+)
+
+type Function uint8
+
+const (
+	FuncQuery        = Function(1)
+	FuncReply        = Function(2)
+	FuncGetNetInfo   = Function(5)
+	FuncNetInfoReply = Function(6)
+	FuncExtReply     = Function(8)
+)
+
+// Flags of a NetInfoReply.
+const (
+	FlagZoneInvalid  = uint8(0x80)
+	FlagUseBroadcast = uint8(0x40)
+	FlagOnlyOneZone  = uint8(0x20)
+)
+
+type (
+	// A ZIP packet. One of *Query, *Reply, *GetNetInfo or *NetInfoReply.
+	Packet interface {
+		Function() Function
+	}
+
+	// Asks for the zones of each network.
+	Query struct {
+		Networks []ddp.Network
+	}
+
+	// Lists zones of networks, in response to a Query.
+	//
+	// An extended reply lists the zones of a single network, and Count
+	// is the total number of zones in that network, which may be split
+	// across several replies. For other replies, Count is ignored.
+	Reply struct {
+		Extended bool
+		Count    uint8
+		Zones    []NetworkZone
+	}
+
+	NetworkZone struct {
+		Network ddp.Network
+		Zone    string
+	}
+
+	// Asks for the cable range and zone information of the network.
+	// Zone is the zone that the node last used, or empty.
+	GetNetInfo struct {
+		Zone string
+	}
+
+	// Responds to a GetNetInfo.
+	//
+	// If the requested zone is not valid for the network,
+	// FlagZoneInvalid is set, and DefaultZone is the network’s default.
+	// Multicast is the data-link multicast address for the zone
+	// (the default zone, if the requested one is invalid).
+	NetInfoReply struct {
+		Flags       uint8
+		Range       ddp.Range
+		Zone        string
+		Multicast   []byte
+		DefaultZone string
+	}
+)
+
+func (*Query) Function() Function      { return FuncQuery }
+func (*GetNetInfo) Function() Function { return FuncGetNetInfo }
+
+func (*NetInfoReply) Function() Function { return FuncNetInfoReply }
+
+func (r *Reply) Function() Function {
+	if r.Extended {
+		return FuncExtReply
+	}
+	return FuncReply
+}
+
+// Unmarshals a packet from bytes.
+func Unmarshal(data []byte) (Packet, error) {
+	r := bytes.NewReader(data)
+	fn, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("read zip header: %s", err.Error())
+	}
+
+	var pak Packet
+	switch Function(fn) {
+	case FuncQuery:
+		pak, err = readQuery(r)
+	case FuncReply, FuncExtReply:
+		pak, err = readReply(r, Function(fn) == FuncExtReply)
+	case FuncGetNetInfo:
+		pak, err = readGetNetInfo(r)
+	case FuncNetInfoReply:
+		pak, err = readNetInfoReply(r)
+	default:
+		return nil, fmt.Errorf("read zip header: unknown function %d", fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.ReadByte()
+	if err != io.EOF {
+		return nil, fmt.Errorf("read zip: excess data")
+	}
+	return pak, nil
+}
+
+func readQuery(r *bytes.Reader) (*Query, error) {
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("read zip query: %s", err.Error())
+	}
+	q := &Query{Networks: make([]ddp.Network, count)}
+	err = binary.Read(r, binary.BigEndian, q.Networks)
+	if err != nil {
+		return nil, fmt.Errorf("read zip query: %s", err.Error())
+	}
+	return q, nil
+}
+
+func readReply(r *bytes.Reader, extended bool) (*Reply, error) {
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("read zip reply: %s", err.Error())
+	}
+	reply := &Reply{Extended: extended}
+	if extended {
+		reply.Count = count
+	}
+	for r.Len() > 0 {
+		nz := NetworkZone{}
+		err = binary.Read(r, binary.BigEndian, &nz.Network)
+		if err != nil {
+			return nil, fmt.Errorf("read zip reply: %s", err.Error())
+		}
+		nz.Zone, err = readString(r)
+		if err != nil {
+			return nil, fmt.Errorf("read zip reply: %s", err.Error())
+		}
+		reply.Zones = append(reply.Zones, nz)
+	}
+	if !extended && len(reply.Zones) != int(count) {
+		return nil, fmt.Errorf("read zip reply: count mismatch (%d != %d)", len(reply.Zones), count)
+	}
+	return reply, nil
+}
+
+func readGetNetInfo(r *bytes.Reader) (*GetNetInfo, error) {
+	unused := [5]byte{}
+	_, err := io.ReadFull(r, unused[:])
+	if err != nil {
+		return nil, fmt.Errorf("read zip getnetinfo: %s", err.Error())
+	}
+	zone, err := readString(r)
+	if err != nil {
+		return nil, fmt.Errorf("read zip getnetinfo: %s", err.Error())
+	}
+	return &GetNetInfo{Zone: zone}, nil
+}
+
+func readNetInfoReply(r *bytes.Reader) (*NetInfoReply, error) {
+	reply := &NetInfoReply{}
+	fields := struct {
+		Flags uint8
+		Range ddp.Range
+	}{}
+	err := binary.Read(r, binary.BigEndian, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("read zip netinforeply: %s", err.Error())
+	}
+	reply.Flags = fields.Flags
+	reply.Range = fields.Range
+
+	reply.Zone, err = readString(r)
+	if err != nil {
+		return nil, fmt.Errorf("read zip netinforeply: %s", err.Error())
+	}
+	reply.Multicast, err = readBytes(r)
+	if err != nil {
+		return nil, fmt.Errorf("read zip netinforeply: %s", err.Error())
+	}
+	if (reply.Flags & FlagZoneInvalid) != 0 {
+		reply.DefaultZone, err = readString(r)
+		if err != nil {
+			return nil, fmt.Errorf("read zip netinforeply: %s", err.Error())
+		}
+	}
+	return reply, nil
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	data, err := readBytes(r)
+	if err != nil {
+		return "", err
+	}
+	return macroman.Decode(data), nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Marshals a packet to bytes.
+func Marshal(pak Packet) ([]byte, error) {
+	w := bytes.NewBuffer([]byte{byte(pak.Function())})
+
+	var err error
+	switch p := pak.(type) {
+	case *Query:
+		if len(p.Networks) > 0xff {
+			return nil, fmt.Errorf("write zip query: too many networks")
+		}
+		w.WriteByte(uint8(len(p.Networks)))
+		binary.Write(w, binary.BigEndian, p.Networks)
+
+	case *Reply:
+		count := p.Count
+		if !p.Extended {
+			if len(p.Zones) > 0xff {
+				return nil, fmt.Errorf("write zip reply: too many zones")
+			}
+			count = uint8(len(p.Zones))
+		}
+		w.WriteByte(count)
+		for _, nz := range p.Zones {
+			binary.Write(w, binary.BigEndian, nz.Network)
+			err = writeString(w, nz.Zone)
+			if err != nil {
+				return nil, fmt.Errorf("write zip reply: %s", err.Error())
+			}
+		}
+
+	case *GetNetInfo:
+		w.Write([]byte{0, 0, 0, 0, 0})
+		err = writeString(w, p.Zone)
+		if err != nil {
+			return nil, fmt.Errorf("write zip getnetinfo: %s", err.Error())
+		}
+
+	case *NetInfoReply:
+		w.WriteByte(p.Flags)
+		binary.Write(w, binary.BigEndian, p.Range)
+		err = writeString(w, p.Zone)
+		if err == nil {
+			err = writeBytes(w, p.Multicast, 0xff)
+		}
+		if err == nil && (p.Flags&FlagZoneInvalid) != 0 {
+			err = writeString(w, p.DefaultZone)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("write zip netinforeply: %s", err.Error())
+		}
+
+	default:
+		return nil, fmt.Errorf("write zip: unknown packet type %T", pak)
+	}
+
+	return w.Bytes(), nil
+}
+
+func writeString(w *bytes.Buffer, s string) error {
+	data, err := macroman.Encode(s)
+	if err != nil {
+		return err
+	}
+	return writeBytes(w, data, MaxZoneLength)
+}
+
+func writeBytes(w *bytes.Buffer, data []byte, max int) error {
+	if len(data) > max {
+		return fmt.Errorf("string too long (%d > %d)", len(data), max)
+	}
+	w.WriteByte(uint8(len(data)))
+	w.Write(data)
+	return nil
+}
+
+// Returns the EtherTalk multicast address for a zone.
+//
+// The address is derived from the DDP checksum of the upper-cased zone
+// name in Mac OS Roman, so that nodes in other zones can discard NBP
+// lookups without examining them. Characters with no Mac OS Roman
+// equivalent are ignored.
+func Multicast(zone string) ethernet.Addr {
+	upper := []byte{}
+	for _, r := range macroman.ToUpper(zone) {
+		c, _ := macroman.Encode(string(r))
+		upper = append(upper, c...)
+	}
+	return ethernet.Addr{0x09, 0x00, 0x07, 0x00, 0x00, uint8(ddp.Checksum(upper) % 0xfd)}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2009-2023 Rob Braun <bbraun@synack.net> and others
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of Rob Braun nor the names of his contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package zip
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sfiera/multitalk/pkg/ddp"
+	"github.com/sfiera/multitalk/pkg/ethernet"
+)
+
+func TestUnmarshalNoError(t *testing.T) {
+	cases := []struct {
+		name, hex string
+		expected  Packet
+	}{{
+		"GetNetInfo",
+		"050000000000012a", // From the ZIP capture in ddp_test.go
+		&GetNetInfo{Zone: "*"},
+	}, {
+		"GetNetInfo_empty",
+		"05000000000000",
+		&GetNetInfo{Zone: ""},
+	}, {
+		"GetNetInfo_macroman",
+		"05000000000004" + "4361668e", // Zone “Café” in Mac OS Roman
+		&GetNetInfo{Zone: "Café"},
+	}, {
+		"NetInfoReply",
+		"06" + "60" + "0064006d" + // Use broadcast, only one zone, 100-109
+			"054e4f525448" + // Zone “NORTH”
+			"06090007000059", // Multicast
+		&NetInfoReply{
+			Flags:     FlagUseBroadcast | FlagOnlyOneZone,
+			Range:     ddp.Range{Start: 100, End: 109},
+			Zone:      "NORTH",
+			Multicast: unhex("090007000059"),
+		},
+	}, {
+		"NetInfoReply_invalid",
+		"06" + "80" + "0064006d" + // Zone invalid, 100-109
+			"0473616e64" + // Zone “sand”
+			"06090007000059" + // Multicast
+			"054e4f525448", // Default zone “NORTH”
+		&NetInfoReply{
+			Flags:       FlagZoneInvalid,
+			Range:       ddp.Range{Start: 100, End: 109},
+			Zone:        "sand",
+			Multicast:   unhex("090007000059"),
+			DefaultZone: "NORTH",
+		},
+	}, {
+		"Query",
+		"01" + "02" + "0005" + "0064",
+		&Query{Networks: []ddp.Network{5, 100}},
+	}, {
+		"Reply",
+		"02" + "02" + "0005" + "054e4f525448" + "0064" + "054e4f525448",
+		&Reply{Zones: []NetworkZone{{5, "NORTH"}, {100, "NORTH"}}},
+	}, {
+		"ExtReply",
+		"08" + "03" + "0064" + "054e4f525448" + "0064" + "05534f555448",
+		&Reply{
+			Extended: true,
+			Count:    3,
+			Zones:    []NetworkZone{{100, "NORTH"}, {100, "SOUTH"}},
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			p, err := Unmarshal(unhex(c.hex))
+			if assert.NoError(err) {
+				assert.Equal(c.expected, p)
+			}
+			data, err := Marshal(c.expected)
+			if assert.NoError(err) {
+				assert.Equal(unhex(c.hex), data)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	cases := []struct {
+		name, hex, err string
+	}{{
+		"empty",
+		"",
+		"read zip header: EOF",
+	}, {
+		"unknown",
+		"07",
+		"read zip header: unknown function 7",
+	}, {
+		"truncated_zone",
+		"0500000000000a2a",
+		"read zip getnetinfo: unexpected EOF",
+	}, {
+		"reply_count",
+		"02" + "02" + "0005" + "054e4f525448",
+		"read zip reply: count mismatch (1 != 2)",
+	}, {
+		"excess",
+		"01" + "01" + "0005" + "00",
+		"read zip: excess data",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			_, err := Unmarshal(unhex(c.hex))
+			if assert.Error(err) {
+				assert.Equal(c.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestMulticast(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(ethernet.Addr{0x09, 0x00, 0x07, 0x00, 0x00, 0x59}, Multicast("NORTH"))
+	assert.Equal(Multicast("NORTH"), Multicast("North"))
+	assert.Equal(Multicast("CAFÉ"), Multicast("Café"))
+}
+
+func unhex(s string) []byte {
+	data := []byte{}
+	for i := 0; i < len(s); i += 2 {
+		n, err := strconv.ParseUint(s[i:i+2], 16, 8)
+		if err != nil {
+			panic(err)
+		}
+		data = append(data, byte(n))
+	}
+	return data
+}